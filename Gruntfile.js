@@ -48,7 +48,10 @@ module.exports = function(grunt){
 
         exec: {
             buildServer: {
-                cmd: 'go build -o src/server/server.exe -v src/server/server.go'
+                cmd: 'go build -o src/server/server.exe -v ./src/server'
+            },
+            buildSingleServer: {
+                cmd: 'go build -tags embed -o src/server/server.exe -v ./src/server'
             },
             startDevServer: {
                 cmd: 'cd src/server && ./server.exe'
@@ -97,6 +100,15 @@ module.exports = function(grunt){
                     mode: true
                 }
             },
+            embeddedClient: {
+                cwd: 'build/client',
+                src: '**',
+                dest: 'src/server/embedded/client/',
+                expand: true,
+                options : {
+                    mode: true
+                }
+            },
             fullClient: {
                 cwd: 'src/client',
                 src: '**',
@@ -136,13 +148,32 @@ module.exports = function(grunt){
         clean: {
             allClientBuildExcept_index_robot_favicon_main_legacy_resources: ['build/client/**/*', '!build/client/index.html', '!build/client/main.js', '!build/client/robots.txt', '!build/client/favicon.ico', '!build/client/resource/**', '!build/client/legacy/**'],
             buildCss: ['build/client/**/*.css', '!build/client/resource/**/*.css'],
-            server: ['build/server', 'src/server/server.exe'],
+            server: ['build/server', 'src/server/server.exe', 'src/server/embedded/client'],
+            embeddedClient: ['src/server/embedded/client'],
             clientBuild: ['build/client'],
             clientTest: ['test/unit/coverage/*','test/unit/results/*'],
             sass: ['src/client/**/*.css'],
             e2e: ['test/e2e/results/*']
         },
 
+        compress: {
+            buildClient: {
+                options: {
+                    mode: 'gzip',
+                    level: 9
+                },
+                files: [{
+                    expand: true,
+                    cwd: 'build/client',
+                    src: '**/*.{js,css,html,json,svg}',
+                    dest: 'build/client',
+                    rename: function (dest, src) {
+                        return dest + '/' + src + '.gz';
+                    }
+                }]
+            }
+        },
+
         compass: {
             dev: {
                 options: {
@@ -173,15 +204,17 @@ module.exports = function(grunt){
     grunt.loadNpmTasks('grunt-contrib-uglify');
     grunt.loadNpmTasks('grunt-contrib-clean');
     grunt.loadNpmTasks('grunt-contrib-compass');
+    grunt.loadNpmTasks('grunt-contrib-compress');
     grunt.loadNpmTasks('grunt-contrib-htmlmin');
     grunt.loadNpmTasks('grunt-json-minify');
     grunt.loadNpmTasks('grunt-contrib-jshint');
 
 
     grunt.registerTask('buildServer', ['exec:buildServer', 'copy:serverExe', 'copy:confJson']);
+    grunt.registerTask('buildSingleServer', ['buildClient', 'clean:embeddedClient', 'copy:embeddedClient', 'exec:buildSingleServer', 'copy:serverExe']);
     grunt.registerTask('cleanServer', ['clean:server']);
 
-    grunt.registerTask('buildClient', ['jshint:client', 'copy:fullClient', 'clean:buildCss', 'exec:compileBuildSass', 'htmlmin:build', 'json-minify:build', 'requirejs:compileMain', 'uglify:mainJsBuild', 'processhtml:clientIndex', 'clean:allClientBuildExcept_index_robot_favicon_main_resources']);
+    grunt.registerTask('buildClient', ['jshint:client', 'copy:fullClient', 'clean:buildCss', 'exec:compileBuildSass', 'htmlmin:build', 'json-minify:build', 'requirejs:compileMain', 'uglify:mainJsBuild', 'processhtml:clientIndex', 'clean:allClientBuildExcept_index_robot_favicon_main_resources', 'compress:buildClient']);
     grunt.registerTask('testClient', ['exec:testClient']);
     grunt.registerTask('testClientCI', ['exec:testClientCI']);
     grunt.registerTask('cleanClientBuild', ['clean:clientBuild']);
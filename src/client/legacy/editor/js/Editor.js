@@ -68,7 +68,7 @@ var Editor = function () {
 
 	this.config = new Config();
 	this.history = new History( this );
-	this.storage = new Storage();
+	this.storage = new Storage( this );
 	this.loader = new Loader( this );
 
 	this.camera = new THREE.PerspectiveCamera( 50, 1, 1, 100000 );
@@ -437,7 +437,7 @@ Editor.prototype = {
 
 		if ( json.scene === undefined ) {
 
-			this.setScene( loader.parse( json ) );
+			this.setScene( loader.parse( this.resolveAssets( json ) ) );
 			return;
 
 		}
@@ -452,11 +452,33 @@ Editor.prototype = {
 		this.camera.near = camera.near;
 		this.camera.far = camera.far;
 
-		this.setScene( loader.parse( json.scene ) );
+		this.setScene( loader.parse( this.resolveAssets( json.scene ) ) );
 		this.scripts = json.scripts;
 
 	},
 
+	// scenes saved on the server refer to their images as asset://<hash>, which are fetched from the asset api
+
+	resolveAssets: function ( json ) {
+
+		var images = json.images || [];
+
+		for ( var i = 0; i < images.length; i ++ ) {
+
+			var url = images[ i ].url;
+
+			if ( typeof url === 'string' && url.indexOf( 'asset://' ) === 0 ) {
+
+				images[ i ].url = '/api/assets/' + url.substr( 'asset://'.length );
+
+			}
+
+		}
+
+		return json;
+
+	},
+
 	toJSON: function () {
 
 		return {
@@ -122,21 +122,38 @@ var Loader = function ( editor ) {
 
 			case 'dae':
 
-				var reader = new FileReader();
-				reader.addEventListener( 'load', function ( event ) {
+				importOnServer( 'dae', file, filename, function () {
 
-					var contents = event.target.result;
+					var reader = new FileReader();
+					reader.addEventListener( 'load', function ( event ) {
 
-					var loader = new THREE.ColladaLoader();
-					var collada = loader.parse( contents );
+						var contents = event.target.result;
 
-					collada.scene.name = filename;
+						var loader = new THREE.ColladaLoader();
+						var collada = loader.parse( contents );
 
-					editor.addObject( collada.scene );
-					editor.select( collada.scene );
+						collada.scene.name = filename;
 
-				}, false );
-				reader.readAsText( file );
+						editor.addObject( collada.scene );
+						editor.select( collada.scene );
+
+					}, false );
+					reader.readAsText( file );
+
+				} );
+
+				break;
+
+			case 'glb':
+			case 'gltf':
+
+				// there is no glTF 2.0 loader in the browser, a .gltf must embed its buffers to be loaded on its own
+
+				importOnServer( extension, file, filename, function () {
+
+					alert( 'glTF files can only be imported through the server.' );
+
+				} );
 
 				break;
 
@@ -200,19 +217,25 @@ var Loader = function ( editor ) {
 
 				case 'kmz':
 
-					var reader = new FileReader();
-					reader.addEventListener( 'load', function ( event ) {
+					// the server keeps the textures of the archive as assets, the browser can only embed them
 
-						var loader = new THREE.KMZLoader();
-						var collada = loader.parse( event.target.result );
+					importOnServer( 'kmz', file, filename, function () {
 
-						collada.scene.name = filename;
+						var reader = new FileReader();
+						reader.addEventListener( 'load', function ( event ) {
 
-						editor.addObject( collada.scene );
-						editor.select( collada.scene );
+							var loader = new THREE.KMZLoader();
+							var collada = loader.parse( event.target.result );
 
-					}, false );
-					reader.readAsArrayBuffer( file );
+							collada.scene.name = filename;
+
+							editor.addObject( collada.scene );
+							editor.select( collada.scene );
+
+						}, false );
+						reader.readAsArrayBuffer( file );
+
+					} );
 
 					break;
 
@@ -243,76 +266,90 @@ var Loader = function ( editor ) {
 
 			case 'obj':
 
-				var reader = new FileReader();
-				reader.addEventListener( 'load', function ( event ) {
+				// large scans are converted by the server, falling back to the browser when it is unavailable
 
-					var contents = event.target.result;
+				importOnServer( 'obj', file, filename, function () {
 
-					var object = new THREE.OBJLoader().parse( contents );
-					object.name = filename;
+					var reader = new FileReader();
+					reader.addEventListener( 'load', function ( event ) {
 
-					editor.addObject( object );
-					editor.select( object );
+						var contents = event.target.result;
 
-				}, false );
-				reader.readAsText( file );
+						var object = new THREE.OBJLoader().parse( contents );
+						object.name = filename;
+
+						editor.addObject( object );
+						editor.select( object );
+
+					}, false );
+					reader.readAsText( file );
+
+				} );
 
 				break;
 
 			case 'ply':
 
-				var reader = new FileReader();
-				reader.addEventListener( 'load', function ( event ) {
+				importOnServer( 'ply', file, filename, function () {
 
-					var contents = event.target.result;
+					var reader = new FileReader();
+					reader.addEventListener( 'load', function ( event ) {
 
-					var geometry = new THREE.PLYLoader().parse( contents );
-					geometry.sourceType = "ply";
-					geometry.sourceFile = file.name;
+						var contents = event.target.result;
 
-					var material = new THREE.MeshPhongMaterial();
+						var geometry = new THREE.PLYLoader().parse( contents );
+						geometry.sourceType = "ply";
+						geometry.sourceFile = file.name;
 
-					var mesh = new THREE.Mesh( geometry, material );
-					mesh.name = filename;
+						var material = new THREE.MeshPhongMaterial();
 
-					editor.addObject( mesh );
-					editor.select( mesh );
+						var mesh = new THREE.Mesh( geometry, material );
+						mesh.name = filename;
 
-				}, false );
-				reader.readAsText( file );
+						editor.addObject( mesh );
+						editor.select( mesh );
+
+					}, false );
+					reader.readAsText( file );
+
+				} );
 
 				break;
 
 			case 'stl':
 
-				var reader = new FileReader();
-				reader.addEventListener( 'load', function ( event ) {
+				importOnServer( 'stl', file, filename, function () {
 
-					var contents = event.target.result;
+					var reader = new FileReader();
+					reader.addEventListener( 'load', function ( event ) {
 
-					var geometry = new THREE.STLLoader().parse( contents );
-					geometry.sourceType = "stl";
-					geometry.sourceFile = file.name;
+						var contents = event.target.result;
 
-					var material = new THREE.MeshPhongMaterial();
+						var geometry = new THREE.STLLoader().parse( contents );
+						geometry.sourceType = "stl";
+						geometry.sourceFile = file.name;
 
-					var mesh = new THREE.Mesh( geometry, material );
-					mesh.name = filename;
+						var material = new THREE.MeshPhongMaterial();
 
-					editor.addObject( mesh );
-					editor.select( mesh );
+						var mesh = new THREE.Mesh( geometry, material );
+						mesh.name = filename;
 
-				}, false );
+						editor.addObject( mesh );
+						editor.select( mesh );
 
-				if ( reader.readAsBinaryString !== undefined ) {
+					}, false );
 
-					reader.readAsBinaryString( file );
+					if ( reader.readAsBinaryString !== undefined ) {
 
-				} else {
+						reader.readAsBinaryString( file );
 
-					reader.readAsArrayBuffer( file );
+					} else {
 
-				}
+						reader.readAsArrayBuffer( file );
+
+					}
+
+				} );
 
 				break;
 
@@ -340,25 +377,29 @@ var Loader = function ( editor ) {
 
 			case 'vtk':
 
-				var reader = new FileReader();
-				reader.addEventListener( 'load', function ( event ) {
+				importOnServer( 'vtk', file, filename, function () {
 
-					var contents = event.target.result;
+					var reader = new FileReader();
+					reader.addEventListener( 'load', function ( event ) {
 
-					var geometry = new THREE.VTKLoader().parse( contents );
-					geometry.sourceType = "vtk";
-					geometry.sourceFile = file.name;
+						var contents = event.target.result;
 
-					var material = new THREE.MeshPhongMaterial();
+						var geometry = new THREE.VTKLoader().parse( contents );
+						geometry.sourceType = "vtk";
+						geometry.sourceFile = file.name;
 
-					var mesh = new THREE.Mesh( geometry, material );
-					mesh.name = filename;
+						var material = new THREE.MeshPhongMaterial();
 
-					editor.addObject( mesh );
-					editor.select( mesh );
+						var mesh = new THREE.Mesh( geometry, material );
+						mesh.name = filename;
 
-				}, false );
-				reader.readAsText( file );
+						editor.addObject( mesh );
+						editor.select( mesh );
+
+					}, false );
+					reader.readAsText( file );
+
+				} );
 
 				break;
 
@@ -378,6 +419,18 @@ var Loader = function ( editor ) {
 
 				break;
 
+			case 'zip':
+
+				// a model with its material libraries and textures, unpacked by the server
+
+				importOnServer( 'zip', file, filename, function () {
+
+					alert( 'Zip archives can only be imported through the server.' );
+
+				} );
+
+				break;
+
 			default:
 
 				alert( 'Unsupported file format (' + extension +  ').' );
@@ -388,6 +441,28 @@ var Loader = function ( editor ) {
 
 	}
 
+	var importOnServer = function ( format, file, filename, fallback ) {
+
+		var request = new XMLHttpRequest();
+		request.open( 'POST', '/api/import/' + format + '?name=' + encodeURIComponent( filename ), true );
+		request.addEventListener( 'load', function ( event ) {
+
+			if ( request.status !== 200 ) {
+
+				console.warn( 'Loader: server import failed, loading ' + filename + ' in the browser', request.responseText );
+				fallback();
+				return;
+
+			}
+
+			handleJSON( JSON.parse( request.responseText ), file, filename );
+
+		}, false );
+		request.addEventListener( 'error', fallback, false );
+		request.send( file );
+
+	};
+
 	var handleJSON = function ( data, file, filename ) {
 
 		if ( data.metadata === undefined ) { // 2.0
@@ -471,7 +546,7 @@ var Loader = function ( editor ) {
 			var loader = new THREE.ObjectLoader();
 			loader.setTexturePath( scope.texturePath );
 
-			var result = loader.parse( data );
+			var result = loader.parse( editor.resolveAssets( data ) );
 
 			if ( result instanceof THREE.Scene ) {
 
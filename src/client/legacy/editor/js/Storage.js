@@ -2,7 +2,378 @@
  * @author mrdoob / http://mrdoob.com/
  */
 
-var Storage = function () {
+// Keeps the editor's scene on the server through /api/scenes, or in IndexedDB when the server can not be reached.
+
+var Storage = function ( editor ) {
+
+	var local = IndexedDBStorage();
+	var server = ServerStorage( editor );
+
+	var storage = local;
+
+	return {
+
+		init: function ( callback ) {
+
+			server.init( function ( available ) {
+
+				if ( available ) {
+
+					storage = server;
+					callback();
+					return;
+
+				}
+
+				console.warn( 'Storage: scene api not available, saving to IndexedDB.' );
+				storage = local;
+				storage.init( callback );
+
+			} );
+
+		},
+
+		get: function ( callback ) {
+
+			storage.get( callback );
+
+		},
+
+		set: function ( data, callback ) {
+
+			storage.set( data, callback );
+
+		},
+
+		clear: function () {
+
+			storage.clear();
+
+		}
+
+	}
+
+};
+
+var ServerStorage = function ( editor ) {
+
+	var prefix = '/api/scenes';
+
+	// the id of the scene being edited is kept across page loads
+	var key = '3ditor-scene';
+
+	// the revision the editor's scene was loaded from or last saved as, saves are made against it
+	var revision = 0;
+
+	// a save is in progress and the latest data to save once it finishes
+	var saving = false;
+	var pending;
+
+	// the 409 response of a save that conflicts with changes made by someone else, nothing more is saved until the
+	// user reloads or keeps their own version
+	var conflict;
+
+	var log = function () {
+
+		var args = [ '[' + /\d\d\:\d\d\:\d\d/.exec( new Date() )[ 0 ] + ']' ];
+		console.log.apply( console, args.concat( Array.prototype.slice.call( arguments ) ) );
+
+	};
+
+	var send = function ( method, url, body, callback ) {
+
+		var request = new XMLHttpRequest();
+		request.open( method, url, true );
+		request.addEventListener( 'load', function () {
+
+			var response;
+
+			try {
+
+				response = JSON.parse( request.responseText );
+
+			} catch ( error ) {}
+
+			callback( request.status, response );
+
+		}, false );
+		request.addEventListener( 'error', function () {
+
+			callback( 0 );
+
+		}, false );
+
+		if ( body !== undefined ) {
+
+			request.setRequestHeader( 'Content-Type', 'application/json' );
+			request.send( JSON.stringify( body ) );
+
+		} else {
+
+			request.send();
+
+		}
+
+	};
+
+	var save = function ( data ) {
+
+		var id = window.localStorage[ key ];
+		var start = performance.now();
+
+		saving = true;
+
+		var done = function () {
+
+			saving = false;
+
+			if ( pending !== undefined && conflict === undefined ) {
+
+				var next = pending;
+				pending = undefined;
+				save( next );
+
+			}
+
+		};
+
+		if ( id === undefined ) {
+
+			send( 'POST', prefix, data, function ( status, meta ) {
+
+				if ( status === 201 ) {
+
+					window.localStorage[ key ] = meta.id;
+					revision = meta.revision;
+					log( 'Created scene ' + meta.id + '. ' + ( performance.now() - start ).toFixed( 2 ) + 'ms' );
+
+				} else {
+
+					console.error( 'Storage: failed to create scene', status, meta );
+
+				}
+
+				done();
+
+			} );
+			return;
+
+		}
+
+		send( 'PUT', prefix + '/' + id + '?base=' + revision, data, function ( status, result ) {
+
+			switch ( status ) {
+
+				case 200:
+
+					revision = result.revision;
+					log( ( result.merged ? 'Merged and saved' : 'Saved' ) + ' scene as revision ' + revision + '. ' + ( performance.now() - start ).toFixed( 2 ) + 'ms' );
+					break;
+
+				case 404:
+
+					// deleted elsewhere, the next save makes it anew
+					delete window.localStorage[ key ];
+					revision = 0;
+					if ( pending === undefined ) pending = data;
+					break;
+
+				case 409:
+
+					// edits made meanwhile are newer than data, which is only kept when there are none
+					if ( pending === undefined ) pending = data;
+
+					if ( result !== undefined && result.conflicts !== undefined ) {
+
+						conflict = result;
+						console.warn( 'Storage: save conflicts with changes made since revision ' + result.base + ' up to revision ' + result.head, result.conflicts );
+						showConflict();
+
+					} else {
+
+						console.error( 'Storage: failed to save scene', status, result );
+
+					}
+					break;
+
+				default:
+
+					console.error( 'Storage: failed to save scene', status, result );
+
+			}
+
+			done();
+
+		} );
+
+	};
+
+	// Lists the conflicting changes and lets the user load the latest revision, dropping their edits, or save their
+	// version over it. Closing the dialog leaves the conflict unresolved and it is shown again on the next edit.
+	var showConflict = function () {
+
+		var content = new UI.Panel();
+
+		var title = new UI.Text( 'Someone else changed this scene since revision ' + conflict.base + '.' );
+		content.add( title );
+		content.add( new UI.Break(), new UI.Break() );
+
+		conflict.conflicts.forEach( function ( c ) {
+
+			content.add( new UI.Text( c.kind + ' ' + c.uuid + ' ' + c.property ) );
+			content.add( new UI.Break() );
+
+		} );
+
+		content.add( new UI.Break() );
+
+		var reload = new UI.Button( 'Load revision ' + conflict.head );
+		reload.onClick( function () {
+
+			conflict = undefined;
+			pending = undefined;
+
+			load( function ( data ) {
+
+				if ( data !== undefined ) editor.fromJSON( data );
+
+			} );
+
+		} );
+		content.add( reload );
+
+		var keep = new UI.Button( 'Keep mine' );
+		keep.setMarginLeft( '4px' );
+		keep.onClick( function () {
+
+			// saved as an edit of the latest revision, so the user's version replaces it
+			revision = conflict.head;
+			conflict = undefined;
+
+			var next = pending;
+			pending = undefined;
+			if ( saving ) pending = next; else save( next );
+
+		} );
+		content.add( keep );
+
+		editor.signals.showModal.dispatch( content );
+
+	};
+
+	var load = function ( callback ) {
+
+		var id = window.localStorage[ key ];
+
+		if ( id === undefined ) {
+
+			callback( undefined );
+			return;
+
+		}
+
+		// the revision is read before the document so a save made in between is merged rather than lost
+		send( 'GET', prefix + '/' + id + '/revisions', undefined, function ( status, revisions ) {
+
+			if ( status !== 200 || revisions.length === 0 ) {
+
+				callback( undefined );
+				return;
+
+			}
+
+			var number = revisions[ revisions.length - 1 ].number;
+
+			send( 'GET', prefix + '/' + id + '/revisions/' + number, undefined, function ( status, data ) {
+
+				if ( status !== 200 ) {
+
+					console.error( 'Storage: failed to load scene', status, data );
+					callback( undefined );
+					return;
+
+				}
+
+				revision = number;
+				callback( data );
+
+			} );
+
+		} );
+
+	};
+
+	return {
+
+		init: function ( callback ) {
+
+			send( 'GET', prefix, undefined, function ( status, metas ) {
+
+				if ( status !== 200 ) {
+
+					callback( false );
+					return;
+
+				}
+
+				var id = window.localStorage[ key ];
+
+				if ( id !== undefined && metas.every( function ( meta ) { return meta.id !== id; } ) ) {
+
+					delete window.localStorage[ key ];
+
+				}
+
+				callback( true );
+
+			} );
+
+		},
+
+		get: function ( callback ) {
+
+			load( callback );
+
+		},
+
+		set: function ( data, callback ) {
+
+			if ( conflict !== undefined ) {
+
+				pending = data;
+				showConflict();
+				return;
+
+			}
+
+			if ( saving ) {
+
+				pending = data;
+				return;
+
+			}
+
+			save( data );
+
+		},
+
+		clear: function () {
+
+			// the scene stays on the server, the editor starts a new one on its next save
+			delete window.localStorage[ key ];
+			revision = 0;
+			pending = undefined;
+			conflict = undefined;
+
+			log( 'Started a new scene.' );
+
+		}
+
+	}
+
+};
+
+var IndexedDBStorage = function () {
 
 	var indexedDB = window.indexedDB || window.mozIndexedDB || window.webkitIndexedDB || window.msIndexedDB;
 
@@ -588,6 +588,14 @@ var Viewport = function ( editor ) {
 		sceneHelpers.updateMatrixWorld();
 		scene.updateMatrixWorld();
 
+		// show the level of each LOD object that suits its distance from the camera
+
+		scene.traverse( function ( object ) {
+
+			if ( object instanceof THREE.LOD ) object.update( camera );
+
+		} );
+
 		renderer.clear();
 		renderer.render( scene, camera );
 
@@ -183,6 +183,12 @@ var APP = {
 
 			dispatch( events.update, { time: time, delta: time - prevTime } );
 
+			scene.traverse( function ( object ) {
+
+				if ( object instanceof THREE.LOD ) object.update( camera );
+
+			} );
+
 			if ( vr === true ) {
 
 				controls.update();
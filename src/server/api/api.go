@@ -0,0 +1,51 @@
+/*
+Small helpers shared by the http api handlers
+*/
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// Writes v as the json response body with the given status code
+func WriteJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set(`Content-Type`, `application/json; charset=utf-8`)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Writes raw json bytes as the response body with the given status code
+func WriteRawJson(w http.ResponseWriter, status int, data []byte) {
+	w.Header().Set(`Content-Type`, `application/json; charset=utf-8`)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Writes {"error": "<msg>"} with the given status code
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJson(w, status, &errorBody{Error: err.Error()})
+}
+
+// Writes a 405 listing the allowed methods
+func MethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set(`Allow`, strings.Join(allowed, `, `))
+	WriteJson(w, http.StatusMethodNotAllowed, &errorBody{Error: `method not allowed`})
+}
+
+// Splits the part of path after prefix into its non empty segments,
+// e.g. SplitPath("/api/scenes/abc/revisions/", "/api/scenes") returns ["abc", "revisions"]
+func SplitPath(path, prefix string) []string {
+	segments := []string{}
+	for _, s := range strings.Split(strings.TrimPrefix(path, prefix), `/`) {
+		if s != `` {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
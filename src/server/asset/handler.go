@@ -0,0 +1,98 @@
+package asset
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// the contents of a hash never change so clients may cache assets for good
+	cacheControl = `public, max-age=31536000, immutable`
+)
+
+// The textures and models assets are served as, anything else could be a page running script in the editor's origin
+// so is served as a download
+var inlineTypes = map[string]bool{
+	`image/png`:                true,
+	`image/jpeg`:               true,
+	`image/gif`:                true,
+	`image/webp`:               true,
+	`image/bmp`:                true,
+	`image/vnd.radiance`:       true,
+	`image/ktx2`:               true,
+	`model/gltf+json`:          true,
+	`model/gltf-binary`:        true,
+	`model/stl`:                true,
+	`model/obj`:                true,
+	`model/mtl`:                true,
+	`model/vnd.collada+xml`:    true,
+	`application/octet-stream`: true,
+}
+
+// Serves POST /api/assets storing the body as an asset of the request's content type, and GET /api/assets/{hash}
+// with the hash as a strong ETag. Assets of a type other than the textures and models in inlineTypes are served as
+// attachments of no particular type.
+func NewHandler(store Store, log golog.Log) http.Handler {
+	return &handler{store: store, log: log}
+}
+
+type handler struct {
+	store Store
+	log   golog.Log
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := api.SplitPath(r.URL.Path, Prefix)
+	switch len(segments) {
+	case 0:
+		h.servePut(w, r)
+	case 1:
+		h.serveAsset(w, r, segments[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) servePut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != `POST` {
+		api.MethodNotAllowed(w, `POST`)
+		return
+	}
+	a, err := h.store.Put(r.Header.Get(`Content-Type`), r.Body)
+	if err != nil {
+		h.log.Error(err)
+		api.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJson(w, http.StatusCreated, a)
+}
+
+func (h *handler) serveAsset(w http.ResponseWriter, r *http.Request, hash string) {
+	if r.Method != `GET` && r.Method != `HEAD` {
+		api.MethodNotAllowed(w, `GET`, `HEAD`)
+		return
+	}
+	a, f, err := h.store.Get(hash)
+	if IsNotFound(err) {
+		api.WriteError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		h.log.Error(err)
+		api.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	if mediaType, _, err := mime.ParseMediaType(a.Type); err == nil && inlineTypes[strings.ToLower(mediaType)] {
+		w.Header().Set(`Content-Type`, a.Type)
+	} else {
+		w.Header().Set(`Content-Type`, defaultMimeType)
+		w.Header().Set(`Content-Disposition`, `attachment; filename="`+a.Hash+`"`)
+	}
+	w.Header().Set(`X-Content-Type-Options`, `nosniff`)
+	w.Header().Set(`ETag`, `"`+a.Hash+`"`)
+	w.Header().Set(`Cache-Control`, cacheControl)
+	http.ServeContent(w, r, ``, a.Created, f)
+}
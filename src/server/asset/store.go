@@ -0,0 +1,239 @@
+/*
+Server side storage of the files scenes refer to, such as textures and large geometry arrays. Assets are addressed by
+the SHA-256 hash of their contents so identical files are only kept once however many scenes use them, and documents
+refer to them as asset://<hash> instead of embedding them.
+*/
+package asset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/atomicfile"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	Prefix          = `/api/assets`
+	Scheme          = `asset://`
+	metaFileExt     = `.json`
+	defaultMimeType = `application/octet-stream`
+)
+
+type Asset struct {
+	Hash    string    `json:"hash"`
+	Ref     string    `json:"ref"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	// when the contents were last put, only set by List
+	Modified time.Time `json:"-"`
+}
+
+// The asset://<hash> reference documents use for the asset with hash
+func Ref(hash string) string {
+	return Scheme + hash
+}
+
+// The hash an asset:// reference refers to, ok is false for any other url
+func ParseRef(ref string) (hash string, ok bool) {
+	if !strings.HasPrefix(ref, Scheme) {
+		return ``, false
+	}
+	hash = strings.TrimPrefix(ref, Scheme)
+	return hash, isHash(hash)
+}
+
+func isHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// The contents of an asset
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type Store interface {
+	// Stores the contents of r unless an asset with the same contents already exists, either way returning the asset
+	// holding them. mimeType is only recorded when the asset is new.
+	Put(mimeType string, r io.Reader) (*Asset, error)
+	// The asset and its contents which the caller must close
+	Get(hash string) (*Asset, File, error)
+	List() ([]*Asset, error)
+	Delete(hash string) error
+}
+
+// Reads the whole of the asset an asset:// reference refers to
+func ReadRef(store Store, ref string) (*Asset, []byte, error) {
+	hash, ok := ParseRef(ref)
+	if !ok {
+		return nil, nil, &noSuchAssetError{hash: ref}
+	}
+	a, f, err := store.Get(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, data, nil
+}
+
+// Stores each asset under storeDir in a directory named after the first two characters of its hash, as a data file
+// named by the hash and a json meta file next to it
+func NewLocalStore(storeDir string) (Store, error) {
+	if err := os.MkdirAll(storeDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: storeDir}, nil
+}
+
+type localStore struct {
+	dir string
+}
+
+func (s *localStore) dataFile(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// The contents are hashed as they are written to a temporary file which then either becomes the asset or, when the
+// asset already exists, is thrown away. Both files are written atomically so concurrent puts of the same contents are
+// harmless and a crash never leaves a truncated asset behind.
+func (s *localStore) Put(mimeType string, r io.Reader) (*Asset, error) {
+	tmp, err := atomicfile.Create(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	// once renamed into place these do nothing
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if existing, err := s.readMeta(sum); err == nil {
+		// a put counts as a use, so pruning spares contents that were only just stored again
+		now := time.Now()
+		os.Chtimes(s.dataFile(sum), now, now)
+		return existing, nil
+	} else if !IsNotFound(err) {
+		return nil, err
+	}
+	if mimeType == `` {
+		mimeType = defaultMimeType
+	}
+	a := &Asset{Hash: sum, Ref: Ref(sum), Type: mimeType, Size: size, Created: time.Now().UTC()}
+	if err := os.MkdirAll(filepath.Dir(s.dataFile(sum)), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := atomicfile.Rename(tmp, s.dataFile(sum)); err != nil {
+		return nil, err
+	}
+	// the meta file is written last, an asset only exists once it has one
+	meta, _ := json.Marshal(a)
+	if err := atomicfile.WriteFile(s.dataFile(sum)+metaFileExt, meta); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *localStore) readMeta(hash string) (*Asset, error) {
+	if !isHash(hash) {
+		return nil, &noSuchAssetError{hash: hash}
+	}
+	data, err := ioutil.ReadFile(s.dataFile(hash) + metaFileExt)
+	if os.IsNotExist(err) {
+		return nil, &noSuchAssetError{hash: hash}
+	} else if err != nil {
+		return nil, err
+	}
+	a := &Asset{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *localStore) Get(hash string) (*Asset, File, error) {
+	a, err := s.readMeta(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.dataFile(hash))
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, f, nil
+}
+
+func (s *localStore) List() ([]*Asset, error) {
+	shards, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var assets []*Asset
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.dir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !isHash(file.Name()) {
+				continue
+			}
+			a, err := s.readMeta(file.Name())
+			if IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			a.Modified = file.ModTime()
+			assets = append(assets, a)
+		}
+	}
+	return assets, nil
+}
+
+// The meta file goes first, without it the asset no longer exists even if removing the data fails
+func (s *localStore) Delete(hash string) error {
+	if _, err := s.readMeta(hash); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataFile(hash) + metaFileExt); err != nil {
+		return err
+	}
+	return os.Remove(s.dataFile(hash))
+}
+
+type noSuchAssetError struct {
+	hash string
+}
+
+func (e *noSuchAssetError) Error() string { return `No such asset exists with hash: ` + e.hash }
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*noSuchAssetError)
+	return ok
+}
@@ -0,0 +1,54 @@
+package asset
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPut(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `asset`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := store.Put(`image/png`, strings.NewReader(`pixels`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.Put(``, strings.NewReader(`pixels`))
+	if err != nil || again.Hash != a.Hash || again.Type != `image/png` {
+		t.Errorf(`got %+v (%v) putting the same contents again, want %+v`, again, err, a)
+	}
+	if _, data, err := ReadRef(store, a.Ref); err != nil || string(data) != `pixels` {
+		t.Errorf(`got %q (%v), want the contents put`, data, err)
+	}
+
+	// only the shard directory is left beside the data and meta files, no temporary files
+	shard := filepath.Dir(store.(*localStore).dataFile(a.Hash))
+	for _, d := range []string{dir, shard} {
+		files, err := ioutil.ReadDir(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			switch {
+			case file.IsDir():
+				if filepath.Join(d, file.Name()) != shard {
+					t.Errorf(`unexpected directory %s`, file.Name())
+				}
+			case file.Name() != a.Hash && file.Name() != a.Hash+metaFileExt:
+				t.Errorf(`unexpected file %s`, file.Name())
+			case file.Mode().Perm() != 0644:
+				t.Errorf(`got %s permissions %o, want 644`, file.Name(), file.Mode().Perm())
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Crash safe file writes shared by the stores. A file is written in full to a temporary sibling, synced and renamed into
+place, then its directory is synced, so after a crash or power cut it holds either its old or its new contents and
+never a truncated mix.
+*/
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The permissions files are left with, temporary files are created more restricted
+const Perm = 0644
+
+// Replaces the contents of file with data
+func WriteFile(file string, data []byte) error {
+	tmp, err := Create(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	return Rename(tmp, file)
+}
+
+// A temporary file in dir for the caller to write and pass to Rename, or to close and remove
+func Create(dir string) (*os.File, error) {
+	return ioutil.TempFile(dir, `.tmp`)
+}
+
+// Syncs and closes tmp, a file from Create, then moves it to file. tmp is removed whenever it fails.
+func Rename(tmp *os.File, file string) error {
+	err := tmp.Chmod(Perm)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,55 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `atomicfile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, `file.json`)
+	for _, data := range []string{`first`, `second`} {
+		if err := WriteFile(file, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(file); err != nil || string(got) != data {
+			t.Errorf(`got %q (%v), want %q`, got, err, data)
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&^Perm != 0 {
+		t.Errorf(`got permissions %o, want at most %o`, perm, Perm)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf(`got %d files (%v), want no temporary file left behind`, len(files), err)
+	}
+}
+
+func TestRenameFailure(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `atomicfile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rename(tmp, filepath.Join(dir, `missing`, `file.json`)); err == nil {
+		t.Error(`got no error renaming into a missing directory`)
+	}
+	if _, err := os.Stat(tmp.Name()); !os.IsNotExist(err) {
+		t.Errorf(`temporary file left behind: %v`, err)
+	}
+}
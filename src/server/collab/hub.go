@@ -0,0 +1,410 @@
+/*
+Real time collaborative editing of stored scenes, every client editing a scene sends its edits as ops over a
+websocket and the server relays them to everyone in one authoritative order
+*/
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/lock"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/websocket"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// outgoing messages a client may fall behind by before it is disconnected and has to resync
+	sendBufferSize = 256
+	// the author recorded against revisions saved by a session without an identifiable client
+	sessionAuthor = `collaboration`
+)
+
+// Messages exchanged over the socket. Clients send:
+//
+//	{"type": "op", "id": "<client op id>", "op": {...}}  apply an op
+//	{"type": "sync"}                                      request a fresh snapshot
+//	{"type": "save"}                                      save the shared scene as a new revision
+//	{"type": "lock", "id": "...", "uuid": "...", "subtree": true, "ttl": seconds}  take or renew a lease on an object
+//	{"type": "unlock", "uuid": "..."}                     release a lease
+//
+// and the server sends:
+//
+//	{"type": "snapshot", "seq": n, "clientId": "...", "token": "...", "document": {...}}  the full scene, on join and request
+//	{"type": "op", "seq": n, "clientId": "...", "id": "...", "op": {...}}  an accepted op, including the sender's own
+//	{"type": "reject", "id": "...", "error": "..."}                         the sender's op was not valid when applied
+//	{"type": "saved", "seq": n, "revision": r}                             the scene was saved as revision r
+//	{"type": "conflict", "revision": r, "error": "...", "conflicts": [...]}  a save conflicts with revision r saved
+//	                                                                         elsewhere, the next save replaces it
+//	{"type": "locks", "locks": [...]}                                       the current leases, sent on join and on change
+//	{"type": "error", "error": "..."}
+//
+// The clientId is public, it marks a client's ops and leases for everyone. The token is secret to the client, it
+// holds the client's leases and is passed as ?holder= to save over them through the REST api.
+type message struct {
+	Type     string        `json:"type"`
+	Id       string        `json:"id,omitempty"`
+	Seq      int           `json:"seq"`
+	ClientId string        `json:"clientId,omitempty"`
+	Token    string        `json:"token,omitempty"`
+	Op       *Op           `json:"op,omitempty"`
+	Document interface{}   `json:"document,omitempty"`
+	Revision int           `json:"revision,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Uuid     string        `json:"uuid,omitempty"`
+	Subtree  bool          `json:"subtree,omitempty"`
+	Ttl      float64       `json:"ttl,omitempty"`
+	Locks    []*lock.Lease `json:"locks,omitempty"`
+	// the properties a save conflicts on, when they are known
+	Conflicts []*scene.Conflict `json:"conflicts,omitempty"`
+}
+
+// Conflict resolution is by total order: ops are applied to the server's copy of the scene in the order they
+// arrive and stamped with an increasing seq. Clients apply ops in seq order, an op that is no longer valid when it
+// arrives, e.g. moving an object someone else just removed, is rejected back to its sender alone so it can undo its
+// optimistic local change, and concurrent property changes resolve to the last one in seq order. Every client
+// therefore converges on the same scene graph.
+//
+// Ops touching an object leased to another client are rejected the same way, and a client's leases are released
+// when its socket closes.
+type Hub struct {
+	store    scene.Store
+	merge    scene.MergeFunc
+	locks    *lock.Manager
+	log      golog.Log
+	mtx      sync.Mutex
+	sessions map[string]*session
+	closed   bool
+}
+
+func NewHub(store scene.Store, merge scene.MergeFunc, locks *lock.Manager, log golog.Log) *Hub {
+	return &Hub{store: store, merge: merge, locks: locks, log: log, sessions: map[string]*session{}}
+}
+
+type session struct {
+	sceneId string
+	mtx     sync.Mutex
+	graph   *scene.Graph
+	base    int
+	seq     int
+	dirty   bool
+	clients map[string]*client
+}
+
+type client struct {
+	id     string
+	name   string
+	conn   *websocket.Conn
+	outbox *websocket.Outbox
+	// holds the client's leases, it is only ever sent to the client itself
+	token string
+}
+
+// Serves the websocket at /api/scenes/{id}/collab, ?name= identifies the client in saved revisions
+func NewSubHandler(hub *Hub) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if _, _, err := hub.store.Get(id); err != nil {
+			scene.WriteError(w, hub.log, err)
+			return
+		}
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		c := &client{
+			id:     uuid.New(),
+			name:   r.URL.Query().Get(`name`),
+			conn:   conn,
+			outbox: websocket.NewOutbox(conn, sendBufferSize),
+			token:  uuid.New(),
+		}
+		hub.serve(id, c)
+	}
+}
+
+func (h *Hub) serve(sceneId string, c *client) {
+	s, err := h.join(sceneId, c)
+	if err != nil {
+		h.log.Error(`failed to join collaboration on scene: `, sceneId, ` `, err)
+		c.sendMessage(&message{Type: `error`, Error: err.Error()})
+		c.outbox.Close()
+		return
+	}
+	defer h.leave(s, c)
+
+	for {
+		data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := &message{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			c.sendMessage(&message{Type: `error`, Error: err.Error()})
+			continue
+		}
+		switch msg.Type {
+		case `op`:
+			h.apply(s, c, msg)
+		case `lock`:
+			h.lock(s, c, msg)
+		case `unlock`:
+			h.locks.Release(s.sceneId, msg.Uuid, c.token)
+			h.broadcastLocks(s)
+		case `sync`:
+			s.snapshot(c)
+		case `save`:
+			// a conflict has already been sent to every client
+			if err := h.save(s, c.name); err != nil && !isSaveConflict(err) {
+				c.sendMessage(&message{Type: `error`, Error: err.Error()})
+			}
+		default:
+			c.sendMessage(&message{Type: `error`, Error: `unknown message type ` + msg.Type})
+		}
+	}
+}
+
+func (h *Hub) join(sceneId string, c *client) (*session, error) {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	if h.closed {
+		return nil, errClosed
+	}
+	s := h.sessions[sceneId]
+	if s == nil {
+		meta, doc, err := h.store.Get(sceneId)
+		if err != nil {
+			return nil, err
+		}
+		graph, err := scene.ParseGraph(doc)
+		if err != nil {
+			return nil, err
+		}
+		s = &session{sceneId: sceneId, graph: graph, base: meta.Revision, clients: map[string]*client{}}
+		h.sessions[sceneId] = s
+	}
+
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	s.clients[c.id] = c
+	s.snapshotLocked(c)
+	c.sendMessage(&message{Type: `locks`, Locks: h.locks.Leases(sceneId)})
+	h.log.Info(`client: `, c.id, ` joined collaboration on scene: `, sceneId)
+	return s, nil
+}
+
+// Removes the client, the last client to leave saves any unsaved edits and closes the session. A session whose edits
+// fail to save is kept for the next client to join, or for Close to save.
+func (h *Hub) leave(s *session, c *client) {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	s.mtx.Lock()
+	delete(s.clients, c.id)
+	c.outbox.Close()
+	empty := len(s.clients) == 0
+	s.mtx.Unlock()
+
+	h.log.Info(`client: `, c.id, ` left collaboration on scene: `, s.sceneId)
+	if h.locks.ReleaseAll(s.sceneId, c.token) {
+		h.broadcastLocks(s)
+	}
+	if !empty {
+		return
+	}
+	if err := h.save(s, c.name); err != nil {
+		h.log.Error(`failed to save collaboration on scene: `, s.sceneId, `, keeping its edits: `, err)
+		return
+	}
+	delete(h.sessions, s.sceneId)
+}
+
+// Saves the unsaved edits of every session and disconnects its clients, telling them the server is going away.
+// Clients can not join once it has been called, calling it again does nothing.
+func (h *Hub) Close() {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for id, s := range h.sessions {
+		if err := h.save(s, ``); err != nil {
+			h.log.Error(`failed to save collaboration on scene: `, id, `, its edits are lost: `, err)
+		}
+		s.mtx.Lock()
+		for _, c := range s.clients {
+			c.conn.CloseWith(websocket.CloseGoingAway)
+		}
+		empty := len(s.clients) == 0
+		s.mtx.Unlock()
+		// sessions kept after a failed save have no clients to leave and close them
+		if empty {
+			delete(h.sessions, id)
+		}
+	}
+}
+
+// Saves the shared scene as a new revision if it has unsaved edits, merging with any revision saved elsewhere in
+// the meantime. When the merge conflicts the edits are kept, every client is told and the session moves its base to
+// the conflicting revision, so the next save replaces that revision with the shared scene.
+func (h *Hub) save(s *session, author string) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	if !s.dirty {
+		return nil
+	}
+	if author == `` {
+		author = sessionAuthor
+	}
+	doc, err := json.Marshal(s.graph.Document())
+	if err != nil {
+		return err
+	}
+	meta, err := h.store.Replace(s.sceneId, ``, author, s.base, doc)
+	if scene.IsHeadMoved(err) {
+		meta, err = h.mergeHead(s, author, doc)
+	}
+	if conflict, ok := err.(*saveConflictError); ok {
+		s.base = conflict.head
+		s.broadcastLocked(&message{Type: `conflict`, Revision: conflict.head, Error: err.Error(), Conflicts: conflict.conflicts})
+	}
+	if err != nil {
+		return err
+	}
+	s.base = meta.Revision
+	s.dirty = false
+	s.broadcastLocked(&message{Type: `saved`, Seq: s.seq, Revision: meta.Revision})
+	return nil
+}
+
+func (h *Hub) mergeHead(s *session, author string, doc []byte) (*scene.Meta, error) {
+	head, headDoc, err := h.store.Get(s.sceneId)
+	if err != nil {
+		return nil, err
+	}
+	if h.merge == nil {
+		return nil, &saveConflictError{sceneId: s.sceneId, head: head.Revision}
+	}
+	_, baseDoc, err := h.store.GetRevision(s.sceneId, s.base)
+	if err != nil {
+		return nil, err
+	}
+	merged, conflicts, err := h.merge(baseDoc, doc, headDoc)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return nil, &saveConflictError{sceneId: s.sceneId, head: head.Revision, conflicts: conflicts}
+	}
+	meta, err := h.store.Replace(s.sceneId, ``, author, head.Revision, merged)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := scene.ParseGraph(merged)
+	if err != nil {
+		return nil, err
+	}
+	// the merge brought in edits the clients have not seen so everyone is resynced
+	s.graph = graph
+	s.seq++
+	for _, c := range s.clients {
+		s.snapshotLocked(c)
+	}
+	return meta, nil
+}
+
+func (h *Hub) apply(s *session, c *client, msg *message) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	if msg.Op == nil {
+		c.sendMessage(&message{Type: `reject`, Id: msg.Id, Error: `missing op`})
+		return
+	}
+	direct, subtree := Targets(s.graph, msg.Op)
+	err := h.locks.Check(s.sceneId, s.graph, c.token, direct...)
+	if err == nil {
+		err = h.locks.CheckSubtree(s.sceneId, s.graph, c.token, subtree...)
+	}
+	if err == nil {
+		err = Apply(s.graph, msg.Op)
+	}
+	if err != nil {
+		c.sendMessage(&message{Type: `reject`, Id: msg.Id, Error: err.Error()})
+		return
+	}
+	s.seq++
+	s.dirty = true
+	s.broadcastLocked(&message{Type: `op`, Seq: s.seq, ClientId: c.id, Id: msg.Id, Op: msg.Op})
+}
+
+func (h *Hub) lock(s *session, c *client, msg *message) {
+	s.mtx.Lock()
+	_, err := h.locks.Acquire(s.sceneId, s.graph, msg.Uuid, msg.Subtree, c.token, c.id, c.name, time.Duration(msg.Ttl*float64(time.Second)))
+	s.mtx.Unlock()
+	if err != nil {
+		c.sendMessage(&message{Type: `reject`, Id: msg.Id, Error: err.Error()})
+		return
+	}
+	h.broadcastLocks(s)
+}
+
+func (h *Hub) broadcastLocks(s *session) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	s.broadcastLocked(&message{Type: `locks`, Locks: h.locks.Leases(s.sceneId)})
+}
+
+func (s *session) snapshot(c *client) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	s.snapshotLocked(c)
+}
+
+func (s *session) snapshotLocked(c *client) {
+	c.sendMessage(&message{Type: `snapshot`, Seq: s.seq, ClientId: c.id, Token: c.token, Document: s.graph.Document()})
+}
+
+func (s *session) broadcastLocked(msg *message) {
+	data, _ := json.Marshal(msg)
+	for _, c := range s.clients {
+		c.outbox.Send(data)
+	}
+}
+
+func (c *client) sendMessage(msg *message) {
+	data, _ := json.Marshal(msg)
+	c.outbox.Send(data)
+}
+
+var errClosed = errors.New(`collaboration is closed, the server is stopping`)
+
+type saveConflictError struct {
+	sceneId   string
+	head      int
+	conflicts []*scene.Conflict
+}
+
+func (e *saveConflictError) Error() string {
+	return `collaboration on scene ` + e.sceneId + ` conflicts with revision ` + strconv.Itoa(e.head) + ` saved elsewhere`
+}
+
+func isSaveConflict(err error) bool {
+	_, ok := err.(*saveConflictError)
+	return ok
+}
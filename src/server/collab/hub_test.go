@@ -0,0 +1,208 @@
+package collab
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/lock"
+	"github.com/robsix/3ditor/src/server/merge"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"github.com/robsix/3ditor/src/server/websocket/websockettest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A scene holding object A with the given name
+func storedDocument(name string) []byte {
+	return []byte(scenetest.Document(`0`, scenetest.Object(`A`, name, `M`, scenetest.Identity)))
+}
+
+// A hub serving collaboration on one stored scene at /{id}, closed by the returned func
+func newTestServer(t *testing.T) (*Hub, scene.Store, *httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir(``, `collab`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := scene.NewLocalStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	meta, err := store.Create(`scene`, `alice`, storedDocument(`a`))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	hub := NewHub(store, merge.Documents, lock.NewManager(), golog.NewDevNullLog())
+	handler := NewSubHandler(hub)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, strings.TrimPrefix(r.URL.Path, `/`), nil)
+	}))
+	return hub, store, server, meta.Id, func() {
+		hub.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func join(t *testing.T, url string) *websockettest.Conn {
+	conn, err := websockettest.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(t, conn, `snapshot`)
+	return conn
+}
+
+// Reads messages until one of type typ, failing on an error message
+func read(t *testing.T, conn *websockettest.Conn, typ string) *message {
+	for {
+		msg := &message{}
+		if err := conn.ReadJson(msg); err != nil {
+			t.Fatalf(`reading a %s message: %v`, typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+		if msg.Type == `error` {
+			t.Fatalf(`got error %q, want a %s message`, msg.Error, typ)
+		}
+	}
+}
+
+// Renames A over the socket, waiting for the op to be accepted
+func rename(t *testing.T, conn *websockettest.Conn, name string) {
+	if err := conn.WriteJson(map[string]interface{}{`type`: `op`, `id`: `1`, `op`: map[string]interface{}{`type`: `setObjectName`, `uuid`: `A`, `name`: name}}); err != nil {
+		t.Fatal(err)
+	}
+	read(t, conn, `op`)
+}
+
+func headName(t *testing.T, store scene.Store, id string) (int, string) {
+	meta, doc, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := scene.ParseGraph(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := g.Objects[`A`].Object[`name`].(string)
+	return meta.Revision, name
+}
+
+// The number of clients in the scene's session, -1 when there is no session
+func clients(hub *Hub, id string) int {
+	defer hub.mtx.Unlock()
+	hub.mtx.Lock()
+
+	s := hub.sessions[id]
+	if s == nil {
+		return -1
+	}
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	return len(s.clients)
+}
+
+func TestSaveConflict(t *testing.T) {
+	_, store, server, id, cleanup := newTestServer(t)
+	defer cleanup()
+
+	alice := join(t, server.URL+`/`+id)
+	defer alice.Close()
+	bob := join(t, server.URL+`/`+id)
+	defer bob.Close()
+	rename(t, alice, `alice`)
+	if _, err := store.Replace(id, ``, `carol`, 1, storedDocument(`carol`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alice.WriteJson(map[string]string{`type`: `save`}); err != nil {
+		t.Fatal(err)
+	}
+	// everyone is told, not only the client that asked for the save
+	for _, conn := range []*websockettest.Conn{alice, bob} {
+		conflict := read(t, conn, `conflict`)
+		if conflict.Revision != 2 || len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Property != `name` {
+			t.Errorf(`got conflict %+v, want one on the name against revision 2`, conflict)
+		}
+	}
+	if revision, name := headName(t, store, id); revision != 2 || name != `carol` {
+		t.Errorf(`got revision %d naming A %q after the conflict, want revision 2 naming it carol`, revision, name)
+	}
+
+	// the edits were kept and the next save replaces the conflicting revision
+	if err := alice.WriteJson(map[string]string{`type`: `save`}); err != nil {
+		t.Fatal(err)
+	}
+	if saved := read(t, alice, `saved`); saved.Revision != 3 {
+		t.Errorf(`got saved revision %d, want 3`, saved.Revision)
+	}
+	if revision, name := headName(t, store, id); revision != 3 || name != `alice` {
+		t.Errorf(`got revision %d naming A %q, want revision 3 naming it alice`, revision, name)
+	}
+}
+
+func TestLeaveKeepsUnsavedEdits(t *testing.T) {
+	hub, store, server, id, cleanup := newTestServer(t)
+	defer cleanup()
+
+	alice := join(t, server.URL+`/`+id)
+	rename(t, alice, `alice`)
+	if _, err := store.Replace(id, ``, `carol`, 1, storedDocument(`carol`)); err != nil {
+		t.Fatal(err)
+	}
+	// the last client leaving saves, which conflicts
+	alice.Close()
+	for deadline := time.Now().Add(5 * time.Second); clients(hub, id) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(`alice never left`)
+		}
+	}
+
+	bob, err := websockettest.Dial(server.URL + `/` + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	snapshot := read(t, bob, `snapshot`)
+	if !strings.Contains(asJson(snapshot.Document), `"name":"alice"`) {
+		t.Errorf(`got snapshot %s, want the unsaved rename`, asJson(snapshot.Document))
+	}
+}
+
+func TestClose(t *testing.T) {
+	hub, store, server, id, cleanup := newTestServer(t)
+	defer cleanup()
+
+	alice := join(t, server.URL+`/`+id)
+	defer alice.Close()
+	rename(t, alice, `alice`)
+
+	hub.Close()
+	if revision, name := headName(t, store, id); revision != 2 || name != `alice` {
+		t.Errorf(`got revision %d naming A %q after closing, want revision 2 naming it alice`, revision, name)
+	}
+	for {
+		if _, err := alice.Read(); err != nil {
+			break
+		}
+	}
+	hub.Close()
+
+	bob, err := websockettest.Dial(server.URL + `/` + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	msg := &message{}
+	if err := bob.ReadJson(msg); err != nil || msg.Type != `error` || msg.Error != errClosed.Error() {
+		t.Errorf(`got %+v (%v) joining a closed hub, want a closed error`, msg, err)
+	}
+}
@@ -0,0 +1,181 @@
+package collab
+
+import (
+	"github.com/robsix/3ditor/src/server/diff"
+	"github.com/robsix/3ditor/src/server/lock"
+	"github.com/robsix/3ditor/src/server/scene"
+)
+
+const (
+	AddObject           = `addObject`
+	RemoveObject        = `removeObject`
+	MoveObject          = `moveObject`
+	SetObjectName       = `setObjectName`
+	SetObjectProperties = `setObjectProperties`
+	SetGeometry         = `setGeometry`
+	SetMaterial         = `setMaterial`
+)
+
+// A single editor action, mirroring Editor.addObject, removeObject, moveObject, nameObject and the sidebar's
+// object, geometry and material edits:
+//
+//	addObject           object (Object3D.toJSON().object) with the geometries, materials, textures and images it uses,
+//	                    added under parent (the scene when empty) before the sibling before (last when empty)
+//	removeObject        removes uuid and its descendants
+//	moveObject          moves uuid under parent before the sibling before
+//	setObjectName       sets the name of uuid
+//	setObjectProperties sets each of properties on uuid, a null value deletes the property
+//	setGeometry         adds or replaces geometry by its uuid
+//	setMaterial         adds or replaces material by its uuid
+type Op struct {
+	Type       string                   `json:"type"`
+	Uuid       string                   `json:"uuid,omitempty"`
+	Parent     string                   `json:"parent,omitempty"`
+	Before     string                   `json:"before,omitempty"`
+	Name       *string                  `json:"name,omitempty"`
+	Object     map[string]interface{}   `json:"object,omitempty"`
+	Properties map[string]interface{}   `json:"properties,omitempty"`
+	Geometry   map[string]interface{}   `json:"geometry,omitempty"`
+	Material   map[string]interface{}   `json:"material,omitempty"`
+	Geometries []map[string]interface{} `json:"geometries,omitempty"`
+	Materials  []map[string]interface{} `json:"materials,omitempty"`
+	Textures   []map[string]interface{} `json:"textures,omitempty"`
+	Images     []map[string]interface{} `json:"images,omitempty"`
+}
+
+// Applies op to g, leaving g untouched when op is not valid against its current state
+func Apply(g *scene.Graph, op *Op) error {
+	switch op.Type {
+	case AddObject:
+		parent := op.Parent
+		if parent == `` {
+			parent = g.Root
+		}
+		parts := []struct {
+			kind  string
+			items []map[string]interface{}
+			index map[string]map[string]interface{}
+		}{
+			{`geometry`, op.Geometries, g.Geometries},
+			{`material`, op.Materials, g.Materials},
+			{`texture`, op.Textures, g.Textures},
+			{`image`, op.Images, g.Images},
+		}
+		// geometries and materials the add replaces are checked against the leases of the objects using them by
+		// Targets, textures and images are not tracked by leases so may only be added, not replaced
+		for _, part := range parts[2:] {
+			for _, item := range part.items {
+				uuid, _ := item[`uuid`].(string)
+				if existing := part.index[uuid]; existing != nil && !diff.Equal(existing, item) {
+					return &invalidOpError{reason: `addObject can not replace the existing ` + part.kind + ` ` + uuid}
+				}
+			}
+		}
+		if err := g.Insert(op.Object, parent, op.Before); err != nil {
+			return err
+		}
+		for _, part := range parts {
+			for _, item := range part.items {
+				if uuid, ok := item[`uuid`].(string); ok {
+					part.index[uuid] = item
+				}
+			}
+		}
+		return nil
+	case RemoveObject:
+		return g.Remove(op.Uuid)
+	case MoveObject:
+		parent := op.Parent
+		if parent == `` {
+			parent = g.Root
+		}
+		return g.Move(op.Uuid, parent, op.Before)
+	case SetObjectName:
+		node := g.Objects[op.Uuid]
+		if node == nil || op.Name == nil {
+			return &invalidOpError{reason: `setObjectName needs an existing uuid and a name`}
+		}
+		node.Object[`name`] = *op.Name
+		return nil
+	case SetObjectProperties:
+		node := g.Objects[op.Uuid]
+		if node == nil {
+			return &invalidOpError{reason: `no such object ` + op.Uuid}
+		}
+		for key := range op.Properties {
+			if key == `uuid` || key == `children` {
+				return &invalidOpError{reason: `property ` + key + ` can not be set directly`}
+			}
+		}
+		for key, val := range op.Properties {
+			if val == nil {
+				delete(node.Object, key)
+			} else {
+				node.Object[key] = val
+			}
+		}
+		return nil
+	case SetGeometry:
+		return setIndexed(g.Geometries, op.Geometry)
+	case SetMaterial:
+		return setIndexed(g.Materials, op.Material)
+	}
+	return &invalidOpError{reason: `unknown op type ` + op.Type}
+}
+
+// The objects op changes directly and the objects it adds children to, used to check op against edit leases
+func Targets(g *scene.Graph, op *Op) ([]string, []string) {
+	parent := op.Parent
+	if parent == `` {
+		parent = g.Root
+	}
+	switch op.Type {
+	case AddObject:
+		// a shared geometry or material sent again unchanged is left alone, a changed one is an edit of its users
+		direct := []string{}
+		for _, part := range []struct {
+			key   string
+			items []map[string]interface{}
+			index map[string]map[string]interface{}
+		}{
+			{`geometry`, op.Geometries, g.Geometries},
+			{`material`, op.Materials, g.Materials},
+		} {
+			for _, item := range part.items {
+				uuid, _ := item[`uuid`].(string)
+				if existing := part.index[uuid]; existing != nil && !diff.Equal(existing, item) {
+					direct = append(direct, lock.Users(g, part.key, uuid)...)
+				}
+			}
+		}
+		return direct, []string{parent}
+	case RemoveObject:
+		return g.Subtree(op.Uuid), nil
+	case MoveObject:
+		return []string{op.Uuid}, []string{parent}
+	case SetObjectName, SetObjectProperties:
+		return []string{op.Uuid}, nil
+	case SetGeometry:
+		uuid, _ := op.Geometry[`uuid`].(string)
+		return lock.Users(g, `geometry`, uuid), nil
+	case SetMaterial:
+		uuid, _ := op.Material[`uuid`].(string)
+		return lock.Users(g, `material`, uuid), nil
+	}
+	return nil, nil
+}
+
+func setIndexed(index map[string]map[string]interface{}, item map[string]interface{}) error {
+	uuid, _ := item[`uuid`].(string)
+	if uuid == `` {
+		return &invalidOpError{reason: `item without a uuid`}
+	}
+	index[uuid] = item
+	return nil
+}
+
+type invalidOpError struct {
+	reason string
+}
+
+func (e *invalidOpError) Error() string { return `Invalid op: ` + e.reason }
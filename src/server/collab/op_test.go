@@ -0,0 +1,282 @@
+package collab
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/scene"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// S holds A, which holds A1, then B and C. A and B use material M, C uses material N and every object geometry G.
+const testDocument = `{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"metadata":{},
+	"geometries":[{"uuid":"G","type":"BoxGeometry","width":1,"height":1,"depth":1}],
+	"materials":[{"uuid":"M","type":"MeshStandardMaterial","color":0},{"uuid":"N","type":"MeshStandardMaterial","color":0}],
+	"textures":[{"uuid":"T","image":"I"}],
+	"images":[{"uuid":"I","url":"data:image/png;base64,AA=="}],
+	"object":{"uuid":"S","type":"Scene","children":[
+		{"uuid":"A","type":"Mesh","geometry":"G","material":"M","children":[{"uuid":"A1","type":"Mesh","geometry":"G","material":"M"}]},
+		{"uuid":"B","type":"Mesh","geometry":"G","material":"M"},
+		{"uuid":"C","type":"Mesh","geometry":"G","material":"N"}]}}}`
+
+func parseOp(t *testing.T, text string) *Op {
+	op := &Op{}
+	if err := json.Unmarshal([]byte(text), op); err != nil {
+		t.Fatalf(`bad op %s: %v`, text, err)
+	}
+	return op
+}
+
+// The object tree as uuids, or names when set, with children in brackets
+func tree(g *scene.Graph, uuid string) string {
+	node := g.Objects[uuid]
+	label := uuid
+	if name, _ := node.Object[`name`].(string); name != `` {
+		label = name
+	}
+	if len(node.Children) == 0 {
+		return label
+	}
+	children := make([]string, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = tree(g, child)
+	}
+	return label + `[` + strings.Join(children, ` `) + `]`
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		// applied in order, as the hub does in seq order
+		ops []string
+		// the ops expected to be rejected, by index
+		invalid []int
+		want    string
+	}{
+		{
+			name: `add last`,
+			ops:  []string{`{"type":"addObject","object":{"uuid":"D","type":"Mesh"}}`},
+			want: `S[A[A1] B C D]`,
+		},
+		{
+			name: `add before a sibling with its own children`,
+			ops:  []string{`{"type":"addObject","before":"B","object":{"uuid":"D","type":"Group","children":[{"uuid":"D1","type":"Mesh"}]}}`},
+			want: `S[A[A1] D[D1] B C]`,
+		},
+		{
+			name: `add under an object`,
+			ops:  []string{`{"type":"addObject","parent":"A","object":{"uuid":"D","type":"Mesh"}}`},
+			want: `S[A[A1 D] B C]`,
+		},
+		{
+			name:    `add under a missing parent`,
+			ops:     []string{`{"type":"addObject","parent":"Z","object":{"uuid":"D","type":"Mesh"}}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name:    `add an existing uuid`,
+			ops:     []string{`{"type":"addObject","object":{"uuid":"D","type":"Group","children":[{"uuid":"B","type":"Mesh"}]}}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name:    `add the same uuid twice`,
+			ops:     []string{`{"type":"addObject","object":{"uuid":"D","type":"Group","children":[{"uuid":"E","type":"Mesh"},{"uuid":"E","type":"Mesh"}]}}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name: `add with the existing texture`,
+			ops: []string{`{"type":"addObject","object":{"uuid":"D","type":"Mesh"},` +
+				`"textures":[{"uuid":"T","image":"I"}],"images":[{"uuid":"I","url":"data:image/png;base64,AA=="}]}`},
+			want: `S[A[A1] B C D]`,
+		},
+		{
+			name:    `add replacing a texture`,
+			ops:     []string{`{"type":"addObject","object":{"uuid":"D","type":"Mesh"},"textures":[{"uuid":"T","image":"J"}]}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name: `move before a sibling`,
+			ops:  []string{`{"type":"moveObject","uuid":"C","before":"A"}`},
+			want: `S[C A[A1] B]`,
+		},
+		{
+			name:    `move into its own subtree`,
+			ops:     []string{`{"type":"moveObject","uuid":"A","parent":"A1"}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name:    `move the root`,
+			ops:     []string{`{"type":"moveObject","uuid":"S","parent":"A"}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name: `ops on a removed object`,
+			ops: []string{
+				`{"type":"removeObject","uuid":"A"}`,
+				`{"type":"setObjectName","uuid":"A1","name":"child"}`,
+				`{"type":"moveObject","uuid":"A","parent":"B"}`,
+				`{"type":"setObjectName","uuid":"B","name":"b"}`,
+			},
+			invalid: []int{1, 2},
+			want:    `S[b C]`,
+		},
+		{
+			name: `a move then a remove of the new parent`,
+			ops: []string{
+				`{"type":"moveObject","uuid":"C","parent":"B"}`,
+				`{"type":"removeObject","uuid":"B"}`,
+				`{"type":"setObjectName","uuid":"C","name":"c"}`,
+			},
+			invalid: []int{2},
+			want:    `S[A[A1]]`,
+		},
+		{
+			name: `the last property change wins`,
+			ops: []string{
+				`{"type":"setObjectProperties","uuid":"B","properties":{"name":"first"}}`,
+				`{"type":"setObjectProperties","uuid":"B","properties":{"name":"second"}}`,
+			},
+			want: `S[A[A1] second C]`,
+		},
+		{
+			name: `a null property is deleted`,
+			ops: []string{
+				`{"type":"setObjectName","uuid":"B","name":"b"}`,
+				`{"type":"setObjectProperties","uuid":"B","properties":{"name":null}}`,
+			},
+			want: `S[A[A1] B C]`,
+		},
+		{
+			name:    `setting children directly`,
+			ops:     []string{`{"type":"setObjectProperties","uuid":"B","properties":{"name":"b","children":[]}}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name:    `geometry without a uuid`,
+			ops:     []string{`{"type":"setGeometry","geometry":{"type":"BoxGeometry"}}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+		{
+			name:    `unknown type`,
+			ops:     []string{`{"type":"renameScene","name":"x"}`},
+			invalid: []int{0},
+			want:    `S[A[A1] B C]`,
+		},
+	}
+	for _, test := range tests {
+		g, err := scene.ParseGraph([]byte(testDocument))
+		if err != nil {
+			t.Fatal(err)
+		}
+		invalid := []int{}
+		for i, text := range test.ops {
+			if err := Apply(g, parseOp(t, text)); err != nil {
+				invalid = append(invalid, i)
+			}
+		}
+		if test.invalid == nil {
+			test.invalid = []int{}
+		}
+		if asJson(invalid) != asJson(test.invalid) {
+			t.Errorf(`%s: got ops %v rejected, want %v`, test.name, invalid, test.invalid)
+		}
+		if got := tree(g, g.Root); got != test.want {
+			t.Errorf(`%s: got %s, want %s`, test.name, got, test.want)
+		}
+		for uuid, node := range g.Objects {
+			if node.Parent != `` && g.Objects[node.Parent] == nil {
+				t.Errorf(`%s: object %s left with a missing parent`, test.name, uuid)
+			}
+		}
+		if len(g.Objects) != len(g.Subtree(g.Root)) {
+			t.Errorf(`%s: %d objects indexed but %d reachable`, test.name, len(g.Objects), len(g.Subtree(g.Root)))
+		}
+	}
+}
+
+func asJson(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      string
+		direct  []string
+		parents []string
+	}{
+		{
+			name:    `add`,
+			op:      `{"type":"addObject","object":{"uuid":"D","type":"Mesh"}}`,
+			direct:  []string{},
+			parents: []string{`S`},
+		},
+		{
+			name:    `add resending a shared material`,
+			op:      `{"type":"addObject","parent":"C","object":{"uuid":"D","type":"Mesh","material":"M"},"materials":[{"uuid":"M","type":"MeshStandardMaterial","color":0}]}`,
+			direct:  []string{},
+			parents: []string{`C`},
+		},
+		{
+			name:    `add changing a shared material`,
+			op:      `{"type":"addObject","object":{"uuid":"D","type":"Mesh","material":"M"},"materials":[{"uuid":"M","type":"MeshStandardMaterial","color":255}]}`,
+			direct:  []string{`A`, `A1`, `B`},
+			parents: []string{`S`},
+		},
+		{
+			name:    `add changing a shared geometry`,
+			op:      `{"type":"addObject","object":{"uuid":"D","type":"Mesh","geometry":"G"},"geometries":[{"uuid":"G","type":"BoxGeometry","width":2,"height":1,"depth":1}]}`,
+			direct:  []string{`A`, `A1`, `B`, `C`},
+			parents: []string{`S`},
+		},
+		{
+			name:   `remove`,
+			op:     `{"type":"removeObject","uuid":"A"}`,
+			direct: []string{`A`, `A1`},
+		},
+		{
+			name:    `move`,
+			op:      `{"type":"moveObject","uuid":"C","parent":"B"}`,
+			direct:  []string{`C`},
+			parents: []string{`B`},
+		},
+		{
+			name:   `name`,
+			op:     `{"type":"setObjectName","uuid":"B","name":"b"}`,
+			direct: []string{`B`},
+		},
+		{
+			name:   `material`,
+			op:     `{"type":"setMaterial","material":{"uuid":"N","type":"MeshBasicMaterial"}}`,
+			direct: []string{`C`},
+		},
+		{
+			name:   `new geometry`,
+			op:     `{"type":"setGeometry","geometry":{"uuid":"H","type":"SphereGeometry"}}`,
+			direct: []string{},
+		},
+	}
+	g, err := scene.ParseGraph([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		direct, parents := Targets(g, parseOp(t, test.op))
+		sort.Strings(direct)
+		if direct == nil {
+			direct = []string{}
+		}
+		if asJson(direct) != asJson(test.direct) || asJson(parents) != asJson(test.parents) {
+			t.Errorf(`%s: got %v and parents %v, want %v and parents %v`, test.name, direct, parents, test.direct, test.parents)
+		}
+	}
+}
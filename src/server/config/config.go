@@ -0,0 +1,392 @@
+/*
+The server's configuration, read from conf.json then overridden by THREEDITOR_ environment variables then by command
+line flags. Every setting has the same dotted key path in all three: "retention.keepLast" in conf.json is
+THREEDITOR_RETENTION_KEEP_LAST in the environment and -retention.keepLast on the command line.
+*/
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the conf file read from the working directory when neither -conf nor THREEDITOR_CONF name another
+	DefaultFile = `conf.json`
+	envPrefix   = `THREEDITOR_`
+	// names the conf file in the environment, it is not a setting so it has no key in the file itself
+	confEnv = envPrefix + `CONF`
+)
+
+type Config struct {
+	Listen          string    `json:"listen" usage:"address to serve on, host:port"`
+	ShutdownSeconds int       `json:"shutdownSeconds" usage:"seconds to wait for requests to finish when stopping"`
+	Tls             Tls       `json:"tls"`
+	PublicDir       Path      `json:"publicDir" usage:"directory the client is served from"`
+	EmbeddedClient  bool      `json:"embeddedClient" usage:"serve the client built into the binary instead of publicDir, when it has one"`
+	SceneDir        Path      `json:"sceneDir" usage:"directory scenes are stored in"`
+	AssetDir        Path      `json:"assetDir" usage:"directory assets are stored in"`
+	ThumbnailDir    Path      `json:"thumbnailDir" usage:"directory scene thumbnails are cached in"`
+	Log             Log       `json:"log"`
+	Limits          Limits    `json:"limits"`
+	Retention       Retention `json:"retention"`
+	Features        Features  `json:"features"`
+}
+
+// HTTPS is served when both files are set or, without them, with a generated self signed certificate when SelfSigned
+// is set
+type Tls struct {
+	Cert       Path `json:"cert" usage:"PEM certificate chain file to serve HTTPS with"`
+	Key        Path `json:"key" usage:"PEM private key file of the certificate"`
+	SelfSigned bool `json:"selfSigned" usage:"serve HTTPS with a generated development certificate when cert and key are not set"`
+	// the certificate is kept so browsers only have to be told to trust it once
+	SelfSignedDir Path `json:"selfSignedDir" usage:"directory the development certificate is kept in"`
+	// localhost and the machine's own name and addresses are always included
+	Hosts string `json:"hosts" usage:"comma separated extra host names and addresses the development certificate is for"`
+	// e.g. ":80", empty serves no plain HTTP at all
+	RedirectHttp string `json:"redirectHttp" usage:"address to serve plain HTTP on, redirecting every request to HTTPS"`
+}
+
+// Whether the server serves HTTPS
+func (t *Tls) Enabled() bool {
+	return t.Cert != `` || t.SelfSigned
+}
+
+// The extra hosts of the development certificate
+func (t *Tls) HostList() []string {
+	hosts := []string{}
+	for _, host := range strings.Split(t.Hosts, `,`) {
+		if host = strings.TrimSpace(host); host != `` {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+type Log struct {
+	// console prints entries, local prints them and keeps the day's entries in Dir, none discards them
+	Output      string `json:"output" usage:"where log entries go: console, local or none"`
+	Dir         Path   `json:"dir" usage:"directory the local log keeps entries in"`
+	Level       string `json:"level" usage:"least severe level logged: info, warning, error or critical"`
+	LineSpacing int    `json:"lineSpacing" usage:"blank lines printed after each entry"`
+}
+
+type Limits struct {
+	// the handlers read whole bodies into memory so this bounds what a single request can take
+	RequestMb int `json:"requestMb" usage:"largest request body accepted in megabytes, 0 is no limit"`
+}
+
+type Retention struct {
+	KeepLast        int `json:"keepLast" usage:"newest revisions of a scene always kept"`
+	KeepDays        int `json:"keepDays" usage:"days every revision is kept for"`
+	KeepDailyDays   int `json:"keepDailyDays" usage:"days the last revision of each day is kept for"`
+	AssetGraceHours int `json:"assetGraceHours" usage:"hours unreferenced assets are kept for"`
+	IntervalHours   int `json:"intervalHours" usage:"hours between prunes, 0 only prunes from the prune command"`
+}
+
+// Optional parts of the server that can be switched off
+type Features struct {
+	Collab     bool `json:"collab" usage:"serve live collaborative editing"`
+	Presence   bool `json:"presence" usage:"serve who is viewing each scene"`
+	Thumbnails bool `json:"thumbnails" usage:"render scene thumbnails"`
+	Turntable  bool `json:"turntable" usage:"render turntable previews"`
+	Lod        bool `json:"lod" usage:"generate levels of detail"`
+	Repair     bool `json:"repair" usage:"check and repair meshes"`
+	Import     bool `json:"import" usage:"import models"`
+}
+
+// A file system path, either a string or, as conf.json has always written them, an array of path segments. Relative
+// paths are relative to the working directory.
+type Path string
+
+// The settings the server uses when nothing overrides them
+func Default() *Config {
+	return &Config{
+		Listen:          `:8080`,
+		ShutdownSeconds: 30,
+		PublicDir:       Path(filepath.Join(`..`, `client`)),
+		EmbeddedClient:  true,
+		Tls:             Tls{SelfSignedDir: Path(filepath.Join(`data`, `tls`))},
+		SceneDir:        Path(filepath.Join(`data`, `scenes`)),
+		AssetDir:        Path(filepath.Join(`data`, `assets`)),
+		ThumbnailDir:    Path(filepath.Join(`data`, `thumbnails`)),
+		Log:             Log{Output: `console`, Dir: Path(filepath.Join(`data`, `log`)), Level: `info`},
+		Retention:       Retention{KeepLast: 10, KeepDays: 7, KeepDailyDays: 90, AssetGraceHours: 24, IntervalHours: 24},
+		Features:        Features{Collab: true, Presence: true, Thumbnails: true, Turntable: true, Lod: true, Repair: true, Import: true},
+	}
+}
+
+// A setting that is not valid, source says where its value came from
+type invalidError struct {
+	source string
+	key    string
+	reason string
+}
+
+func (e *invalidError) Error() string {
+	return `invalid configuration, ` + e.source + `: ` + e.key + `: ` + e.reason
+}
+
+// A problem with a whole source of settings rather than one setting
+type sourceError struct {
+	source string
+	reason string
+}
+
+func (e *sourceError) Error() string {
+	return `invalid configuration, ` + e.source + `: ` + e.reason
+}
+
+// Reads the configuration for the working directory wd, args are the command line arguments after the program name
+// and env the environment as os.Environ returns it. Relative paths in the result are made absolute against wd. It
+// returns flag.ErrHelp when args ask for help, after printing the usage of every setting to stderr.
+func Load(wd string, args []string, env []string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+	sources := map[string]string{}
+
+	flags := flag.NewFlagSet(`server`, flag.ContinueOnError)
+	file := flags.String(`conf`, ``, `conf file to read, defaults to THREEDITOR_CONF or `+DefaultFile)
+	for _, s := range settings {
+		flags.Var(&flagValue{setting: s, sources: sources}, s.key, s.usage)
+	}
+
+	// the flags apply over the conf file so -conf is picked out of args before they are parsed
+	explicit := false
+	for _, kv := range env {
+		if strings.HasPrefix(kv, confEnv+`=`) {
+			*file, explicit = strings.TrimPrefix(kv, confEnv+`=`), true
+		}
+	}
+	if name := findConfFlag(args); name != `` {
+		*file, explicit = name, true
+	}
+	if *file == `` {
+		*file = DefaultFile
+	}
+	path := *file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(wd, path)
+	}
+	if err := readFile(path, *file, explicit, c, sources); err != nil {
+		return nil, err
+	}
+
+	byEnv := map[string]*setting{}
+	for _, s := range settings {
+		byEnv[s.env] = s
+	}
+	for _, kv := range env {
+		name := strings.SplitN(kv, `=`, 2)[0]
+		if !strings.HasPrefix(name, envPrefix) || name == confEnv {
+			continue
+		}
+		s := byEnv[name]
+		if s == nil {
+			return nil, &sourceError{source: `environment ` + name, reason: `no such setting`}
+		}
+		source := `environment ` + name
+		if err := s.parse(strings.TrimPrefix(kv, name+`=`)); err != nil {
+			return nil, &invalidError{source: source, key: s.key, reason: err.Error()}
+		}
+		sources[s.key] = source
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, &invalidError{source: `command line`, key: flags.Arg(0), reason: `unexpected argument`}
+	}
+
+	c.resolve(wd)
+	if err := c.validate(sources); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// The value of a -conf flag in args, they have not been parsed yet
+func findConfFlag(args []string) string {
+	for i, arg := range args {
+		if arg == `--` {
+			break
+		}
+		name := strings.TrimLeft(arg, `-`)
+		if len(arg)-len(name) < 1 || len(arg)-len(name) > 2 {
+			continue
+		}
+		if strings.HasPrefix(name, `conf=`) {
+			return strings.TrimPrefix(name, `conf=`)
+		}
+		if name == `conf` && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ``
+}
+
+// Reads the conf file at path into c, a missing file is only an error when it was named explicitly
+func readFile(path, name string, explicit bool, c *Config, sources map[string]string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	} else if err != nil {
+		return &sourceError{source: name, reason: err.Error()}
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			// the offset is just past the byte that could not be read
+			line, column := position(data, syntax.Offset-1)
+			return &sourceError{source: name, reason: `line ` + strconv.Itoa(line) + ` column ` + strconv.Itoa(column) + `: ` + err.Error()}
+		}
+		return &sourceError{source: name, reason: err.Error()}
+	}
+	return decodeJson(name, ``, raw, reflect.ValueOf(c).Elem(), func(key string) { sources[key] = name })
+}
+
+// The 1 based line and column of the byte at offset
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	} else if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line := 1 + strings.Count(string(before), "\n")
+	column := int(offset) - strings.LastIndexByte(string(before), '\n')
+	return line, column
+}
+
+func (c *Config) resolve(wd string) {
+	for _, p := range []*Path{&c.PublicDir, &c.SceneDir, &c.AssetDir, &c.ThumbnailDir, &c.Log.Dir, &c.Tls.Cert, &c.Tls.Key, &c.Tls.SelfSignedDir} {
+		if *p != `` && !filepath.IsAbs(string(*p)) {
+			*p = Path(filepath.Join(wd, string(*p)))
+		}
+	}
+}
+
+func (c *Config) validate(sources map[string]string) error {
+	fail := func(key, reason string) error {
+		source := sources[key]
+		if source == `` {
+			source = `default`
+		}
+		return &invalidError{source: source, key: key, reason: reason}
+	}
+
+	if reason := checkAddress(c.Listen); reason != `` {
+		return fail(`listen`, reason)
+	}
+
+	if c.Tls.Cert != `` && c.Tls.Key == `` {
+		return fail(`tls.cert`, `needs tls.key to be set too`)
+	} else if c.Tls.Key != `` && c.Tls.Cert == `` {
+		return fail(`tls.key`, `needs tls.cert to be set too`)
+	}
+	for _, file := range []struct {
+		key  string
+		path Path
+	}{{`tls.cert`, c.Tls.Cert}, {`tls.key`, c.Tls.Key}} {
+		if file.path == `` {
+			continue
+		}
+		if info, err := os.Stat(string(file.path)); err != nil {
+			return fail(file.key, err.Error())
+		} else if info.IsDir() {
+			return fail(file.key, string(file.path)+` is a directory`)
+		}
+	}
+	if c.Tls.SelfSigned && c.Tls.SelfSignedDir == `` {
+		return fail(`tls.selfSignedDir`, `must be set when tls.selfSigned is`)
+	}
+	if c.Tls.RedirectHttp != `` {
+		if !c.Tls.Enabled() {
+			return fail(`tls.redirectHttp`, `needs HTTPS, set tls.cert and tls.key or tls.selfSigned`)
+		} else if reason := checkAddress(c.Tls.RedirectHttp); reason != `` {
+			return fail(`tls.redirectHttp`, reason)
+		} else if c.Tls.RedirectHttp == c.Listen {
+			return fail(`tls.redirectHttp`, `must not be the listen address`)
+		}
+	}
+
+	for _, dir := range []struct {
+		key  string
+		path Path
+	}{{`publicDir`, c.PublicDir}, {`sceneDir`, c.SceneDir}, {`assetDir`, c.AssetDir}, {`thumbnailDir`, c.ThumbnailDir}} {
+		if dir.path == `` {
+			return fail(dir.key, `must be set`)
+		}
+	}
+
+	switch c.Log.Output {
+	case `console`, `none`:
+	case `local`:
+		if c.Log.Dir == `` {
+			return fail(`log.dir`, `must be set when log.output is local`)
+		}
+	default:
+		return fail(`log.output`, `must be console, local or none, not "`+c.Log.Output+`"`)
+	}
+	if _, known := levels[strings.ToLower(c.Log.Level)]; !known {
+		return fail(`log.level`, `must be info, warning, error or critical, not "`+c.Log.Level+`"`)
+	}
+	if c.Log.LineSpacing < 0 {
+		return fail(`log.lineSpacing`, `must not be negative`)
+	}
+
+	for _, setting := range []struct {
+		key string
+		n   int
+	}{
+		{`shutdownSeconds`, c.ShutdownSeconds},
+		{`limits.requestMb`, c.Limits.RequestMb},
+		{`retention.keepLast`, c.Retention.KeepLast},
+		{`retention.keepDays`, c.Retention.KeepDays},
+		{`retention.keepDailyDays`, c.Retention.KeepDailyDays},
+		{`retention.assetGraceHours`, c.Retention.AssetGraceHours},
+		{`retention.intervalHours`, c.Retention.IntervalHours},
+	} {
+		if setting.n < 0 {
+			return fail(setting.key, `must not be negative`)
+		}
+	}
+	return nil
+}
+
+// Why addr is not an address that can be listened on, empty when it is one
+func checkAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err.Error()
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		if _, err := net.LookupPort(`tcp`, port); err != nil {
+			return `invalid port "` + port + `"`
+		}
+	}
+	if strings.ContainsAny(host, ` /`) {
+		return `invalid host "` + host + `"`
+	}
+	return ``
+}
+
+// The keys of the settings whose values differ between c and o
+func (c *Config) Changed(o *Config) []string {
+	changed := []string{}
+	theirs := o.settings()
+	for i, s := range c.settings() {
+		if fmtValue(s.value) != fmtValue(theirs[i].value) {
+			changed = append(changed, s.key)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	wd, err := ioutil.TempDir(``, `config`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+	if err := ioutil.WriteFile(filepath.Join(wd, `other.json`), []byte(`{"listen": ":9000"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// the conf.json in the working directory, none when empty
+		conf string
+		env  []string
+		args []string
+		// the setting checked and its expected value, or the error expected instead
+		key  string
+		want string
+		err  string
+	}{
+		{
+			name: `defaults`,
+			key:  `retention.keepLast`,
+			want: `10`,
+		},
+		{
+			name: `conf file`,
+			conf: `{"retention": {"keepLast": 3}}`,
+			key:  `retention.keepLast`,
+			want: `3`,
+		},
+		{
+			name: `path segments`,
+			conf: `{"sceneDir": ["store", "scenes"]}`,
+			key:  `sceneDir`,
+			want: filepath.Join(wd, `store`, `scenes`),
+		},
+		{
+			name: `absolute path`,
+			conf: `{"sceneDir": "/srv/scenes"}`,
+			key:  `sceneDir`,
+			want: `/srv/scenes`,
+		},
+		{
+			name: `environment over conf file`,
+			conf: `{"retention": {"keepLast": 3}}`,
+			env:  []string{`THREEDITOR_RETENTION_KEEP_LAST=4`, `PATH=/bin`},
+			key:  `retention.keepLast`,
+			want: `4`,
+		},
+		{
+			name: `flag over environment`,
+			env:  []string{`THREEDITOR_RETENTION_KEEP_LAST=4`},
+			args: []string{`-retention.keepLast`, `5`},
+			key:  `retention.keepLast`,
+			want: `5`,
+		},
+		{
+			name: `bool flag`,
+			args: []string{`-features.collab=false`},
+			key:  `features.collab`,
+			want: `false`,
+		},
+		{
+			name: `conf flag`,
+			args: []string{`-conf`, `other.json`},
+			key:  `listen`,
+			want: `:9000`,
+		},
+		{
+			name: `conf environment`,
+			env:  []string{`THREEDITOR_CONF=other.json`},
+			key:  `listen`,
+			want: `:9000`,
+		},
+		{
+			name: `missing named conf file`,
+			args: []string{`-conf=missing.json`},
+			err:  `invalid configuration, missing.json: open ` + filepath.Join(wd, `missing.json`) + `: no such file or directory`,
+		},
+		{
+			name: `syntax error`,
+			conf: "{\n  \"listen\": :8080\n}",
+			err:  `invalid configuration, conf.json: line 2 column 13: invalid character ':' looking for beginning of value`,
+		},
+		{
+			name: `misspelt setting`,
+			conf: `{"retention": {"keeplast": 3}}`,
+			err:  `invalid configuration, conf.json: retention.keeplast: no such setting`,
+		},
+		{
+			name: `wrong type`,
+			conf: `{"retention": {"keepLast": "3"}}`,
+			err:  `invalid configuration, conf.json: retention.keepLast: expected a whole number, got "3"`,
+		},
+		{
+			name: `fraction`,
+			conf: `{"retention": {"keepLast": 2.5}}`,
+			err:  `invalid configuration, conf.json: retention.keepLast: expected a whole number, got 2.5`,
+		},
+		{
+			name: `bad path segment`,
+			conf: `{"sceneDir": ["data", 1]}`,
+			err:  `invalid configuration, conf.json: sceneDir: path segment 1: expected a string, got 1`,
+		},
+		{
+			name: `not an object`,
+			conf: `[]`,
+			err:  `invalid configuration, conf.json: expected an object, got an array`,
+		},
+		{
+			name: `unknown environment setting`,
+			env:  []string{`THREEDITOR_RETENTION_KEEP=4`},
+			err:  `invalid configuration, environment THREEDITOR_RETENTION_KEEP: no such setting`,
+		},
+		{
+			name: `bad environment value`,
+			env:  []string{`THREEDITOR_FEATURES_LOD=maybe`},
+			err:  `invalid configuration, environment THREEDITOR_FEATURES_LOD: features.lod: expected true or false, got "maybe"`,
+		},
+		{
+			name: `negative setting`,
+			args: []string{`-retention.keepDays=-1`},
+			err:  `invalid configuration, flag -retention.keepDays: retention.keepDays: must not be negative`,
+		},
+		{
+			name: `bad listen address`,
+			conf: `{"listen": "8080"}`,
+			err:  `invalid configuration, conf.json: listen: address 8080: missing port in address`,
+		},
+		{
+			name: `cert without key`,
+			args: []string{`-tls.cert=cert.pem`},
+			err:  `invalid configuration, flag -tls.cert: tls.cert: needs tls.key to be set too`,
+		},
+		{
+			name: `redirect without HTTPS`,
+			args: []string{`-tls.redirectHttp=:80`},
+			err:  `invalid configuration, flag -tls.redirectHttp: tls.redirectHttp: needs HTTPS, set tls.cert and tls.key or tls.selfSigned`,
+		},
+		{
+			name: `unknown log output`,
+			env:  []string{`THREEDITOR_LOG_OUTPUT=syslog`},
+			err:  `invalid configuration, environment THREEDITOR_LOG_OUTPUT: log.output: must be console, local or none, not "syslog"`,
+		},
+		{
+			name: `stray argument`,
+			args: []string{`serve`},
+			err:  `invalid configuration, command line: serve: unexpected argument`,
+		},
+	}
+	for _, test := range tests {
+		conf := filepath.Join(wd, DefaultFile)
+		os.Remove(conf)
+		if test.conf != `` {
+			if err := ioutil.WriteFile(conf, []byte(test.conf), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c, err := Load(wd, test.args, test.env)
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		got := ``
+		for _, s := range c.settings() {
+			if s.key == test.key {
+				got = fmtValue(s.value)
+			}
+		}
+		if got != test.want {
+			t.Errorf(`%s: got %s = %q, want %q`, test.name, test.key, got, test.want)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{`nothing`, func(c *Config) {}, []string{}},
+		{`nested`, func(c *Config) { c.Retention.KeepLast = 1 }, []string{`retention.keepLast`}},
+		{`several`, func(c *Config) { c.Listen, c.Features.Lod = `:9000`, false }, []string{`listen`, `features.lod`}},
+	}
+	for _, test := range tests {
+		changed := Default()
+		test.change(changed)
+		got := Default().Changed(changed)
+		if len(got) != len(test.want) {
+			t.Errorf(`%s: got %v, want %v`, test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf(`%s: got %v, want %v`, test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var pathType = reflect.TypeOf(Path(``))
+
+// A single value of the configuration, found by walking the fields of Config
+type setting struct {
+	key   string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+type typeError struct {
+	want string
+	got  string
+}
+
+func (e *typeError) Error() string {
+	return `expected ` + e.want + `, got ` + e.got
+}
+
+// Every setting in c, in the order the fields are declared
+func (c *Config) settings() []*setting {
+	var settings []*setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := prefix + jsonName(field)
+			if field.Type.Kind() == reflect.Struct {
+				walk(key+`.`, v.Field(i))
+				continue
+			}
+			settings = append(settings, &setting{key: key, env: envName(key), usage: field.Tag.Get(`usage`), value: v.Field(i)})
+		}
+	}
+	walk(``, reflect.ValueOf(c).Elem())
+	return settings
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get(`json`), `,`)[0]
+}
+
+// THREEDITOR_ followed by the key in upper snake case
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for _, r := range key {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// Sets the setting from the text of an environment variable or flag
+func (s *setting) parse(text string) error {
+	switch s.value.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return &typeError{want: `true or false`, got: strconv.Quote(text)}
+		}
+		s.value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return &typeError{want: `a whole number`, got: strconv.Quote(text)}
+		}
+		s.value.SetInt(int64(n))
+	default:
+		s.value.SetString(text)
+	}
+	return nil
+}
+
+// Decodes the json value raw into v, the struct or setting at key, calling set with the key of each setting it sets.
+// Keys that are not settings are an error so misspelt settings are not silently ignored.
+func decodeJson(source, key string, raw interface{}, v reflect.Value, set func(key string)) error {
+	fail := func(reason string) error {
+		if key == `` {
+			return &sourceError{source: source, reason: reason}
+		}
+		return &invalidError{source: source, key: key, reason: reason}
+	}
+	mismatch := func(want string) error {
+		return fail((&typeError{want: want, got: describe(raw)}).Error())
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return mismatch(`an object`)
+		}
+		fields := map[string]reflect.Value{}
+		for i := 0; i < v.NumField(); i++ {
+			fields[jsonName(v.Type().Field(i))] = v.Field(i)
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childKey := name
+			if key != `` {
+				childKey = key + `.` + name
+			}
+			field, exists := fields[name]
+			if !exists {
+				return &invalidError{source: source, key: childKey, reason: `no such setting`}
+			}
+			if err := decodeJson(source, childKey, obj[name], field, set); err != nil {
+				return err
+			}
+		}
+		return nil
+	case v.Type() == pathType:
+		switch value := raw.(type) {
+		case string:
+			v.SetString(value)
+		case []interface{}:
+			segments := make([]string, len(value))
+			for i, item := range value {
+				segment, ok := item.(string)
+				if !ok {
+					return fail(`path segment ` + strconv.Itoa(i) + `: ` + (&typeError{want: `a string`, got: describe(item)}).Error())
+				}
+				segments[i] = segment
+			}
+			v.SetString(filepath.Join(segments...))
+		default:
+			return mismatch(`a path string or an array of path segments`)
+		}
+	case v.Kind() == reflect.String:
+		value, ok := raw.(string)
+		if !ok {
+			return mismatch(`a string`)
+		}
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		value, ok := raw.(bool)
+		if !ok {
+			return mismatch(`true or false`)
+		}
+		v.SetBool(value)
+	case v.Kind() == reflect.Int:
+		value, ok := raw.(float64)
+		if !ok || value != math.Trunc(value) || math.Abs(value) > math.MaxInt32 {
+			return mismatch(`a whole number`)
+		}
+		v.SetInt(int64(value))
+	}
+	set(key)
+	return nil
+}
+
+// The json value as it would be written in the file, abbreviated for objects and arrays
+func describe(raw interface{}) string {
+	switch value := raw.(type) {
+	case nil:
+		return `null`
+	case string:
+		return strconv.Quote(value)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case []interface{}:
+		return `an array`
+	default:
+		return `an object`
+	}
+}
+
+// Sets a setting from the command line, recording the flag as its source
+type flagValue struct {
+	setting *setting
+	sources map[string]string
+}
+
+func (f *flagValue) String() string {
+	// the flag package makes zero values to find the defaults it prints
+	if f.setting == nil {
+		return ``
+	}
+	return fmtValue(f.setting.value)
+}
+
+func (f *flagValue) Set(text string) error {
+	if err := f.setting.parse(text); err != nil {
+		return err
+	}
+	f.sources[f.setting.key] = `flag -` + f.setting.key
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting != nil && f.setting.value.Kind() == reflect.Bool
+}
+
+func fmtValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	default:
+		return v.String()
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	ct "github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/daviddengcn/go-colortext"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// entries queued for printing before logging blocks until the console catches up
+const printBufferSize = 256
+
+// The log levels by severity
+var levels = map[string]int{`info`: 0, `warning`: 1, `error`: 2, `critical`: 3}
+
+// A golog.Log whose level and line spacing can be changed while it is in use. It prints entries in order from its own
+// goroutine like golog's console log but, unlike golog, Flush waits for them to be printed so nothing logged before
+// the server exits is lost.
+type Logger struct {
+	// keeps entries without printing them and gets them back
+	golog.Log
+	print       bool
+	least       int32
+	lineSpacing int32
+	queue       chan *printJob
+}
+
+// An entry to print, or with a nil entry a Flush waiting on done
+type printJob struct {
+	entry *golog.LogEntry
+	done  chan struct{}
+}
+
+// Opens the log the settings describe
+func (l *Log) Open() (*Logger, error) {
+	logger := &Logger{print: l.Output != `none`, queue: make(chan *printJob, printBufferSize)}
+	switch l.Output {
+	case `local`:
+		// golog creates a missing directory without any permissions
+		if err := os.MkdirAll(string(l.Dir), os.ModePerm); err != nil {
+			return nil, err
+		}
+		var err error
+		if logger.Log, err = golog.NewLocalLog(string(l.Dir), false, 0); err != nil {
+			return nil, err
+		}
+	default:
+		logger.Log = golog.NewDevNullLog()
+	}
+	logger.Reload(l)
+	go logger.printEntries()
+	return logger, nil
+}
+
+// Applies the level and line spacing of settings, the output and dir are only read when the log is opened
+func (l *Logger) Reload(settings *Log) {
+	atomic.StoreInt32(&l.least, int32(levels[strings.ToLower(settings.Level)]))
+	atomic.StoreInt32(&l.lineSpacing, int32(settings.LineSpacing))
+}
+
+// Waits for every entry logged so far to be printed
+func (l *Logger) Flush() {
+	if !l.print {
+		return
+	}
+	done := make(chan struct{})
+	l.queue <- &printJob{done: done}
+	<-done
+}
+
+func (l *Logger) printEntries() {
+	for job := range l.queue {
+		if job.entry == nil {
+			close(job.done)
+			continue
+		}
+		var levelPadding string
+		switch job.entry.Level {
+		case golog.INFO:
+			levelPadding = `    `
+			ct.Foreground(ct.Cyan, true)
+		case golog.WARNING:
+			levelPadding = ` `
+			ct.Foreground(ct.Yellow, true)
+		case golog.ERROR:
+			levelPadding = `   `
+			ct.Foreground(ct.Red, true)
+		case golog.CRITICAL:
+			levelPadding = ``
+			ct.ChangeColor(ct.Black, true, ct.Red, true)
+		}
+		fmt.Println(job.entry.Time.Format(`15:04:05.00`), string(job.entry.Level)+levelPadding, job.entry.Message)
+		ct.ResetColor()
+		for i := int32(0); i < atomic.LoadInt32(&l.lineSpacing); i++ {
+			fmt.Println(``)
+		}
+	}
+}
+
+func (l *Logger) enabled(level string) bool {
+	return int32(levels[level]) >= atomic.LoadInt32(&l.least)
+}
+
+func (l *Logger) printed(entry golog.LogEntry) golog.LogEntry {
+	if l.print {
+		l.queue <- &printJob{entry: &entry}
+	}
+	return entry
+}
+
+func (l *Logger) Info(a ...interface{}) golog.LogEntry {
+	if !l.enabled(`info`) {
+		return golog.LogEntry{}
+	}
+	return l.printed(l.Log.Info(a...))
+}
+
+func (l *Logger) Warning(a ...interface{}) golog.LogEntry {
+	if !l.enabled(`warning`) {
+		return golog.LogEntry{}
+	}
+	return l.printed(l.Log.Warning(a...))
+}
+
+func (l *Logger) Error(a ...interface{}) golog.LogEntry {
+	if !l.enabled(`error`) {
+		return golog.LogEntry{}
+	}
+	return l.printed(l.Log.Error(a...))
+}
+
+func (l *Logger) Critical(a ...interface{}) golog.LogEntry {
+	return l.printed(l.Log.Critical(a...))
+}
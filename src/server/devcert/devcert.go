@@ -0,0 +1,169 @@
+/*
+A self signed certificate for serving HTTPS in development, so features that need a secure context work when the
+editor is opened from another machine. It is kept on disk and reused until it nears expiry or stops covering the
+machine's names and addresses, so a browser told to trust it once keeps trusting it.
+*/
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	certFile = `cert.pem`
+	keyFile  = `key.pem`
+	validFor = 365 * 24 * time.Hour
+	// a certificate expiring sooner than this is replaced rather than reused
+	renewBefore = 30 * 24 * time.Hour
+)
+
+type Cert struct {
+	CertFile string
+	KeyFile  string
+	Hosts    []string
+	NotAfter time.Time
+	// the SHA-256 fingerprint as browsers show it, to check against when trusting the certificate
+	Fingerprint string
+	// whether the certificate was made just now rather than read from dir
+	Generated bool
+}
+
+// The certificate kept in dir, made anew when there is none, it expires within renewBefore or it does not cover every
+// one of hosts. localhost and the machine's own names and addresses are always covered.
+func Ensure(dir string, hosts []string) (*Cert, error) {
+	hosts = unique(append(defaultHosts(), hosts...))
+	c := &Cert{CertFile: filepath.Join(dir, certFile), KeyFile: filepath.Join(dir, keyFile)}
+	if pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(pair.Certificate[0]); err == nil && reusable(leaf, hosts) {
+			c.describe(leaf)
+			return c, nil
+		}
+	}
+
+	leaf, err := c.generate(dir, hosts)
+	if err != nil {
+		return nil, err
+	}
+	c.describe(leaf)
+	c.Generated = true
+	return c, nil
+}
+
+func reusable(leaf *x509.Certificate, hosts []string) bool {
+	if time.Until(leaf.NotAfter) < renewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cert) describe(leaf *x509.Certificate) {
+	c.Hosts = append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		c.Hosts = append(c.Hosts, ip.String())
+	}
+	c.NotAfter = leaf.NotAfter
+	sum := sha256.Sum256(leaf.Raw)
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	c.Fingerprint = strings.Join(pairs, `:`)
+}
+
+// Writes a new key and certificate for hosts to dir
+func (c *Cert) generate(dir string, hosts []string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{`3ditor development`}, CommonName: hosts[0]},
+		// allows for clocks running behind
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// the key first so a certificate on disk always has its key beside it
+	if err := ioutil.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: `PRIVATE KEY`, Bytes: keyDer}), 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// localhost and the names and addresses other machines may reach this one by
+func defaultHosts() []string {
+	hosts := []string{`localhost`, `127.0.0.1`, `::1`}
+	if name, err := os.Hostname(); err == nil && name != `` {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+func unique(hosts []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, host := range hosts {
+		if key := strings.ToLower(host); !seen[key] {
+			seen[key] = true
+			out = append(out, host)
+		}
+	}
+	return out
+}
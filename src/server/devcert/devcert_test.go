@@ -0,0 +1,105 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsure(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `devcert`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, `certs`)
+
+	first, err := Ensure(dir, []string{`editor.test`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Generated || first.Fingerprint == `` || time.Until(first.NotAfter) < validFor-2*time.Hour {
+		t.Errorf(`got %+v, want a new certificate valid for a year`, first)
+	}
+	if info, err := os.Stat(first.KeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf(`got key file %v, %v, want it readable by its owner only`, info, err)
+	}
+	pair, err := tls.LoadX509KeyPair(first.CertFile, first.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{`localhost`, `127.0.0.1`, `::1`, `editor.test`} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf(`certificate does not cover %s: %v`, host, err)
+		}
+	}
+	// a client that trusts the certificate itself can connect with it
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	listener, err := tls.Listen(`tcp`, `127.0.0.1:0`, &tls.Config{Certificates: []tls.Certificate{pair}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	if conn, err := tls.Dial(`tcp`, listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: `localhost`}); err != nil {
+		t.Errorf(`handshake failed: %v`, err)
+	} else {
+		conn.Close()
+	}
+
+	again, err := Ensure(dir, []string{`EDITOR.test`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Generated || again.Fingerprint != first.Fingerprint {
+		t.Errorf(`a certificate covering every host was not reused`)
+	}
+
+	more, err := Ensure(dir, []string{`other.test`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !more.Generated || more.Fingerprint == first.Fingerprint {
+		t.Errorf(`a certificate not covering other.test was reused`)
+	}
+}
+
+func TestReusable(t *testing.T) {
+	tests := []struct {
+		name     string
+		expires  time.Duration
+		hosts    []string
+		reusable bool
+	}{
+		{name: `covers every host`, expires: validFor, hosts: []string{`localhost`, `127.0.0.1`}, reusable: true},
+		{name: `missing a name`, expires: validFor, hosts: []string{`localhost`, `other`}},
+		{name: `missing an address`, expires: validFor, hosts: []string{`10.0.0.1`}},
+		{name: `renewed a month before expiry`, expires: renewBefore - time.Hour, hosts: []string{`localhost`}},
+		{name: `expired`, expires: -time.Hour, hosts: []string{`localhost`}},
+	}
+	for _, test := range tests {
+		leaf := &x509.Certificate{
+			NotAfter:    time.Now().Add(test.expires),
+			DNSNames:    []string{`localhost`},
+			IPAddresses: []net.IP{net.ParseIP(`127.0.0.1`)},
+		}
+		if got := reusable(leaf, test.hosts); got != test.reusable {
+			t.Errorf(`%s: got reusable %v, want %v`, test.name, got, test.reusable)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package diff
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+)
+
+// Implements `server diff [-json] <from.json> <to.json>`. It also accepts the seven arguments git passes to an
+// external diff driver so it can be configured as the diff for committed scene files:
+//
+//	git config diff.scene.command "server diff"
+//	echo "*.scene.json diff=scene" >> .gitattributes
+func RunCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(`diff`, flag.ContinueOnError)
+	asJson := flags.Bool(`json`, false, `print the report as json`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files := flags.Args()
+	switch len(files) {
+	case 2:
+	case 7:
+		files = []string{files[1], files[4]}
+	default:
+		return &usageError{}
+	}
+
+	from, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+	to, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		return err
+	}
+	report, err := Documents(from, to)
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		data, _ := json.MarshalIndent(report, ``, `  `)
+		_, err = out.Write(append(data, '\n'))
+		return err
+	}
+	_, err = io.WriteString(out, report.String())
+	return err
+}
+
+type usageError struct{}
+
+func (e *usageError) Error() string { return `usage: diff [-json] <from.json> <to.json>` }
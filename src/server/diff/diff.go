@@ -0,0 +1,462 @@
+/*
+Structural diffing of Editor.toJSON() documents, objects, geometries, materials and scripts are matched by uuid so
+the report reads as "moved Cube by +2 on x" rather than a json text diff
+*/
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// float differences smaller than this are treated as noise from matrix round trips
+	epsilon = 1e-6
+	// arrays longer than this, e.g. geometry attributes, are reported by length rather than by value
+	maxInlineArray = 16
+)
+
+type Change struct {
+	Property string      `json:"property"`
+	From     interface{} `json:"from,omitempty"`
+	To       interface{} `json:"to,omitempty"`
+	Delta    []float64   `json:"delta,omitempty"`
+}
+
+type Entry struct {
+	Uuid       string    `json:"uuid"`
+	Name       string    `json:"name,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	FromParent string    `json:"fromParent,omitempty"`
+	ToParent   string    `json:"toParent,omitempty"`
+	Changes    []*Change `json:"changes,omitempty"`
+
+	fromParentLabel string
+	toParentLabel   string
+}
+
+type Section struct {
+	Added      []*Entry `json:"added"`
+	Removed    []*Entry `json:"removed"`
+	Reparented []*Entry `json:"reparented"`
+	Modified   []*Entry `json:"modified"`
+}
+
+func (s *Section) empty() bool {
+	return len(s.Added) == 0 && len(s.Removed) == 0 && len(s.Reparented) == 0 && len(s.Modified) == 0
+}
+
+type Report struct {
+	Objects    *Section `json:"objects"`
+	Geometries *Section `json:"geometries"`
+	Materials  *Section `json:"materials"`
+	Scripts    *Section `json:"scripts"`
+}
+
+func (r *Report) Empty() bool {
+	return r.Objects.empty() && r.Geometries.empty() && r.Materials.empty() && r.Scripts.empty()
+}
+
+// Diffs two raw Editor.toJSON() documents. An empty document, such as git's /dev/null for a file that is being added
+// or deleted, has nothing in it so every object in the other is reported as added or removed.
+func Documents(from, to []byte) (*Report, error) {
+	fromGraph, err := parseDocument(from)
+	if err != nil {
+		return nil, err
+	}
+	toGraph, err := parseDocument(to)
+	if err != nil {
+		return nil, err
+	}
+	return Graphs(fromGraph, toGraph), nil
+}
+
+func parseDocument(doc []byte) (*scene.Graph, error) {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return &scene.Graph{
+			Objects:    map[string]*scene.Node{},
+			Geometries: map[string]map[string]interface{}{},
+			Materials:  map[string]map[string]interface{}{},
+			Textures:   map[string]map[string]interface{}{},
+			Images:     map[string]map[string]interface{}{},
+			Scripts:    map[string][]interface{}{},
+		}, nil
+	}
+	return scene.ParseGraph(doc)
+}
+
+func Graphs(from, to *scene.Graph) *Report {
+	return &Report{
+		Objects:    diffObjects(from, to),
+		Geometries: diffIndex(from.Geometries, to.Geometries),
+		Materials:  diffIndex(from.Materials, to.Materials),
+		Scripts:    diffScripts(from.Scripts, to.Scripts),
+	}
+}
+
+func newSection() *Section {
+	return &Section{Added: []*Entry{}, Removed: []*Entry{}, Reparented: []*Entry{}, Modified: []*Entry{}}
+}
+
+func newEntry(uuid string, obj map[string]interface{}) *Entry {
+	name, _ := obj[`name`].(string)
+	typ, _ := obj[`type`].(string)
+	return &Entry{Uuid: uuid, Name: name, Type: typ}
+}
+
+func diffObjects(from, to *scene.Graph) *Section {
+	section := newSection()
+	from.Walk(func(node *scene.Node) {
+		if to.Objects[node.Uuid] == nil {
+			section.Removed = append(section.Removed, newEntry(node.Uuid, node.Object))
+		}
+	})
+	to.Walk(func(node *scene.Node) {
+		fromNode := from.Objects[node.Uuid]
+		if fromNode == nil {
+			section.Added = append(section.Added, newEntry(node.Uuid, node.Object))
+			return
+		}
+		if fromNode.Parent != node.Parent {
+			entry := newEntry(node.Uuid, node.Object)
+			entry.FromParent = fromNode.Parent
+			entry.ToParent = node.Parent
+			entry.fromParentLabel = parentLabel(from, fromNode.Parent)
+			entry.toParentLabel = parentLabel(to, node.Parent)
+			section.Reparented = append(section.Reparented, entry)
+		}
+		if changes := ObjectChanges(fromNode.Object, node.Object); len(changes) > 0 {
+			entry := newEntry(node.Uuid, node.Object)
+			entry.Changes = changes
+			section.Modified = append(section.Modified, entry)
+		}
+	})
+	return section
+}
+
+// An object that has no parent is the root of its document
+func parentLabel(graph *scene.Graph, uuid string) string {
+	parent := graph.Objects[uuid]
+	if uuid == `` || parent == nil {
+		return `root`
+	}
+	return label(newEntry(uuid, parent.Object))
+}
+
+// Property level changes between two versions of the same object, the matrix is reported as
+// position, rotation and scale changes rather than as raw matrix elements
+func ObjectChanges(from, to map[string]interface{}) []*Change {
+	changes := []*Change{}
+	fromTransform, fromOk := Decompose(from[`matrix`])
+	toTransform, toOk := Decompose(to[`matrix`])
+	if fromOk && toOk {
+		changes = appendVectorChange(changes, `position`, fromTransform.Position, toTransform.Position)
+		changes = appendVectorChange(changes, `rotation`, fromTransform.Rotation, toTransform.Rotation)
+		changes = appendVectorChange(changes, `scale`, fromTransform.Scale, toTransform.Scale)
+	} else {
+		changes = appendValueChanges(changes, `matrix`, from[`matrix`], to[`matrix`])
+	}
+	for _, key := range unionKeys(from, to) {
+		if key == `matrix` || key == `uuid` {
+			continue
+		}
+		changes = appendValueChanges(changes, key, from[key], to[key])
+	}
+	return changes
+}
+
+// Property level changes between two versions of the same geometry, material, texture or image
+func PropertyChanges(from, to map[string]interface{}) []*Change {
+	changes := []*Change{}
+	for _, key := range unionKeys(from, to) {
+		if key == `uuid` {
+			continue
+		}
+		changes = appendValueChanges(changes, key, from[key], to[key])
+	}
+	return changes
+}
+
+func diffIndex(from, to map[string]map[string]interface{}) *Section {
+	section := newSection()
+	for _, uuid := range sortedKeys(from) {
+		if to[uuid] == nil {
+			section.Removed = append(section.Removed, newEntry(uuid, from[uuid]))
+		}
+	}
+	for _, uuid := range sortedKeys(to) {
+		if from[uuid] == nil {
+			section.Added = append(section.Added, newEntry(uuid, to[uuid]))
+		} else if changes := PropertyChanges(from[uuid], to[uuid]); len(changes) > 0 {
+			entry := newEntry(uuid, to[uuid])
+			entry.Changes = changes
+			section.Modified = append(section.Modified, entry)
+		}
+	}
+	return section
+}
+
+// Scripts have no uuid of their own so they are keyed by the uuid of the object they are attached to,
+// their name and the occurrence of that name on the object
+func ScriptKeys(scripts map[string][]interface{}) map[string]map[string]interface{} {
+	keyed := map[string]map[string]interface{}{}
+	for objectUuid, list := range scripts {
+		occurrences := map[string]int{}
+		for _, item := range list {
+			script, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := script[`name`].(string)
+			keyed[ScriptKey(objectUuid, name, occurrences[name])] = script
+			occurrences[name]++
+		}
+	}
+	return keyed
+}
+
+func ScriptKey(objectUuid, name string, occurrence int) string {
+	return objectUuid + `/` + name + `#` + strconv.Itoa(occurrence)
+}
+
+func diffScripts(from, to map[string][]interface{}) *Section {
+	section := diffIndex(ScriptKeys(from), ScriptKeys(to))
+	for _, entries := range [][]*Entry{section.Added, section.Removed, section.Modified} {
+		for _, entry := range entries {
+			entry.Uuid = entry.Uuid[:strings.Index(entry.Uuid, `/`)]
+			entry.Type = `Script`
+		}
+	}
+	return section
+}
+
+func appendVectorChange(changes []*Change, property string, from, to [3]float64) []*Change {
+	delta := []float64{to[0] - from[0], to[1] - from[1], to[2] - from[2]}
+	if property == `rotation` {
+		// the shorter way round, so turning from 179° to -179° reads as +2° rather than -358°
+		for i := range delta {
+			delta[i] = wrapAngle(delta[i])
+		}
+	}
+	if math.Abs(delta[0]) < epsilon && math.Abs(delta[1]) < epsilon && math.Abs(delta[2]) < epsilon {
+		return changes
+	}
+	for i := range delta {
+		if math.Abs(delta[i]) < epsilon {
+			delta[i] = 0
+		}
+	}
+	return append(changes, &Change{Property: property, From: round(from[:]), To: round(to[:]), Delta: round(delta)})
+}
+
+// Normalizes an angle in radians to (-π, π]
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a, 2*math.Pi)
+	if a <= -math.Pi {
+		a += 2 * math.Pi
+	} else if a > math.Pi {
+		a -= 2 * math.Pi
+	}
+	return a
+}
+
+// Recurses into nested json objects so changes are reported against the deepest differing property path
+func appendValueChanges(changes []*Change, property string, from, to interface{}) []*Change {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		for _, key := range unionKeys(fromMap, toMap) {
+			changes = appendValueChanges(changes, property+`.`+key, fromMap[key], toMap[key])
+		}
+		return changes
+	}
+	if Equal(from, to) {
+		return changes
+	}
+	return append(changes, &Change{Property: property, From: inline(from), To: inline(to)})
+}
+
+// Deep json value equality with a small tolerance on numbers
+func Equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		return ok && math.Abs(av-bv) < epsilon
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !Equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, val := range av {
+			if other, exists := bv[key]; !exists || !Equal(val, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func inline(v interface{}) interface{} {
+	if list, ok := v.([]interface{}); ok && len(list) > maxInlineArray {
+		return fmt.Sprintf(`<%d values>`, len(list))
+	}
+	return v
+}
+
+func round(values []float64) []float64 {
+	rounded := make([]float64, len(values))
+	for i, v := range values {
+		rounded[i] = math.Round(v/epsilon) * epsilon
+	}
+	return rounded
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(index map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Renders the report as one human readable line per change
+func (r *Report) String() string {
+	lines := []string{}
+	for _, part := range []struct {
+		noun    string
+		section *Section
+	}{
+		{`object`, r.Objects},
+		{`geometry`, r.Geometries},
+		{`material`, r.Materials},
+		{`script`, r.Scripts},
+	} {
+		for _, entry := range part.section.Added {
+			lines = append(lines, `+ added `+part.noun+` `+label(entry))
+		}
+		for _, entry := range part.section.Removed {
+			lines = append(lines, `- removed `+part.noun+` `+label(entry))
+		}
+		for _, entry := range part.section.Reparented {
+			lines = append(lines, `~ re-parented `+part.noun+` `+label(entry)+` from `+entry.fromParentLabel+` to `+entry.toParentLabel)
+		}
+		for _, entry := range part.section.Modified {
+			for _, change := range entry.Changes {
+				lines = append(lines, `~ `+describe(part.noun, entry, change))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return `no changes` + "\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func label(entry *Entry) string {
+	if entry.Name != `` {
+		return strconv.Quote(entry.Name)
+	}
+	return entry.Type + ` ` + entry.Uuid
+}
+
+func describe(noun string, entry *Entry, change *Change) string {
+	axes := []string{`x`, `y`, `z`}
+	switch change.Property {
+	case `position`, `rotation`:
+		if noun != `object` {
+			break
+		}
+		verb, unit, scale := `moved`, ``, 1.0
+		if change.Property == `rotation` {
+			verb, unit, scale = `rotated`, `°`, 180/math.Pi
+		}
+		parts := []string{}
+		for i, d := range change.Delta {
+			if d != 0 {
+				parts = append(parts, fmt.Sprintf(`%+g%s on %s`, roundTo(d*scale, 4), unit, axes[i]))
+			}
+		}
+		return verb + ` ` + label(entry) + ` by ` + strings.Join(parts, `, `)
+	case `scale`:
+		if noun == `object` {
+			return `scaled ` + label(entry) + ` from ` + formatValue(change.Property, change.From) + ` to ` + formatValue(change.Property, change.To)
+		}
+	}
+	subject := label(entry)
+	if noun != `object` {
+		subject = noun + ` ` + subject
+	}
+	switch {
+	case change.From == nil:
+		return `set ` + subject + ` ` + change.Property + ` to ` + formatValue(change.Property, change.To)
+	case change.To == nil:
+		return `unset ` + subject + ` ` + change.Property + ` (was ` + formatValue(change.Property, change.From) + `)`
+	default:
+		return `changed ` + subject + ` ` + change.Property + ` from ` + formatValue(change.Property, change.From) + ` to ` + formatValue(change.Property, change.To)
+	}
+}
+
+var colorProperties = map[string]bool{`color`: true, `emissive`: true, `specular`: true}
+
+func formatValue(property string, v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		if colorProperties[property] {
+			return fmt.Sprintf(`#%06x`, int64(val))
+		}
+		return strconv.FormatFloat(roundTo(val, 4), 'f', -1, 64)
+	case []float64:
+		parts := make([]string, len(val))
+		for i, f := range val {
+			parts[i] = strconv.FormatFloat(roundTo(f, 4), 'f', -1, 64)
+		}
+		return `[` + strings.Join(parts, `, `) + `]`
+	case string:
+		if property == `source` && len(val) > 40 {
+			return fmt.Sprintf(`<%d characters>`, len(val))
+		}
+		return strconv.Quote(val)
+	default:
+		data, _ := json.Marshal(val)
+		return string(data)
+	}
+}
+
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,124 @@
+package diff
+
+import (
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+	cube := scenetest.Object(`A`, `cube`, `M`, scenetest.Identity)
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []string
+	}{
+		{
+			name: `unchanged`,
+			from: scenetest.Document(`16777215`, cube),
+			to:   scenetest.Document(`16777215`, cube),
+			want: []string{`no changes`},
+		},
+		{
+			name: `added`,
+			from: scenetest.Document(`16777215`),
+			to:   scenetest.Document(`16777215`, cube),
+			want: []string{`+ added object "cube"`},
+		},
+		{
+			name: `removed`,
+			from: scenetest.Document(`16777215`, cube),
+			to:   scenetest.Document(`16777215`),
+			want: []string{`- removed object "cube"`},
+		},
+		{
+			name: `moved`,
+			from: scenetest.Document(`16777215`, cube),
+			to:   scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(2, 0, -1))),
+			want: []string{`~ moved "cube" by +2 on x, -1 on z`},
+		},
+		{
+			name: `rotated across the half turn`,
+			from: scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.RotationZ(179))),
+			to:   scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.RotationZ(-179))),
+			want: []string{`~ rotated "cube" by +2° on z`},
+		},
+		{
+			name: `re-parented`,
+			from: scenetest.Document(`16777215`, cube, scenetest.Object(`B`, `group`, `M`, scenetest.Identity)),
+			to:   scenetest.Document(`16777215`, scenetest.Object(`B`, `group`, `M`, scenetest.Identity, cube)),
+			want: []string{`~ re-parented object "cube" from "scene" to "group"`},
+		},
+		{
+			name: `root becomes a child`,
+			from: scenetest.DocumentWithRoot(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Identity)),
+			to:   scenetest.Document(`16777215`, cube),
+			want: []string{`+ added object "scene"`, `~ re-parented object "cube" from root to "scene"`},
+		},
+		{
+			name: `material changed`,
+			from: scenetest.Document(`16777215`, cube),
+			to:   scenetest.Document(`255`, cube),
+			want: []string{`~ changed material MeshStandardMaterial M color from #ffffff to #0000ff`},
+		},
+		{
+			name: `added file`,
+			from: ``,
+			to:   scenetest.Document(`16777215`, cube),
+			want: []string{`+ added object "scene"`, `+ added object "cube"`, `+ added geometry BoxGeometry G`, `+ added material MeshStandardMaterial M`, `+ added material MeshStandardMaterial N`},
+		},
+		{
+			name: `deleted file`,
+			from: scenetest.Document(`16777215`, cube),
+			to:   "\n",
+			want: []string{`- removed object "scene"`, `- removed object "cube"`, `- removed geometry BoxGeometry G`, `- removed material MeshStandardMaterial M`, `- removed material MeshStandardMaterial N`},
+		},
+	}
+	for _, test := range tests {
+		report, err := Documents([]byte(test.from), []byte(test.to))
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if got, want := report.String(), strings.Join(test.want, "\n")+"\n"; got != want {
+			t.Errorf("%s: got\n%swant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestDocumentsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{`not json`, `{"scene":`},
+		{`no root object`, `{"metadata":{},"scene":{}}`},
+	}
+	for _, test := range tests {
+		if _, err := Documents([]byte(test.doc), []byte(scenetest.Document(`0`))); err == nil {
+			t.Errorf(`%s: expected an error`, test.name)
+		}
+	}
+}
+
+func TestWrapAngle(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want float64
+	}{
+		{0, 0},
+		{math.Pi, math.Pi},
+		{-math.Pi, math.Pi},
+		{3 * math.Pi / 2, -math.Pi / 2},
+		{-3 * math.Pi / 2, math.Pi / 2},
+		{-358 * math.Pi / 180, 2 * math.Pi / 180},
+		{5 * math.Pi, math.Pi},
+	}
+	for _, test := range tests {
+		if got := wrapAngle(test.in); math.Abs(got-test.want) > epsilon {
+			t.Errorf(`wrapAngle(%g) = %g, want %g`, test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package diff
+
+import (
+	"errors"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+	"strconv"
+)
+
+// Serves GET /api/scenes/{id}/diff?from={n}&to={m} comparing two revisions of a scene. to defaults to the head
+// revision and from defaults to the revision before to, ?format=text returns the human readable report. from=0 diffs
+// against an empty document so the first revision reports everything in it as added.
+func NewSubHandler(store scene.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		query := r.URL.Query()
+		to, err := revisionParam(query.Get(`to`), 0)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if to == 0 {
+			meta, _, err := store.Get(id)
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			to = meta.Revision
+		}
+		from, err := revisionParam(query.Get(`from`), to-1)
+		if err == nil && from < 0 {
+			err = errors.New(`from must not be negative`)
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		_, toDoc, err := store.GetRevision(id, to)
+		if err != nil {
+			scene.WriteError(w, log, err)
+			return
+		}
+		var fromDoc []byte
+		if from != 0 {
+			if _, fromDoc, err = store.GetRevision(id, from); err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+		}
+		report, err := Documents(fromDoc, toDoc)
+		if err != nil {
+			scene.WriteError(w, log, err)
+			return
+		}
+
+		if query.Get(`format`) == `text` {
+			w.Header().Set(`Content-Type`, `text/plain; charset=utf-8`)
+			w.Write([]byte(report.String()))
+			return
+		}
+		api.WriteJson(w, http.StatusOK, report)
+	}
+}
+
+func revisionParam(param string, def int) (int, error) {
+	if param == `` {
+		return def, nil
+	}
+	return strconv.Atoi(param)
+}
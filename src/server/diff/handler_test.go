@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `diff`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := scene.NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cube := scenetest.Object(`A`, `cube`, `M`, scenetest.Identity)
+	meta, err := store.Create(`boxes`, `alice`, []byte(scenetest.Document(`0`, cube)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{
+		scenetest.Document(`0`, cube, scenetest.Object(`B`, `ball`, `M`, scenetest.Identity)),
+		scenetest.Document(`0`, cube, scenetest.Object(`C`, `cone`, `M`, scenetest.Identity)),
+	} {
+		if meta, err = store.Replace(meta.Id, ``, `alice`, 0, []byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Prune(meta.Id, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewSubHandler(store, golog.NewDevNullLog())
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		added   []string
+		removed []string
+		// a part of the error message
+		error string
+	}{
+		{name: `first revision`, query: `?to=1`, status: 200, added: []string{`scene`, `cube`}},
+		{name: `from nothing`, query: `?from=0`, status: 200, added: []string{`scene`, `cube`, `cone`}},
+		{name: `between revisions`, query: `?from=1&to=3`, status: 200, added: []string{`cone`}},
+		{name: `pruned from`, status: 404, error: `Revision 2 of scene with id: ` + meta.Id + ` was pruned`},
+		{name: `pruned to`, query: `?from=1&to=2`, status: 404, error: `was pruned`},
+		{name: `missing revision`, query: `?to=4`, status: 404, error: `No such revision 4`},
+		{name: `negative from`, query: `?from=-1`, status: 400, error: `from must not be negative`},
+		{name: `bad to`, query: `?to=head`, status: 400},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(`GET`, `/`+test.query, nil), meta.Id, nil)
+		if w.Code != test.status {
+			t.Errorf(`%s: got status %d, want %d: %s`, test.name, w.Code, test.status, w.Body)
+			continue
+		}
+		if test.status != 200 {
+			if !strings.Contains(w.Body.String(), test.error) {
+				t.Errorf(`%s: got %s, want an error containing %q`, test.name, w.Body, test.error)
+			}
+			continue
+		}
+		report := &Report{}
+		if err := json.Unmarshal(w.Body.Bytes(), report); err != nil {
+			t.Errorf(`%s: %v`, test.name, err)
+			continue
+		}
+		if got := entryNames(report.Objects.Added); strings.Join(got, `,`) != strings.Join(test.added, `,`) {
+			t.Errorf(`%s: got added %v, want %v`, test.name, got, test.added)
+		}
+		if got := entryNames(report.Objects.Removed); strings.Join(got, `,`) != strings.Join(test.removed, `,`) {
+			t.Errorf(`%s: got removed %v, want %v`, test.name, got, test.removed)
+		}
+	}
+}
+
+func entryNames(entries []*Entry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"math"
+)
+
+// Position, rotation (XYZ euler angles in radians) and scale decomposed from a column major Matrix4 array,
+// mirroring THREE.Matrix4.decompose and THREE.Euler.setFromRotationMatrix
+type Transform struct {
+	Position [3]float64
+	Rotation [3]float64
+	Scale    [3]float64
+}
+
+// Decomposes an object's json matrix, false when it is not an array of 16 numbers
+func Decompose(matrix interface{}) (*Transform, bool) {
+	te, ok := toFloats(matrix)
+	if !ok || len(te) != 16 {
+		return nil, false
+	}
+	t := &Transform{}
+	t.Position = [3]float64{te[12], te[13], te[14]}
+
+	sx := math.Sqrt(te[0]*te[0] + te[1]*te[1] + te[2]*te[2])
+	sy := math.Sqrt(te[4]*te[4] + te[5]*te[5] + te[6]*te[6])
+	sz := math.Sqrt(te[8]*te[8] + te[9]*te[9] + te[10]*te[10])
+	det := te[0]*(te[5]*te[10]-te[9]*te[6]) - te[4]*(te[1]*te[10]-te[9]*te[2]) + te[8]*(te[1]*te[6]-te[5]*te[2])
+	if det < 0 {
+		sx = -sx
+	}
+	t.Scale = [3]float64{sx, sy, sz}
+	if sx == 0 || sy == 0 || sz == 0 {
+		return t, true
+	}
+
+	m11, m12, m13 := te[0]/sx, te[4]/sy, te[8]/sz
+	m22, m23 := te[5]/sy, te[9]/sz
+	m32, m33 := te[6]/sy, te[10]/sz
+	t.Rotation[1] = math.Asin(math.Max(-1, math.Min(1, m13)))
+	if math.Abs(m13) < 0.99999 {
+		t.Rotation[0] = math.Atan2(-m23, m33)
+		t.Rotation[2] = math.Atan2(-m12, m11)
+	} else {
+		t.Rotation[0] = math.Atan2(m32, m22)
+	}
+	return t, true
+}
+
+// Composes the json matrix, mirroring THREE.Matrix4.makeRotationFromEuler for the XYZ order followed by scaling
+// and setting the position as THREE.Matrix4.compose does
+func (t *Transform) Matrix() []interface{} {
+	a, b := math.Cos(t.Rotation[0]), math.Sin(t.Rotation[0])
+	c, d := math.Cos(t.Rotation[1]), math.Sin(t.Rotation[1])
+	e, f := math.Cos(t.Rotation[2]), math.Sin(t.Rotation[2])
+	ae, af, be, bf := a*e, a*f, b*e, b*f
+	sx, sy, sz := t.Scale[0], t.Scale[1], t.Scale[2]
+	te := []float64{
+		c * e * sx, (af + be*d) * sx, (bf - ae*d) * sx, 0,
+		-c * f * sy, (ae - bf*d) * sy, (be + af*d) * sy, 0,
+		d * sz, -b * c * sz, a * c * sz, 0,
+		t.Position[0], t.Position[1], t.Position[2], 1,
+	}
+	matrix := make([]interface{}, len(te))
+	for i, v := range te {
+		matrix[i] = v
+	}
+	return matrix
+}
+
+func toFloats(v interface{}) ([]float64, bool) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	floats := make([]float64, len(list))
+	for i, item := range list {
+		f, ok := item.(float64)
+		if !ok {
+			return nil, false
+		}
+		floats[i] = f
+	}
+	return floats, true
+}
@@ -0,0 +1,46 @@
+/*
+Exclusive locks on data directories held across processes, so the server and the sub commands that write to its
+stores, such as prune, never write to the same store at once. The lock is held on a file in the directory for as long
+as the process keeps it, the operating system drops it when the process exits however it exits.
+*/
+package dirlock
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const fileName = `.lock`
+
+type Lock struct {
+	file *os.File
+}
+
+// Takes the lock on dir without waiting, failing with a held error when another process has it
+func Acquire(dir string) (*Lock, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := lockFile(filepath.Join(dir, fileName))
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{file: file}, nil
+}
+
+func (l *Lock) Release() error {
+	return l.file.Close()
+}
+
+type heldError struct {
+	dir string
+}
+
+func (e *heldError) Error() string {
+	return `another process, such as the server, is using ` + e.dir
+}
+
+func IsHeld(err error) bool {
+	_, ok := err.(*heldError)
+	return ok
+}
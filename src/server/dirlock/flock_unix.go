@@ -0,0 +1,24 @@
+//go:build !windows
+
+package dirlock
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func lockFile(name string) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, &heldError{dir: filepath.Dir(name)}
+		}
+		return nil, err
+	}
+	return file, nil
+}
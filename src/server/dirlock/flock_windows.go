@@ -0,0 +1,27 @@
+//go:build windows
+
+package dirlock
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ERROR_SHARING_VIOLATION, opening a file another process has open without sharing it
+const errSharingViolation syscall.Errno = 32
+
+// Windows has no advisory locks in syscall, opening the file without sharing it locks it as well
+func lockFile(name string) (*os.File, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errSharingViolation {
+		return nil, &heldError{dir: filepath.Dir(name)}
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), name), nil
+}
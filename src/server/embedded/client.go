@@ -0,0 +1,20 @@
+//go:build embed
+
+package embedded
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// all: keeps files starting with . or _ which the client's libraries may use
+//
+//go:embed all:client
+var files embed.FS
+
+func init() {
+	var err error
+	if client, err = fs.Sub(files, `client`); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,22 @@
+/*
+The built client, embedded into the server binary when it is built with the embed tag so a single executable can be
+run from any working directory:
+
+	grunt buildSingleServer
+
+which copies build/client into embedded/client then runs go build -tags embed. A server built without the tag serves
+the client from publicDir on disk as it always has.
+*/
+package embedded
+
+import (
+	"io/fs"
+)
+
+// set by client.go when the binary is built with the embed tag
+var client fs.FS
+
+// The files of the built client, ok is false when the binary was built without them
+func Client() (files fs.FS, ok bool) {
+	return client, client != nil
+}
@@ -0,0 +1,14 @@
+//go:build !embed
+
+package embedded
+
+import (
+	"testing"
+)
+
+func TestClientWithoutEmbedTag(t *testing.T) {
+	// the server falls back to publicDir on disk when this is not ok
+	if files, ok := Client(); ok || files != nil {
+		t.Errorf(`got client files %v without the embed tag`, files)
+	}
+}
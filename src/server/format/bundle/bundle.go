@@ -0,0 +1,329 @@
+/*
+Import of zip archives, and the KMZ archives Google Earth saves, that hold a model together with the files it refers to
+*/
+package bundle
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Model formats in the order the primary model is looked for when an archive holds more than one kind
+var modelFormats = []string{`dae`, `gltf`, `glb`, `obj`, `ply`, `vtk`, `stl`}
+
+// Files textures are read from, these are stored as assets instead of being passed on to the model importer
+var imageExts = map[string]bool{
+	`png`: true, `jpg`: true, `jpeg`: true, `gif`: true, `bmp`: true, `tga`: true, `dds`: true, `webp`: true,
+	`tif`: true, `tiff`: true,
+}
+
+// Limits on what an archive expands to, the upload limit only bounds the compressed size and a few kilobytes of
+// deflated zeros unpack to gigabytes
+var (
+	maxEntrySize   uint64 = 512 << 20
+	maxArchiveSize uint64 = 1 << 30
+)
+
+type noArchiveError struct{}
+
+func (e *noArchiveError) Error() string {
+	return `upload contains no .zip or .kmz file`
+}
+
+type tooLargeError struct {
+	name string
+}
+
+func (e *tooLargeError) Error() string {
+	if e.name == `` {
+		return `archive expands to more than ` + strconv.FormatUint(maxArchiveSize>>20, 10) + `MB`
+	}
+	return `archive entry ` + e.name + ` expands to more than ` + strconv.FormatUint(maxEntrySize>>20, 10) + `MB`
+}
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `archive contains no model in a supported format`
+}
+
+// An importer for .zip and .kmz uploads which imports the primary model of the archive with the importer h has
+// registered for its format. Textures the model refers to by a path inside the archive are stored in assets and the
+// document's images refer to the stored copies.
+func NewImportFunc(h *importer.Handler, assets asset.Store) importer.ImportFunc {
+	return func(files importer.Files, name string) (*threejs.Document, error) {
+		a, err := readArchive(files)
+		if err != nil {
+			return nil, err
+		}
+		defer a.Close()
+
+		model := a.primaryModel(h)
+		if model == nil {
+			return nil, &noModelError{}
+		}
+		if name == `` {
+			name = importer.BaseName(model.Name)
+		}
+		doc, err := h.Format(importer.Ext(model.Name))(a.modelFiles(model), name)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.storeImages(doc, path.Dir(model.Name), assets); err != nil {
+			doc.Close()
+			return nil, err
+		}
+		return doc, nil
+	}
+}
+
+// An uploaded archive, zip needs random access so the upload is spooled to a temporary file
+type archive struct {
+	file    *os.File
+	entries []*zip.File
+	// entries by lower case path and by lower case file name, exporters are careless with case
+	byPath map[string]*zip.File
+	byName map[string]*zip.File
+}
+
+func readArchive(files importer.Files) (*archive, error) {
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			return nil, &noArchiveError{}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ext := importer.Ext(fileName); ext != `zip` && ext != `kmz` {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		f, err := ioutil.TempFile(``, `bundle`)
+		if err != nil {
+			return nil, err
+		}
+		a := &archive{file: f, byPath: map[string]*zip.File{}, byName: map[string]*zip.File{}}
+		size, err := io.Copy(f, r)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		reader, err := zip.NewReader(f, size)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		var total uint64
+		for _, entry := range reader.File {
+			// skip directories and the resource forks macOS adds to archives
+			if strings.HasSuffix(entry.Name, `/`) || strings.HasPrefix(entry.Name, `__MACOSX/`) || strings.HasPrefix(path.Base(entry.Name), `._`) {
+				continue
+			}
+			// zip refuses to read past the size an entry declares, so checking the declared sizes bounds what is read
+			if entry.UncompressedSize64 > maxEntrySize {
+				a.Close()
+				return nil, &tooLargeError{name: entry.Name}
+			}
+			if total += entry.UncompressedSize64; total > maxArchiveSize {
+				a.Close()
+				return nil, &tooLargeError{}
+			}
+			a.entries = append(a.entries, entry)
+			a.byPath[strings.ToLower(path.Clean(entry.Name))] = entry
+			if _, exists := a.byName[strings.ToLower(path.Base(entry.Name))]; !exists {
+				a.byName[strings.ToLower(path.Base(entry.Name))] = entry
+			}
+		}
+		return a, nil
+	}
+}
+
+func (a *archive) Close() {
+	a.file.Close()
+	os.Remove(a.file.Name())
+}
+
+// The model a KMZ's kml links to, otherwise the least deeply nested model of the first format found
+func (a *archive) primaryModel(h *importer.Handler) *zip.File {
+	for _, entry := range a.entries {
+		if importer.Ext(entry.Name) != `kml` {
+			continue
+		}
+		for _, href := range modelLinks(entry) {
+			if model := a.resolve(path.Dir(entry.Name), href); model != nil && h.Format(importer.Ext(model.Name)) != nil {
+				return model
+			}
+		}
+	}
+	for _, format := range modelFormats {
+		if h.Format(format) == nil {
+			continue
+		}
+		var models []*zip.File
+		for _, entry := range a.entries {
+			if importer.Ext(entry.Name) == format {
+				models = append(models, entry)
+			}
+		}
+		if len(models) > 0 {
+			sort.Sort(byDepth(models))
+			return models[0]
+		}
+	}
+	return nil
+}
+
+// The hrefs of the Link elements of the Model placemarks in a kml file
+func modelLinks(entry *zip.File) []string {
+	r, err := entry.Open()
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	var hrefs []string
+	var stack []string
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return hrefs
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if n := len(stack); n >= 3 && stack[n-1] == `href` && stack[n-2] == `Link` && stack[n-3] == `Model` {
+				hrefs = append(hrefs, strings.TrimSpace(string(t)))
+			}
+		}
+	}
+}
+
+// The model followed by every entry that is neither an image nor another model, so importers get the material
+// libraries and buffers the model needs but leave textures referenced by path
+func (a *archive) modelFiles(model *zip.File) importer.Files {
+	list := []*zip.File{model}
+	for _, entry := range a.entries {
+		ext := importer.Ext(entry.Name)
+		if entry == model || imageExts[ext] || ext == `kml` || isModelFormat(ext) {
+			continue
+		}
+		list = append(list, entry)
+	}
+	return &entryFiles{entries: list}
+}
+
+func isModelFormat(ext string) bool {
+	for _, format := range modelFormats {
+		if format == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Stores each archive file the document's images refer to as an asset and points the images at it
+func (a *archive) storeImages(doc *threejs.Document, dir string, assets asset.Store) error {
+	stored := map[*zip.File]string{}
+	for _, image := range doc.Images {
+		src, _ := image[`url`].(string)
+		entry := a.resolve(dir, src)
+		if entry == nil {
+			continue
+		}
+		ref, exists := stored[entry]
+		if !exists {
+			r, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			added, err := assets.Put(mime.TypeByExtension(path.Ext(entry.Name)), r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			ref = added.Ref
+		}
+		stored[entry] = ref
+		image[`url`] = ref
+	}
+	return nil
+}
+
+// The entry a reference made from a file in dir points at. Relative paths are tried against dir and then the root
+// of the archive, failing that, as with absolute paths from the exporting machine, the file name is looked up.
+func (a *archive) resolve(dir, ref string) *zip.File {
+	if ref == `` || strings.HasPrefix(ref, `data:`) {
+		return nil
+	}
+	if u, err := url.Parse(ref); err == nil && (u.Scheme == `http` || u.Scheme == `https`) {
+		return nil
+	}
+	if unescaped, err := url.QueryUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	ref = strings.Replace(strings.TrimPrefix(ref, `file://`), `\`, `/`, -1)
+	if !path.IsAbs(ref) && !strings.Contains(ref, `:`) {
+		if entry := a.byPath[strings.ToLower(path.Join(dir, ref))]; entry != nil {
+			return entry
+		}
+		if entry := a.byPath[strings.ToLower(path.Clean(ref))]; entry != nil {
+			return entry
+		}
+	}
+	return a.byName[strings.ToLower(path.Base(ref))]
+}
+
+// Archive entries read one after the other as importer files
+type entryFiles struct {
+	entries []*zip.File
+	open    io.ReadCloser
+}
+
+func (f *entryFiles) Next() (string, io.Reader, error) {
+	if f.open != nil {
+		f.open.Close()
+		f.open = nil
+	}
+	if len(f.entries) == 0 {
+		return ``, nil, io.EOF
+	}
+	entry := f.entries[0]
+	f.entries = f.entries[1:]
+	r, err := entry.Open()
+	if err != nil {
+		return ``, nil, err
+	}
+	f.open = r
+	return entry.Name, r, nil
+}
+
+type byDepth []*zip.File
+
+func (m byDepth) Len() int      { return len(m) }
+func (m byDepth) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byDepth) Less(i, j int) bool {
+	di, dj := strings.Count(m[i].Name, `/`), strings.Count(m[j].Name, `/`)
+	if di != dj {
+		return di < dj
+	}
+	return m[i].Name < m[j].Name
+}
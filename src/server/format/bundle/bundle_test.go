@@ -0,0 +1,185 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/importer/importertest"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A zip archive of the files, given as name then contents
+func zipOf(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		f, err := w.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(files[i+1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// An obj importer whose model file lists the urls of the document's images, one a line, recording the names of the
+// files it is given
+func fakeImport(received *[]string) importer.ImportFunc {
+	return func(files importer.Files, name string) (*threejs.Document, error) {
+		doc := &threejs.Document{Object: threejs.NewObject(`Group`, name)}
+		for {
+			fileName, r, err := files.Next()
+			if err != nil {
+				return doc, nil
+			}
+			data, _ := ioutil.ReadAll(r)
+			if len(*received) == 0 {
+				for _, url := range strings.Split(string(data), "\n") {
+					doc.Images = append(doc.Images, map[string]interface{}{`url`: url})
+				}
+			}
+			*received = append(*received, fileName)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		data  [][]byte
+		// the error expected, when empty the import must succeed
+		err string
+		// the files the model importer is given, in order
+		received []string
+		// the urls of the document's images, stored assets are given as the contents they were stored with
+		images []string
+	}{
+		{
+			name:  `no archive`,
+			files: []string{`model.obj`},
+			data:  [][]byte{[]byte(`v 0 0 0`)},
+			err:   `upload contains no .zip or .kmz file`,
+		},
+		{
+			name:  `not a zip`,
+			files: []string{`model.zip`},
+			data:  [][]byte{[]byte(`PK`)},
+			err:   `zip: not a valid zip file`,
+		},
+		{
+			name:  `no model`,
+			files: []string{`model.zip`},
+			data:  [][]byte{zipOf(t, `readme.txt`, `hi`, `wood.png`, `png`)},
+			err:   `archive contains no model in a supported format`,
+		},
+		{
+			name:     `least nested model`,
+			files:    []string{`notes.txt`, `model.zip`},
+			data:     [][]byte{[]byte(`hi`), zipOf(t, `a/deep/b.obj`, ``, `a/c.obj`, ``, `a/c.mtl`, ``, `__MACOSX/a/._c.obj`, ``, `a/d.stl`, ``)},
+			received: []string{`a/c.obj`, `a/c.mtl`},
+			images:   []string{``},
+		},
+		{
+			name:     `kmz links its model`,
+			files:    []string{`place.kmz`},
+			data:     [][]byte{zipOf(t, `doc.kml`, `<kml><Placemark><Model><Link><href>models/b.obj</href></Link></Model></Placemark></kml>`, `a.obj`, ``, `models/b.obj`, ``)},
+			received: []string{`models/b.obj`},
+			images:   []string{``},
+		},
+		{
+			name:  `textures`,
+			files: []string{`model.zip`},
+			data: [][]byte{zipOf(t,
+				`models/m.obj`, strings.Join([]string{
+					`tex/wood.png`,
+					`TEX/Wood.PNG`,
+					`stone%20wall.jpg`,
+					`C:\Users\me\Desktop\grass.png`,
+					`file:///home/me/grass.png`,
+					`http://example.com/wood.png`,
+					`data:image/png;base64,cG5n`,
+					`missing.png`,
+				}, "\n"),
+				`models/tex/wood.png`, `wood`,
+				`stone wall.jpg`, `stone`,
+				`textures/grass.png`, `grass`,
+			)},
+			received: []string{`models/m.obj`},
+			images:   []string{`wood`, `wood`, `stone`, `grass`, `grass`, `http://example.com/wood.png`, `data:image/png;base64,cG5n`, `missing.png`},
+		},
+		{
+			// references out of the model's directory only ever resolve to entries of the archive
+			name:     `path traversal`,
+			files:    []string{`model.zip`},
+			data:     [][]byte{zipOf(t, `m.obj`, "../../../../etc/passwd\n/etc/hostname\n..\\..\\secret.png", `passwd`, `archived`)},
+			received: []string{`m.obj`, `passwd`},
+			images:   []string{`archived`, `/etc/hostname`, `..\..\secret.png`},
+		},
+		{
+			name:  `entry too large`,
+			files: []string{`model.zip`},
+			data:  [][]byte{zipOf(t, `m.obj`, ``, `huge.bin`, strings.Repeat(`0`, 1025))},
+			err:   `archive entry huge.bin expands to more than 0MB`,
+		},
+		{
+			name:  `archive too large`,
+			files: []string{`model.zip`},
+			data:  [][]byte{zipOf(t, `m.obj`, ``, `a.bin`, strings.Repeat(`0`, 1024), `b.bin`, strings.Repeat(`0`, 1024))},
+			err:   `archive expands to more than 0MB`,
+		},
+	}
+	defer func(entry, archive uint64) { maxEntrySize, maxArchiveSize = entry, archive }(maxEntrySize, maxArchiveSize)
+	maxEntrySize, maxArchiveSize = 1024, 2047
+
+	dir, err := ioutil.TempDir(``, `bundle`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	assets, err := asset.NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		var received []string
+		h := importer.NewHandler(golog.NewDevNullLog())
+		h.Register(`obj`, fakeImport(&received))
+		doc, err := NewImportFunc(h, assets)(importertest.NewFiles(test.files, test.data), ``)
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(received, test.received) {
+			t.Errorf(`%s: importer got files %v, want %v`, test.name, received, test.received)
+		}
+		var images []string
+		for _, image := range doc.Images {
+			url := image[`url`].(string)
+			if _, data, err := asset.ReadRef(assets, url); err == nil {
+				url = string(data)
+			}
+			images = append(images, url)
+		}
+		if !reflect.DeepEqual(images, test.images) {
+			t.Errorf(`%s: got images %q, want %q`, test.name, images, test.images)
+		}
+		doc.Close()
+	}
+}
@@ -0,0 +1,145 @@
+/*
+COLLADA (.dae) reading into Three.js json for the importer, covering the node hierarchy with its transforms, triangle
+and polygon meshes, common profile materials and the images they reference
+*/
+package collada
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// An xml element kept in document order, since transforms apply in the order they are written
+type element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []*element `xml:",any"`
+}
+
+type parseError struct {
+	msg string
+}
+
+func (e *parseError) Error() string {
+	return `collada: ` + e.msg
+}
+
+func (e *element) attr(name string) string {
+	if e == nil {
+		return ``
+	}
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ``
+}
+
+// The first child with the local name, nil when there is none
+func (e *element) child(name string) *element {
+	if e == nil {
+		return nil
+	}
+	for _, c := range e.Children {
+		if c.XMLName.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (e *element) children(name string) []*element {
+	if e == nil {
+		return nil
+	}
+	var out []*element
+	for _, c := range e.Children {
+		if c.XMLName.Local == name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Follows a chain of first children
+func (e *element) path(names ...string) *element {
+	for _, name := range names {
+		e = e.child(name)
+	}
+	return e
+}
+
+func (e *element) text() string {
+	if e == nil {
+		return ``
+	}
+	return strings.TrimSpace(e.Text)
+}
+
+func (e *element) floats() ([]float64, error) {
+	fields := strings.Fields(e.text())
+	out := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, &parseError{msg: `invalid number ` + strconv.Quote(field) + ` in <` + e.XMLName.Local + `>`}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (e *element) ints() ([]int, error) {
+	fields := strings.Fields(e.text())
+	out := make([]int, len(fields))
+	for i, field := range fields {
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, &parseError{msg: `invalid index ` + strconv.Quote(field) + ` in <` + e.XMLName.Local + `>`}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// A parsed file with its elements indexed by id for resolving "#id" urls
+type document struct {
+	root *element
+	ids  map[string]*element
+}
+
+func parse(r io.Reader) (*document, error) {
+	root := &element{}
+	if err := xml.NewDecoder(r).Decode(root); err != nil {
+		return nil, &parseError{msg: err.Error()}
+	}
+	if root.XMLName.Local != `COLLADA` {
+		return nil, &parseError{msg: `not a COLLADA file`}
+	}
+	d := &document{root: root, ids: map[string]*element{}}
+	var index func(e *element)
+	index = func(e *element) {
+		if id := e.attr(`id`); id != `` {
+			d.ids[id] = e
+		}
+		for _, c := range e.Children {
+			index(c)
+		}
+	}
+	index(root)
+	return d, nil
+}
+
+// The element a url such as "#id" refers to, nil when it is not in the file
+func (d *document) resolve(url string) *element {
+	return d.ids[strings.TrimPrefix(url, `#`)]
+}
+
+func dataUri(mimeType string, data []byte) string {
+	return `data:` + mimeType + `;base64,` + base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,148 @@
+package collada
+
+import (
+	"fmt"
+	"github.com/robsix/3ditor/src/server/importer/importertest"
+	"strings"
+	"testing"
+)
+
+// A file with a textured red triangle under a translated node, replacing the named placeholders in the template
+// edits it
+func triangleDae(replace ...string) []byte {
+	dae := `<?xml version="1.0" encoding="utf-8"?>
+<COLLADA xmlns="http://www.collada.org/2005/11/COLLADASchema" version="1.4.1">
+  <asset><up_axis>UP</up_axis></asset>
+  <library_images><image id="wood"><init_from>C:\Users\me\textures\wood.png</init_from></image></library_images>
+  <library_effects>
+    <effect id="red-fx"><profile_COMMON>
+      <newparam sid="wood-surface"><surface type="2D"><init_from>wood</init_from></surface></newparam>
+      <newparam sid="wood-sampler"><sampler2D><source>wood-surface</source></sampler2D></newparam>
+      <technique sid="common"><phong>
+        <diffuse>DIFFUSE</diffuse>
+        <transparent opaque="A_ONE"><color>1 1 1 0.5</color></transparent>
+      </phong></technique>
+    </profile_COMMON></effect>
+  </library_effects>
+  <library_materials><material id="red" name="red"><instance_effect url="#red-fx"/></material></library_materials>
+  <library_geometries>
+    <geometry id="tri" name="tri"><mesh>
+      <source id="tri-pos"><float_array id="tri-pos-array" count="9">POSITIONS</float_array>
+        <technique_common><accessor source="#tri-pos-array" count="3" stride="3"/></technique_common></source>
+      <vertices id="tri-vtx"><input semantic="POSITION" source="#tri-pos"/></vertices>
+      <triangles material="mat" count="1"><input semantic="VERTEX" source="#tri-vtx" offset="0"/><p>INDICES</p></triangles>
+    </mesh></geometry>
+  </library_geometries>
+  <library_visual_scenes>
+    <visual_scene id="scene">
+      <node id="n" name="triangle"><translate>TRANSLATE</translate>
+        <instance_geometry url="#tri"><bind_material><technique_common>
+          <instance_material symbol="mat" target="#red"/>
+        </technique_common></bind_material></instance_geometry>
+      </node>
+    </visual_scene>
+  </library_visual_scenes>
+  <scene><instance_visual_scene url="#scene"/></scene>
+</COLLADA>`
+	defaults := map[string]string{
+		`UP`:        `Y_UP`,
+		`DIFFUSE`:   `<texture texture="wood-sampler" texcoord="uv"/>`,
+		`POSITIONS`: `0 0 0 1 0 0 0 1 0`,
+		`INDICES`:   `0 1 2`,
+		`TRANSLATE`: `1 2 3`,
+	}
+	for i := 0; i+1 < len(replace); i += 2 {
+		defaults[replace[i]] = replace[i+1]
+	}
+	for placeholder, value := range defaults {
+		dae = strings.Replace(dae, placeholder, value, -1)
+	}
+	return []byte(dae)
+}
+
+// A file whose scene instances a library node that instances the next level twice, and so on, so it expands to
+// 2^levels meshes
+func nestedDae(levels int) []byte {
+	var nodes strings.Builder
+	for i := 0; i < levels; i++ {
+		fmt.Fprintf(&nodes, `<node id="n%d"><instance_node url="#n%d"/><instance_node url="#n%d"/></node>`, i, i+1, i+1)
+	}
+	fmt.Fprintf(&nodes, `<node id="n%d"><instance_geometry url="#tri"/></node>`, levels)
+	return []byte(strings.Replace(string(triangleDae(`</node>`, `<instance_node url="#n0"/></node>`)), `<library_visual_scenes>`, `<library_nodes>`+nodes.String()+`</library_nodes><library_visual_scenes>`, 1))
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		data  [][]byte
+		// the error expected, when empty the import must succeed
+		err string
+	}{
+		{name: `no dae file`, files: []string{`wood.png`}, data: [][]byte{{1}}, err: `upload contains no .dae file`},
+		{name: `not xml`, files: []string{`a.dae`}, data: [][]byte{[]byte(`ply`)}, err: `collada: EOF`},
+		{name: `not collada`, files: []string{`a.dae`}, data: [][]byte{[]byte(`<svg/>`)}, err: `collada: not a COLLADA file`},
+		{name: `no visual scene`, files: []string{`a.dae`}, data: [][]byte{[]byte(`<COLLADA/>`)}, err: `collada: no visual scene`},
+		{name: `bad number`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`POSITIONS`, `0 0 x`)}, err: `collada: invalid number "x" in <float_array>`},
+		{name: `bad index`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`INDICES`, `0 1 -`)}, err: `collada: invalid index "-" in <p>`},
+		{name: `index out of range`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`INDICES`, `0 1 3`)}, err: `collada: geometry tri has an index out of range`},
+		{name: `negative index`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`INDICES`, `0 1 -1`)}, err: `collada: geometry tri has an index out of range`},
+		{name: `short translate`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`TRANSLATE`, `1 2`)}, err: `collada: translate needs 3 values`},
+		{name: `instance node cycle`, files: []string{`a.dae`}, data: [][]byte{triangleDae(`</node>`, `<instance_node url="#n"/></node>`)}, err: `collada: nodes nested too deeply`},
+		{name: `instance node fan out`, files: []string{`a.dae`}, data: [][]byte{nestedDae(20)}, err: `collada: too many nodes`},
+		{name: `instanced nodes`, files: []string{`a.dae`}, data: [][]byte{nestedDae(3)}},
+		{name: `valid`, files: []string{`a.dae`}, data: [][]byte{triangleDae()}},
+	}
+	for _, test := range tests {
+		doc, err := Import(importertest.NewFiles(test.files, test.data), ``)
+		if test.err == `` && err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		} else if test.err != `` && (err == nil || err.Error() != test.err) {
+			t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+		}
+		if doc != nil {
+			doc.Close()
+		}
+	}
+}
+
+func TestImportTriangle(t *testing.T) {
+	doc, err := Import(importertest.NewFiles([]string{`models/tri.dae`, `wood.png`}, [][]byte{triangleDae(`UP`, `Z_UP`), []byte(`png`)}), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if doc.Object.Name != `tri` || doc.Object.Matrix[6] != -1 || len(doc.Object.Children) != 1 {
+		t.Errorf(`got root %q with matrix %v and %d children, want tri rotated to Y up with one child`, doc.Object.Name, doc.Object.Matrix, len(doc.Object.Children))
+	}
+	mesh := doc.Object.Children[0]
+	if mesh.Type != `Mesh` || mesh.Name != `triangle` || mesh.Matrix[12] != 1 || mesh.Matrix[13] != 2 || mesh.Matrix[14] != 3 {
+		t.Errorf(`got %s %q with matrix %v`, mesh.Type, mesh.Name, mesh.Matrix)
+	}
+	if len(doc.Geometries) != 1 || doc.Geometries[0].VertexCount() != 3 || doc.Geometries[0].Attribute(`normal`) == nil {
+		t.Errorf(`got %d geometries, want one triangle with normals`, len(doc.Geometries))
+	}
+	if len(doc.Materials) != 1 {
+		t.Fatalf(`got %d materials, want 1`, len(doc.Materials))
+	}
+	material := doc.Materials[0]
+	if material[`type`] != `MeshPhongMaterial` || material[`opacity`] != 0.5 || material[`map`] == nil {
+		t.Errorf(`got material %v`, material)
+	}
+	// the image is found by its file name whatever the path it was exported with
+	if len(doc.Images) != 1 || doc.Images[0][`url`] != `data:image/png;base64,cG5n` {
+		t.Errorf(`got images %v`, doc.Images)
+	}
+}
+
+func TestImportMissingImage(t *testing.T) {
+	doc, err := Import(importertest.NewFiles([]string{`tri.dae`}, [][]byte{triangleDae(`C:\Users\me\textures\wood.png`, `textures/wood%20grain.png`)}), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	// relative paths are kept for the editor to resolve and nothing is read from the server's disk
+	if len(doc.Images) != 1 || doc.Images[0][`url`] != `textures/wood grain.png` {
+		t.Errorf(`got images %v`, doc.Images)
+	}
+}
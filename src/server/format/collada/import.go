@@ -0,0 +1,763 @@
+package collada
+
+import (
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// THREE.RepeatWrapping, texture coordinates routinely fall outside 0 to 1
+	repeatWrapping = 1000
+	// transforms nested deeper than this are taken to be instance_node cycles
+	maxDepth = 256
+	// instanced nodes are copied, so a few kilobytes of nodes each instancing the next twice would expand without end
+	maxNodes = 100000
+)
+
+var (
+	// rotations turning Z_UP and X_UP files the right way up for the editor's Y up
+	zUp = []float64{1, 0, 0, 0, 0, 0, -1, 0, 0, 1, 0, 0, 0, 0, 0, 1}
+	xUp = []float64{0, 1, 0, 0, -1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+)
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .dae file`
+}
+
+// Imports every .dae file of an upload, the other files are the images they reference by name which are embedded as
+// data uris. Several files become a group so a batch of models can be imported at once.
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	type model struct {
+		name string
+		doc  *document
+	}
+	var models []*model
+	images := map[string][]byte{}
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if importer.Ext(fileName) == `dae` {
+			d, err := parse(r)
+			if err != nil {
+				return nil, err
+			}
+			models = append(models, &model{name: importer.BaseName(fileName), doc: d})
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		images[path.Base(fileName)] = data
+	}
+	if len(models) == 0 {
+		return nil, &noModelError{}
+	}
+
+	if name == `` {
+		name = models[0].name
+	}
+	doc := &threejs.Document{}
+	var roots []*threejs.Object
+	for _, m := range models {
+		b := &builder{doc: doc, d: m.doc, images: images, geometries: map[string]*geometry{}, materials: map[string]threejs.Material{}, textures: map[string]string{}, added: map[string]bool{}}
+		root, err := b.scene(m.name)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 1 {
+		doc.Object = roots[0]
+		doc.Object.Name = name
+	} else {
+		doc.Object = threejs.NewObject(`Group`, name)
+		doc.Object.Children = roots
+	}
+	return doc, nil
+}
+
+type builder struct {
+	doc    *threejs.Document
+	d      *document
+	images map[string][]byte
+	// converted geometries by id, materials by id and whether they use vertex colours or by the uuids a multi
+	// material combines, textures by image id
+	geometries map[string]*geometry
+	materials  map[string]threejs.Material
+	textures   map[string]string
+	// uuids of the materials already in the document
+	added map[string]bool
+	// the number of nodes converted so far
+	nodes int
+}
+
+// A converted geometry with the material symbol of each of its groups
+type geometry struct {
+	geometry     *threejs.Geometry
+	symbols      []string
+	vertexColors bool
+}
+
+// The visual scene as a group, rotated to Y up when the file says another axis is up
+func (b *builder) scene(name string) (*threejs.Object, error) {
+	visualScene := b.d.resolve(b.d.root.path(`scene`, `instance_visual_scene`).attr(`url`))
+	if visualScene == nil {
+		visualScene = b.d.root.path(`library_visual_scenes`, `visual_scene`)
+	}
+	if visualScene == nil {
+		return nil, &parseError{msg: `no visual scene`}
+	}
+	root := threejs.NewObject(`Group`, name)
+	switch b.d.root.path(`asset`, `up_axis`).text() {
+	case `Z_UP`:
+		root.Matrix = append([]float64{}, zUp...)
+	case `X_UP`:
+		root.Matrix = append([]float64{}, xUp...)
+	}
+	for _, node := range visualScene.children(`node`) {
+		object, err := b.node(node, 0)
+		if err != nil {
+			return nil, err
+		}
+		root.Children = append(root.Children, object)
+	}
+	return root, nil
+}
+
+// Converts a node, a node with a single geometry becomes the mesh itself and one with several gets a mesh child for
+// each of them
+func (b *builder) node(node *element, depth int) (*threejs.Object, error) {
+	if depth > maxDepth {
+		return nil, &parseError{msg: `nodes nested too deeply`}
+	}
+	if b.nodes++; b.nodes > maxNodes {
+		return nil, &parseError{msg: `too many nodes`}
+	}
+	name := node.attr(`name`)
+	if name == `` {
+		name = node.attr(`id`)
+	}
+	matrix, err := transform(node)
+	if err != nil {
+		return nil, err
+	}
+
+	var meshes []*threejs.Object
+	for _, instance := range node.children(`instance_geometry`) {
+		object, err := b.mesh(instance, name)
+		if err != nil {
+			return nil, err
+		}
+		if object != nil {
+			meshes = append(meshes, object)
+		}
+	}
+	var object *threejs.Object
+	if len(meshes) == 1 {
+		object = meshes[0]
+	} else {
+		object = threejs.NewObject(`Group`, name)
+		object.Children = meshes
+	}
+	object.Matrix = matrix
+
+	for _, instance := range node.children(`instance_node`) {
+		library := b.d.resolve(instance.attr(`url`))
+		if library == nil {
+			continue
+		}
+		child, err := b.node(library, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object.Children = append(object.Children, child)
+	}
+	for _, childNode := range node.children(`node`) {
+		child, err := b.node(childNode, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object.Children = append(object.Children, child)
+	}
+	return object, nil
+}
+
+// The product of the node's transform elements in the order they are written, as a column major matrix
+func transform(node *element) ([]float64, error) {
+	m := mesh.Identity()
+	for _, c := range node.Children {
+		var t []float64
+		switch c.XMLName.Local {
+		case `matrix`, `translate`, `rotate`, `scale`:
+		default:
+			continue
+		}
+		values, err := c.floats()
+		if err != nil {
+			return nil, err
+		}
+		switch c.XMLName.Local {
+		case `matrix`:
+			if len(values) != 16 {
+				return nil, &parseError{msg: `matrix needs 16 values`}
+			}
+			// COLLADA matrices are written row by row
+			t = make([]float64, 16)
+			for row := 0; row < 4; row++ {
+				for col := 0; col < 4; col++ {
+					t[col*4+row] = values[row*4+col]
+				}
+			}
+		case `translate`:
+			if len(values) != 3 {
+				return nil, &parseError{msg: `translate needs 3 values`}
+			}
+			t = mesh.Identity()
+			t[12], t[13], t[14] = values[0], values[1], values[2]
+		case `rotate`:
+			if len(values) != 4 {
+				return nil, &parseError{msg: `rotate needs 4 values`}
+			}
+			t = rotation(values[0], values[1], values[2], values[3]*math.Pi/180)
+		case `scale`:
+			if len(values) != 3 {
+				return nil, &parseError{msg: `scale needs 3 values`}
+			}
+			t = mesh.Identity()
+			t[0], t[5], t[10] = values[0], values[1], values[2]
+		}
+		m = mesh.Multiply(m, t)
+	}
+	return m, nil
+}
+
+// A rotation by angle radians about an axis, as Matrix4.makeRotationAxis builds it
+func rotation(x, y, z, angle float64) []float64 {
+	length := math.Sqrt(x*x + y*y + z*z)
+	if length == 0 {
+		return mesh.Identity()
+	}
+	x, y, z = x/length, y/length, z/length
+	c, s := math.Cos(angle), math.Sin(angle)
+	t := 1 - c
+	return []float64{
+		t*x*x + c, t*x*y + s*z, t*x*z - s*y, 0,
+		t*x*y - s*z, t*y*y + c, t*y*z + s*x, 0,
+		t*x*z + s*y, t*y*z - s*x, t*z*z + c, 0,
+		0, 0, 0, 1,
+	}
+}
+
+// A mesh for an instance_geometry with the materials its bind_material binds to the geometry's symbols, nil for
+// geometries without triangles such as splines
+func (b *builder) mesh(instance *element, name string) (*threejs.Object, error) {
+	id := strings.TrimPrefix(instance.attr(`url`), `#`)
+	g, exists := b.geometries[id]
+	if !exists {
+		var err error
+		if g, err = b.geometry(id); err != nil {
+			return nil, err
+		}
+		b.geometries[id] = g
+	}
+	if g == nil {
+		return nil, nil
+	}
+
+	targets := map[string]string{}
+	for _, bound := range instance.path(`bind_material`, `technique_common`).children(`instance_material`) {
+		targets[bound.attr(`symbol`)] = bound.attr(`target`)
+	}
+	materials := make([]threejs.Material, len(g.symbols))
+	for i, symbol := range g.symbols {
+		target, bound := targets[symbol]
+		if !bound {
+			// some exporters leave out bind_material and use the material id as the symbol
+			target = symbol
+		}
+		material, err := b.material(target, g.vertexColors)
+		if err != nil {
+			return nil, err
+		}
+		materials[i] = material
+	}
+	material := materials[0]
+	if len(materials) > 1 {
+		uuids := make([]string, len(materials))
+		for i, m := range materials {
+			uuids[i] = m.Uuid()
+		}
+		key := strings.Join(uuids, ` `)
+		if material = b.materials[key]; material == nil {
+			material = threejs.NewMultiMaterial(materials)
+			b.materials[key] = material
+		}
+	}
+	if !b.added[material.Uuid()] {
+		b.added[material.Uuid()] = true
+		b.doc.Materials = append(b.doc.Materials, material)
+	}
+
+	object := threejs.NewObject(`Mesh`, name)
+	object.Geometry = g.geometry.Uuid
+	object.Material = material.Uuid()
+	return object, nil
+}
+
+// An input of a primitive, the source its indices read from and where in each corner its index is
+type input struct {
+	semantic string
+	source   *source
+	offset   int
+}
+
+type source struct {
+	values []float64
+	stride int
+}
+
+func (b *builder) source(url string) (*source, error) {
+	e := b.d.resolve(url)
+	if e == nil {
+		return nil, &parseError{msg: `missing source ` + url}
+	}
+	values, err := e.child(`float_array`).floats()
+	if err != nil {
+		return nil, err
+	}
+	stride := 1
+	if accessor := e.path(`technique_common`, `accessor`); accessor != nil {
+		if n, err := strconv.Atoi(accessor.attr(`stride`)); err == nil && n > 0 {
+			stride = n
+		}
+	}
+	return &source{values: values, stride: stride}, nil
+}
+
+// Reads the triangles, polylist and polygons of a mesh into one geometry with a group per primitive. Each distinct
+// combination of position, normal, texture coordinate and colour index becomes a vertex.
+func (b *builder) geometry(id string) (*geometry, error) {
+	e := b.d.ids[id]
+	meshElement := e.child(`mesh`)
+	if meshElement == nil {
+		return nil, nil
+	}
+	vertices := meshElement.child(`vertices`)
+
+	type key [4]int
+	unique := map[key]uint32{}
+	var positions, normals, uvs, colors threejs.FloatArray
+	var index threejs.UintArray
+	hasNormals, hasUvs, hasColors := true, false, false
+	out := &geometry{geometry: threejs.NewGeometry(e.attr(`name`))}
+	symbols := map[string]int{}
+
+	for _, primitive := range meshElement.Children {
+		kind := primitive.XMLName.Local
+		if kind != `triangles` && kind != `polylist` && kind != `polygons` {
+			continue
+		}
+		var inputs [4]*input
+		stride := 0
+		for _, in := range primitive.children(`input`) {
+			offset, _ := strconv.Atoi(in.attr(`offset`))
+			if offset+1 > stride {
+				stride = offset + 1
+			}
+			semantic := in.attr(`semantic`)
+			if semantic == `VERTEX` {
+				// the vertices element can carry normals and texture coordinates alongside positions
+				for _, vertexInput := range vertices.children(`input`) {
+					if err := b.addInput(&inputs, vertexInput.attr(`semantic`), vertexInput.attr(`source`), offset); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if err := b.addInput(&inputs, semantic, in.attr(`source`), offset); err != nil {
+				return nil, err
+			}
+		}
+		if inputs[0] == nil {
+			return nil, &parseError{msg: `geometry ` + id + ` has a primitive without positions`}
+		}
+		hasNormals = hasNormals && inputs[1] != nil
+		hasUvs = hasUvs || inputs[2] != nil
+		hasColors = hasColors || inputs[3] != nil
+
+		polygons, err := polygonIndices(primitive, kind, stride)
+		if err != nil {
+			return nil, err
+		}
+		symbol := primitive.attr(`material`)
+		materialIndex, exists := symbols[symbol]
+		if !exists {
+			materialIndex = len(out.symbols)
+			symbols[symbol] = materialIndex
+			out.symbols = append(out.symbols, symbol)
+		}
+		start := len(index)
+
+		for _, polygon := range polygons {
+			corners := make([]uint32, 0, len(polygon)/stride)
+			for c := 0; c+stride <= len(polygon); c += stride {
+				k := key{-1, -1, -1, -1}
+				for i, in := range inputs {
+					if in != nil {
+						k[i] = polygon[c+in.offset]
+						if k[i] < 0 || k[i]*in.source.stride+minComponents(i) > len(in.source.values) {
+							return nil, &parseError{msg: `geometry ` + id + ` has an index out of range`}
+						}
+					}
+				}
+				vertex, seen := unique[k]
+				if !seen {
+					vertex = uint32(len(positions) / 3)
+					unique[k] = vertex
+					positions = appendValues(positions, inputs[0], k[0], 3, 0)
+					normals = appendValues(normals, inputs[1], k[1], 3, 0)
+					uvs = appendValues(uvs, inputs[2], k[2], 2, 0)
+					colors = appendValues(colors, inputs[3], k[3], 3, 1)
+				}
+				corners = append(corners, vertex)
+			}
+			for i := 1; i+1 < len(corners); i++ {
+				index = append(index, corners[0], corners[i], corners[i+1])
+			}
+		}
+		out.geometry.Groups = append(out.geometry.Groups, &threejs.Group{Start: start, Count: len(index) - start, MaterialIndex: materialIndex})
+	}
+	if len(index) == 0 {
+		return nil, nil
+	}
+
+	out.geometry.AddAttribute(`position`, 3, positions)
+	if hasNormals {
+		out.geometry.AddAttribute(`normal`, 3, normals)
+	} else {
+		computed, err := threejs.ComputeNormals(positions, index)
+		if err != nil {
+			return nil, err
+		}
+		out.geometry.AddAttribute(`normal`, 3, computed)
+	}
+	if hasUvs {
+		out.geometry.AddAttribute(`uv`, 2, uvs)
+	}
+	if hasColors {
+		out.geometry.AddAttribute(`color`, 3, colors)
+		out.vertexColors = true
+	}
+	out.geometry.Index = index
+	if len(out.symbols) == 1 {
+		out.geometry.Groups = nil
+	}
+	b.doc.Geometries = append(b.doc.Geometries, out.geometry)
+	return out, nil
+}
+
+// The number of values an attribute takes from its source
+func minComponents(attribute int) int {
+	if attribute == 2 {
+		return 2
+	}
+	return 3
+}
+
+// Records an input as the position, normal, texture coordinate or colour, only the first texture coordinate set is
+// kept
+func (b *builder) addInput(inputs *[4]*input, semantic, url string, offset int) error {
+	slot := -1
+	switch semantic {
+	case `POSITION`:
+		slot = 0
+	case `NORMAL`:
+		slot = 1
+	case `TEXCOORD`:
+		slot = 2
+	case `COLOR`:
+		slot = 3
+	}
+	if slot < 0 || inputs[slot] != nil {
+		return nil
+	}
+	s, err := b.source(url)
+	if err != nil {
+		return err
+	}
+	if s.stride < minComponents(slot) {
+		return &parseError{msg: `source ` + url + ` has too few components for ` + semantic}
+	}
+	inputs[slot] = &input{semantic: semantic, source: s, offset: offset}
+	return nil
+}
+
+// The corner indices of every polygon of a primitive, each corner has stride indices
+func polygonIndices(primitive *element, kind string, stride int) ([][]int, error) {
+	switch kind {
+	case `triangles`:
+		p, err := primitive.child(`p`).ints()
+		if err != nil {
+			return nil, err
+		}
+		var polygons [][]int
+		for i := 0; i+stride*3 <= len(p); i += stride * 3 {
+			polygons = append(polygons, p[i:i+stride*3])
+		}
+		return polygons, nil
+	case `polylist`:
+		p, err := primitive.child(`p`).ints()
+		if err != nil {
+			return nil, err
+		}
+		counts, err := primitive.child(`vcount`).ints()
+		if err != nil {
+			return nil, err
+		}
+		var polygons [][]int
+		offset := 0
+		for _, count := range counts {
+			end := offset + count*stride
+			if count < 0 || end > len(p) {
+				return nil, &parseError{msg: `polylist vcount exceeds its indices`}
+			}
+			polygons = append(polygons, p[offset:end])
+			offset = end
+		}
+		return polygons, nil
+	}
+	// polygons has a <p> per polygon, holes given by <ph> are not cut out
+	var polygons [][]int
+	for _, e := range primitive.children(`p`) {
+		p, err := e.ints()
+		if err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, p)
+	}
+	for _, e := range primitive.children(`ph`) {
+		p, err := e.child(`p`).ints()
+		if err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, p)
+	}
+	return polygons, nil
+}
+
+// Appends n values for element i of the input's source, or n copies of fill when the primitive has no such input
+func appendValues(values threejs.FloatArray, in *input, i, n int, fill float32) threejs.FloatArray {
+	if in == nil {
+		for j := 0; j < n; j++ {
+			values = append(values, fill)
+		}
+		return values
+	}
+	start := i * in.source.stride
+	for j := 0; j < n; j++ {
+		values = append(values, float32(in.source.values[start+j]))
+	}
+	return values
+}
+
+// Maps the common profile effect of a material onto the Three.js material its shading model corresponds to
+func (b *builder) material(id string, vertexColors bool) (threejs.Material, error) {
+	key := id + strconv.FormatBool(vertexColors)
+	if material, exists := b.materials[key]; exists {
+		return material, nil
+	}
+	e := b.d.resolve(id)
+	name := e.attr(`name`)
+	if name == `` {
+		name = strings.TrimPrefix(id, `#`)
+	}
+	effect := b.d.resolve(e.path(`instance_effect`).attr(`url`))
+	profile := effect.child(`profile_COMMON`)
+	technique := profile.child(`technique`)
+
+	typ, shading := `MeshPhongMaterial`, technique.child(`phong`)
+	if shading == nil {
+		shading = technique.child(`blinn`)
+	}
+	if shading == nil {
+		if shading = technique.child(`lambert`); shading != nil {
+			typ = `MeshLambertMaterial`
+		} else if shading = technique.child(`constant`); shading != nil {
+			typ = `MeshBasicMaterial`
+		}
+	}
+	material := threejs.NewMaterial(typ, name)
+	material[`color`] = 0xffffff
+	if shading != nil {
+		if color, ok := colorOf(shading.path(`diffuse`, `color`)); ok {
+			material[`color`] = hex(color)
+		}
+		if typ != `MeshBasicMaterial` {
+			if color, ok := colorOf(shading.path(`emission`, `color`)); ok {
+				material[`emissive`] = hex(color)
+			}
+		}
+		if typ == `MeshPhongMaterial` {
+			if color, ok := colorOf(shading.path(`specular`, `color`)); ok {
+				material[`specular`] = hex(color)
+			}
+			if shininess, err := shading.path(`shininess`, `float`).floats(); err == nil && len(shininess) == 1 {
+				material[`shininess`] = shininess[0]
+			}
+		}
+		if opacity := opacityOf(shading); opacity > 0 && opacity < 1 {
+			material[`opacity`] = opacity
+			material[`transparent`] = true
+		}
+		if texture := shading.path(`diffuse`, `texture`); texture != nil {
+			if uuid := b.texture(profile, texture.attr(`texture`)); uuid != `` {
+				material[`map`] = uuid
+			}
+		}
+	}
+	if doubleSided(effect) {
+		material[`side`] = threejs.DoubleSide
+	}
+	if vertexColors {
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	b.materials[key] = material
+	return material, nil
+}
+
+func colorOf(e *element) ([]float64, bool) {
+	values, err := e.floats()
+	if err != nil || len(values) < 3 {
+		return nil, false
+	}
+	return values, true
+}
+
+func hex(color []float64) int {
+	value := 0
+	for _, c := range color[:3] {
+		value = value<<8 | int(math.Max(0, math.Min(255, math.Floor(c*255+0.5))))
+	}
+	return value
+}
+
+// The opacity given by transparent and transparency in either of the COLLADA opacity modes, 1 when not given
+func opacityOf(shading *element) float64 {
+	transparency := 1.0
+	if values, err := shading.path(`transparency`, `float`).floats(); err == nil && len(values) == 1 {
+		transparency = values[0]
+	}
+	transparent := shading.child(`transparent`)
+	if transparent == nil {
+		return 1
+	}
+	color, ok := colorOf(transparent.child(`color`))
+	if !ok {
+		return 1
+	}
+	if transparent.attr(`opaque`) == `RGB_ZERO` {
+		return 1 - transparency*(color[0]+color[1]+color[2])/3
+	}
+	alpha := 1.0
+	if len(color) > 3 {
+		alpha = color[3]
+	}
+	return alpha * transparency
+}
+
+// Exporters mark double sided materials in the extra techniques of the effect
+func doubleSided(e *element) bool {
+	var found bool
+	var walk func(e *element)
+	walk = func(e *element) {
+		if e.XMLName.Local == `double_sided` && e.text() == `1` {
+			found = true
+		}
+		for _, c := range e.Children {
+			walk(c)
+		}
+	}
+	if e != nil {
+		walk(e)
+	}
+	return found
+}
+
+// Follows a texture's sampler to its image, the sampler refers to a surface in COLLADA 1.4 and straight to the image
+// in 1.5. Some exporters name the image itself.
+func (b *builder) texture(profile *element, sampler string) string {
+	image := sampler
+	params := map[string]*element{}
+	for _, param := range profile.children(`newparam`) {
+		params[param.attr(`sid`)] = param
+	}
+	if param := params[sampler]; param != nil {
+		s := param.child(`sampler2D`)
+		if instance := s.child(`instance_image`); instance != nil {
+			image = strings.TrimPrefix(instance.attr(`url`), `#`)
+		} else if surface := params[s.child(`source`).text()]; surface != nil {
+			image = surface.path(`surface`, `init_from`).text()
+		}
+	}
+	if uuid, exists := b.textures[image]; exists {
+		return uuid
+	}
+
+	imageElement := b.d.ids[image]
+	if imageElement == nil {
+		return ``
+	}
+	initFrom := imageElement.child(`init_from`)
+	src := initFrom.text()
+	if ref := initFrom.child(`ref`); ref != nil {
+		src = ref.text()
+	}
+	if src == `` {
+		return ``
+	}
+	if unescaped, err := url.QueryUnescape(src); err == nil {
+		src = unescaped
+	}
+	// paths are often absolute on the machine that exported them, so the image is looked up by its file name and only
+	// relative paths are kept when it was not uploaded
+	src = strings.Replace(strings.TrimPrefix(src, `file://`), `\`, `/`, -1)
+	fileName := path.Base(src)
+	if data, exists := b.images[fileName]; exists {
+		mimeType := mime.TypeByExtension(path.Ext(fileName))
+		if mimeType == `` {
+			mimeType = `application/octet-stream`
+		}
+		src = dataUri(mimeType, data)
+	} else if path.IsAbs(src) || strings.Contains(src, `:`) {
+		src = fileName
+	}
+
+	imageUuid := threejs.NewUuid()
+	b.doc.Images = append(b.doc.Images, map[string]interface{}{`uuid`: imageUuid, `url`: src})
+	uuid := threejs.NewUuid()
+	b.doc.Textures = append(b.doc.Textures, map[string]interface{}{
+		`uuid`:  uuid,
+		`name`:  fileName,
+		`image`: imageUuid,
+		`wrap`:  []int{repeatWrapping, repeatWrapping},
+	})
+	b.textures[image] = uuid
+	return uuid
+}
@@ -0,0 +1,107 @@
+package gltf
+
+import (
+	"bytes"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/scene"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Implements `server gltf <in> <out>` converting between editor scene files and glTF, the direction is chosen by the
+// extensions. A .gltf output gets its buffer written next to it as a .bin file of the same name.
+//
+//	server gltf scene.json model.glb
+//	server gltf model.gltf scene.json
+func RunCommand(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return &usageError{}
+	}
+	in, target := args[0], args[1]
+	inExt, targetExt := importer.Ext(in), importer.Ext(target)
+	switch {
+	case inExt == `json` && (targetExt == `glb` || targetExt == `gltf`):
+		return exportFile(in, target, targetExt)
+	case (inExt == `glb` || inExt == `gltf`) && targetExt == `json`:
+		return importFile(in, target)
+	}
+	return &usageError{}
+}
+
+func exportFile(in, target, format string) error {
+	doc, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	g, err := scene.ParseGraph(doc)
+	if err != nil {
+		return err
+	}
+	out, bin := Export(g, g.Root)
+
+	var buf bytes.Buffer
+	if format == `glb` {
+		err = WriteGlb(&buf, out, bin)
+	} else {
+		binName := importer.BaseName(target) + `.bin`
+		if err = ioutil.WriteFile(filepath.Join(filepath.Dir(target), binName), bin, 0644); err != nil {
+			return err
+		}
+		err = WriteGltf(&buf, out, bin, binName)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, buf.Bytes(), 0644)
+}
+
+// Reads the model with the files beside it available as its buffers and images
+func importFile(in, target string) error {
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	g, bin, err := Read(data)
+	if err != nil {
+		return err
+	}
+	resources := map[string][]byte{}
+	uris := []string{}
+	for _, buffer := range g.Buffers {
+		uris = append(uris, buffer.Uri)
+	}
+	for _, image := range g.Images {
+		uris = append(uris, image.Uri)
+	}
+	for _, uri := range uris {
+		if _, _, ok := decodeDataUri(uri); ok || uri == `` {
+			continue
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(in), filepath.FromSlash(uri))); err == nil {
+			resources[filepath.Base(uri)] = data
+		}
+	}
+
+	doc, err := newReader(g, bin, resources).document(importer.BaseName(in))
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := doc.WriteSceneTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+type usageError struct{}
+
+func (e *usageError) Error() string {
+	return `usage: gltf <scene.json> <out.glb|out.gltf> or gltf <in.glb|in.gltf> <scene.json>`
+}
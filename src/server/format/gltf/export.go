@@ -0,0 +1,463 @@
+package gltf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+)
+
+const (
+	generator = `3ditor`
+
+	// THREE.RepeatWrapping, THREE.ClampToEdgeWrapping and THREE.MirroredRepeatWrapping
+	repeatWrapping         = 1000
+	clampToEdgeWrapping    = 1001
+	mirroredRepeatWrapping = 1002
+	// THREE.DoubleSide
+	doubleSide = 2
+
+	glRepeat         = 10497
+	glClampToEdge    = 33071
+	glMirroredRepeat = 33648
+)
+
+var wrapModes = map[int]int{
+	repeatWrapping:         glRepeat,
+	clampToEdgeWrapping:    glClampToEdge,
+	mirroredRepeatWrapping: glMirroredRepeat,
+}
+
+// Converts the subtree of root, or the whole scene when root is the scene object, into glTF with all binary data in
+// a single buffer that is returned alongside it. Objects whose geometry can not be read are kept as empty nodes.
+func Export(g *scene.Graph, root string) (*Gltf, []byte) {
+	e := &exporter{
+		g:         g,
+		out:       &Gltf{Asset: Asset{Version: `2.0`, Generator: generator}},
+		meshes:    map[[2]string]int{},
+		materials: map[string]int{},
+		textures:  map[string]int{},
+		geometry:  map[string]*mesh.Mesh{},
+	}
+	sceneNodes := []int{}
+	if root == g.Root {
+		for _, child := range g.Objects[root].Children {
+			sceneNodes = append(sceneNodes, e.addNode(child, nil))
+		}
+	} else {
+		sceneNodes = append(sceneNodes, e.addNode(root, worldMatrix(g, root)))
+	}
+	name, _ := g.Objects[root].Object[`name`].(string)
+	e.out.Scenes = []*Scene{{Name: name, Nodes: sceneNodes}}
+	e.out.Scene = intPtr(0)
+	if e.bin.Len() > 0 {
+		e.out.Buffers = []*Buffer{{ByteLength: e.bin.Len()}}
+	}
+	return e.out, e.bin.Bytes()
+}
+
+type exporter struct {
+	g   *scene.Graph
+	out *Gltf
+	bin bytes.Buffer
+	// mesh indices by object type and geometry, material
+	meshes    map[[2]string]int
+	materials map[string]int
+	textures  map[string]int
+	geometry  map[string]*mesh.Mesh
+}
+
+// The world matrix of an object, the product of its own and all its ancestors' matrices
+func worldMatrix(g *scene.Graph, uuid string) []float64 {
+	world := mesh.Identity()
+	for node := g.Objects[uuid]; node != nil; node = g.Objects[node.Parent] {
+		if local, ok := mesh.Matrix(node.Object[`matrix`]); ok {
+			world = mesh.Multiply(local, world)
+		}
+	}
+	return world
+}
+
+// Adds the object and its descendants, matrix overrides the object's own matrix when it is not nil
+func (e *exporter) addNode(uuid string, matrix []float64) int {
+	node := e.g.Objects[uuid]
+	object := node.Object
+	out := &Node{}
+	out.Name, _ = object[`name`].(string)
+	if matrix == nil {
+		matrix, _ = mesh.Matrix(object[`matrix`])
+	}
+	if matrix != nil && !isIdentity(matrix) {
+		out.Matrix = matrix
+	}
+	index := len(e.out.Nodes)
+	e.out.Nodes = append(e.out.Nodes, out)
+
+	typ, _ := object[`type`].(string)
+	switch typ {
+	case `PerspectiveCamera`, `OrthographicCamera`:
+		out.Camera = e.addCamera(typ, object)
+	default:
+		if geometry, _ := object[`geometry`].(string); geometry != `` {
+			out.Mesh = e.addMesh(typ, object, geometry)
+		}
+	}
+	for _, child := range node.Children {
+		out.Children = append(out.Children, e.addNode(child, nil))
+	}
+	return index
+}
+
+func isIdentity(m []float64) bool {
+	for i, v := range mesh.Identity() {
+		if m[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *exporter) addCamera(typ string, object map[string]interface{}) *int {
+	number := func(key string, def float64) float64 {
+		if v, ok := object[key].(float64); ok {
+			return v
+		}
+		return def
+	}
+	camera := &Camera{}
+	camera.Name, _ = object[`name`].(string)
+	if typ == `PerspectiveCamera` {
+		camera.Type = `perspective`
+		camera.Perspective = &Perspective{
+			AspectRatio: number(`aspect`, 1),
+			Yfov:        number(`fov`, 50) * math.Pi / 180,
+			Znear:       number(`near`, 0.1),
+			Zfar:        floatPtr(number(`far`, 2000)),
+		}
+	} else {
+		camera.Type = `orthographic`
+		camera.Orthographic = &Orthographic{
+			Xmag:  (number(`right`, 1) - number(`left`, -1)) / 2,
+			Ymag:  (number(`top`, 1) - number(`bottom`, -1)) / 2,
+			Znear: number(`near`, 0.1),
+			Zfar:  number(`far`, 2000),
+		}
+	}
+	e.out.Cameras = append(e.out.Cameras, camera)
+	return intPtr(len(e.out.Cameras) - 1)
+}
+
+// The glTF primitive mode an object type draws its geometry with
+func primitiveMode(typ string, object map[string]interface{}) int {
+	switch typ {
+	case `Points`, `PointCloud`:
+		return modePoints
+	case `LineSegments`:
+		return modeLines
+	case `Line`:
+		if mode, _ := object[`mode`].(float64); mode == 1 {
+			return modeLines
+		}
+		return modeLineStrip
+	}
+	return modeTriangles
+}
+
+func (e *exporter) addMesh(typ string, object map[string]interface{}, geometryUuid string) *int {
+	mode := primitiveMode(typ, object)
+	materialUuid, _ := object[`material`].(string)
+	key := [2]string{typ + geometryUuid, materialUuid}
+	if index, exists := e.meshes[key]; exists {
+		return intPtr(index)
+	}
+
+	m, exists := e.geometry[geometryUuid]
+	if !exists {
+		if geometry := e.g.Geometries[geometryUuid]; geometry != nil {
+			m, _ = mesh.FromJson(geometry)
+		}
+		e.geometry[geometryUuid] = m
+	}
+	if m == nil || m.VertexCount() == 0 {
+		return nil
+	}
+
+	attributes := map[string]int{`POSITION`: e.addPositions(m.Positions)}
+	if m.Normals != nil && mode == modeTriangles {
+		attributes[`NORMAL`] = e.addFloats(m.Normals, 3, `VEC3`, targetArrayBuffer)
+	}
+	if m.Uvs != nil {
+		flipped := make([]float64, len(m.Uvs))
+		for i := 0; i < len(m.Uvs); i += 2 {
+			flipped[i], flipped[i+1] = m.Uvs[i], 1-m.Uvs[i+1]
+		}
+		attributes[`TEXCOORD_0`] = e.addFloats(flipped, 2, `VEC2`, targetArrayBuffer)
+	}
+	if m.Colors != nil {
+		linear := make([]float64, len(m.Colors))
+		for i, c := range m.Colors {
+			linear[i] = toLinear(c)
+		}
+		attributes[`COLOR_0`] = e.addFloats(linear, 3, `VEC3`, targetArrayBuffer)
+	}
+
+	material := e.g.Materials[materialUuid]
+	out := &Mesh{}
+	out.Name, _ = e.g.Geometries[geometryUuid][`name`].(string)
+	if mode != modeTriangles {
+		out.Primitives = []*Primitive{{Attributes: attributes, Mode: intPtr(mode), Material: e.addMaterial(material, 0)}}
+	} else if len(m.Groups) == 0 || !isMultiMaterial(material) {
+		out.Primitives = []*Primitive{{Attributes: attributes, Indices: e.addIndices(m.Indices), Material: e.addMaterial(material, 0)}}
+	} else {
+		for _, group := range m.Groups {
+			if group.Count == 0 {
+				continue
+			}
+			out.Primitives = append(out.Primitives, &Primitive{
+				Attributes: attributes,
+				Indices:    e.addIndices(m.Indices[group.Start : group.Start+group.Count]),
+				Material:   e.addMaterial(material, group.MaterialIndex),
+			})
+		}
+	}
+	e.out.Meshes = append(e.out.Meshes, out)
+	e.meshes[key] = len(e.out.Meshes) - 1
+	return intPtr(len(e.out.Meshes) - 1)
+}
+
+// Group material indices only select between the materials of a MultiMaterial, any other material draws every group
+func isMultiMaterial(material map[string]interface{}) bool {
+	typ, _ := material[`type`].(string)
+	return typ == `MultiMaterial`
+}
+
+// Appends data to the binary buffer as a new buffer view, keeping every view 4 byte aligned
+func (e *exporter) addView(data []byte, target int) int {
+	for e.bin.Len()%4 != 0 {
+		e.bin.WriteByte(0)
+	}
+	view := &BufferView{Buffer: 0, ByteOffset: e.bin.Len(), ByteLength: len(data), Target: target}
+	e.bin.Write(data)
+	e.out.BufferViews = append(e.out.BufferViews, view)
+	return len(e.out.BufferViews) - 1
+}
+
+func (e *exporter) addFloats(values []float64, itemSize int, typ string, target int) int {
+	data := make([]byte, len(values)*4)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(v)))
+	}
+	e.out.Accessors = append(e.out.Accessors, &Accessor{
+		BufferView:    intPtr(e.addView(data, target)),
+		ComponentType: componentFloat,
+		Count:         len(values) / itemSize,
+		Type:          typ,
+	})
+	return len(e.out.Accessors) - 1
+}
+
+// Positions must carry their bounds
+func (e *exporter) addPositions(positions []float64) int {
+	index := e.addFloats(positions, 3, `VEC3`, targetArrayBuffer)
+	min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i, v := range positions {
+		// bounds are compared against the stored float32 values
+		v = float64(float32(v))
+		min[i%3] = math.Min(min[i%3], v)
+		max[i%3] = math.Max(max[i%3], v)
+	}
+	e.out.Accessors[index].Min, e.out.Accessors[index].Max = min, max
+	return index
+}
+
+func (e *exporter) addIndices(indices []int) *int {
+	max := 0
+	for _, i := range indices {
+		if i > max {
+			max = i
+		}
+	}
+	var data []byte
+	componentType := componentUnsignedShort
+	if max < math.MaxUint16 {
+		data = make([]byte, len(indices)*2)
+		for i, v := range indices {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(v))
+		}
+	} else {
+		componentType = componentUnsignedInt
+		data = make([]byte, len(indices)*4)
+		for i, v := range indices {
+			binary.LittleEndian.PutUint32(data[i*4:], uint32(v))
+		}
+	}
+	e.out.Accessors = append(e.out.Accessors, &Accessor{
+		BufferView:    intPtr(e.addView(data, targetElementArrayBuffer)),
+		ComponentType: componentType,
+		Count:         len(indices),
+		Type:          `SCALAR`,
+	})
+	return intPtr(len(e.out.Accessors) - 1)
+}
+
+// Maps a Three.js material onto the metallic roughness model, choosing the material at index of a MultiMaterial.
+// Phong shininess becomes roughness, nothing in the editor is metallic and basic materials are marked unlit.
+func (e *exporter) addMaterial(material map[string]interface{}, index int) *int {
+	if material == nil {
+		return nil
+	}
+	if isMultiMaterial(material) {
+		list, _ := material[`materials`].([]interface{})
+		if len(list) == 0 {
+			return nil
+		}
+		if index < 0 || index >= len(list) {
+			index = 0
+		}
+		material, _ = list[index].(map[string]interface{})
+		if material == nil {
+			return nil
+		}
+	}
+	uuid, _ := material[`uuid`].(string)
+	if i, exists := e.materials[uuid]; exists {
+		return intPtr(i)
+	}
+
+	typ, _ := material[`type`].(string)
+	out := &Material{PbrMetallicRoughness: &Pbr{MetallicFactor: floatPtr(0)}}
+	out.Name, _ = material[`name`].(string)
+	color := []float64{1, 1, 1}
+	if hex, ok := material[`color`].(float64); ok {
+		color = linearColor(int(hex))
+	}
+	opacity := 1.0
+	if v, ok := material[`opacity`].(float64); ok {
+		opacity = v
+	}
+	out.PbrMetallicRoughness.BaseColorFactor = append(color, opacity)
+	if transparent, _ := material[`transparent`].(bool); transparent && opacity < 1 {
+		out.AlphaMode = `BLEND`
+	}
+	if alphaTest, _ := material[`alphaTest`].(float64); alphaTest > 0 && out.AlphaMode == `` {
+		out.AlphaMode = `MASK`
+		out.AlphaCutoff = floatPtr(alphaTest)
+	}
+	if side, _ := material[`side`].(float64); side == doubleSide {
+		out.DoubleSided = true
+	}
+	if hex, _ := material[`emissive`].(float64); hex != 0 {
+		out.EmissiveFactor = linearColor(int(hex))
+	}
+
+	switch typ {
+	case `MeshBasicMaterial`, `PointsMaterial`, `PointCloudMaterial`, `LineBasicMaterial`, `LineDashedMaterial`:
+		out.Extensions = map[string]interface{}{extensionUnlit: map[string]interface{}{}}
+		e.useExtension(extensionUnlit)
+	case `MeshPhongMaterial`:
+		shininess := 30.0
+		if v, ok := material[`shininess`].(float64); ok {
+			shininess = v
+		}
+		out.PbrMetallicRoughness.RoughnessFactor = floatPtr(math.Sqrt(2 / (math.Max(shininess, 0) + 2)))
+	default:
+		out.PbrMetallicRoughness.RoughnessFactor = floatPtr(1)
+	}
+	if texture, _ := material[`map`].(string); texture != `` {
+		if i := e.addTexture(texture); i != nil {
+			out.PbrMetallicRoughness.BaseColorTexture = &TextureRef{Index: *i}
+		}
+	}
+
+	e.out.Materials = append(e.out.Materials, out)
+	e.materials[uuid] = len(e.out.Materials) - 1
+	return intPtr(len(e.out.Materials) - 1)
+}
+
+func (e *exporter) useExtension(name string) {
+	for _, used := range e.out.ExtensionsUsed {
+		if used == name {
+			return
+		}
+	}
+	e.out.ExtensionsUsed = append(e.out.ExtensionsUsed, name)
+}
+
+// Images held as data uris are embedded in the binary buffer, any other url is referenced as it is
+func (e *exporter) addTexture(uuid string) *int {
+	if i, exists := e.textures[uuid]; exists {
+		return intPtr(i)
+	}
+	texture := e.g.Textures[uuid]
+	imageUuid, _ := texture[`image`].(string)
+	image := e.g.Images[imageUuid]
+	url, _ := image[`url`].(string)
+	if url == `` {
+		return nil
+	}
+
+	out := &Image{}
+	out.Name, _ = texture[`name`].(string)
+	if data, mimeType, ok := decodeDataUri(url); ok {
+		out.BufferView = intPtr(e.addView(data, 0))
+		out.MimeType = mimeType
+	} else {
+		out.Uri = url
+	}
+	e.out.Images = append(e.out.Images, out)
+
+	sampler := &Sampler{WrapS: glClampToEdge, WrapT: glClampToEdge}
+	if wrap, _ := texture[`wrap`].([]interface{}); len(wrap) == 2 {
+		s, _ := wrap[0].(float64)
+		t, _ := wrap[1].(float64)
+		if mode, ok := wrapModes[int(s)]; ok {
+			sampler.WrapS = mode
+		}
+		if mode, ok := wrapModes[int(t)]; ok {
+			sampler.WrapT = mode
+		}
+	}
+	e.out.Samplers = append(e.out.Samplers, sampler)
+
+	e.out.Textures = append(e.out.Textures, &Texture{
+		Name:    out.Name,
+		Sampler: intPtr(len(e.out.Samplers) - 1),
+		Source:  intPtr(len(e.out.Images) - 1),
+	})
+	e.textures[uuid] = len(e.out.Textures) - 1
+	return intPtr(len(e.out.Textures) - 1)
+}
+
+// glTF colour factors and vertex colours are linear while Three.js colours are sRGB
+func linearColor(hex int) []float64 {
+	out := make([]float64, 3)
+	for i := range out {
+		out[i] = toLinear(float64(hex>>uint(16-i*8)&0xff) / 255)
+	}
+	return out
+}
+
+func srgbColor(linear []float64) int {
+	hex := 0
+	for i := 0; i < 3 && i < len(linear); i++ {
+		hex = hex<<8 | int(math.Floor(toSrgb(linear[i])*255+0.5))
+	}
+	return hex
+}
+
+func toLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func toSrgb(c float64) float64 {
+	c = math.Max(0, math.Min(1, c))
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
@@ -0,0 +1,352 @@
+/*
+Conversion between editor scenes and glTF 2.0, as .gltf json with its buffer alongside or embedded, and as binary GLB
+*/
+package gltf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Accessor component types and buffer view targets
+const (
+	componentByte          = 5120
+	componentUnsignedByte  = 5121
+	componentShort         = 5122
+	componentUnsignedShort = 5123
+	componentUnsignedInt   = 5125
+	componentFloat         = 5126
+
+	targetArrayBuffer        = 34962
+	targetElementArrayBuffer = 34963
+)
+
+// Primitive modes
+const (
+	modePoints        = 0
+	modeLines         = 1
+	modeLineLoop      = 2
+	modeLineStrip     = 3
+	modeTriangles     = 4
+	modeTriangleStrip = 5
+	modeTriangleFan   = 6
+)
+
+const (
+	glbMagic     = 0x46546C67
+	glbVersion   = 2
+	glbChunkJson = 0x4E4F534A
+	glbChunkBin  = 0x004E4942
+
+	extensionUnlit = `KHR_materials_unlit`
+)
+
+type Gltf struct {
+	Asset              Asset         `json:"asset"`
+	Scene              *int          `json:"scene,omitempty"`
+	Scenes             []*Scene      `json:"scenes,omitempty"`
+	Nodes              []*Node       `json:"nodes,omitempty"`
+	Meshes             []*Mesh       `json:"meshes,omitempty"`
+	Materials          []*Material   `json:"materials,omitempty"`
+	Textures           []*Texture    `json:"textures,omitempty"`
+	Images             []*Image      `json:"images,omitempty"`
+	Samplers           []*Sampler    `json:"samplers,omitempty"`
+	Cameras            []*Camera     `json:"cameras,omitempty"`
+	Accessors          []*Accessor   `json:"accessors,omitempty"`
+	BufferViews        []*BufferView `json:"bufferViews,omitempty"`
+	Buffers            []*Buffer     `json:"buffers,omitempty"`
+	ExtensionsUsed     []string      `json:"extensionsUsed,omitempty"`
+	ExtensionsRequired []string      `json:"extensionsRequired,omitempty"`
+}
+
+type Asset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator,omitempty"`
+}
+
+type Scene struct {
+	Name  string `json:"name,omitempty"`
+	Nodes []int  `json:"nodes,omitempty"`
+}
+
+type Node struct {
+	Name        string      `json:"name,omitempty"`
+	Children    []int       `json:"children,omitempty"`
+	Matrix      []float64   `json:"matrix,omitempty"`
+	Translation []float64   `json:"translation,omitempty"`
+	Rotation    []float64   `json:"rotation,omitempty"`
+	Scale       []float64   `json:"scale,omitempty"`
+	Mesh        *int        `json:"mesh,omitempty"`
+	Camera      *int        `json:"camera,omitempty"`
+	Extras      interface{} `json:"extras,omitempty"`
+}
+
+type Mesh struct {
+	Name       string       `json:"name,omitempty"`
+	Primitives []*Primitive `json:"primitives"`
+}
+
+type Primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices,omitempty"`
+	Material   *int           `json:"material,omitempty"`
+	Mode       *int           `json:"mode,omitempty"`
+}
+
+type Material struct {
+	Name                 string                 `json:"name,omitempty"`
+	PbrMetallicRoughness *Pbr                   `json:"pbrMetallicRoughness,omitempty"`
+	EmissiveFactor       []float64              `json:"emissiveFactor,omitempty"`
+	AlphaMode            string                 `json:"alphaMode,omitempty"`
+	AlphaCutoff          *float64               `json:"alphaCutoff,omitempty"`
+	DoubleSided          bool                   `json:"doubleSided,omitempty"`
+	Extensions           map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type Pbr struct {
+	BaseColorFactor  []float64   `json:"baseColorFactor,omitempty"`
+	BaseColorTexture *TextureRef `json:"baseColorTexture,omitempty"`
+	MetallicFactor   *float64    `json:"metallicFactor,omitempty"`
+	RoughnessFactor  *float64    `json:"roughnessFactor,omitempty"`
+}
+
+type TextureRef struct {
+	Index    int `json:"index"`
+	TexCoord int `json:"texCoord,omitempty"`
+}
+
+type Texture struct {
+	Name    string `json:"name,omitempty"`
+	Sampler *int   `json:"sampler,omitempty"`
+	Source  *int   `json:"source,omitempty"`
+}
+
+type Image struct {
+	Name       string `json:"name,omitempty"`
+	Uri        string `json:"uri,omitempty"`
+	MimeType   string `json:"mimeType,omitempty"`
+	BufferView *int   `json:"bufferView,omitempty"`
+}
+
+type Sampler struct {
+	MagFilter int `json:"magFilter,omitempty"`
+	MinFilter int `json:"minFilter,omitempty"`
+	WrapS     int `json:"wrapS,omitempty"`
+	WrapT     int `json:"wrapT,omitempty"`
+}
+
+type Camera struct {
+	Name         string        `json:"name,omitempty"`
+	Type         string        `json:"type"`
+	Perspective  *Perspective  `json:"perspective,omitempty"`
+	Orthographic *Orthographic `json:"orthographic,omitempty"`
+}
+
+type Perspective struct {
+	AspectRatio float64  `json:"aspectRatio,omitempty"`
+	Yfov        float64  `json:"yfov"`
+	Zfar        *float64 `json:"zfar,omitempty"`
+	Znear       float64  `json:"znear"`
+}
+
+type Orthographic struct {
+	Xmag  float64 `json:"xmag"`
+	Ymag  float64 `json:"ymag"`
+	Zfar  float64 `json:"zfar"`
+	Znear float64 `json:"znear"`
+}
+
+type Accessor struct {
+	BufferView    *int      `json:"bufferView,omitempty"`
+	ByteOffset    int       `json:"byteOffset,omitempty"`
+	ComponentType int       `json:"componentType"`
+	Normalized    bool      `json:"normalized,omitempty"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Max           []float64 `json:"max,omitempty"`
+	Min           []float64 `json:"min,omitempty"`
+	Sparse        *Sparse   `json:"sparse,omitempty"`
+}
+
+type Sparse struct {
+	Count   int `json:"count"`
+	Indices struct {
+		BufferView    int `json:"bufferView"`
+		ByteOffset    int `json:"byteOffset,omitempty"`
+		ComponentType int `json:"componentType"`
+	} `json:"indices"`
+	Values struct {
+		BufferView int `json:"bufferView"`
+		ByteOffset int `json:"byteOffset,omitempty"`
+	} `json:"values"`
+}
+
+type BufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset,omitempty"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride,omitempty"`
+	Target     int `json:"target,omitempty"`
+}
+
+type Buffer struct {
+	Uri        string `json:"uri,omitempty"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type invalidError struct {
+	reason string
+}
+
+func (e *invalidError) Error() string {
+	return `invalid gltf: ` + e.reason
+}
+
+func IsInvalid(err error) bool {
+	_, ok := err.(*invalidError)
+	return ok
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// Writes a binary GLB holding the json and the single binary buffer
+func WriteGlb(w io.Writer, g *Gltf, bin []byte) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	for len(data)%4 != 0 {
+		data = append(data, ' ')
+	}
+	binLength := (len(bin) + 3) / 4 * 4
+	length := 12 + 8 + len(data)
+	if len(bin) > 0 {
+		length += 8 + binLength
+	}
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header, glbMagic)
+	binary.LittleEndian.PutUint32(header[4:], glbVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(length))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if err := writeChunk(w, glbChunkJson, data, len(data)); err != nil {
+		return err
+	}
+	if len(bin) > 0 {
+		return writeChunk(w, glbChunkBin, bin, binLength)
+	}
+	return nil
+}
+
+func writeChunk(w io.Writer, typ uint32, data []byte, length int) error {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, uint32(length))
+	binary.LittleEndian.PutUint32(header[4:], typ)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, length-len(data)))
+	return err
+}
+
+// Writes .gltf json, binUri names the file the buffer will be written to, or when empty the buffer is embedded
+// as a data uri
+func WriteGltf(w io.Writer, g *Gltf, bin []byte, binUri string) error {
+	if len(g.Buffers) > 0 {
+		if binUri == `` {
+			binUri = `data:application/octet-stream;base64,` + base64.StdEncoding.EncodeToString(bin)
+		}
+		buffers := append([]*Buffer{}, g.Buffers...)
+		buffers[0] = &Buffer{Uri: binUri, ByteLength: g.Buffers[0].ByteLength}
+		copy := *g
+		copy.Buffers = buffers
+		g = &copy
+	}
+	data, err := json.MarshalIndent(g, ``, `  `)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Reads a .gltf or .glb file, returning the binary chunk of a GLB
+func Read(data []byte) (*Gltf, []byte, error) {
+	g := &Gltf{}
+	if len(data) < 12 || binary.LittleEndian.Uint32(data) != glbMagic {
+		if err := json.Unmarshal(data, g); err != nil {
+			return nil, nil, &invalidError{reason: err.Error()}
+		}
+		return g, nil, nil
+	}
+
+	if version := binary.LittleEndian.Uint32(data[4:]); version != glbVersion {
+		return nil, nil, &invalidError{reason: `unsupported glb version`}
+	}
+	var jsonChunk, bin []byte
+	for offset := 12; offset+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		typ := binary.LittleEndian.Uint32(data[offset+4:])
+		offset += 8
+		if length < 0 || offset+length > len(data) {
+			return nil, nil, &invalidError{reason: `truncated glb chunk`}
+		}
+		switch typ {
+		case glbChunkJson:
+			jsonChunk = data[offset : offset+length]
+		case glbChunkBin:
+			bin = data[offset : offset+length]
+		}
+		offset += length
+	}
+	if jsonChunk == nil {
+		return nil, nil, &invalidError{reason: `glb has no json chunk`}
+	}
+	if err := json.Unmarshal(jsonChunk, g); err != nil {
+		return nil, nil, &invalidError{reason: err.Error()}
+	}
+	return g, bin, nil
+}
+
+// Decodes a base64 data uri, returning its mime type
+func decodeDataUri(uri string) ([]byte, string, bool) {
+	if !strings.HasPrefix(uri, `data:`) {
+		return nil, ``, false
+	}
+	comma := strings.Index(uri, `,`)
+	if comma < 0 {
+		return nil, ``, false
+	}
+	meta := uri[len(`data:`):comma]
+	if !strings.HasSuffix(meta, `;base64`) {
+		return nil, ``, false
+	}
+	data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(uri[comma+1:])))
+	if err != nil {
+		return nil, ``, false
+	}
+	return data, strings.TrimSuffix(meta, `;base64`), true
+}
+
+func dataUri(mimeType string, data []byte) string {
+	var buf bytes.Buffer
+	buf.WriteString(`data:` + mimeType + `;base64,`)
+	buf.WriteString(base64.StdEncoding.EncodeToString(data))
+	return buf.String()
+}
@@ -0,0 +1,89 @@
+package gltf
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+	"strconv"
+)
+
+type unknownFormatError struct {
+	format string
+}
+
+func (e *unknownFormatError) Error() string {
+	return `unknown format "` + e.format + `", expected glb or gltf`
+}
+
+// Serves GET /api/scenes/{id}/gltf?format={glb|gltf}&object={uuid}&revision={n} downloading the object, or the whole
+// scene, as binary GLB or as .gltf json with its buffer embedded. format defaults to glb and revision to the head
+// revision. Images held in assets are embedded like data uri images.
+func NewSubHandler(store scene.Store, assets asset.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		query := r.URL.Query()
+		format := query.Get(`format`)
+		if format == `` {
+			format = `glb`
+		}
+		if format != `glb` && format != `gltf` {
+			api.WriteError(w, http.StatusBadRequest, &unknownFormatError{format: format})
+			return
+		}
+
+		rev := scene.ReadRequestedRevision(w, r, store, log, id)
+		if rev == nil {
+			return
+		}
+		node := rev.RequestedObject(w, r)
+		if node == nil {
+			return
+		}
+		g, root, name := rev.Graph, node.Uuid, rev.Meta.Name
+		if query.Get(`object`) != `` {
+			if objectName, _ := node.Object[`name`].(string); objectName != `` {
+				name = objectName
+			}
+		}
+		if name == `` {
+			name = `scene`
+		}
+
+		for _, image := range g.Images {
+			ref, _ := image[`url`].(string)
+			if _, ok := asset.ParseRef(ref); !ok {
+				continue
+			}
+			a, data, err := asset.ReadRef(assets, ref)
+			if err != nil {
+				log.Error(`failed to read image `, ref, `: `, err)
+				continue
+			}
+			image[`url`] = dataUri(a.Type, data)
+		}
+
+		var err error
+		out, bin := Export(g, root)
+		w.Header().Set(`Content-Disposition`, `attachment; filename=`+strconv.Quote(name+`.`+format))
+		if format == `glb` {
+			w.Header().Set(`Content-Type`, `model/gltf-binary`)
+			err = WriteGlb(w, out, bin)
+		} else {
+			w.Header().Set(`Content-Type`, `model/gltf+json`)
+			err = WriteGltf(w, out, bin, ``)
+		}
+		if err != nil {
+			log.Error(`failed to write `, format, `: `, err)
+		}
+	}
+}
@@ -0,0 +1,780 @@
+package gltf
+
+import (
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+const (
+	// The largest shininess given to fully smooth materials
+	maxShininess = 1000
+	// The most values an accessor without a buffer view may have, it has no data to bound its count by
+	maxUnbackedValues = 1 << 22
+)
+
+var (
+	componentCounts = map[string]int{`SCALAR`: 1, `VEC2`: 2, `VEC3`: 3, `VEC4`: 4, `MAT2`: 4, `MAT3`: 9, `MAT4`: 16}
+	componentSizes  = map[int]int{
+		componentByte:          1,
+		componentUnsignedByte:  1,
+		componentShort:         2,
+		componentUnsignedShort: 2,
+		componentUnsignedInt:   4,
+		componentFloat:         4,
+	}
+	threeWrapModes = map[int]int{
+		glRepeat:         repeatWrapping,
+		glClampToEdge:    clampToEdgeWrapping,
+		glMirroredRepeat: mirroredRepeatWrapping,
+	}
+)
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .gltf or .glb file`
+}
+
+// Imports the first .gltf or .glb file of an upload, the other files are the buffers and images it refers to by
+// name. Images become data uris so the scene does not depend on files the server does not keep.
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	var model []byte
+	var modelName string
+	resources := map[string][]byte{}
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		switch ext := importer.Ext(fileName); {
+		case model == nil && (ext == `gltf` || ext == `glb`):
+			model, modelName = data, importer.BaseName(fileName)
+		default:
+			resources[path.Base(fileName)] = data
+		}
+	}
+	if model == nil {
+		return nil, &noModelError{}
+	}
+	g, bin, err := Read(model)
+	if err != nil {
+		return nil, err
+	}
+	if name == `` {
+		name = modelName
+	}
+	return newReader(g, bin, resources).document(name)
+}
+
+type reader struct {
+	g         *Gltf
+	bin       []byte
+	resources map[string][]byte
+	buffers   [][]byte
+	doc       *threejs.Document
+	// geometries by mesh and primitive index, materials by index and kind and textures by index
+	geometries map[[2]int]*primitive
+	materials  map[string]threejs.Material
+	textures   map[int]string
+	visited    map[int]bool
+}
+
+// A primitive converted to a geometry and the object type that draws it
+type primitive struct {
+	typ          string
+	mode         int
+	geometry     *threejs.Geometry
+	material     *int
+	vertexColors bool
+}
+
+func newReader(g *Gltf, bin []byte, resources map[string][]byte) *reader {
+	return &reader{
+		g:          g,
+		bin:        bin,
+		resources:  resources,
+		doc:        &threejs.Document{},
+		geometries: map[[2]int]*primitive{},
+		materials:  map[string]threejs.Material{},
+		textures:   map[int]string{},
+		visited:    map[int]bool{},
+	}
+}
+
+func (r *reader) document(name string) (*threejs.Document, error) {
+	if len(r.g.Asset.Version) == 0 || r.g.Asset.Version[0] != '2' {
+		return nil, &invalidError{reason: `unsupported version ` + strconv.Quote(r.g.Asset.Version)}
+	}
+	if err := r.loadBuffers(); err != nil {
+		return nil, err
+	}
+
+	var roots []int
+	if len(r.g.Scenes) > 0 {
+		index := 0
+		if r.g.Scene != nil {
+			index = *r.g.Scene
+		}
+		if index < 0 || index >= len(r.g.Scenes) {
+			return nil, &invalidError{reason: `scene out of range`}
+		}
+		roots = r.g.Scenes[index].Nodes
+	} else {
+		// without scenes every node that is nobody's child is shown
+		children := map[int]bool{}
+		for _, node := range r.g.Nodes {
+			for _, child := range node.Children {
+				children[child] = true
+			}
+		}
+		for i := range r.g.Nodes {
+			if !children[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	r.doc.Object = threejs.NewObject(`Group`, name)
+	for _, root := range roots {
+		object, err := r.node(root)
+		if err != nil {
+			return nil, err
+		}
+		r.doc.Object.Children = append(r.doc.Object.Children, object)
+	}
+	return r.doc, nil
+}
+
+func (r *reader) loadBuffers() error {
+	r.buffers = make([][]byte, len(r.g.Buffers))
+	for i, buffer := range r.g.Buffers {
+		var data []byte
+		switch {
+		case buffer.Uri == `` && i == 0 && r.bin != nil:
+			data = r.bin
+		case buffer.Uri != ``:
+			var err error
+			if data, err = r.resource(buffer.Uri); err != nil {
+				return err
+			}
+		}
+		if len(data) < buffer.ByteLength {
+			return &invalidError{reason: `buffer ` + strconv.Itoa(i) + ` is shorter than its byteLength`}
+		}
+		r.buffers[i] = data
+	}
+	return nil
+}
+
+// The contents of a data uri or of an uploaded file named by a relative uri
+func (r *reader) resource(uri string) ([]byte, error) {
+	if data, _, ok := decodeDataUri(uri); ok {
+		return data, nil
+	}
+	name, err := url.QueryUnescape(uri)
+	if err != nil {
+		name = uri
+	}
+	data, exists := r.resources[path.Base(name)]
+	if !exists {
+		return nil, &invalidError{reason: `missing file ` + strconv.Quote(uri)}
+	}
+	return data, nil
+}
+
+func (r *reader) node(index int) (*threejs.Object, error) {
+	if index < 0 || index >= len(r.g.Nodes) {
+		return nil, &invalidError{reason: `node out of range`}
+	}
+	if r.visited[index] {
+		return nil, &invalidError{reason: `node ` + strconv.Itoa(index) + ` is used more than once`}
+	}
+	r.visited[index] = true
+	node := r.g.Nodes[index]
+
+	var object *threejs.Object
+	var children []*threejs.Object
+	if node.Mesh != nil {
+		meshObjects, err := r.mesh(*node.Mesh)
+		if err != nil {
+			return nil, err
+		}
+		if len(meshObjects) == 1 && node.Camera == nil {
+			object = meshObjects[0]
+			object.Name = node.Name
+		} else {
+			children = meshObjects
+		}
+	}
+	if node.Camera != nil && object == nil {
+		camera, err := r.camera(*node.Camera)
+		if err != nil {
+			return nil, err
+		}
+		object = camera
+		object.Name = node.Name
+	}
+	if object == nil {
+		object = threejs.NewObject(`Group`, node.Name)
+	}
+	object.Matrix = nodeMatrix(node)
+	object.Children = append(object.Children, children...)
+	for _, child := range node.Children {
+		childObject, err := r.node(child)
+		if err != nil {
+			return nil, err
+		}
+		object.Children = append(object.Children, childObject)
+	}
+	return object, nil
+}
+
+// The node's matrix or its translation, rotation and scale composed as T * R * S
+func nodeMatrix(node *Node) []float64 {
+	if len(node.Matrix) == 16 {
+		return node.Matrix
+	}
+	t := []float64{0, 0, 0}
+	if len(node.Translation) == 3 {
+		t = node.Translation
+	}
+	q := []float64{0, 0, 0, 1}
+	if len(node.Rotation) == 4 {
+		q = node.Rotation
+	}
+	s := []float64{1, 1, 1}
+	if len(node.Scale) == 3 {
+		s = node.Scale
+	}
+	x, y, z, w := q[0], q[1], q[2], q[3]
+	return []float64{
+		(1 - 2*(y*y+z*z)) * s[0], 2 * (x*y + z*w) * s[0], 2 * (x*z - y*w) * s[0], 0,
+		2 * (x*y - z*w) * s[1], (1 - 2*(x*x+z*z)) * s[1], 2 * (y*z + x*w) * s[1], 0,
+		2 * (x*z + y*w) * s[2], 2 * (y*z - x*w) * s[2], (1 - 2*(x*x+y*y)) * s[2], 0,
+		t[0], t[1], t[2], 1,
+	}
+}
+
+func (r *reader) camera(index int) (*threejs.Object, error) {
+	if index < 0 || index >= len(r.g.Cameras) {
+		return nil, &invalidError{reason: `camera out of range`}
+	}
+	camera := r.g.Cameras[index]
+	if camera.Orthographic != nil {
+		o := camera.Orthographic
+		object := threejs.NewObject(`OrthographicCamera`, ``)
+		object.Properties = map[string]interface{}{
+			`left`: -o.Xmag, `right`: o.Xmag, `top`: o.Ymag, `bottom`: -o.Ymag, `near`: o.Znear, `far`: o.Zfar,
+		}
+		return object, nil
+	}
+	object := threejs.NewObject(`PerspectiveCamera`, ``)
+	fov, aspect, near, far := 50.0, 1.0, 0.1, 2000.0
+	if p := camera.Perspective; p != nil {
+		fov = p.Yfov * 180 / math.Pi
+		near = p.Znear
+		if p.AspectRatio > 0 {
+			aspect = p.AspectRatio
+		}
+		if p.Zfar != nil {
+			far = *p.Zfar
+		}
+	}
+	object.Properties = map[string]interface{}{`fov`: fov, `aspect`: aspect, `near`: near, `far`: far}
+	return object, nil
+}
+
+// One object per primitive of the mesh
+func (r *reader) mesh(index int) ([]*threejs.Object, error) {
+	if index < 0 || index >= len(r.g.Meshes) {
+		return nil, &invalidError{reason: `mesh out of range`}
+	}
+	m := r.g.Meshes[index]
+	objects := []*threejs.Object{}
+	for i := range m.Primitives {
+		p, err := r.primitive(index, i)
+		if err != nil {
+			return nil, err
+		}
+		material, err := r.material(p)
+		if err != nil {
+			return nil, err
+		}
+		object := threejs.NewObject(p.typ, m.Name)
+		object.Geometry = p.geometry.Uuid
+		object.Material = material.Uuid()
+		if p.typ == `Line` && p.mode == modeLines {
+			object.Properties = map[string]interface{}{`mode`: 1}
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// Converts a primitive into a BufferGeometry, strips and fans become triangle lists and the vertices of points and
+// lines are laid out in drawing order since they are drawn without an index
+func (r *reader) primitive(meshIndex, index int) (*primitive, error) {
+	key := [2]int{meshIndex, index}
+	if p, exists := r.geometries[key]; exists {
+		return p, nil
+	}
+	source := r.g.Meshes[meshIndex].Primitives[index]
+	mode := modeTriangles
+	if source.Mode != nil {
+		mode = *source.Mode
+	}
+
+	attribute := func(name string, itemSize int) ([]float64, error) {
+		accessor, exists := source.Attributes[name]
+		if !exists {
+			return nil, nil
+		}
+		values, n, err := r.accessor(accessor)
+		if err != nil {
+			return nil, err
+		}
+		if n < itemSize {
+			return nil, &invalidError{reason: name + ` has too few components`}
+		}
+		if n == itemSize {
+			return values, nil
+		}
+		// drop the alpha of rgba colours
+		out := make([]float64, 0, len(values)/n*itemSize)
+		for i := 0; i < len(values); i += n {
+			out = append(out, values[i:i+itemSize]...)
+		}
+		return out, nil
+	}
+	positions, err := attribute(`POSITION`, 3)
+	if err != nil {
+		return nil, err
+	}
+	if positions == nil {
+		return nil, &invalidError{reason: `primitive without positions`}
+	}
+	count := len(positions) / 3
+	normals, err := attribute(`NORMAL`, 3)
+	if err != nil {
+		return nil, err
+	}
+	uvs, err := attribute(`TEXCOORD_0`, 2)
+	if err != nil {
+		return nil, err
+	}
+	colors, err := attribute(`COLOR_0`, 3)
+	if err != nil {
+		return nil, err
+	}
+	if (normals != nil && len(normals) != count*3) || (uvs != nil && len(uvs) != count*2) ||
+		(colors != nil && len(colors) != count*3) {
+		return nil, &invalidError{reason: `attribute counts differ`}
+	}
+
+	var indices []int
+	if source.Indices != nil {
+		values, _, err := r.accessor(*source.Indices)
+		if err != nil {
+			return nil, err
+		}
+		indices = make([]int, len(values))
+		for i, v := range values {
+			if indices[i] = int(v); indices[i] < 0 || indices[i] >= count {
+				return nil, &invalidError{reason: `index out of range`}
+			}
+		}
+	} else {
+		indices = make([]int, count)
+		for i := range indices {
+			indices[i] = i
+		}
+	}
+
+	p := &primitive{typ: `Mesh`, mode: mode, material: source.Material, vertexColors: colors != nil}
+	switch mode {
+	case modePoints:
+		p.typ = `Points`
+	case modeLines, modeLineStrip:
+		p.typ = `Line`
+	case modeLineLoop:
+		p.typ, p.mode = `Line`, modeLines
+		loop := []int{}
+		for i := range indices {
+			loop = append(loop, indices[i], indices[(i+1)%len(indices)])
+		}
+		indices = loop
+	case modeTriangleStrip:
+		strip := []int{}
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				strip = append(strip, indices[i], indices[i+1], indices[i+2])
+			} else {
+				strip = append(strip, indices[i+1], indices[i], indices[i+2])
+			}
+		}
+		indices, p.mode = strip, modeTriangles
+	case modeTriangleFan:
+		fan := []int{}
+		for i := 1; i+1 < len(indices); i++ {
+			fan = append(fan, indices[0], indices[i], indices[i+1])
+		}
+		indices, p.mode = fan, modeTriangles
+	case modeTriangles:
+		indices = indices[:len(indices)/3*3]
+	default:
+		return nil, &invalidError{reason: `unknown primitive mode ` + strconv.Itoa(mode)}
+	}
+
+	// flat normals are called for when a mesh has none, which needs every triangle to have its own vertices
+	flat := p.mode == modeTriangles && normals == nil
+	if p.typ != `Mesh` || flat {
+		positions, normals, uvs, colors = deindex(positions, 3, indices), deindex(normals, 3, indices), deindex(uvs, 2, indices), deindex(colors, 3, indices)
+		indices = nil
+	}
+	if flat {
+		normals = make([]float64, len(positions))
+		for i := 0; i+9 <= len(positions); i += 9 {
+			n := mesh.Normal(
+				[3]float64{positions[i], positions[i+1], positions[i+2]},
+				[3]float64{positions[i+3], positions[i+4], positions[i+5]},
+				[3]float64{positions[i+6], positions[i+7], positions[i+8]})
+			for v := 0; v < 3; v++ {
+				copy(normals[i+v*3:], n[:])
+			}
+		}
+	}
+
+	p.geometry = threejs.NewGeometry(r.g.Meshes[meshIndex].Name)
+	p.geometry.AddAttribute(`position`, 3, floats(positions))
+	if normals != nil {
+		p.geometry.AddAttribute(`normal`, 3, floats(normals))
+	}
+	if uvs != nil {
+		for i := 1; i < len(uvs); i += 2 {
+			uvs[i] = 1 - uvs[i]
+		}
+		p.geometry.AddAttribute(`uv`, 2, floats(uvs))
+	}
+	if colors != nil {
+		for i, c := range colors {
+			colors[i] = toSrgb(c)
+		}
+		p.geometry.AddAttribute(`color`, 3, floats(colors))
+	}
+	if indices != nil {
+		index := make(threejs.UintArray, len(indices))
+		for i, v := range indices {
+			index[i] = uint32(v)
+		}
+		p.geometry.Index = index
+	}
+	r.doc.Geometries = append(r.doc.Geometries, p.geometry)
+	r.geometries[key] = p
+	return p, nil
+}
+
+// The values of an attribute in the order indices visits its vertices
+func deindex(values []float64, itemSize int, indices []int) []float64 {
+	if values == nil {
+		return nil
+	}
+	out := make([]float64, 0, len(indices)*itemSize)
+	for _, i := range indices {
+		out = append(out, values[i*itemSize:(i+1)*itemSize]...)
+	}
+	return out
+}
+
+func floats(values []float64) threejs.FloatArray {
+	out := make(threejs.FloatArray, len(values))
+	for i, v := range values {
+		out[i] = float32(v)
+	}
+	return out
+}
+
+// Reads an accessor's elements as float64s, applying normalization and sparse substitution, returning the number of
+// components per element
+func (r *reader) accessor(index int) ([]float64, int, error) {
+	if index < 0 || index >= len(r.g.Accessors) {
+		return nil, 0, &invalidError{reason: `accessor out of range`}
+	}
+	a := r.g.Accessors[index]
+	n := componentCounts[a.Type]
+	size := componentSizes[a.ComponentType]
+	if n == 0 || size == 0 || a.Count < 0 {
+		return nil, 0, &invalidError{reason: `accessor ` + strconv.Itoa(index) + ` has an unknown type`}
+	}
+	// the view is checked to hold count elements before any are allocated
+	var data []byte
+	var stride int
+	if a.BufferView != nil {
+		var err error
+		if data, stride, err = r.view(*a.BufferView, a.ByteOffset, a.Count, n*size); err != nil {
+			return nil, 0, err
+		}
+	} else if a.Count > maxUnbackedValues/n {
+		return nil, 0, &invalidError{reason: `accessor ` + strconv.Itoa(index) + ` has no buffer view and more than ` + strconv.Itoa(maxUnbackedValues) + ` values`}
+	}
+	values := make([]float64, a.Count*n)
+	if data != nil {
+		for i := 0; i < a.Count; i++ {
+			for c := 0; c < n; c++ {
+				values[i*n+c] = component(data[i*stride+c*size:], a.ComponentType, a.Normalized)
+			}
+		}
+	}
+
+	if s := a.Sparse; s != nil {
+		indexSize := componentSizes[s.Indices.ComponentType]
+		if indexSize == 0 {
+			return nil, 0, &invalidError{reason: `sparse indices have an unknown type`}
+		}
+		indexData, _, err := r.view(s.Indices.BufferView, s.Indices.ByteOffset, s.Count, indexSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		valueData, _, err := r.view(s.Values.BufferView, s.Values.ByteOffset, s.Count, n*size)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i < s.Count; i++ {
+			target := int(component(indexData[i*indexSize:], s.Indices.ComponentType, false))
+			if target < 0 || target >= a.Count {
+				return nil, 0, &invalidError{reason: `sparse index out of range`}
+			}
+			for c := 0; c < n; c++ {
+				values[target*n+c] = component(valueData[(i*n+c)*size:], a.ComponentType, a.Normalized)
+			}
+		}
+	}
+	return values, n, nil
+}
+
+// The bytes of count elements of elementSize read from a buffer view, with the distance between elements
+func (r *reader) view(index, offset, count, elementSize int) ([]byte, int, error) {
+	if index < 0 || index >= len(r.g.BufferViews) {
+		return nil, 0, &invalidError{reason: `buffer view out of range`}
+	}
+	view := r.g.BufferViews[index]
+	if view.Buffer < 0 || view.Buffer >= len(r.buffers) {
+		return nil, 0, &invalidError{reason: `buffer out of range`}
+	}
+	stride := elementSize
+	if view.ByteStride > 0 {
+		stride = view.ByteStride
+	}
+	buffer := r.buffers[view.Buffer]
+	// bounds count before it is multiplied so a huge count can not overflow past the checks below
+	if count < 0 || count > 0 && count-1 > len(buffer)/stride {
+		return nil, 0, &invalidError{reason: `accessor reads past the end of buffer view ` + strconv.Itoa(index)}
+	}
+	start := view.ByteOffset + offset
+	end := start
+	if count > 0 {
+		end = start + (count-1)*stride + elementSize
+	}
+	if start < 0 || offset+(end-start) > view.ByteLength || end > len(buffer) {
+		return nil, 0, &invalidError{reason: `accessor reads past the end of buffer view ` + strconv.Itoa(index)}
+	}
+	return buffer[start:end], stride, nil
+}
+
+func component(data []byte, componentType int, normalized bool) float64 {
+	switch componentType {
+	case componentByte:
+		v := float64(int8(data[0]))
+		if normalized {
+			return math.Max(v/127, -1)
+		}
+		return v
+	case componentUnsignedByte:
+		v := float64(data[0])
+		if normalized {
+			return v / 255
+		}
+		return v
+	case componentShort:
+		v := float64(int16(binary.LittleEndian.Uint16(data)))
+		if normalized {
+			return math.Max(v/32767, -1)
+		}
+		return v
+	case componentUnsignedShort:
+		v := float64(binary.LittleEndian.Uint16(data))
+		if normalized {
+			return v / 65535
+		}
+		return v
+	case componentUnsignedInt:
+		return float64(binary.LittleEndian.Uint32(data))
+	}
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+}
+
+// The material for a primitive, points and lines get their own material types and vertex colours their own copy
+func (r *reader) material(p *primitive) (threejs.Material, error) {
+	index := -1
+	if p.material != nil {
+		index = *p.material
+		if index < 0 || index >= len(r.g.Materials) {
+			return nil, &invalidError{reason: `material out of range`}
+		}
+	}
+	key := strconv.Itoa(index) + p.typ + strconv.FormatBool(p.vertexColors)
+	if material, exists := r.materials[key]; exists {
+		return material, nil
+	}
+
+	source := &Material{}
+	if index >= 0 {
+		source = r.g.Materials[index]
+	}
+	pbr := source.PbrMetallicRoughness
+	if pbr == nil {
+		pbr = &Pbr{}
+	}
+	color := []float64{1, 1, 1, 1}
+	if len(pbr.BaseColorFactor) == 4 {
+		color = pbr.BaseColorFactor
+	}
+
+	var material threejs.Material
+	_, unlit := source.Extensions[extensionUnlit]
+	switch {
+	case p.typ == `Points`:
+		material = threejs.NewMaterial(`PointsMaterial`, source.Name)
+	case p.typ == `Line`:
+		material = threejs.NewMaterial(`LineBasicMaterial`, source.Name)
+	case unlit:
+		material = threejs.NewMaterial(`MeshBasicMaterial`, source.Name)
+	default:
+		material = threejs.NewMaterial(`MeshPhongMaterial`, source.Name)
+		roughness := 1.0
+		if pbr.RoughnessFactor != nil {
+			roughness = *pbr.RoughnessFactor
+		}
+		shininess := float64(maxShininess)
+		if roughness > 0 {
+			shininess = math.Min(maxShininess, math.Max(0, 2/(roughness*roughness)-2))
+		}
+		material[`shininess`] = shininess
+		if len(source.EmissiveFactor) == 3 {
+			material[`emissive`] = srgbColor(source.EmissiveFactor)
+		}
+	}
+	material[`color`] = srgbColor(color)
+	// alpha is ignored by opaque materials
+	if source.AlphaMode == `BLEND` {
+		material[`opacity`] = color[3]
+		material[`transparent`] = true
+	}
+	if source.AlphaMode == `MASK` {
+		cutoff := 0.5
+		if source.AlphaCutoff != nil {
+			cutoff = *source.AlphaCutoff
+		}
+		material[`alphaTest`] = cutoff
+	}
+	if source.DoubleSided {
+		material[`side`] = threejs.DoubleSide
+	}
+	if p.vertexColors {
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	if pbr.BaseColorTexture != nil && p.typ == `Mesh` {
+		texture, err := r.texture(pbr.BaseColorTexture.Index)
+		if err != nil {
+			return nil, err
+		}
+		if texture != `` {
+			material[`map`] = texture
+		}
+	}
+	r.doc.Materials = append(r.doc.Materials, material)
+	r.materials[key] = material
+	return material, nil
+}
+
+// Adds the texture with its image embedded as a data uri, images that can not be found keep their uri as the url
+func (r *reader) texture(index int) (string, error) {
+	if uuid, exists := r.textures[index]; exists {
+		return uuid, nil
+	}
+	if index < 0 || index >= len(r.g.Textures) {
+		return ``, &invalidError{reason: `texture out of range`}
+	}
+	texture := r.g.Textures[index]
+	if texture.Source == nil || *texture.Source < 0 || *texture.Source >= len(r.g.Images) {
+		r.textures[index] = ``
+		return ``, nil
+	}
+	image := r.g.Images[*texture.Source]
+
+	src := image.Uri
+	if image.BufferView != nil {
+		if *image.BufferView < 0 || *image.BufferView >= len(r.g.BufferViews) {
+			return ``, &invalidError{reason: `buffer view out of range`}
+		}
+		data, _, err := r.view(*image.BufferView, 0, 1, r.g.BufferViews[*image.BufferView].ByteLength)
+		if err != nil {
+			return ``, err
+		}
+		src = dataUri(image.MimeType, data)
+	} else if _, _, ok := decodeDataUri(src); !ok && src != `` {
+		if data, err := r.resource(src); err == nil {
+			mimeType := mime.TypeByExtension(path.Ext(src))
+			if mimeType == `` {
+				mimeType = `application/octet-stream`
+			}
+			src = dataUri(mimeType, data)
+		}
+	}
+	if src == `` {
+		r.textures[index] = ``
+		return ``, nil
+	}
+
+	wrap := []int{repeatWrapping, repeatWrapping}
+	if texture.Sampler != nil && *texture.Sampler >= 0 && *texture.Sampler < len(r.g.Samplers) {
+		sampler := r.g.Samplers[*texture.Sampler]
+		if mode, ok := threeWrapModes[sampler.WrapS]; ok {
+			wrap[0] = mode
+		}
+		if mode, ok := threeWrapModes[sampler.WrapT]; ok {
+			wrap[1] = mode
+		}
+	}
+
+	imageUuid := threejs.NewUuid()
+	r.doc.Images = append(r.doc.Images, map[string]interface{}{`uuid`: imageUuid, `url`: src})
+	uuid := threejs.NewUuid()
+	name := texture.Name
+	if name == `` {
+		name = image.Name
+	}
+	r.doc.Textures = append(r.doc.Textures, map[string]interface{}{
+		`uuid`:  uuid,
+		`name`:  name,
+		`image`: imageUuid,
+		`wrap`:  wrap,
+	})
+	r.textures[index] = uuid
+	return uuid, nil
+}
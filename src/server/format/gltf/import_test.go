@@ -0,0 +1,245 @@
+package gltf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/importer/importertest"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The positions of one triangle
+func triangleBuffer() []byte {
+	data := make([]byte, 36)
+	for i, v := range []float32{0, 0, 0, 1, 0, 0, 0, 1, 0} {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// A .gltf holding one triangle with its buffer embedded, edit changes the decoded json before it is encoded again
+func triangleGltf(edit func(g map[string]interface{})) []byte {
+	doc := `{"asset":{"version":"2.0"},` +
+		`"buffers":[{"byteLength":36,"uri":"data:application/octet-stream;base64,` + base64.StdEncoding.EncodeToString(triangleBuffer()) + `"}],` +
+		`"bufferViews":[{"buffer":0,"byteLength":36}],` +
+		`"accessors":[{"bufferView":0,"componentType":5126,"count":3,"type":"VEC3"}],` +
+		`"meshes":[{"primitives":[{"attributes":{"POSITION":0}}]}],` +
+		`"nodes":[{"mesh":0}],"scenes":[{"nodes":[0]}],"scene":0}`
+	g := map[string]interface{}{}
+	json.Unmarshal([]byte(doc), &g)
+	if edit != nil {
+		edit(g)
+	}
+	data, _ := json.Marshal(g)
+	return data
+}
+
+func index(g map[string]interface{}, key string, i int) map[string]interface{} {
+	return g[key].([]interface{})[i].(map[string]interface{})
+}
+
+func triangleGlb() []byte {
+	g, _, _ := Read(triangleGltf(func(g map[string]interface{}) {
+		delete(index(g, `buffers`, 0), `uri`)
+	}))
+	out := &bytes.Buffer{}
+	WriteGlb(out, g, triangleBuffer())
+	return out.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		data  [][]byte
+		// vertices of each geometry, or the reason of the invalid gltf error expected instead
+		want    []int
+		invalid string
+	}{
+		{
+			name:  `gltf`,
+			files: []string{`model.gltf`},
+			data:  [][]byte{triangleGltf(nil)},
+			want:  []int{3},
+		},
+		{
+			name:  `glb`,
+			files: []string{`model.glb`},
+			data:  [][]byte{triangleGlb()},
+			want:  []int{3},
+		},
+		{
+			name:  `external buffer`,
+			files: []string{`model.gltf`, `model.bin`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `buffers`, 0)[`uri`] = `model.bin`
+			}), triangleBuffer()},
+			want: []int{3},
+		},
+		{
+			name:  `missing external buffer`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `buffers`, 0)[`uri`] = `model.bin`
+			})},
+			invalid: `missing file "model.bin"`,
+		},
+		{
+			name:  `short buffer`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `buffers`, 0)[`byteLength`] = 72
+			})},
+			invalid: `buffer 0 is shorter than its byteLength`,
+		},
+		{
+			name:  `huge accessor count`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `accessors`, 0)[`count`] = 2000000000
+			})},
+			invalid: `accessor reads past the end of buffer view 0`,
+		},
+		{
+			name:  `huge accessor without a buffer view`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				delete(index(g, `accessors`, 0), `bufferView`)
+				index(g, `accessors`, 0)[`count`] = 2000000000
+			})},
+			invalid: `accessor 0 has no buffer view and more than 4194304 values`,
+		},
+		{
+			name:  `accessor past its buffer view`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `accessors`, 0)[`byteOffset`] = 4
+			})},
+			invalid: `accessor reads past the end of buffer view 0`,
+		},
+		{
+			name:  `buffer view past its buffer`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `bufferViews`, 0)[`byteOffset`] = 12
+			})},
+			invalid: `accessor reads past the end of buffer view 0`,
+		},
+		{
+			name:  `unknown accessor type`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `accessors`, 0)[`type`] = `VEC7`
+			})},
+			invalid: `accessor 0 has an unknown type`,
+		},
+		{
+			name:  `accessor out of range`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `meshes`, 0)[`primitives`].([]interface{})[0].(map[string]interface{})[`attributes`] = map[string]interface{}{`POSITION`: 4}
+			})},
+			invalid: `accessor out of range`,
+		},
+		{
+			name:  `primitive without positions`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `meshes`, 0)[`primitives`].([]interface{})[0].(map[string]interface{})[`attributes`] = map[string]interface{}{}
+			})},
+			invalid: `primitive without positions`,
+		},
+		{
+			name:  `unknown primitive mode`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `meshes`, 0)[`primitives`].([]interface{})[0].(map[string]interface{})[`mode`] = 9
+			})},
+			invalid: `unknown primitive mode 9`,
+		},
+		{
+			name:  `node out of range`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `scenes`, 0)[`nodes`] = []int{1}
+			})},
+			invalid: `node out of range`,
+		},
+		{
+			name:  `node cycle`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				index(g, `nodes`, 0)[`children`] = []int{0}
+			})},
+			invalid: `node 0 is used more than once`,
+		},
+		{
+			name:  `scene out of range`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				g[`scene`] = 1
+			})},
+			invalid: `scene out of range`,
+		},
+		{
+			name:  `version 1`,
+			files: []string{`model.gltf`},
+			data: [][]byte{triangleGltf(func(g map[string]interface{}) {
+				g[`asset`] = map[string]interface{}{`version`: `1.0`}
+			})},
+			invalid: `unsupported version "1.0"`,
+		},
+		{
+			name:    `not json`,
+			files:   []string{`model.gltf`},
+			data:    [][]byte{[]byte(`{"asset":`)},
+			invalid: `unexpected end of JSON input`,
+		},
+		{
+			name:    `truncated glb`,
+			files:   []string{`model.glb`},
+			data:    [][]byte{triangleGlb()[:40]},
+			invalid: `truncated glb chunk`,
+		},
+		{
+			name:    `glb without json`,
+			files:   []string{`model.glb`},
+			data:    [][]byte{triangleGlb()[:12]},
+			invalid: `glb has no json chunk`,
+		},
+	}
+	for _, test := range tests {
+		doc, err := Import(importertest.NewFiles(test.files, test.data), ``)
+		if test.invalid != `` {
+			if !IsInvalid(err) || !strings.HasSuffix(err.Error(), `: `+test.invalid) {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.invalid)
+			}
+			if doc != nil {
+				doc.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		got := []int{}
+		for _, geometry := range doc.Geometries {
+			got = append(got, geometry.Attributes[0].Values.Len()/3)
+		}
+		doc.Close()
+		if len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+			t.Errorf(`%s: got geometries with %v vertices, want %v`, test.name, got, test.want)
+		}
+	}
+}
+
+func TestImportWithoutModel(t *testing.T) {
+	_, err := Import(importertest.NewFiles([]string{`model.bin`}, [][]byte{triangleBuffer()}), ``)
+	if _, ok := err.(*noModelError); !ok {
+		t.Errorf(`got %v, want a no model error`, err)
+	}
+}
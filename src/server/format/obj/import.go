@@ -0,0 +1,168 @@
+package obj
+
+import (
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+const (
+	// THREE.RepeatWrapping, OBJ texture coordinates routinely fall outside 0 to 1
+	repeatWrapping = 1000
+)
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .obj file`
+}
+
+// Imports the .obj files of an upload using the materials of any .mtl files sent with them, other files are skipped
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	var models []*Model
+	materials := map[string]*Mtl{}
+	closeModels := func() {
+		for _, model := range models {
+			model.Close()
+		}
+	}
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeModels()
+			return nil, err
+		}
+		switch importer.Ext(fileName) {
+		case `obj`:
+			model, err := Parse(r, importer.BaseName(fileName))
+			if err != nil {
+				closeModels()
+				return nil, err
+			}
+			models = append(models, model)
+		case `mtl`:
+			library, err := ParseMtl(r)
+			if err != nil {
+				closeModels()
+				return nil, err
+			}
+			for materialName, material := range library {
+				materials[materialName] = material
+			}
+		default:
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				closeModels()
+				return nil, err
+			}
+		}
+	}
+	if len(models) == 0 {
+		return nil, &noModelError{}
+	}
+
+	if name == `` {
+		name = models[0].Name
+	}
+	doc := &threejs.Document{Object: threejs.NewObject(`Group`, name)}
+	builder := &builder{doc: doc, materials: materials, textures: map[string]string{}}
+	for _, model := range models {
+		parent := doc.Object
+		if len(models) > 1 {
+			parent = threejs.NewObject(`Group`, model.Name)
+			doc.Object.Children = append(doc.Object.Children, parent)
+		}
+		for _, mesh := range model.Meshes {
+			builder.addMesh(parent, mesh)
+		}
+	}
+	return doc, nil
+}
+
+type builder struct {
+	doc       *threejs.Document
+	materials map[string]*Mtl
+	// texture uuids by path so each map is only loaded once
+	textures map[string]string
+}
+
+// Hands the mesh attributes over to the document, which takes care of removing their temporary files
+func (b *builder) addMesh(parent *threejs.Object, mesh *Mesh) {
+	geometry := threejs.NewGeometry(mesh.Name)
+	geometry.AddAttribute(`position`, 3, mesh.Position)
+	geometry.AddAttribute(`normal`, 3, mesh.Normal)
+	if mesh.Uv != nil {
+		geometry.AddAttribute(`uv`, 2, mesh.Uv)
+	}
+	if mesh.Color != nil {
+		geometry.AddAttribute(`color`, 3, mesh.Color)
+	}
+
+	vertexColors := mesh.Color != nil
+	var material threejs.Material
+	if len(mesh.Groups) == 1 {
+		material = b.material(mesh.Groups[0].Material, vertexColors)
+	} else {
+		list := make([]threejs.Material, len(mesh.Groups))
+		for i, group := range mesh.Groups {
+			list[i] = b.material(group.Material, vertexColors)
+			geometry.Groups = append(geometry.Groups, &threejs.Group{Start: group.Start, Count: group.Count, MaterialIndex: i})
+		}
+		material = threejs.NewMultiMaterial(list)
+	}
+	b.doc.AddMesh(parent, `Mesh`, mesh.Name, geometry, material)
+	mesh.Position, mesh.Normal, mesh.Uv, mesh.Color = nil, nil, nil, nil
+}
+
+// A MeshPhongMaterial for the named MTL material, falling back to the defaults when the library has no such material
+func (b *builder) material(name string, vertexColors bool) threejs.Material {
+	mtl := b.materials[name]
+	if mtl == nil {
+		mtl = newMtl(name)
+	}
+	material := threejs.NewMaterial(`MeshPhongMaterial`, mtl.Name)
+	material[`color`] = hex(mtl.Diffuse)
+	material[`specular`] = hex(mtl.Specular)
+	material[`emissive`] = hex(mtl.Emissive)
+	material[`shininess`] = mtl.Shininess
+	if mtl.Opacity < 1 {
+		material[`opacity`] = mtl.Opacity
+		material[`transparent`] = true
+	}
+	if vertexColors {
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	if mtl.DiffuseMap != `` {
+		material[`map`] = b.texture(mtl.DiffuseMap)
+	}
+	return material
+}
+
+func (b *builder) texture(path string) string {
+	if uuid, exists := b.textures[path]; exists {
+		return uuid
+	}
+	image := threejs.NewUuid()
+	b.doc.Images = append(b.doc.Images, map[string]interface{}{`uuid`: image, `url`: path})
+	uuid := threejs.NewUuid()
+	b.textures[path] = uuid
+	b.doc.Textures = append(b.doc.Textures, map[string]interface{}{
+		`uuid`:  uuid,
+		`name`:  path,
+		`image`: image,
+		`wrap`:  []int{repeatWrapping, repeatWrapping},
+	})
+	return uuid
+}
+
+func hex(color []float64) int {
+	value := 0
+	for _, c := range color {
+		value = value<<8 | int(math.Max(0, math.Min(255, math.Floor(c*255+0.5))))
+	}
+	return value
+}
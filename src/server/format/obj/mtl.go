@@ -0,0 +1,130 @@
+package obj
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A material from an MTL library, colors are linear rgb in the range 0 to 1
+type Mtl struct {
+	Name       string
+	Diffuse    []float64
+	Specular   []float64
+	Emissive   []float64
+	Shininess  float64
+	Opacity    float64
+	DiffuseMap string
+}
+
+func newMtl(name string) *Mtl {
+	return &Mtl{
+		Name:      name,
+		Diffuse:   []float64{1, 1, 1},
+		Specular:  []float64{0.067, 0.067, 0.067},
+		Emissive:  []float64{0, 0, 0},
+		Shininess: 30,
+		Opacity:   1,
+	}
+}
+
+// Reads the materials of an MTL library keyed by name, unsupported statements are ignored
+func ParseMtl(r io.Reader) (map[string]*Mtl, error) {
+	materials := map[string]*Mtl{}
+	var current *Mtl
+	scanner := newScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], `#`) {
+			continue
+		}
+		if fields[0] == `newmtl` {
+			current = newMtl(strings.Join(fields[1:], ` `))
+			materials[current.Name] = current
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case `Kd`:
+			current.Diffuse, err = parseColor(fields[1:])
+		case `Ks`:
+			current.Specular, err = parseColor(fields[1:])
+		case `Ke`:
+			current.Emissive, err = parseColor(fields[1:])
+		case `Ns`:
+			current.Shininess, err = parseScalar(fields[1:])
+		case `d`:
+			current.Opacity, err = parseScalar(fields[1:])
+		case `Tr`:
+			var transparency float64
+			transparency, err = parseScalar(fields[1:])
+			current.Opacity = 1 - transparency
+		case `map_Kd`:
+			// options such as -s 1 1 1 come before the file name, which may itself contain spaces
+			if len(fields) > 1 {
+				current.DiffuseMap = mapPath(fields[1:])
+			}
+		}
+		if err != nil {
+			return nil, &parseError{file: `mtl`, line: line, msg: err.Error()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+func parseColor(fields []string) ([]float64, error) {
+	if len(fields) < 3 {
+		return nil, &valueError{`expected r g b`}
+	}
+	color := make([]float64, 3)
+	for i := range color {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		color[i] = v
+	}
+	return color, nil
+}
+
+func parseScalar(fields []string) (float64, error) {
+	if len(fields) < 1 {
+		return 0, &valueError{`expected a value`}
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// The number of arguments taken by each texture map option, -o, -s and -t take up to three numbers
+var mapOptionArgs = map[string]int{
+	`-blendu`: 1, `-blendv`: 1, `-boost`: 1, `-mm`: 2, `-o`: 3, `-s`: 3, `-t`: 3, `-texres`: 1, `-clamp`: 1,
+	`-bm`: 1, `-imfchan`: 1, `-type`: 1,
+}
+
+func mapPath(fields []string) string {
+	i := 0
+	for i < len(fields) {
+		option := fields[i]
+		n, isOption := mapOptionArgs[option]
+		if !isOption {
+			break
+		}
+		i++
+		for ; n > 0 && i < len(fields); n-- {
+			if option == `-o` || option == `-s` || option == `-t` {
+				if _, err := strconv.ParseFloat(fields[i], 64); err != nil {
+					break
+				}
+			}
+			i++
+		}
+	}
+	return strings.Replace(strings.Join(fields[i:], ` `), `\`, `/`, -1)
+}
@@ -0,0 +1,359 @@
+/*
+Wavefront OBJ and MTL reader for the server side importer. Vertex pools are held in memory because faces may refer
+to any of them, the de-indexed triangle attributes are spilled to temporary files as they are produced.
+*/
+package obj
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxLineLength = 16 * 1024 * 1024
+)
+
+// A parsed OBJ file, Close must be called once the model is no longer needed
+type Model struct {
+	Name         string
+	MaterialLibs []string
+	Meshes       []*Mesh
+}
+
+// The triangles of one o or g statement, split into groups by usemtl
+type Mesh struct {
+	Name     string
+	Position *threejs.SpillFloats
+	Normal   *threejs.SpillFloats
+	Uv       *threejs.SpillFloats
+	Color    *threejs.SpillFloats
+	Groups   []*Group
+	vertices int
+	hasUv    bool
+	hasColor bool
+}
+
+// A run of vertices drawn with the named material
+type Group struct {
+	Material string
+	Start    int
+	Count    int
+}
+
+type parseError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf(`%s line %d: %s`, e.file, e.line, e.msg)
+}
+
+type valueError struct {
+	msg string
+}
+
+func (e *valueError) Error() string {
+	return e.msg
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	return scanner
+}
+
+type vertex struct {
+	position int
+	uv       int
+	normal   int
+}
+
+type parser struct {
+	model     *Model
+	positions []float32
+	colors    []float32
+	uvs       []float32
+	normals   []float32
+	mesh      *Mesh
+	material  string
+	face      []vertex
+}
+
+// Reads an OBJ file, name is used for meshes that are not inside an o or g statement
+func Parse(r io.Reader, name string) (*Model, error) {
+	p := &parser{model: &Model{Name: name}}
+	scanner := newScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		// joins lines continued with a trailing backslash
+		for strings.HasSuffix(text, `\`) && scanner.Scan() {
+			line++
+			text = text[:len(text)-1] + ` ` + scanner.Text()
+		}
+		if err := p.statement(strings.Fields(text)); err != nil {
+			p.close()
+			return nil, &parseError{file: `obj`, line: line, msg: err.Error()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		p.close()
+		return nil, err
+	}
+	p.endMesh()
+	return p.model, nil
+}
+
+func (p *parser) statement(fields []string) error {
+	if len(fields) == 0 || strings.HasPrefix(fields[0], `#`) {
+		return nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case `v`:
+		if len(args) < 3 {
+			return &valueError{`vertex needs x y z`}
+		}
+		if err := appendFloats(&p.positions, args[:3]); err != nil {
+			return err
+		}
+		if len(args) >= 6 {
+			// vertex colors as written by many scanners, fill in white for the vertices before the first colored one
+			for len(p.colors) < len(p.positions)-3 {
+				p.colors = append(p.colors, 1)
+			}
+			return appendFloats(&p.colors, args[3:6])
+		}
+	case `vt`:
+		if len(args) < 1 {
+			return &valueError{`texture coordinate needs u`}
+		}
+		if len(args) == 1 {
+			args = append(args, `0`)
+		}
+		return appendFloats(&p.uvs, args[:2])
+	case `vn`:
+		if len(args) < 3 {
+			return &valueError{`normal needs x y z`}
+		}
+		return appendFloats(&p.normals, args[:3])
+	case `f`:
+		return p.addFace(args)
+	case `o`, `g`:
+		p.endMesh()
+		if p.mesh != nil {
+			p.mesh.Name = strings.Join(args, ` `)
+		} else {
+			p.startMesh(strings.Join(args, ` `))
+		}
+	case `usemtl`:
+		p.material = strings.Join(args, ` `)
+		if p.mesh != nil {
+			p.startGroup()
+		}
+	case `mtllib`:
+		p.model.MaterialLibs = append(p.model.MaterialLibs, strings.Join(args, ` `))
+	}
+	return nil
+}
+
+func appendFloats(pool *[]float32, fields []string) error {
+	for _, field := range fields {
+		v, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return err
+		}
+		*pool = append(*pool, float32(v))
+	}
+	return nil
+}
+
+func (p *parser) startMesh(name string) {
+	p.mesh = &Mesh{Name: name}
+	p.startGroup()
+}
+
+func (p *parser) startGroup() {
+	if n := len(p.mesh.Groups); n > 0 && p.mesh.Groups[n-1].Count == 0 {
+		p.mesh.Groups[n-1].Material = p.material
+		return
+	}
+	p.mesh.Groups = append(p.mesh.Groups, &Group{Material: p.material, Start: p.mesh.vertices})
+}
+
+// Finishes the current mesh, it is kept if it has any triangles and otherwise left to be renamed and reused
+func (p *parser) endMesh() {
+	if p.mesh == nil || p.mesh.vertices == 0 {
+		return
+	}
+	mesh := p.mesh
+	groups := mesh.Groups[:0]
+	for _, group := range mesh.Groups {
+		if group.Count > 0 {
+			groups = append(groups, group)
+		}
+	}
+	mesh.Groups = groups
+	if !mesh.hasUv {
+		mesh.Uv.Close()
+		mesh.Uv = nil
+	}
+	if !mesh.hasColor {
+		mesh.Color.Close()
+		mesh.Color = nil
+	}
+	p.model.Meshes = append(p.model.Meshes, mesh)
+	p.mesh = nil
+}
+
+func (p *parser) openMesh() error {
+	if p.mesh == nil {
+		p.startMesh(p.model.Name)
+	}
+	if p.mesh.Position != nil {
+		return nil
+	}
+	var err error
+	for _, values := range []**threejs.SpillFloats{&p.mesh.Position, &p.mesh.Normal, &p.mesh.Uv, &p.mesh.Color} {
+		if *values, err = threejs.NewSpillFloats(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) addFace(args []string) error {
+	if len(args) < 3 {
+		return &valueError{`face needs at least three vertices`}
+	}
+	p.face = p.face[:0]
+	for _, arg := range args {
+		v, err := p.parseVertex(arg)
+		if err != nil {
+			return err
+		}
+		p.face = append(p.face, v)
+	}
+	if err := p.openMesh(); err != nil {
+		return err
+	}
+	// fan triangulation, fine for the convex polygons OBJ exporters write
+	for i := 1; i+1 < len(p.face); i++ {
+		p.addTriangle(p.face[0], p.face[i], p.face[i+1])
+	}
+	return nil
+}
+
+func (p *parser) parseVertex(arg string) (vertex, error) {
+	v := vertex{uv: -1, normal: -1}
+	parts := strings.Split(arg, `/`)
+	var err error
+	if v.position, err = resolveIndex(parts[0], len(p.positions)/3); err != nil {
+		return v, err
+	}
+	if len(parts) > 1 && parts[1] != `` {
+		if v.uv, err = resolveIndex(parts[1], len(p.uvs)/2); err != nil {
+			return v, err
+		}
+	}
+	if len(parts) > 2 && parts[2] != `` {
+		if v.normal, err = resolveIndex(parts[2], len(p.normals)/3); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// Converts a one based, or negative relative, OBJ index into a zero based one
+func resolveIndex(field string, count int) (int, error) {
+	i, err := strconv.Atoi(field)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		i += count
+	} else {
+		i--
+	}
+	if i < 0 || i >= count {
+		return 0, &valueError{fmt.Sprintf(`index %s out of range`, field)}
+	}
+	return i, nil
+}
+
+func (p *parser) addTriangle(vertices ...vertex) {
+	mesh := p.mesh
+	var flat []float32
+	for _, v := range vertices {
+		if v.normal < 0 {
+			flat = p.faceNormal(vertices)
+			break
+		}
+	}
+	for _, v := range vertices {
+		mesh.Position.Append(p.positions[v.position*3 : v.position*3+3]...)
+		if v.normal >= 0 {
+			mesh.Normal.Append(p.normals[v.normal*3 : v.normal*3+3]...)
+		} else {
+			mesh.Normal.Append(flat...)
+		}
+		if v.uv >= 0 {
+			mesh.Uv.Append(p.uvs[v.uv*2 : v.uv*2+2]...)
+			mesh.hasUv = true
+		} else {
+			mesh.Uv.Append(0, 0)
+		}
+		if v.position*3 < len(p.colors) {
+			mesh.Color.Append(p.colors[v.position*3 : v.position*3+3]...)
+			mesh.hasColor = true
+		} else {
+			mesh.Color.Append(1, 1, 1)
+		}
+	}
+	mesh.vertices += 3
+	mesh.Groups[len(mesh.Groups)-1].Count += 3
+}
+
+func (p *parser) faceNormal(vertices []vertex) []float32 {
+	a := p.positions[vertices[0].position*3:]
+	b := p.positions[vertices[1].position*3:]
+	c := p.positions[vertices[2].position*3:]
+	ux, uy, uz := b[0]-a[0], b[1]-a[1], b[2]-a[2]
+	vx, vy, vz := c[0]-a[0], c[1]-a[1], c[2]-a[2]
+	x, y, z := uy*vz-uz*vy, uz*vx-ux*vz, ux*vy-uy*vx
+	length := float32(math.Sqrt(float64(x*x + y*y + z*z)))
+	if length == 0 {
+		return []float32{0, 0, 1}
+	}
+	return []float32{x / length, y / length, z / length}
+}
+
+func (p *parser) close() {
+	if p.mesh != nil {
+		p.mesh.close()
+	}
+	p.model.Close()
+}
+
+func (m *Mesh) close() {
+	for _, values := range []*threejs.SpillFloats{m.Position, m.Normal, m.Uv, m.Color} {
+		if values != nil {
+			values.Close()
+		}
+	}
+}
+
+// Removes the temporary files holding the mesh attributes
+func (m *Model) Close() {
+	for _, mesh := range m.Meshes {
+		mesh.close()
+	}
+}
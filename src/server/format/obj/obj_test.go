@@ -0,0 +1,159 @@
+package obj
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		// vertices of each mesh, or the error expected instead
+		want []int
+		err  string
+	}{
+		{
+			name: `triangle`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+			want: []int{3},
+		},
+		{
+			name: `quad fan`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf 1 2 3 4\n",
+			want: []int{6},
+		},
+		{
+			name: `texture coordinate before it is defined`,
+			obj:  "o a\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf -3 -2 -1\ng b\nf 1/1 2/1 3/1\nvt 0 0\n",
+			err:  `obj line 7: index 1 out of range`,
+		},
+		{
+			name: `two objects`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\no a\nf -3 -2 -1\no b\nf 1/1 2/1 3/1\n",
+			want: []int{3, 3},
+		},
+		{
+			name: `continued line`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 \\\n2 3\n",
+			want: []int{3},
+		},
+		{
+			name: `empty`,
+			obj:  ``,
+			want: []int{},
+		},
+		{
+			name: `short vertex`,
+			obj:  "v 0 0\n",
+			err:  `obj line 1: vertex needs x y z`,
+		},
+		{
+			name: `bad number`,
+			obj:  "v 0 0 x\n",
+			err:  `obj line 1: strconv.ParseFloat: parsing "x": invalid syntax`,
+		},
+		{
+			name: `short face`,
+			obj:  "v 0 0 0\nv 1 0 0\nf 1 2\n",
+			err:  `obj line 3: face needs at least three vertices`,
+		},
+		{
+			name: `face before its vertices`,
+			obj:  "f 1 2 3\nv 0 0 0\nv 1 0 0\nv 0 1 0\n",
+			err:  `obj line 1: index 1 out of range`,
+		},
+		{
+			name: `zero index`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n",
+			err:  `obj line 4: index 0 out of range`,
+		},
+		{
+			name: `normal out of range`,
+			obj:  "v 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\nf 1//1 2//2 3//1\n",
+			err:  `obj line 5: index 2 out of range`,
+		},
+		{
+			name: `short normal`,
+			obj:  "vn 0 1\n",
+			err:  `obj line 1: normal needs x y z`,
+		},
+	}
+	for _, test := range tests {
+		model, err := Parse(strings.NewReader(test.obj), `model`)
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			if model != nil {
+				model.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		got := []int{}
+		for _, mesh := range model.Meshes {
+			got = append(got, mesh.vertices)
+		}
+		model.Close()
+		if len(got) != len(test.want) {
+			t.Errorf(`%s: got meshes with %v vertices, want %v`, test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf(`%s: got meshes with %v vertices, want %v`, test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseMtl(t *testing.T) {
+	tests := []struct {
+		name string
+		mtl  string
+		want []string
+		err  string
+	}{
+		{
+			name: `materials`,
+			mtl:  "newmtl red\nKd 1 0 0\nnewmtl blue\nKd 0 0 1\nmap_Kd -s 1 1 1 blue tiles.png\n",
+			want: []string{`blue`, `red`},
+		},
+		{
+			name: `short color`,
+			mtl:  "newmtl red\nKd 1 0\n",
+			err:  `mtl line 2: expected r g b`,
+		},
+		{
+			name: `bad opacity`,
+			mtl:  "newmtl red\nd half\n",
+			err:  `mtl line 2: strconv.ParseFloat: parsing "half": invalid syntax`,
+		},
+	}
+	for _, test := range tests {
+		materials, err := ParseMtl(strings.NewReader(test.mtl))
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if len(materials) != len(test.want) {
+			t.Errorf(`%s: got %d materials, want %v`, test.name, len(materials), test.want)
+		}
+		for _, name := range test.want {
+			if materials[name] == nil {
+				t.Errorf(`%s: missing material %q`, test.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,509 @@
+/*
+PLY reading into Three.js json for the importer, ascii and binary little and big endian, keeping per vertex normals,
+colours and texture coordinates. Files without faces, such as lidar scans, become point clouds.
+*/
+package ply
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatAscii        = `ascii`
+	formatLittleEndian = `binary_little_endian`
+	formatBigEndian    = `binary_big_endian`
+)
+
+// Property types and their sizes in binary files, with the names used by old exporters
+var typeSizes = map[string]int{
+	`char`: 1, `uchar`: 1, `short`: 2, `ushort`: 2, `int`: 4, `uint`: 4, `float`: 4, `double`: 8,
+	`int8`: 1, `uint8`: 1, `int16`: 2, `uint16`: 2, `int32`: 4, `uint32`: 4, `float32`: 4, `float64`: 8,
+}
+
+// Vertex property names by the attribute component they hold
+var vertexRoles = map[string]int{
+	`x`: roleX, `y`: roleY, `z`: roleZ,
+	`nx`: roleNx, `ny`: roleNy, `nz`: roleNz,
+	`red`: roleRed, `green`: roleGreen, `blue`: roleBlue,
+	`r`: roleRed, `g`: roleGreen, `b`: roleBlue,
+	`diffuse_red`: roleRed, `diffuse_green`: roleGreen, `diffuse_blue`: roleBlue,
+	`s`: roleU, `t`: roleV, `u`: roleU, `v`: roleV,
+	`texture_u`: roleU, `texture_v`: roleV, `texture_s`: roleU, `texture_t`: roleV,
+}
+
+const (
+	roleNone = iota
+	roleX
+	roleY
+	roleZ
+	roleNx
+	roleNy
+	roleNz
+	roleRed
+	roleGreen
+	roleBlue
+	roleU
+	roleV
+	roleCount
+)
+
+type parseError struct {
+	element string
+	index   int
+	msg     string
+}
+
+func (e *parseError) Error() string {
+	if e.element == `` {
+		return `ply: ` + e.msg
+	}
+	return fmt.Sprintf(`ply %s %d: %s`, e.element, e.index, e.msg)
+}
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .ply file`
+}
+
+type property struct {
+	name string
+	typ  string
+	// the type of the item count for list properties, empty for scalars
+	countType string
+}
+
+type element struct {
+	name       string
+	count      int
+	properties []*property
+}
+
+type header struct {
+	format   string
+	elements []*element
+}
+
+// Imports every .ply file of an upload, each becomes a mesh or a point cloud
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	doc := &threejs.Document{}
+	models := 0
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			doc.Close()
+			return nil, err
+		}
+		if importer.Ext(fileName) != `ply` {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				doc.Close()
+				return nil, err
+			}
+			continue
+		}
+		if name == `` {
+			name = importer.BaseName(fileName)
+		}
+		if doc.Object == nil {
+			doc.Object = threejs.NewObject(`Group`, name)
+		}
+		if err := Read(r, doc, importer.BaseName(fileName)); err != nil {
+			doc.Close()
+			return nil, err
+		}
+		models++
+	}
+	if models == 0 {
+		return nil, &noModelError{}
+	}
+	// a single model is imported as it is rather than wrapped in a group
+	if children := doc.Object.Children; len(children) == 1 {
+		children[0].Name = name
+		doc.Object = children[0]
+	}
+	return doc, nil
+}
+
+// Reads a PLY file adding it to the document under doc.Object as a mesh, or as points when it has no faces
+func Read(r io.Reader, doc *threejs.Document, name string) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	h, err := readHeader(reader)
+	if err != nil {
+		return err
+	}
+	var values valueReader
+	switch h.format {
+	case formatAscii:
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		scanner.Split(bufio.ScanWords)
+		values = &asciiReader{scanner: scanner}
+	case formatLittleEndian:
+		values = &binaryReader{reader: reader, order: binary.LittleEndian}
+	case formatBigEndian:
+		values = &binaryReader{reader: reader, order: binary.BigEndian}
+	default:
+		return &parseError{msg: `unknown format ` + strconv.Quote(h.format)}
+	}
+
+	m, err := newModel(name, h)
+	if err != nil {
+		return err
+	}
+	for _, e := range h.elements {
+		switch e.name {
+		case `vertex`:
+			err = m.readVertices(e, values)
+		case `face`:
+			err = m.readFaces(e, values)
+		default:
+			err = skip(e, values)
+		}
+		if err != nil {
+			m.close()
+			return err
+		}
+	}
+	return m.addTo(doc)
+}
+
+func readHeader(reader *bufio.Reader) (*header, error) {
+	h := &header{}
+	line, err := reader.ReadString('\n')
+	if strings.TrimSpace(line) != `ply` {
+		return nil, &parseError{msg: `not a ply file`}
+	}
+	var current *element
+	for {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, &parseError{msg: `header has no end_header`}
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case `format`:
+			if len(fields) < 2 {
+				return nil, &parseError{msg: `format line needs a format`}
+			}
+			h.format = fields[1]
+		case `element`:
+			if len(fields) < 3 {
+				return nil, &parseError{msg: `element line needs a name and a count`}
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return nil, &parseError{msg: `invalid count for element ` + fields[1]}
+			}
+			current = &element{name: fields[1], count: count}
+			h.elements = append(h.elements, current)
+		case `property`:
+			if current == nil {
+				return nil, &parseError{msg: `property before any element`}
+			}
+			p := &property{}
+			if len(fields) == 5 && fields[1] == `list` {
+				p.countType, p.typ, p.name = fields[2], fields[3], fields[4]
+				if typeSizes[p.countType] == 0 {
+					return nil, &parseError{msg: `unknown type ` + p.countType}
+				}
+			} else if len(fields) == 3 {
+				p.typ, p.name = fields[1], fields[2]
+			} else {
+				return nil, &parseError{msg: `invalid property line ` + strconv.Quote(strings.TrimSpace(line))}
+			}
+			if typeSizes[p.typ] == 0 {
+				return nil, &parseError{msg: `unknown type ` + p.typ}
+			}
+			current.properties = append(current.properties, p)
+		case `end_header`:
+			return h, nil
+		}
+	}
+}
+
+// Reads element values one at a time in the encoding of the file
+type valueReader interface {
+	read(typ string) (float64, error)
+}
+
+type asciiReader struct {
+	scanner *bufio.Scanner
+}
+
+func (a *asciiReader) read(typ string) (float64, error) {
+	if !a.scanner.Scan() {
+		if err := a.scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseFloat(a.scanner.Text(), 64)
+}
+
+type binaryReader struct {
+	reader *bufio.Reader
+	order  binary.ByteOrder
+	buf    [8]byte
+}
+
+func (b *binaryReader) read(typ string) (float64, error) {
+	buf := b.buf[:typeSizes[typ]]
+	if _, err := io.ReadFull(b.reader, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch typ {
+	case `char`, `int8`:
+		return float64(int8(buf[0])), nil
+	case `uchar`, `uint8`:
+		return float64(buf[0]), nil
+	case `short`, `int16`:
+		return float64(int16(b.order.Uint16(buf))), nil
+	case `ushort`, `uint16`:
+		return float64(b.order.Uint16(buf)), nil
+	case `int`, `int32`:
+		return float64(int32(b.order.Uint32(buf))), nil
+	case `uint`, `uint32`:
+		return float64(b.order.Uint32(buf)), nil
+	case `float`, `float32`:
+		return float64(math.Float32frombits(b.order.Uint32(buf))), nil
+	}
+	return math.Float64frombits(b.order.Uint64(buf)), nil
+}
+
+// Reads one property, returning the items of a list
+func readProperty(p *property, values valueReader, items []float64) (float64, []float64, error) {
+	if p.countType == `` {
+		v, err := values.read(p.typ)
+		return v, items, err
+	}
+	count, err := values.read(p.countType)
+	if err != nil {
+		return 0, items, err
+	}
+	if count < 0 || count > math.MaxInt32 {
+		return 0, items, fmt.Errorf(`invalid list length %v`, count)
+	}
+	items = items[:0]
+	for i := 0; i < int(count); i++ {
+		v, err := values.read(p.typ)
+		if err != nil {
+			return 0, items, err
+		}
+		items = append(items, v)
+	}
+	return count, items, nil
+}
+
+func skip(e *element, values valueReader) error {
+	var items []float64
+	for i := 0; i < e.count; i++ {
+		for _, p := range e.properties {
+			var err error
+			if _, items, err = readProperty(p, values, items); err != nil {
+				return &parseError{element: e.name, index: i, msg: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// The attributes of the file spilled to temporary files as they are read
+type model struct {
+	name        string
+	vertexCount int
+	position    *threejs.SpillFloats
+	normal      *threejs.SpillFloats
+	color       *threejs.SpillFloats
+	uv          *threejs.SpillFloats
+	index       *threejs.SpillUints
+}
+
+// Allocates the attributes the vertex element has properties for
+func newModel(name string, h *header) (*model, error) {
+	m := &model{name: name}
+	has := make([]bool, roleCount)
+	for _, e := range h.elements {
+		if e.name == `vertex` {
+			m.vertexCount = e.count
+			for _, p := range e.properties {
+				if p.countType == `` {
+					has[vertexRoles[p.name]] = true
+				}
+			}
+		}
+	}
+	if !has[roleX] || !has[roleY] || !has[roleZ] {
+		return nil, &parseError{msg: `vertex element needs x, y and z properties`}
+	}
+
+	var err error
+	for _, attribute := range []struct {
+		spill  **threejs.SpillFloats
+		needed bool
+	}{
+		{&m.position, true},
+		{&m.normal, has[roleNx] && has[roleNy] && has[roleNz]},
+		{&m.color, has[roleRed] && has[roleGreen] && has[roleBlue]},
+		{&m.uv, has[roleU] && has[roleV]},
+	} {
+		if !attribute.needed {
+			continue
+		}
+		if *attribute.spill, err = threejs.NewSpillFloats(); err != nil {
+			m.close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *model) readVertices(e *element, values valueReader) error {
+	var v [roleCount]float64
+	var items []float64
+	// colours stored as integers are scaled to 0 to 1 by the largest value of their type
+	scale := [roleCount]float64{}
+	for _, p := range e.properties {
+		switch role := vertexRoles[p.name]; role {
+		case roleRed, roleGreen, roleBlue:
+			scale[role] = 1
+			switch p.typ {
+			case `uchar`, `uint8`, `char`, `int8`:
+				scale[role] = 255
+			case `ushort`, `uint16`, `short`, `int16`:
+				scale[role] = 65535
+			}
+		}
+	}
+	for i := 0; i < e.count; i++ {
+		for _, p := range e.properties {
+			value, list, err := readProperty(p, values, items)
+			if err != nil {
+				return &parseError{element: e.name, index: i, msg: err.Error()}
+			}
+			items = list
+			if p.countType == `` {
+				v[vertexRoles[p.name]] = value
+			}
+		}
+		m.position.Append(float32(v[roleX]), float32(v[roleY]), float32(v[roleZ]))
+		if m.normal != nil {
+			m.normal.Append(float32(v[roleNx]), float32(v[roleNy]), float32(v[roleNz]))
+		}
+		if m.color != nil {
+			m.color.Append(float32(v[roleRed]/scale[roleRed]), float32(v[roleGreen]/scale[roleGreen]),
+				float32(v[roleBlue]/scale[roleBlue]))
+		}
+		if m.uv != nil {
+			m.uv.Append(float32(v[roleU]), float32(v[roleV]))
+		}
+	}
+	return nil
+}
+
+// Polygons are split into fans of triangles, properties other than the vertex indices are skipped
+func (m *model) readFaces(e *element, values valueReader) error {
+	if e.count > 0 && m.index == nil {
+		var err error
+		if m.index, err = threejs.NewSpillUints(); err != nil {
+			return err
+		}
+	}
+	var items []float64
+	for i := 0; i < e.count; i++ {
+		for _, p := range e.properties {
+			_, list, err := readProperty(p, values, items)
+			if err != nil {
+				return &parseError{element: e.name, index: i, msg: err.Error()}
+			}
+			items = list
+			if p.countType == `` || (p.name != `vertex_indices` && p.name != `vertex_index`) {
+				continue
+			}
+			for _, index := range list {
+				if index < 0 || int(index) >= m.vertexCount {
+					return &parseError{element: e.name, index: i, msg: `vertex index ` + strconv.Itoa(int(index)) + ` out of range`}
+				}
+			}
+			for j := 1; j+1 < len(list); j++ {
+				m.index.Append(uint32(list[0]), uint32(list[j]), uint32(list[j+1]))
+			}
+		}
+	}
+	return nil
+}
+
+// Hands the attributes to the document which then removes their temporary files
+func (m *model) addTo(doc *threejs.Document) error {
+	geometry := threejs.NewGeometry(m.name)
+	geometry.AddAttribute(`position`, 3, m.position)
+	isMesh := m.index != nil && m.index.Len() > 0
+	if isMesh {
+		geometry.Index = m.index
+		if m.normal != nil {
+			geometry.AddAttribute(`normal`, 3, m.normal)
+		} else {
+			normals, err := threejs.ComputeNormals(m.position, m.index)
+			if err != nil {
+				m.close()
+				return err
+			}
+			geometry.AddAttribute(`normal`, 3, normals)
+		}
+	} else {
+		// normals are of no use to points
+		if m.index != nil {
+			m.index.Close()
+		}
+		if m.normal != nil {
+			m.normal.Close()
+		}
+	}
+	if m.color != nil {
+		geometry.AddAttribute(`color`, 3, m.color)
+	}
+	if m.uv != nil {
+		if isMesh {
+			geometry.AddAttribute(`uv`, 2, m.uv)
+		} else {
+			m.uv.Close()
+		}
+	}
+
+	typ, material := `Mesh`, threejs.NewMaterial(`MeshPhongMaterial`, ``)
+	if !isMesh {
+		typ, material = `Points`, threejs.NewMaterial(`PointsMaterial`, ``)
+	}
+	if m.color != nil {
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	doc.AddMesh(doc.Object, typ, m.name, geometry, material)
+	return nil
+}
+
+func (m *model) close() {
+	for _, s := range []*threejs.SpillFloats{m.position, m.normal, m.color, m.uv} {
+		if s != nil {
+			s.Close()
+		}
+	}
+	if m.index != nil {
+		m.index.Close()
+	}
+}
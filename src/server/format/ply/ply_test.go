@@ -0,0 +1,151 @@
+package ply
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"math"
+	"strings"
+	"testing"
+)
+
+const triangleHeader = "element vertex 3\nproperty float x\nproperty float y\nproperty float z\n" +
+	"element face 1\nproperty list uchar int vertex_indices\nend_header\n"
+
+// A binary triangle in the given byte order
+func binaryTriangle(format string, order binary.ByteOrder) []byte {
+	out := &bytes.Buffer{}
+	out.WriteString("ply\nformat " + format + " 1.0\n" + triangleHeader)
+	for _, v := range []float32{0, 0, 0, 1, 0, 0, 0, 1, 0} {
+		binary.Write(out, order, math.Float32bits(v))
+	}
+	out.WriteByte(3)
+	for _, i := range []int32{0, 1, 2} {
+		binary.Write(out, order, i)
+	}
+	return out.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// the type of the object read and its vertex count, or the error expected instead
+		typ      string
+		vertices int
+		err      string
+	}{
+		{
+			name:     `ascii mesh`,
+			data:     []byte("ply\nformat ascii 1.0\n" + triangleHeader + "0 0 0\n1 0 0\n0 1 0\n3 0 1 2\n"),
+			typ:      `Mesh`,
+			vertices: 3,
+		},
+		{
+			name:     `ascii points`,
+			data:     []byte("ply\nformat ascii 1.0\nelement vertex 2\nproperty float x\nproperty float y\nproperty float z\nend_header\n0 0 0\n1 1 1\n"),
+			typ:      `Points`,
+			vertices: 2,
+		},
+		{
+			name:     `other elements`,
+			data:     []byte("ply\nformat ascii 1.0\nelement camera 1\nproperty float fov\n" + triangleHeader + "45\n0 0 0\n1 0 0\n0 1 0\n3 0 1 2\n"),
+			typ:      `Mesh`,
+			vertices: 3,
+		},
+		{
+			name:     `binary little endian`,
+			data:     binaryTriangle(`binary_little_endian`, binary.LittleEndian),
+			typ:      `Mesh`,
+			vertices: 3,
+		},
+		{
+			name:     `binary big endian`,
+			data:     binaryTriangle(`binary_big_endian`, binary.BigEndian),
+			typ:      `Mesh`,
+			vertices: 3,
+		},
+		{
+			name: `not a ply file`,
+			data: []byte("solid cube\n"),
+			err:  `ply: not a ply file`,
+		},
+		{
+			name: `no end_header`,
+			data: []byte("ply\nformat ascii 1.0\nelement vertex 3\n"),
+			err:  `ply: header has no end_header`,
+		},
+		{
+			name: `unknown format`,
+			data: []byte("ply\nformat utf8 1.0\n" + triangleHeader),
+			err:  `ply: unknown format "utf8"`,
+		},
+		{
+			name: `unknown type`,
+			data: []byte("ply\nformat ascii 1.0\nelement vertex 1\nproperty float128 x\nend_header\n"),
+			err:  `ply: unknown type float128`,
+		},
+		{
+			name: `property before any element`,
+			data: []byte("ply\nformat ascii 1.0\nproperty float x\nend_header\n"),
+			err:  `ply: property before any element`,
+		},
+		{
+			name: `negative count`,
+			data: []byte("ply\nformat ascii 1.0\nelement vertex -1\nend_header\n"),
+			err:  `ply: invalid count for element vertex`,
+		},
+		{
+			name: `no positions`,
+			data: []byte("ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\nproperty float y\nend_header\n0 0\n"),
+			err:  `ply: vertex element needs x, y and z properties`,
+		},
+		{
+			name: `vertex index out of range`,
+			data: []byte("ply\nformat ascii 1.0\n" + triangleHeader + "0 0 0\n1 0 0\n0 1 0\n3 0 1 3\n"),
+			err:  `ply face 0: vertex index 3 out of range`,
+		},
+		{
+			name: `bad number`,
+			data: []byte("ply\nformat ascii 1.0\n" + triangleHeader + "0 0 0\n1 x 0\n"),
+			err:  `ply vertex 1: strconv.ParseFloat: parsing "x": invalid syntax`,
+		},
+		{
+			name: `ascii data cut short`,
+			data: []byte("ply\nformat ascii 1.0\n" + triangleHeader + "0 0 0\n1 0 0\n"),
+			err:  `ply vertex 2: unexpected EOF`,
+		},
+		{
+			name: `binary data cut short`,
+			data: binaryTriangle(`binary_little_endian`, binary.LittleEndian)[:len("ply\nformat binary_little_endian 1.0\n"+triangleHeader)+20],
+			err:  `ply vertex 1: unexpected EOF`,
+		},
+		{
+			name: `count the data does not back`,
+			data: []byte(strings.Replace("ply\nformat ascii 1.0\n"+triangleHeader, `vertex 3`, `vertex 2000000000`, 1) + "0 0 0\n"),
+			err:  `ply vertex 1: unexpected EOF`,
+		},
+	}
+	for _, test := range tests {
+		doc := &threejs.Document{Object: threejs.NewObject(`Group`, `upload`)}
+		err := Read(bytes.NewReader(test.data), doc, `model`)
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			doc.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			doc.Close()
+			continue
+		}
+		if len(doc.Object.Children) != 1 || len(doc.Geometries) != 1 {
+			t.Errorf(`%s: got %d objects and %d geometries, want one of each`, test.name, len(doc.Object.Children), len(doc.Geometries))
+		} else if typ, vertices := doc.Object.Children[0].Type, doc.Geometries[0].Attributes[0].Values.Len()/3; typ != test.typ || vertices != test.vertices {
+			t.Errorf(`%s: got %s with %d vertices, want %s with %d`, test.name, typ, vertices, test.typ, test.vertices)
+		}
+		doc.Close()
+	}
+}
@@ -0,0 +1,82 @@
+package stl
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+	"strconv"
+)
+
+type noMeshError struct{}
+
+func (e *noMeshError) Error() string {
+	return `nothing to export, no meshes found`
+}
+
+type unreadableMeshError struct {
+	name string
+	err  error
+}
+
+func (e *unreadableMeshError) Error() string {
+	return `can not export "` + e.name + `": ` + e.err.Error()
+}
+
+// Serves GET /api/scenes/{id}/stl?object={uuid}&revision={n} downloading the meshes under object, or the whole
+// scene, as a binary STL in world space. revision defaults to the head revision.
+func NewSubHandler(store scene.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		query := r.URL.Query()
+		rev := scene.ReadRequestedRevision(w, r, store, log, id)
+		if rev == nil {
+			return
+		}
+		node := rev.RequestedObject(w, r)
+		if node == nil {
+			return
+		}
+		g, root, name := rev.Graph, node.Uuid, rev.Meta.Name
+		if query.Get(`object`) != `` {
+			if objectName, _ := node.Object[`name`].(string); objectName != `` {
+				name = objectName
+			}
+		}
+
+		meshes := []*mesh.Mesh{}
+		for _, instance := range mesh.Instances(g, root) {
+			if !instance.IsSurface() {
+				continue
+			}
+			if instance.Err != nil {
+				objectName, _ := instance.Node.Object[`name`].(string)
+				api.WriteError(w, http.StatusBadRequest, &unreadableMeshError{name: objectName, err: instance.Err})
+				return
+			}
+			meshes = append(meshes, instance.Mesh.Transform(instance.World))
+		}
+		if len(meshes) == 0 {
+			api.WriteError(w, http.StatusBadRequest, &noMeshError{})
+			return
+		}
+
+		if name == `` {
+			name = `scene`
+		}
+		w.Header().Set(`Content-Type`, `model/stl`)
+		w.Header().Set(`Content-Disposition`, `attachment; filename=`+strconv.Quote(name+`.stl`))
+		if err := Write(w, meshes, `3ditor export: `+name); err != nil {
+			log.Error(`failed to write stl: `, err)
+		}
+	}
+}
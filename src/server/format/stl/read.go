@@ -0,0 +1,329 @@
+/*
+STL reading into Three.js json for the importer and binary STL writing of scene meshes for download
+*/
+package stl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerSize = 80
+	recordSize = 50
+)
+
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (e *parseError) Error() string {
+	if e.line == 0 {
+		return `stl: ` + e.msg
+	}
+	return fmt.Sprintf(`stl line %d: %s`, e.line, e.msg)
+}
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .stl file`
+}
+
+// Imports every .stl file of an upload, each solid becomes a mesh
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	doc := &threejs.Document{}
+	models := 0
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			doc.Close()
+			return nil, err
+		}
+		if importer.Ext(fileName) != `stl` {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				doc.Close()
+				return nil, err
+			}
+			continue
+		}
+		if name == `` {
+			name = importer.BaseName(fileName)
+		}
+		if doc.Object == nil {
+			doc.Object = threejs.NewObject(`Group`, name)
+		}
+		if err := Read(r, doc, importer.BaseName(fileName)); err != nil {
+			doc.Close()
+			return nil, err
+		}
+		models++
+	}
+	if models == 0 {
+		return nil, &noModelError{}
+	}
+	// a single mesh is imported as it is rather than wrapped in a group
+	if children := doc.Object.Children; len(children) == 1 {
+		children[0].Name = name
+		doc.Object = children[0]
+	}
+	return doc, nil
+}
+
+// Reads an ASCII or binary STL adding its solids to the document under doc.Object, name is used for unnamed solids
+func Read(r io.Reader, doc *threejs.Document, name string) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	if isAscii(reader) {
+		return readAscii(reader, doc, name)
+	}
+	return readBinary(reader, doc, name)
+}
+
+// Binary files may also start with "solid" so the start of the body is checked for ASCII keywords too
+func isAscii(reader *bufio.Reader) bool {
+	start, _ := reader.Peek(1024)
+	text := bytes.TrimLeft(start, " \t\r\n")
+	if !bytes.HasPrefix(text, []byte(`solid`)) {
+		return false
+	}
+	if len(start) < headerSize+4 {
+		return true
+	}
+	for _, b := range start {
+		if b > 127 || (b < 32 && b != '\n' && b != '\r' && b != '\t') {
+			return false
+		}
+	}
+	return bytes.Contains(start, []byte(`facet`)) || bytes.Contains(start, []byte(`endsolid`))
+}
+
+type solid struct {
+	name     string
+	position *threejs.SpillFloats
+	normal   *threejs.SpillFloats
+	color    *threejs.SpillFloats
+}
+
+func newSolid(name string, withColor bool) (*solid, error) {
+	s := &solid{name: name}
+	var err error
+	if s.position, err = threejs.NewSpillFloats(); err != nil {
+		return nil, err
+	}
+	if s.normal, err = threejs.NewSpillFloats(); err != nil {
+		s.position.Close()
+		return nil, err
+	}
+	if withColor {
+		if s.color, err = threejs.NewSpillFloats(); err != nil {
+			s.position.Close()
+			s.normal.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Appends a triangle using the given normal, or the one computed from the winding when it is zero
+func (s *solid) addTriangle(normal [3]float32, vertices [3][3]float32) {
+	if normal == ([3]float32{}) {
+		normal = faceNormal(vertices)
+	}
+	for _, v := range vertices {
+		s.position.Append(v[0], v[1], v[2])
+		s.normal.Append(normal[0], normal[1], normal[2])
+	}
+}
+
+// Hands the solid to the document which then removes its temporary files, empty solids are dropped
+func (s *solid) addTo(doc *threejs.Document) {
+	if s.position.Len() == 0 {
+		s.close()
+		return
+	}
+	geometry := threejs.NewGeometry(s.name)
+	geometry.AddAttribute(`position`, 3, s.position)
+	geometry.AddAttribute(`normal`, 3, s.normal)
+	material := threejs.NewMaterial(`MeshPhongMaterial`, ``)
+	if s.color != nil {
+		geometry.AddAttribute(`color`, 3, s.color)
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	doc.AddMesh(doc.Object, `Mesh`, s.name, geometry, material)
+}
+
+func (s *solid) close() {
+	s.position.Close()
+	s.normal.Close()
+	if s.color != nil {
+		s.color.Close()
+	}
+}
+
+func faceNormal(v [3][3]float32) [3]float32 {
+	ux, uy, uz := v[1][0]-v[0][0], v[1][1]-v[0][1], v[1][2]-v[0][2]
+	vx, vy, vz := v[2][0]-v[0][0], v[2][1]-v[0][1], v[2][2]-v[0][2]
+	x, y, z := uy*vz-uz*vy, uz*vx-ux*vz, ux*vy-uy*vx
+	length := float32(math.Sqrt(float64(x*x + y*y + z*z)))
+	if length == 0 {
+		return [3]float32{0, 0, 1}
+	}
+	return [3]float32{x / length, y / length, z / length}
+}
+
+func readAscii(reader *bufio.Reader, doc *threejs.Document, name string) error {
+	scanner := bufio.NewScanner(reader)
+	var current *solid
+	var normal [3]float32
+	var loop [][3]float32
+	line := 0
+	fail := func(msg string) error {
+		if current != nil {
+			current.close()
+		}
+		return &parseError{line: line, msg: msg}
+	}
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case `solid`:
+			if current != nil {
+				current.addTo(doc)
+			}
+			solidName := strings.Join(fields[1:], ` `)
+			if solidName == `` {
+				solidName = name
+			}
+			var err error
+			if current, err = newSolid(solidName, false); err != nil {
+				return err
+			}
+		case `facet`:
+			if current == nil {
+				return fail(`facet outside of a solid`)
+			}
+			if len(fields) < 5 || fields[1] != `normal` {
+				return fail(`expected facet normal nx ny nz`)
+			}
+			v, err := parseVector(fields[2:5])
+			if err != nil {
+				return fail(err.Error())
+			}
+			normal = v
+			loop = loop[:0]
+		case `vertex`:
+			if len(fields) < 4 {
+				return fail(`expected vertex x y z`)
+			}
+			v, err := parseVector(fields[1:4])
+			if err != nil {
+				return fail(err.Error())
+			}
+			loop = append(loop, v)
+		case `endloop`:
+			if current == nil || len(loop) < 3 {
+				return fail(`loop needs at least three vertices`)
+			}
+			for i := 1; i+1 < len(loop); i++ {
+				current.addTriangle(normal, [3][3]float32{loop[0], loop[i], loop[i+1]})
+			}
+			loop = loop[:0]
+		case `endsolid`:
+			if current != nil {
+				current.addTo(doc)
+				current = nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(err.Error())
+	}
+	// tolerate files cut off before endsolid
+	if current != nil {
+		current.addTo(doc)
+	}
+	return nil
+}
+
+func parseVector(fields []string) ([3]float32, error) {
+	var v [3]float32
+	for i := range v {
+		f, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil {
+			return v, err
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+func readBinary(reader *bufio.Reader, doc *threejs.Document, name string) error {
+	header := make([]byte, headerSize+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return &parseError{msg: `file too short for a binary header`}
+	}
+	count := binary.LittleEndian.Uint32(header[headerSize:])
+
+	// colors as written by VisCAM and SolidView, a default in the header and 15 bit colors per face
+	var defaultColor [3]float32
+	withColor := false
+	if i := bytes.Index(header[:headerSize], []byte(`COLOR=`)); i >= 0 && i+10 <= headerSize {
+		withColor = true
+		for c := range defaultColor {
+			defaultColor[c] = float32(header[i+6+c]) / 255
+		}
+	}
+
+	s, err := newSolid(name, withColor)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordSize)
+	for n := uint32(0); n < count; n++ {
+		if _, err := io.ReadFull(reader, record); err != nil {
+			s.close()
+			return &parseError{msg: fmt.Sprintf(`expected %d triangles but the file ends after %d`, count, n)}
+		}
+		var values [12]float32
+		for i := range values {
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(record[i*4:]))
+		}
+		s.addTriangle([3]float32{values[0], values[1], values[2]}, [3][3]float32{
+			{values[3], values[4], values[5]},
+			{values[6], values[7], values[8]},
+			{values[9], values[10], values[11]},
+		})
+		if withColor {
+			color := defaultColor
+			if attribute := binary.LittleEndian.Uint16(record[48:]); attribute&0x8000 == 0 {
+				color = [3]float32{
+					float32(attribute&31) / 31,
+					float32((attribute>>5)&31) / 31,
+					float32((attribute>>10)&31) / 31,
+				}
+			}
+			for i := 0; i < 3; i++ {
+				s.color.Append(color[0], color[1], color[2])
+			}
+		}
+	}
+	s.addTo(doc)
+	return nil
+}
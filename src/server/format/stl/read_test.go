@@ -0,0 +1,139 @@
+package stl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"math"
+	"testing"
+)
+
+// A binary STL with the given header and triangle count followed by the given number of zero normal triangles
+func binaryStl(header string, count, triangles int) []byte {
+	data := make([]byte, headerSize+4, headerSize+4+triangles*recordSize)
+	copy(data, header)
+	binary.LittleEndian.PutUint32(data[headerSize:], uint32(count))
+	for i := 0; i < triangles; i++ {
+		record := make([]byte, recordSize)
+		for j, v := range []float32{0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0} {
+			binary.LittleEndian.PutUint32(record[j*4:], math.Float32bits(v))
+		}
+		data = append(data, record...)
+	}
+	return data
+}
+
+const asciiFacet = `
+  facet normal 0 0 1
+    outer loop
+      vertex 0 0 0
+      vertex 1 0 0
+      vertex 0 1 0
+    endloop
+  endfacet
+`
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// triangles of each mesh read, or the error expected instead
+		want []int
+		err  string
+	}{
+		{
+			name: `ascii`,
+			data: []byte("solid cube" + asciiFacet + asciiFacet + "endsolid cube\n"),
+			want: []int{2},
+		},
+		{
+			name: `ascii solids`,
+			data: []byte("solid a" + asciiFacet + "endsolid a\nsolid b" + asciiFacet + "endsolid b\n"),
+			want: []int{1, 1},
+		},
+		{
+			name: `ascii cut off before endsolid`,
+			data: []byte("solid a" + asciiFacet),
+			want: []int{1},
+		},
+		{
+			name: `ascii empty solid`,
+			data: []byte("solid a\nendsolid a\n"),
+			want: []int{},
+		},
+		{
+			name: `ascii facet outside a solid`,
+			data: []byte("solid a\nendsolid a\n" + asciiFacet),
+			err:  `stl line 4: facet outside of a solid`,
+		},
+		{
+			name: `ascii bad number`,
+			data: []byte("solid a\nfacet normal 0 0 1\nouter loop\nvertex 0 0 x\n"),
+			err:  `stl line 4: strconv.ParseFloat: parsing "x": invalid syntax`,
+		},
+		{
+			name: `ascii short vertex`,
+			data: []byte("solid a\nfacet normal 0 0 1\nouter loop\nvertex 0 0\n"),
+			err:  `stl line 4: expected vertex x y z`,
+		},
+		{
+			name: `ascii short loop`,
+			data: []byte("solid a\nfacet normal 0 0 1\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nendloop\n"),
+			err:  `stl line 6: loop needs at least three vertices`,
+		},
+		{
+			name: `binary`,
+			data: binaryStl(`exported`, 3, 3),
+			want: []int{3},
+		},
+		{
+			name: `binary with a solid header`,
+			data: binaryStl(`solid exported by a cad package`, 1, 1),
+			want: []int{1},
+		},
+		{
+			name: `binary without triangles`,
+			data: binaryStl(`exported`, 0, 0),
+			want: []int{},
+		},
+		{
+			name: `binary truncated`,
+			data: binaryStl(`exported`, 2000000000, 2),
+			err:  `stl: expected 2000000000 triangles but the file ends after 2`,
+		},
+		{
+			name: `binary too short`,
+			data: []byte(`not an stl`),
+			err:  `stl: file too short for a binary header`,
+		},
+	}
+	for _, test := range tests {
+		doc := &threejs.Document{Object: threejs.NewObject(`Group`, `upload`)}
+		err := Read(bytes.NewReader(test.data), doc, `model`)
+		got := []int{}
+		for _, geometry := range doc.Geometries {
+			got = append(got, geometry.Attributes[0].Values.Len()/9)
+		}
+		doc.Close()
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf(`%s: got meshes with %v triangles, want %v`, test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf(`%s: got meshes with %v triangles, want %v`, test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
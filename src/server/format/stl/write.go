@@ -0,0 +1,45 @@
+package stl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"io"
+	"math"
+)
+
+// Writes the triangles of the meshes in world space as a binary STL, header is truncated to fit the 80 bytes
+func Write(w io.Writer, meshes []*mesh.Mesh, header string) error {
+	out := bufio.NewWriter(w)
+	buf := make([]byte, headerSize+4)
+	copy(buf, header)
+	count := 0
+	for _, m := range meshes {
+		count += m.TriangleCount()
+	}
+	binary.LittleEndian.PutUint32(buf[headerSize:], uint32(count))
+	if _, err := out.Write(buf); err != nil {
+		return err
+	}
+
+	record := make([]byte, recordSize)
+	for _, m := range meshes {
+		for i := 0; i < m.TriangleCount(); i++ {
+			a, b, c := m.Triangle(i)
+			normal := m.FaceNormal(i)
+			values := [12]float64{}
+			copy(values[:3], normal[:])
+			for v, index := range []int{a, b, c} {
+				vertex := m.Vertex(index)
+				copy(values[3+v*3:], vertex[:])
+			}
+			for j, value := range values {
+				binary.LittleEndian.PutUint32(record[j*4:], math.Float32bits(float32(value)))
+			}
+			if _, err := out.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,737 @@
+/*
+Legacy VTK reading into Three.js json for the importer, ascii and binary POLYDATA and UNSTRUCTURED_GRID in both the
+classic cell layout and the OFFSETS and CONNECTIVITY layout of version 5. Surfaces become a mesh, lines become line
+segments and datasets with neither become point clouds. Point normals, colours and texture coordinates are kept.
+*/
+package vtk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sizes of the data types in binary files, which are always big endian
+var typeSizes = map[string]int{
+	`unsigned_char`: 1, `char`: 1, `unsigned_short`: 2, `short`: 2, `unsigned_int`: 4, `int`: 4,
+	`unsigned_long`: 8, `long`: 8, `float`: 4, `double`: 8, `vtktypeint64`: 8, `vtktypeuint64`: 8, `vtkidtype`: 4,
+}
+
+const (
+	// The largest count a file may declare for points, cells or values
+	maxCount = math.MaxInt32
+	// Value arrays start at most this large and grow as values are read, so a count the file does not back with
+	// data can not claim memory up front
+	maxPrealloc = 1 << 16
+)
+
+// Cell types of unstructured grids, 3D cells are skipped
+const (
+	cellVertex        = 1
+	cellPolyVertex    = 2
+	cellLine          = 3
+	cellPolyLine      = 4
+	cellTriangle      = 5
+	cellTriangleStrip = 6
+	cellPolygon       = 7
+	cellPixel         = 8
+	cellQuad          = 9
+)
+
+type parseError struct {
+	msg string
+}
+
+func (e *parseError) Error() string {
+	return `vtk: ` + e.msg
+}
+
+type noModelError struct{}
+
+func (e *noModelError) Error() string {
+	return `upload contains no .vtk file`
+}
+
+// Imports every .vtk file of an upload
+func Import(files importer.Files, name string) (*threejs.Document, error) {
+	doc := &threejs.Document{}
+	models := 0
+	for {
+		fileName, r, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if importer.Ext(fileName) != `vtk` {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if name == `` {
+			name = importer.BaseName(fileName)
+		}
+		if doc.Object == nil {
+			doc.Object = threejs.NewObject(`Group`, name)
+		}
+		if err := Read(r, doc, importer.BaseName(fileName)); err != nil {
+			return nil, err
+		}
+		models++
+	}
+	if models == 0 {
+		return nil, &noModelError{}
+	}
+	// a single object is imported as it is rather than wrapped in a group
+	if children := doc.Object.Children; len(children) == 1 {
+		children[0].Name = name
+		doc.Object = children[0]
+	}
+	return doc, nil
+}
+
+// Reads a legacy VTK file adding its surface, lines or points to the document under doc.Object
+func Read(r io.Reader, doc *threejs.Document, name string) error {
+	t := &tokenizer{reader: bufio.NewReaderSize(r, 64*1024)}
+	version, err := t.line()
+	if err != nil || !strings.HasPrefix(version, `# vtk DataFile Version`) {
+		return &parseError{msg: `not a legacy vtk file`}
+	}
+	major, _ := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(version, `# vtk DataFile Version`)), 64)
+	if _, err := t.line(); err != nil {
+		return &parseError{msg: `missing title`}
+	}
+	encoding, err := t.line()
+	if err != nil {
+		return &parseError{msg: `missing ASCII or BINARY`}
+	}
+	switch strings.ToUpper(strings.TrimSpace(encoding)) {
+	case `ASCII`:
+	case `BINARY`:
+		t.binary = true
+	default:
+		return &parseError{msg: `unknown encoding ` + strconv.Quote(strings.TrimSpace(encoding))}
+	}
+
+	d := &dataset{offsets: major >= 5}
+	if err := d.read(t); err != nil {
+		return err
+	}
+	return d.addTo(doc, name)
+}
+
+type tokenizer struct {
+	reader *bufio.Reader
+	binary bool
+}
+
+func (t *tokenizer) line() (string, error) {
+	line, err := t.reader.ReadString('\n')
+	if err == io.EOF && line != `` {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// The next whitespace separated word, io.EOF at the end of the file. The whitespace after the word is left unread
+// so binary data can start after the end of the line.
+func (t *tokenizer) word() (string, error) {
+	var word []byte
+	for {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(word) > 0 {
+				return string(word), nil
+			}
+			return ``, err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			if len(word) > 0 {
+				return string(word), t.reader.UnreadByte()
+			}
+			continue
+		}
+		word = append(word, b)
+	}
+}
+
+func (t *tokenizer) int() (int, error) {
+	word, err := t.word()
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 0 || n > maxCount {
+		return 0, &parseError{msg: `expected a count but found ` + strconv.Quote(word)}
+	}
+	return n, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return &parseError{msg: `unexpected end of file`}
+	}
+	return err
+}
+
+// Reads n values of typ, binary data starts on the line after the keyword that announces it
+func (t *tokenizer) values(n int, typ string) ([]float64, error) {
+	typ = strings.ToLower(typ)
+	size := typeSizes[typ]
+	if size == 0 {
+		return nil, &parseError{msg: `unsupported data type ` + typ}
+	}
+	if n < 0 {
+		return nil, &parseError{msg: `invalid value count ` + strconv.Itoa(n)}
+	}
+	capacity := n
+	if capacity > maxPrealloc {
+		capacity = maxPrealloc
+	}
+	values := make([]float64, 0, capacity)
+	if !t.binary {
+		for i := 0; i < n; i++ {
+			word, err := t.word()
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			value, err := strconv.ParseFloat(word, 64)
+			if err != nil {
+				return nil, &parseError{msg: `invalid number ` + strconv.Quote(word)}
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	if _, err := t.reader.ReadString('\n'); err != nil {
+		return nil, unexpected(err)
+	}
+	buf := make([]byte, size)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(t.reader, buf); err != nil {
+			return nil, &parseError{msg: `unexpected end of binary data`}
+		}
+		values = append(values, 0)
+		switch typ {
+		case `unsigned_char`:
+			values[i] = float64(buf[0])
+		case `char`:
+			values[i] = float64(int8(buf[0]))
+		case `unsigned_short`:
+			values[i] = float64(binary.BigEndian.Uint16(buf))
+		case `short`:
+			values[i] = float64(int16(binary.BigEndian.Uint16(buf)))
+		case `unsigned_int`:
+			values[i] = float64(binary.BigEndian.Uint32(buf))
+		case `int`, `vtkidtype`:
+			values[i] = float64(int32(binary.BigEndian.Uint32(buf)))
+		case `float`:
+			values[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(buf)))
+		case `double`:
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(buf))
+		case `unsigned_long`, `vtktypeuint64`:
+			values[i] = float64(binary.BigEndian.Uint64(buf))
+		default:
+			values[i] = float64(int64(binary.BigEndian.Uint64(buf)))
+		}
+	}
+	return values, nil
+}
+
+type dataset struct {
+	// whether cells are written as OFFSETS and CONNECTIVITY arrays, from version 5
+	offsets   bool
+	positions threejs.FloatArray
+	normals   threejs.FloatArray
+	colors    threejs.FloatArray
+	uvs       threejs.FloatArray
+	triangles threejs.UintArray
+	// line segments as pairs of point indices
+	segments threejs.UintArray
+	// cells of an unstructured grid waiting for their CELL_TYPES
+	cells [][]int
+}
+
+func (d *dataset) read(t *tokenizer) error {
+	// the number of values attribute data describes, and whether they belong to points rather than cells
+	count, pointData := 0, false
+	for {
+		keyword, err := t.word()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch strings.ToUpper(keyword) {
+		case `DATASET`:
+			typ, err := t.word()
+			if err != nil {
+				return unexpected(err)
+			}
+			switch strings.ToUpper(typ) {
+			case `POLYDATA`, `UNSTRUCTURED_GRID`, `STRUCTURED_GRID`:
+			default:
+				return &parseError{msg: `unsupported dataset ` + typ + `, only POLYDATA and UNSTRUCTURED_GRID are read`}
+			}
+		case `DIMENSIONS`:
+			for i := 0; i < 3; i++ {
+				if _, err := t.int(); err != nil {
+					return err
+				}
+			}
+		case `POINTS`:
+			n, err := t.int()
+			if err != nil {
+				return err
+			}
+			typ, err := t.word()
+			if err != nil {
+				return unexpected(err)
+			}
+			values, err := t.values(n*3, typ)
+			if err != nil {
+				return err
+			}
+			d.positions = toFloats(values, 1)
+		case `METADATA`:
+			if err := skipMetadata(t); err != nil {
+				return err
+			}
+		case `VERTICES`:
+			if _, err := d.readCells(t); err != nil {
+				return err
+			}
+		case `LINES`:
+			cells, err := d.readCells(t)
+			if err != nil {
+				return err
+			}
+			for _, cell := range cells {
+				d.addPolyLine(cell)
+			}
+		case `POLYGONS`:
+			cells, err := d.readCells(t)
+			if err != nil {
+				return err
+			}
+			for _, cell := range cells {
+				d.addPolygon(cell)
+			}
+		case `TRIANGLE_STRIPS`:
+			cells, err := d.readCells(t)
+			if err != nil {
+				return err
+			}
+			for _, cell := range cells {
+				d.addStrip(cell)
+			}
+		case `CELLS`:
+			if d.cells, err = d.readCells(t); err != nil {
+				return err
+			}
+		case `CELL_TYPES`:
+			n, err := t.int()
+			if err != nil {
+				return err
+			}
+			types, err := t.values(n, `int`)
+			if err != nil {
+				return err
+			}
+			d.addCells(types)
+		case `POINT_DATA`, `CELL_DATA`:
+			if count, err = t.int(); err != nil {
+				return err
+			}
+			pointData = strings.ToUpper(keyword) == `POINT_DATA`
+		case `SCALARS`, `COLOR_SCALARS`, `NORMALS`, `TEXTURE_COORDINATES`, `VECTORS`, `TENSORS`:
+			if err := d.readAttribute(t, strings.ToUpper(keyword), count, pointData); err != nil {
+				return err
+			}
+		case `LOOKUP_TABLE`:
+			// a colour table for scalars, name size followed by rgba values
+			if _, err := t.word(); err != nil {
+				return unexpected(err)
+			}
+			n, err := t.int()
+			if err != nil {
+				return err
+			}
+			typ := `float`
+			if t.binary {
+				typ = `unsigned_char`
+			}
+			if _, err := t.values(n*4, typ); err != nil {
+				return err
+			}
+		case `FIELD`:
+			if err := skipField(t); err != nil {
+				return err
+			}
+		default:
+			return &parseError{msg: `unexpected keyword ` + strconv.Quote(keyword)}
+		}
+	}
+}
+
+// Reads a cell section as lists of point indices in either layout
+func (d *dataset) readCells(t *tokenizer) ([][]int, error) {
+	n, err := t.int()
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.int()
+	if err != nil {
+		return nil, err
+	}
+	var cells [][]int
+	if d.offsets {
+		offsets, err := readArray(t, `OFFSETS`, n)
+		if err != nil {
+			return nil, err
+		}
+		connectivity, err := readArray(t, `CONNECTIVITY`, size)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(offsets); i++ {
+			start, end := int(offsets[i]), int(offsets[i+1])
+			if start < 0 || start > end || end > len(connectivity) {
+				return nil, &parseError{msg: `cell offsets out of range`}
+			}
+			cells = append(cells, d.indices(connectivity[start:end]))
+		}
+	} else {
+		values, err := t.values(size, `int`)
+		if err != nil {
+			return nil, err
+		}
+		for offset := 0; offset < len(values); {
+			count := int(values[offset])
+			if count < 0 || offset+1+count > len(values) {
+				return nil, &parseError{msg: `cell list out of range`}
+			}
+			cells = append(cells, d.indices(values[offset+1:offset+1+count]))
+			offset += 1 + count
+		}
+		if len(cells) != n {
+			return nil, &parseError{msg: `expected ` + strconv.Itoa(n) + ` cells but found ` + strconv.Itoa(len(cells))}
+		}
+	}
+	for _, cell := range cells {
+		for _, index := range cell {
+			if index < 0 || index >= len(d.positions)/3 {
+				return nil, &parseError{msg: `point index ` + strconv.Itoa(index) + ` out of range`}
+			}
+		}
+	}
+	return cells, nil
+}
+
+func (d *dataset) indices(values []float64) []int {
+	out := make([]int, len(values))
+	for i, v := range values {
+		out[i] = int(v)
+	}
+	return out
+}
+
+// Reads the named array of the version 5 cell layout
+func readArray(t *tokenizer, name string, n int) ([]float64, error) {
+	keyword, err := t.word()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if strings.ToUpper(keyword) != name {
+		return nil, &parseError{msg: `expected ` + name + ` but found ` + strconv.Quote(keyword)}
+	}
+	typ, err := t.word()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	return t.values(n, typ)
+}
+
+func (d *dataset) addPolygon(cell []int) {
+	for i := 1; i+1 < len(cell); i++ {
+		d.triangles = append(d.triangles, uint32(cell[0]), uint32(cell[i]), uint32(cell[i+1]))
+	}
+}
+
+// Alternate triangles of a strip are flipped to keep a consistent winding
+func (d *dataset) addStrip(cell []int) {
+	for i := 0; i+2 < len(cell); i++ {
+		if i%2 == 0 {
+			d.triangles = append(d.triangles, uint32(cell[i]), uint32(cell[i+1]), uint32(cell[i+2]))
+		} else {
+			d.triangles = append(d.triangles, uint32(cell[i+1]), uint32(cell[i]), uint32(cell[i+2]))
+		}
+	}
+}
+
+func (d *dataset) addPolyLine(cell []int) {
+	for i := 0; i+1 < len(cell); i++ {
+		d.segments = append(d.segments, uint32(cell[i]), uint32(cell[i+1]))
+	}
+}
+
+func (d *dataset) addCells(types []float64) {
+	for i, typ := range types {
+		if i >= len(d.cells) {
+			break
+		}
+		cell := d.cells[i]
+		switch int(typ) {
+		case cellLine, cellPolyLine:
+			d.addPolyLine(cell)
+		case cellTriangle, cellPolygon, cellQuad:
+			d.addPolygon(cell)
+		case cellTriangleStrip:
+			d.addStrip(cell)
+		case cellPixel:
+			if len(cell) == 4 {
+				d.addPolygon([]int{cell[0], cell[1], cell[3], cell[2]})
+			}
+		}
+	}
+	d.cells = nil
+}
+
+// Keeps the point normals, colours and texture coordinates, everything else is read past
+func (d *dataset) readAttribute(t *tokenizer, keyword string, count int, pointData bool) error {
+	if _, err := t.word(); err != nil {
+		return unexpected(err)
+	}
+	components, typ := 3, `float`
+	switch keyword {
+	case `SCALARS`:
+		word, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		typ, components = word, 1
+		// the component count is optional and followed by the lookup table
+		next, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		if strings.ToUpper(next) != `LOOKUP_TABLE` {
+			// the format allows one to four components
+			if components, err = strconv.Atoi(next); err != nil || components < 1 || components > 4 {
+				return &parseError{msg: `invalid scalar component count ` + strconv.Quote(next)}
+			}
+			if next, err = t.word(); err != nil {
+				return unexpected(err)
+			}
+		}
+		if strings.ToUpper(next) == `LOOKUP_TABLE` {
+			if _, err := t.word(); err != nil {
+				return unexpected(err)
+			}
+		} else {
+			return &parseError{msg: `expected LOOKUP_TABLE but found ` + strconv.Quote(next)}
+		}
+	case `COLOR_SCALARS`:
+		var err error
+		if components, err = t.int(); err != nil {
+			return err
+		}
+		// colours are floats from 0 to 1 in ascii files and bytes in binary ones
+		typ = `float`
+		if t.binary {
+			typ = `unsigned_char`
+		}
+	case `TEXTURE_COORDINATES`:
+		var err error
+		if components, err = t.int(); err != nil {
+			return err
+		}
+		if typ, err = t.word(); err != nil {
+			return unexpected(err)
+		}
+	case `TENSORS`:
+		word, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		typ, components = word, 9
+	default:
+		word, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		typ = word
+	}
+	values, err := t.values(count*components, typ)
+	if err != nil {
+		return err
+	}
+	if !pointData || count != len(d.positions)/3 {
+		return nil
+	}
+
+	switch {
+	case keyword == `NORMALS`:
+		d.normals = toFloats(values, 1)
+	case keyword == `TEXTURE_COORDINATES` && components >= 2:
+		d.uvs = pick(values, components, 2, 1)
+	case keyword == `COLOR_SCALARS` && components >= 3:
+		scale := 1.0
+		if t.binary {
+			scale = 255
+		}
+		d.colors = pick(values, components, 3, scale)
+	case keyword == `SCALARS` && components >= 3 && d.colors == nil && strings.ToLower(typ) == `unsigned_char`:
+		// rgb stored as byte scalars, as point cloud tools write colour
+		d.colors = pick(values, components, 3, 255)
+	}
+	return nil
+}
+
+// Version 5 metadata ends with an empty line
+func skipMetadata(t *tokenizer) error {
+	if _, err := t.line(); err != nil {
+		return unexpected(err)
+	}
+	for {
+		line, err := t.line()
+		if err != nil {
+			return unexpected(err)
+		}
+		if strings.TrimSpace(line) == `` {
+			return nil
+		}
+	}
+}
+
+// FIELD name n is followed by n arrays each announced by name components tuples type
+func skipField(t *tokenizer) error {
+	if _, err := t.word(); err != nil {
+		return unexpected(err)
+	}
+	n, err := t.int()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		if name == `NULL_ARRAY` {
+			continue
+		}
+		components, err := t.int()
+		if err != nil {
+			return err
+		}
+		tuples, err := t.int()
+		if err != nil {
+			return err
+		}
+		typ, err := t.word()
+		if err != nil {
+			return unexpected(err)
+		}
+		if _, err := t.values(components*tuples, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toFloats(values []float64, scale float64) threejs.FloatArray {
+	out := make(threejs.FloatArray, len(values))
+	for i, v := range values {
+		out[i] = float32(v / scale)
+	}
+	return out
+}
+
+// The first n of every components values, divided by scale
+func pick(values []float64, components, n int, scale float64) threejs.FloatArray {
+	out := make(threejs.FloatArray, 0, len(values)/components*n)
+	for i := 0; i+components <= len(values); i += components {
+		for c := 0; c < n; c++ {
+			out = append(out, float32(values[i+c]/scale))
+		}
+	}
+	return out
+}
+
+// Adds the surface and the lines, grouped when there are both, or the points when there is neither
+func (d *dataset) addTo(doc *threejs.Document, name string) error {
+	if len(d.positions) == 0 {
+		return &parseError{msg: `no points`}
+	}
+	parent := doc.Object
+	if len(d.triangles) > 0 && len(d.segments) > 0 {
+		parent = threejs.NewObject(`Group`, name)
+		doc.Object.Children = append(doc.Object.Children, parent)
+	}
+
+	if len(d.triangles) > 0 {
+		geometry := d.geometry(name, nil)
+		geometry.Index = d.triangles
+		normals := d.normals
+		if normals == nil {
+			var err error
+			if normals, err = threejs.ComputeNormals(d.positions, d.triangles); err != nil {
+				return err
+			}
+		}
+		geometry.AddAttribute(`normal`, 3, normals)
+		if d.uvs != nil {
+			geometry.AddAttribute(`uv`, 2, d.uvs)
+		}
+		doc.AddMesh(parent, `Mesh`, name, geometry, d.material(`MeshPhongMaterial`))
+	}
+	if len(d.segments) > 0 {
+		// segments are drawn without an index so their points are laid out in pairs
+		line := doc.AddMesh(parent, `Line`, name, d.geometry(name, d.segments), d.material(`LineBasicMaterial`))
+		line.Properties = map[string]interface{}{`mode`: 1}
+	}
+	if len(d.triangles) == 0 && len(d.segments) == 0 {
+		doc.AddMesh(parent, `Points`, name, d.geometry(name, nil), d.material(`PointsMaterial`))
+	}
+	return nil
+}
+
+// A geometry with the positions and colours, reordered by order when it is not nil
+func (d *dataset) geometry(name string, order []uint32) *threejs.Geometry {
+	geometry := threejs.NewGeometry(name)
+	geometry.AddAttribute(`position`, 3, reorder(d.positions, 3, order))
+	if d.colors != nil {
+		geometry.AddAttribute(`color`, 3, reorder(d.colors, 3, order))
+	}
+	return geometry
+}
+
+func reorder(values threejs.FloatArray, itemSize int, order []uint32) threejs.FloatArray {
+	if order == nil {
+		return values
+	}
+	out := make(threejs.FloatArray, 0, len(order)*itemSize)
+	for _, i := range order {
+		out = append(out, values[int(i)*itemSize:(int(i)+1)*itemSize]...)
+	}
+	return out
+}
+
+func (d *dataset) material(typ string) threejs.Material {
+	material := threejs.NewMaterial(typ, ``)
+	if d.colors != nil {
+		material[`vertexColors`] = threejs.VertexColors
+	}
+	return material
+}
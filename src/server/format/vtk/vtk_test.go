@@ -0,0 +1,182 @@
+package vtk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"math"
+	"strings"
+	"testing"
+)
+
+const header = "# vtk DataFile Version 3.0\ntest\nASCII\nDATASET POLYDATA\n"
+
+const trianglePoints = "POINTS 3 float\n0 0 0 1 0 0 0 1 0\n"
+
+func binaryTriangle() []byte {
+	out := &bytes.Buffer{}
+	out.WriteString("# vtk DataFile Version 3.0\ntest\nBINARY\nDATASET POLYDATA\nPOINTS 3 float\n")
+	for _, v := range []float32{0, 0, 0, 1, 0, 0, 0, 1, 0} {
+		binary.Write(out, binary.BigEndian, math.Float32bits(v))
+	}
+	out.WriteString("\nPOLYGONS 1 4\n")
+	for _, i := range []int32{3, 0, 1, 2} {
+		binary.Write(out, binary.BigEndian, i)
+	}
+	return out.Bytes()
+}
+
+func objectTypes(object *threejs.Object) []string {
+	types := []string{}
+	for _, child := range object.Children {
+		if child.Type == `Group` {
+			types = append(types, objectTypes(child)...)
+		} else {
+			types = append(types, child.Type)
+		}
+	}
+	return types
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// the types of the objects read, or the error expected instead
+		want []string
+		err  string
+	}{
+		{
+			name: `polygons`,
+			data: header + trianglePoints + "POLYGONS 1 4\n3 0 1 2\n",
+			want: []string{`Mesh`},
+		},
+		{
+			name: `lines`,
+			data: header + trianglePoints + "LINES 1 4\n3 0 1 2\n",
+			want: []string{`Line`},
+		},
+		{
+			name: `polygons and lines`,
+			data: header + trianglePoints + "POLYGONS 1 4\n3 0 1 2\nLINES 1 3\n2 0 1\n",
+			want: []string{`Mesh`, `Line`},
+		},
+		{
+			name: `points`,
+			data: header + trianglePoints + "POINT_DATA 3\nCOLOR_SCALARS rgb 3\n1 0 0 0 1 0 0 0 1\n",
+			want: []string{`Points`},
+		},
+		{
+			name: `unstructured grid`,
+			data: "# vtk DataFile Version 3.0\ntest\nASCII\nDATASET UNSTRUCTURED_GRID\n" + trianglePoints +
+				"CELLS 2 7\n3 0 1 2\n2 0 1\nCELL_TYPES 2\n5\n3\n",
+			want: []string{`Mesh`, `Line`},
+		},
+		{
+			name: `version 5 offsets`,
+			data: "# vtk DataFile Version 5.1\ntest\nASCII\nDATASET POLYDATA\n" + trianglePoints +
+				"POLYGONS 2 3\nOFFSETS vtktypeint64\n0 3\nCONNECTIVITY vtktypeint64\n0 1 2\n",
+			want: []string{`Mesh`},
+		},
+		{
+			name: `binary`,
+			data: string(binaryTriangle()),
+			want: []string{`Mesh`},
+		},
+		{
+			name: `not a vtk file`,
+			data: "ply\n",
+			err:  `vtk: not a legacy vtk file`,
+		},
+		{
+			name: `unknown encoding`,
+			data: "# vtk DataFile Version 3.0\ntest\nXML\n",
+			err:  `vtk: unknown encoding "XML"`,
+		},
+		{
+			name: `unsupported dataset`,
+			data: "# vtk DataFile Version 3.0\ntest\nASCII\nDATASET RECTILINEAR_GRID\n",
+			err:  `vtk: unsupported dataset RECTILINEAR_GRID, only POLYDATA and UNSTRUCTURED_GRID are read`,
+		},
+		{
+			name: `no points`,
+			data: header,
+			err:  `vtk: no points`,
+		},
+		{
+			name: `unsupported data type`,
+			data: header + "POINTS 3 half\n",
+			err:  `vtk: unsupported data type half`,
+		},
+		{
+			name: `bad number`,
+			data: header + "POINTS 1 float\n0 x 0\n",
+			err:  `vtk: invalid number "x"`,
+		},
+		{
+			name: `bad count`,
+			data: header + "POINTS -3 float\n",
+			err:  `vtk: expected a count but found "-3"`,
+		},
+		{
+			name: `count the data does not back`,
+			data: header + "POINTS 2000000000 float\n0 0 0\n",
+			err:  `vtk: unexpected end of file`,
+		},
+		{
+			name: `count the binary data does not back`,
+			data: strings.Replace(string(binaryTriangle()), `POINTS 3`, `POINTS 2000000000`, 1),
+			err:  `vtk: unexpected end of binary data`,
+		},
+		{
+			name: `point index out of range`,
+			data: header + trianglePoints + "POLYGONS 1 4\n3 0 1 3\n",
+			err:  `vtk: point index 3 out of range`,
+		},
+		{
+			name: `cell list out of range`,
+			data: header + trianglePoints + "POLYGONS 1 4\n4 0 1 2\n",
+			err:  `vtk: cell list out of range`,
+		},
+		{
+			name: `wrong cell count`,
+			data: header + trianglePoints + "POLYGONS 2 4\n3 0 1 2\n",
+			err:  `vtk: expected 2 cells but found 1`,
+		},
+		{
+			name: `offsets out of range`,
+			data: "# vtk DataFile Version 5.1\ntest\nASCII\nDATASET POLYDATA\n" + trianglePoints +
+				"POLYGONS 2 3\nOFFSETS vtktypeint64\n0 4\nCONNECTIVITY vtktypeint64\n0 1 2\n",
+			err: `vtk: cell offsets out of range`,
+		},
+		{
+			name: `bad scalar components`,
+			data: header + trianglePoints + "POINT_DATA 3\nSCALARS s float -1\n",
+			err:  `vtk: invalid scalar component count "-1"`,
+		},
+		{
+			name: `unexpected keyword`,
+			data: header + trianglePoints + "SPHERES 1\n",
+			err:  `vtk: unexpected keyword "SPHERES"`,
+		},
+	}
+	for _, test := range tests {
+		doc := &threejs.Document{Object: threejs.NewObject(`Group`, `upload`)}
+		err := Read(strings.NewReader(test.data), doc, `model`)
+		got := objectTypes(doc.Object)
+		doc.Close()
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if strings.Join(got, ` `) != strings.Join(test.want, ` `) {
+			t.Errorf(`%s: got objects %v, want %v`, test.name, got, test.want)
+		}
+	}
+}
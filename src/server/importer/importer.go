@@ -0,0 +1,134 @@
+/*
+Server side conversion of uploaded model files into the Three.js json object format the editor's
+THREE.ObjectLoader consumes, so huge files never have to be parsed in the browser
+*/
+package importer
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	Prefix = `/api/import`
+)
+
+// The files of an upload, read one after the other as they arrive so each must be fully consumed, or skipped,
+// before asking for the next. Next returns io.EOF once there are no more files.
+type Files interface {
+	Next() (name string, r io.Reader, err error)
+}
+
+// Converts the uploaded files into a document, name is the name the upload should appear under in the editor or
+// empty when the importer should name it after the model file
+type ImportFunc func(files Files, name string) (*threejs.Document, error)
+
+// Serves POST /api/import/{format}, the body is either a multipart form whose file parts are passed on in order or
+// the raw contents of a single file named by ?name=. The converted document is streamed back as the response.
+func NewHandler(log golog.Log) *Handler {
+	return &Handler{log: log, formats: map[string]ImportFunc{}}
+}
+
+type Handler struct {
+	log     golog.Log
+	formats map[string]ImportFunc
+}
+
+// Registers fn as the importer for format, it must be called before the handler starts serving
+func (h *Handler) Register(format string, fn ImportFunc) {
+	h.formats[format] = fn
+}
+
+// The importer registered for format, nil when there is none
+func (h *Handler) Format(format string) ImportFunc {
+	return h.formats[format]
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := api.SplitPath(r.URL.Path, Prefix)
+	if len(segments) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	format := strings.ToLower(segments[0])
+	fn := h.formats[format]
+	if fn == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != `POST` {
+		api.MethodNotAllowed(w, `POST`)
+		return
+	}
+
+	name := r.URL.Query().Get(`name`)
+	var files Files
+	if reader, err := r.MultipartReader(); err == nil {
+		files = &multipartFiles{reader: reader}
+	} else if name != `` {
+		files = &singleFile{name: name, reader: r.Body}
+	} else {
+		files = &singleFile{name: `upload.` + format, reader: r.Body}
+	}
+
+	doc, err := fn(files, BaseName(name))
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer doc.Close()
+
+	w.Header().Set(`Content-Type`, `application/json; charset=utf-8`)
+	if _, err := doc.WriteTo(w); err != nil {
+		h.log.Error(`failed to write imported `, format, `: `, err)
+	}
+}
+
+type singleFile struct {
+	name   string
+	reader io.Reader
+	done   bool
+}
+
+func (f *singleFile) Next() (string, io.Reader, error) {
+	if f.done {
+		return ``, nil, io.EOF
+	}
+	f.done = true
+	return f.name, f.reader, nil
+}
+
+type multipartFiles struct {
+	reader *multipart.Reader
+}
+
+func (f *multipartFiles) Next() (string, io.Reader, error) {
+	for {
+		part, err := f.reader.NextPart()
+		if err != nil {
+			return ``, nil, err
+		}
+		if part.FileName() != `` {
+			return part.FileName(), part, nil
+		}
+	}
+}
+
+// The file name without its directory or extension
+func BaseName(name string) string {
+	if name == `` {
+		return ``
+	}
+	return strings.TrimSuffix(path.Base(name), path.Ext(name))
+}
+
+// Lower case extension of name without the dot
+func Ext(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), `.`))
+}
@@ -0,0 +1,29 @@
+/*
+Uploads held in memory for testing importers
+*/
+package importertest
+
+import (
+	"bytes"
+	"github.com/robsix/3ditor/src/server/importer"
+	"io"
+)
+
+type files struct {
+	names []string
+	data  [][]byte
+}
+
+// The upload of a file named by each of names holding the matching data, in order
+func NewFiles(names []string, data [][]byte) importer.Files {
+	return &files{names: names, data: data}
+}
+
+func (f *files) Next() (string, io.Reader, error) {
+	if len(f.names) == 0 {
+		return ``, nil, io.EOF
+	}
+	name, data := f.names[0], f.data[0]
+	f.names, f.data = f.names[1:], f.data[1:]
+	return name, bytes.NewReader(data), nil
+}
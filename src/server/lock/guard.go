@@ -0,0 +1,76 @@
+package lock
+
+import (
+	"github.com/robsix/3ditor/src/server/diff"
+	"github.com/robsix/3ditor/src/server/scene"
+)
+
+// Rejects saves that change an object covered by a lease held by anyone other than the saving holder. Changes to
+// a geometry, material or script count as changes to the objects using them. Deleting the scene changes every object
+// so any lease held by someone else rejects it.
+func NewSaveGuard(m *Manager) scene.SaveGuard {
+	return func(sceneId, holder string, before, after []byte) error {
+		if len(m.Leases(sceneId)) == 0 {
+			return nil
+		}
+		beforeGraph, err := scene.ParseGraph(before)
+		if err != nil {
+			return err
+		}
+		if after == nil {
+			all := []string{}
+			beforeGraph.Walk(func(node *scene.Node) {
+				all = append(all, node.Uuid)
+			})
+			return m.Check(sceneId, beforeGraph, holder, all...)
+		}
+		afterGraph, err := scene.ParseGraph(after)
+		if err != nil {
+			return err
+		}
+		report := diff.Graphs(beforeGraph, afterGraph)
+
+		changed := []string{}
+		for _, entries := range [][]*diff.Entry{report.Objects.Removed, report.Objects.Reparented, report.Objects.Modified, report.Scripts.Added, report.Scripts.Removed, report.Scripts.Modified} {
+			for _, entry := range entries {
+				changed = append(changed, entry.Uuid)
+			}
+		}
+		for _, part := range []struct {
+			key     string
+			section *diff.Section
+		}{
+			{`geometry`, report.Geometries},
+			{`material`, report.Materials},
+		} {
+			for _, entries := range [][]*diff.Entry{part.section.Removed, part.section.Modified} {
+				for _, entry := range entries {
+					changed = append(changed, Users(beforeGraph, part.key, entry.Uuid)...)
+				}
+			}
+		}
+		if err := m.Check(sceneId, beforeGraph, holder, changed...); err != nil {
+			return err
+		}
+
+		parents := []string{}
+		for _, entry := range report.Objects.Added {
+			parents = append(parents, afterGraph.Objects[entry.Uuid].Parent)
+		}
+		for _, entry := range report.Objects.Reparented {
+			parents = append(parents, entry.ToParent)
+		}
+		return m.CheckSubtree(sceneId, beforeGraph, holder, parents...)
+	}
+}
+
+// The uuids of the objects whose key property, e.g. geometry or material, references uuid
+func Users(g *scene.Graph, key, uuid string) []string {
+	users := []string{}
+	for objectUuid, node := range g.Objects {
+		if ref, _ := node.Object[key].(string); ref == uuid {
+			users = append(users, objectUuid)
+		}
+	}
+	return users
+}
@@ -0,0 +1,28 @@
+package lock
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+)
+
+// Serves GET /api/scenes/{id}/locks listing the unexpired leases on the scene, leases themselves are taken and
+// released over the collaboration websocket so they can be dropped when the socket does
+func NewSubHandler(m *Manager, store scene.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+		if _, _, err := store.Get(id); err != nil {
+			scene.WriteError(w, log, err)
+			return
+		}
+		api.WriteJson(w, http.StatusOK, m.Leases(id))
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Exclusive, expiring edit leases on scene objects so collaborators do not silently overwrite each other's work
+*/
+package lock
+
+import (
+	"github.com/robsix/3ditor/src/server/scene"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTtl = time.Minute
+	MaxTtl     = 10 * time.Minute
+)
+
+// An exclusive lease on an object, and on all of its descendants when Subtree is set. Holder is never sent to
+// clients, anyone knowing it can save over the lease.
+type Lease struct {
+	Uuid       string    `json:"uuid"`
+	Subtree    bool      `json:"subtree"`
+	Holder     string    `json:"-"`
+	ClientId   string    `json:"clientId"`
+	HolderName string    `json:"holderName"`
+	Expires    time.Time `json:"expires"`
+}
+
+// Keeps the leases of every scene in memory, expired leases are dropped lazily on the next call that touches the
+// scene. Holders are identified by a secret, normally the token of their collaboration websocket client, so that
+// all of a client's leases can be released when its socket drops and saves can prove they come from the holder.
+// The lease also records the holder's public client id so others can tell who has it.
+type Manager struct {
+	mtx    sync.Mutex
+	scenes map[string]map[string]*Lease
+}
+
+func NewManager() *Manager {
+	return &Manager{scenes: map[string]map[string]*Lease{}}
+}
+
+func (m *Manager) purgeExpiredLocked(sceneId string) {
+	now := time.Now()
+	for uuid, lease := range m.scenes[sceneId] {
+		if now.After(lease.Expires) {
+			delete(m.scenes[sceneId], uuid)
+		}
+	}
+	if len(m.scenes[sceneId]) == 0 {
+		delete(m.scenes, sceneId)
+	}
+}
+
+// Takes or renews a lease on uuid for ttl, clamped to MaxTtl and defaulting to DefaultTtl. It fails if any object
+// the lease would cover is already covered by a lease held by someone else.
+func (m *Manager) Acquire(sceneId string, g *scene.Graph, uuid string, subtree bool, holder, clientId, holderName string, ttl time.Duration) (*Lease, error) {
+	if g.Objects[uuid] == nil {
+		return nil, &noSuchObjectError{uuid: uuid}
+	}
+	if ttl <= 0 {
+		ttl = DefaultTtl
+	} else if ttl > MaxTtl {
+		ttl = MaxTtl
+	}
+
+	defer m.mtx.Unlock()
+	m.mtx.Lock()
+
+	m.purgeExpiredLocked(sceneId)
+	covered := []string{uuid}
+	if subtree {
+		covered = g.Subtree(uuid)
+	}
+	for _, u := range covered {
+		if lease := m.coveringLocked(sceneId, g, u, true); lease != nil && lease.Holder != holder {
+			return nil, newLockedError(g, u, lease)
+		}
+	}
+
+	lease := &Lease{
+		Uuid:       uuid,
+		Subtree:    subtree,
+		Holder:     holder,
+		ClientId:   clientId,
+		HolderName: holderName,
+		Expires:    time.Now().Add(ttl).UTC(),
+	}
+	if m.scenes[sceneId] == nil {
+		m.scenes[sceneId] = map[string]*Lease{}
+	}
+	m.scenes[sceneId][uuid] = lease
+	acquired := *lease
+	return &acquired, nil
+}
+
+func (m *Manager) Release(sceneId, uuid, holder string) {
+	defer m.mtx.Unlock()
+	m.mtx.Lock()
+
+	if lease := m.scenes[sceneId][uuid]; lease != nil && lease.Holder == holder {
+		delete(m.scenes[sceneId], uuid)
+	}
+	m.purgeExpiredLocked(sceneId)
+}
+
+// Releases every lease the holder has on the scene, returning whether there were any
+func (m *Manager) ReleaseAll(sceneId, holder string) bool {
+	defer m.mtx.Unlock()
+	m.mtx.Lock()
+
+	released := false
+	for uuid, lease := range m.scenes[sceneId] {
+		if lease.Holder == holder {
+			delete(m.scenes[sceneId], uuid)
+			released = true
+		}
+	}
+	m.purgeExpiredLocked(sceneId)
+	return released
+}
+
+// The unexpired leases on the scene ordered by uuid
+func (m *Manager) Leases(sceneId string) []*Lease {
+	defer m.mtx.Unlock()
+	m.mtx.Lock()
+
+	m.purgeExpiredLocked(sceneId)
+	leases := make([]*Lease, 0, len(m.scenes[sceneId]))
+	for _, lease := range m.scenes[sceneId] {
+		leaseCopy := *lease
+		leases = append(leases, &leaseCopy)
+	}
+	sort.Sort(byUuid(leases))
+	return leases
+}
+
+// Fails with a locked error if any of uuids is covered by a lease held by someone other than holder
+func (m *Manager) Check(sceneId string, g *scene.Graph, holder string, uuids ...string) error {
+	return m.check(sceneId, g, holder, true, uuids)
+}
+
+// Like Check but only subtree leases count, used when adding children to an object
+func (m *Manager) CheckSubtree(sceneId string, g *scene.Graph, holder string, uuids ...string) error {
+	return m.check(sceneId, g, holder, false, uuids)
+}
+
+func (m *Manager) check(sceneId string, g *scene.Graph, holder string, includeDirect bool, uuids []string) error {
+	defer m.mtx.Unlock()
+	m.mtx.Lock()
+
+	m.purgeExpiredLocked(sceneId)
+	for _, uuid := range uuids {
+		if lease := m.coveringLocked(sceneId, g, uuid, includeDirect); lease != nil && lease.Holder != holder {
+			return newLockedError(g, uuid, lease)
+		}
+	}
+	return nil
+}
+
+// The lease on uuid itself, when includeDirect is set, or the subtree lease of its nearest locked ancestor
+func (m *Manager) coveringLocked(sceneId string, g *scene.Graph, uuid string, includeDirect bool) *Lease {
+	leases := m.scenes[sceneId]
+	if lease := leases[uuid]; lease != nil && (includeDirect || lease.Subtree) {
+		return lease
+	}
+	for node := g.Objects[uuid]; node != nil && node.Parent != ``; node = g.Objects[node.Parent] {
+		if lease := leases[node.Parent]; lease != nil && lease.Subtree {
+			return lease
+		}
+	}
+	return nil
+}
+
+type byUuid []*Lease
+
+func (l byUuid) Len() int           { return len(l) }
+func (l byUuid) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byUuid) Less(i, j int) bool { return l[i].Uuid < l[j].Uuid }
+
+type lockedError struct {
+	uuid       string
+	name       string
+	holderName string
+	expires    time.Time
+}
+
+func newLockedError(g *scene.Graph, uuid string, lease *Lease) error {
+	name := ``
+	if node := g.Objects[uuid]; node != nil {
+		name, _ = node.Object[`name`].(string)
+	}
+	return &lockedError{uuid: uuid, name: name, holderName: lease.HolderName, expires: lease.Expires}
+}
+
+func (e *lockedError) Error() string {
+	object := `object ` + e.uuid
+	if e.name != `` {
+		object = `object ` + strconv.Quote(e.name) + ` (` + e.uuid + `)`
+	}
+	holder := e.holderName
+	if holder == `` {
+		holder = `another client`
+	}
+	return object + ` is locked by ` + holder + ` until ` + e.expires.Format(time.RFC3339)
+}
+
+func IsLocked(err error) bool {
+	_, ok := err.(*lockedError)
+	return ok
+}
+
+type noSuchObjectError struct {
+	uuid string
+}
+
+func (e *noSuchObjectError) Error() string { return `No such object exists with uuid: ` + e.uuid }
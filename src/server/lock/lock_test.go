@@ -0,0 +1,211 @@
+package lock
+
+import (
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	moved = scenetest.Translation(1, 0, 0)
+	a1    = scenetest.Object(`A1`, `a1`, `M`, scenetest.Identity)
+	a     = scenetest.Object(`A`, `a`, `M`, scenetest.Identity, a1)
+	b1    = scenetest.Object(`B1`, `b1`, `N`, scenetest.Identity)
+	b     = scenetest.Object(`B`, `b`, `N`, scenetest.Identity, b1)
+	c     = scenetest.Object(`C`, `c`, `N`, scenetest.Identity)
+)
+
+// A with child A1 using material M, B with child B1 and C using material N
+func testDocument() []byte {
+	return []byte(scenetest.Document(`0`, a, b, c))
+}
+
+func TestAcquire(t *testing.T) {
+	g, err := scene.ParseGraph(testDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// leases alice holds before bob acquires, a trailing * makes it a subtree lease
+		alice    []string
+		uuid     string
+		subtree  bool
+		expired  bool
+		locked   bool
+		notFound bool
+	}{
+		{name: `free object`, uuid: `A`},
+		{name: `free subtree`, uuid: `A`, subtree: true},
+		{name: `locked object`, alice: []string{`A`}, uuid: `A`, locked: true},
+		{name: `child of a locked object`, alice: []string{`A`}, uuid: `A1`},
+		{name: `child of a locked subtree`, alice: []string{`A*`}, uuid: `A1`, locked: true},
+		{name: `subtree over a locked child`, alice: []string{`A1`}, uuid: `A`, subtree: true, locked: true},
+		{name: `expired lease`, alice: []string{`A*`}, expired: true, uuid: `A`},
+		{name: `missing object`, uuid: `Z`, notFound: true},
+	}
+	for _, test := range tests {
+		m := NewManager()
+		ttl := time.Minute
+		if test.expired {
+			ttl = time.Nanosecond
+		}
+		for _, uuid := range test.alice {
+			if _, err := m.Acquire(`scene`, g, strings.TrimSuffix(uuid, `*`), strings.HasSuffix(uuid, `*`), `alice-token`, `alice`, `Alice`, ttl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.expired {
+			time.Sleep(time.Millisecond)
+		}
+
+		lease, err := m.Acquire(`scene`, g, test.uuid, test.subtree, `bob-token`, `bob`, `Bob`, 0)
+		switch {
+		case test.locked:
+			if !IsLocked(err) {
+				t.Errorf(`%s: got %v, want a locked error`, test.name, err)
+			}
+		case test.notFound:
+			if _, ok := err.(*noSuchObjectError); !ok {
+				t.Errorf(`%s: got %v, want a no such object error`, test.name, err)
+			}
+		case err != nil:
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		case lease.Holder != `bob-token` || lease.ClientId != `bob` || lease.Expires.Before(time.Now().Add(DefaultTtl-time.Second)):
+			t.Errorf(`%s: got lease %+v`, test.name, lease)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	g, err := scene.ParseGraph(testDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager()
+	for _, uuid := range []string{`A`, `B`} {
+		if _, err := m.Acquire(`scene`, g, uuid, false, `alice-token`, `alice`, `Alice`, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the holder's secret token releases a lease, not the public client id
+	m.Release(`scene`, `A`, `alice`)
+	if leases := m.Leases(`scene`); len(leases) != 2 {
+		t.Errorf(`got %d leases after a release by client id, want 2`, len(leases))
+	}
+	m.Release(`scene`, `A`, `alice-token`)
+	if leases := m.Leases(`scene`); len(leases) != 1 || leases[0].Uuid != `B` {
+		t.Errorf(`got leases %v after a release, want only B`, leases)
+	}
+	if !m.ReleaseAll(`scene`, `alice-token`) || len(m.Leases(`scene`)) != 0 {
+		t.Errorf(`leases left after releasing them all`)
+	}
+}
+
+func TestSaveGuard(t *testing.T) {
+	before := testDocument()
+	tests := []struct {
+		name   string
+		holder string
+		after  []byte
+		locked bool
+	}{
+		{
+			name:   `holder moves its object`,
+			holder: `alice-token`,
+			after:  []byte(scenetest.Document(`0`, scenetest.Object(`A`, `a`, `M`, moved, a1), b, c)),
+		},
+		{
+			name:   `other moves a locked object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, scenetest.Object(`A`, `a`, `M`, moved, a1), b, c)),
+			locked: true,
+		},
+		{
+			name:   `client id instead of the token`,
+			holder: `alice`,
+			after:  []byte(scenetest.Document(`0`, scenetest.Object(`A`, `a`, `M`, moved, a1), b, c)),
+			locked: true,
+		},
+		{
+			name:   `other moves a free object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, a, b, scenetest.Object(`C`, `c`, `N`, moved))),
+		},
+		{
+			name:   `other removes a locked object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, b, c)),
+			locked: true,
+		},
+		{
+			name:   `other changes the material of a locked object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`255`, a, b, c)),
+			locked: true,
+		},
+		{
+			name:   `other changes a child of a locked object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, scenetest.Object(`A`, `a`, `M`, scenetest.Identity, scenetest.Object(`A1`, `a1`, `M`, moved)), b, c)),
+		},
+		{
+			name:   `other changes a child of a locked subtree`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, a, scenetest.Object(`B`, `b`, `N`, scenetest.Identity, scenetest.Object(`B1`, `b1`, `N`, moved)), c)),
+			locked: true,
+		},
+		{
+			name:   `other adds a child to a locked object`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, scenetest.Object(`A`, `a`, `M`, scenetest.Identity, a1, scenetest.Object(`A2`, `a2`, `N`, scenetest.Identity)), b, c)),
+		},
+		{
+			name:   `other adds a child to a locked subtree`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, a, scenetest.Object(`B`, `b`, `N`, scenetest.Identity, b1, scenetest.Object(`B2`, `b2`, `N`, scenetest.Identity)), c)),
+			locked: true,
+		},
+		{
+			name:   `other moves a free object into a locked subtree`,
+			holder: `bob-token`,
+			after:  []byte(scenetest.Document(`0`, a, scenetest.Object(`B`, `b`, `N`, scenetest.Identity, b1, c))),
+			locked: true,
+		},
+		{
+			name:   `holder deletes the scene`,
+			holder: `alice-token`,
+		},
+		{
+			name:   `other deletes the scene`,
+			holder: `bob-token`,
+			locked: true,
+		},
+	}
+	g, err := scene.ParseGraph(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager()
+	guard := NewSaveGuard(m)
+	if _, err := m.Acquire(`scene`, g, `A`, false, `alice-token`, `alice`, `Alice`, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Acquire(`scene`, g, `B`, true, `alice-token`, `alice`, `Alice`, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		err := guard(`scene`, test.holder, before, test.after)
+		if test.locked && !IsLocked(err) {
+			t.Errorf(`%s: got %v, want a locked error`, test.name, err)
+		} else if !test.locked && err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		}
+	}
+	// a scene nobody has leases on is never parsed
+	if err := guard(`other`, `bob-token`, []byte(`{}`), []byte(`{}`)); err != nil {
+		t.Errorf(`unleased scene: unexpected error: %v`, err)
+	}
+}
@@ -0,0 +1,87 @@
+package lod
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+)
+
+const (
+	// saves racing other saves are generated again from the new head this many times before giving up
+	maxAttempts = 3
+)
+
+type saveResult struct {
+	*scene.Meta
+	*Result
+}
+
+// Serves POST /api/scenes/{id}/lod with a json Request body, adding the reduced geometries to the head revision and
+// saving it as a new revision. ?author= and ?holder= are used as when saving a scene, guard may veto the save with a
+// 423 as it does for saves from the editor.
+func NewSubHandler(store scene.Store, guard scene.SaveGuard, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `POST` {
+			api.MethodNotAllowed(w, `POST`)
+			return
+		}
+		req := &Request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		query := r.URL.Query()
+		for attempt := 1; ; attempt++ {
+			head, doc, err := store.Get(id)
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			g, err := scene.ParseGraph(doc)
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			result, err := Generate(g, req)
+			if IsNotFound(err) {
+				api.WriteError(w, http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				api.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			after, err := json.Marshal(g.Document())
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			if guard != nil {
+				if err := guard(id, query.Get(`holder`), doc, after); err != nil {
+					if scene.IsInvalid(err) {
+						scene.WriteError(w, log, err)
+					} else {
+						api.WriteError(w, http.StatusLocked, err)
+					}
+					return
+				}
+			}
+			meta, err := store.Replace(id, ``, query.Get(`author`), head.Revision, after)
+			if scene.IsHeadMoved(err) && attempt < maxAttempts {
+				continue
+			} else if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			log.Info(`generated `, len(result.Levels), ` levels of detail for geometry `, req.Geometry, ` of scene `, id)
+			api.WriteJson(w, http.StatusOK, &saveResult{Meta: meta, Result: result})
+			return
+		}
+	}
+}
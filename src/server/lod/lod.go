@@ -0,0 +1,211 @@
+package lod
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	// each level is switched to this many bounding radii further from the camera than the last
+	levelSpacing = 4
+)
+
+// What to generate for a geometry of a scene
+type Request struct {
+	Geometry string `json:"geometry"`
+	// the fraction of the triangles each variant keeps, between 0 and 1
+	Ratios []float64 `json:"ratios"`
+	// replaces every mesh drawing the geometry with a LOD object holding the mesh and a mesh for each variant
+	Wrap bool `json:"wrap"`
+	// camera distances the variants are switched to at, spaced by the geometry's size when empty
+	Distances []float64 `json:"distances,omitempty"`
+}
+
+// A reduced copy of the geometry
+type Level struct {
+	Geometry  string  `json:"geometry"`
+	Ratio     float64 `json:"ratio"`
+	Triangles int     `json:"triangles"`
+	Distance  float64 `json:"distance,omitempty"`
+}
+
+type Result struct {
+	// triangles in the source geometry
+	Triangles int      `json:"triangles"`
+	Levels    []*Level `json:"levels"`
+	// the LOD objects made when wrapping
+	Objects []string `json:"objects"`
+}
+
+type noSuchGeometryError struct {
+	uuid string
+}
+
+func (e *noSuchGeometryError) Error() string {
+	return `No such geometry ` + e.uuid
+}
+
+type invalidRequestError struct {
+	reason string
+}
+
+func (e *invalidRequestError) Error() string {
+	return `invalid lod request: ` + e.reason
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*noSuchGeometryError)
+	return ok
+}
+
+func IsInvalid(err error) bool {
+	_, ok := err.(*invalidRequestError)
+	return ok
+}
+
+// Adds a simplified copy of the request's geometry to g for each ratio, most detailed first, and when asked wraps the
+// meshes drawing it in LOD objects
+func Generate(g *scene.Graph, r *Request) (*Result, error) {
+	geometry := g.Geometries[r.Geometry]
+	if geometry == nil {
+		return nil, &noSuchGeometryError{uuid: r.Geometry}
+	}
+	if len(r.Ratios) == 0 {
+		return nil, &invalidRequestError{reason: `no ratios`}
+	}
+	for _, ratio := range r.Ratios {
+		if !(ratio > 0 && ratio < 1) {
+			return nil, &invalidRequestError{reason: `ratios must be between 0 and 1`}
+		}
+	}
+	if len(r.Distances) != 0 && len(r.Distances) != len(r.Ratios) {
+		return nil, &invalidRequestError{reason: `one distance is needed for each ratio`}
+	}
+	m, err := mesh.FromJson(geometry)
+	if err != nil {
+		return nil, &invalidRequestError{reason: err.Error()}
+	}
+	if m.TriangleCount() == 0 {
+		return nil, &invalidRequestError{reason: `geometry ` + r.Geometry + ` has no triangles`}
+	}
+
+	name, _ := geometry[`name`].(string)
+	result := &Result{Triangles: m.TriangleCount(), Levels: []*Level{}, Objects: []string{}}
+	for i, ratio := range r.Ratios {
+		level := &Level{Geometry: threejs.NewUuid(), Ratio: ratio}
+		if len(r.Distances) != 0 {
+			level.Distance = r.Distances[i]
+		}
+		result.Levels = append(result.Levels, level)
+	}
+	sort.Sort(byRatio(result.Levels))
+	radius := boundingRadius(m)
+	for i, level := range result.Levels {
+		if len(r.Distances) == 0 {
+			level.Distance = radius * levelSpacing * float64(i+1)
+		}
+		reduced := Simplify(m, level.Ratio)
+		level.Triangles = reduced.TriangleCount()
+		levelName := name
+		if levelName != `` {
+			levelName += ` `
+		}
+		g.Geometries[level.Geometry] = reduced.ToJson(level.Geometry, levelName+percent(level.Ratio))
+	}
+
+	if r.Wrap {
+		var meshes []*scene.Node
+		g.Walk(func(node *scene.Node) {
+			typ, _ := node.Object[`type`].(string)
+			if node.Object[`geometry`] == r.Geometry && typ == `Mesh` {
+				meshes = append(meshes, node)
+			}
+		})
+		for _, node := range meshes {
+			uuid, err := wrap(g, node, result.Levels)
+			if err != nil {
+				return nil, err
+			}
+			result.Objects = append(result.Objects, uuid)
+		}
+	}
+	return result, nil
+}
+
+// Puts a LOD object in the mesh's place with the mesh as its most detailed level, the LOD takes over the mesh's name
+// and transform. A mesh already inside a LOD gets its new levels added to that LOD instead.
+func wrap(g *scene.Graph, node *scene.Node, levels []*Level) (string, error) {
+	parent := g.Objects[node.Parent]
+	var lod map[string]interface{}
+	if typ, _ := parent.Object[`type`].(string); typ == `LOD` {
+		lod = parent.Object
+	} else {
+		lod = map[string]interface{}{
+			`uuid`:   threejs.NewUuid(),
+			`type`:   `LOD`,
+			`name`:   node.Object[`name`],
+			`matrix`: node.Object[`matrix`],
+			`levels`: []interface{}{map[string]interface{}{`object`: node.Uuid, `distance`: 0.0}},
+		}
+		if lod[`name`] == nil {
+			delete(lod, `name`)
+		}
+		if err := g.Insert(lod, node.Parent, node.Uuid); err != nil {
+			return ``, err
+		}
+		if err := g.Move(node.Uuid, lod[`uuid`].(string), ``); err != nil {
+			return ``, err
+		}
+		node.Object[`matrix`] = append([]float64{}, threejs.IdentityMatrix...)
+		// the graph holds a copy of the inserted object
+		lod = g.Objects[lod[`uuid`].(string)].Object
+	}
+
+	lodUuid, _ := lod[`uuid`].(string)
+	list, _ := lod[`levels`].([]interface{})
+	for _, level := range levels {
+		obj := map[string]interface{}{}
+		for key, value := range node.Object {
+			obj[key] = value
+		}
+		obj[`uuid`] = threejs.NewUuid()
+		obj[`geometry`] = level.Geometry
+		if name, _ := node.Object[`name`].(string); name != `` {
+			obj[`name`] = name + ` ` + percent(level.Ratio)
+		}
+		if err := g.Insert(obj, lodUuid, ``); err != nil {
+			return ``, err
+		}
+		list = append(list, map[string]interface{}{`object`: obj[`uuid`], `distance`: level.Distance})
+	}
+	lod[`levels`] = list
+	return lodUuid, nil
+}
+
+// Half the diagonal of the mesh's bounding box
+func boundingRadius(m *mesh.Mesh) float64 {
+	min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i := 0; i < m.VertexCount(); i++ {
+		v := m.Vertex(i)
+		for k := range v {
+			min[k], max[k] = math.Min(min[k], v[k]), math.Max(max[k], v[k])
+		}
+	}
+	return length(sub(max, min)) / 2
+}
+
+func percent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'g', -1, 64) + `%`
+}
+
+// Most detailed first
+type byRatio []*Level
+
+func (l byRatio) Len() int           { return len(l) }
+func (l byRatio) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byRatio) Less(i, j int) bool { return l[i].Ratio > l[j].Ratio }
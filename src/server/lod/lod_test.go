@@ -0,0 +1,128 @@
+package lod
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+	"testing"
+)
+
+const sphere = `{"uuid":"G","type":"SphereGeometry","radius":1,"widthSegments":32,"heightSegments":16}`
+
+func testGraph(t *testing.T) *scene.Graph {
+	g, err := scene.ParseGraph([]byte(`{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"metadata":{},` +
+		`"geometries":[` + sphere + `,{"uuid":"E","type":"BufferGeometry","data":{"attributes":{"position":{"itemSize":3,"type":"Float32Array","array":[0,0,0,1,0,0]}}}}],` +
+		`"materials":[{"uuid":"M","type":"MeshStandardMaterial"}],` +
+		`"object":{"uuid":"S","type":"Scene","children":[` +
+		`{"uuid":"A","type":"Mesh","name":"ball","geometry":"G","material":"M","matrix":[1,0,0,0,0,1,0,0,0,0,1,0,5,0,0,1]},` +
+		`{"uuid":"B","type":"Mesh","geometry":"E","material":"M"}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestSimplify(t *testing.T) {
+	g := testGraph(t)
+	m, err := mesh.FromJson(g.Geometries[`G`])
+	if err != nil {
+		t.Fatal(err)
+	}
+	radius := boundingRadius(m)
+	for _, ratio := range []float64{0.75, 0.5, 0.25, 0.1} {
+		reduced := Simplify(m, ratio)
+		triangles := reduced.TriangleCount()
+		if triangles == 0 || triangles > int(math.Ceil(float64(m.TriangleCount())*ratio)) {
+			t.Errorf(`ratio %g: got %d of %d triangles`, ratio, triangles, m.TriangleCount())
+		}
+		for i := 0; i < triangles; i++ {
+			a, b, c := reduced.Triangle(i)
+			if a == b || b == c || a == c || a >= reduced.VertexCount() || b >= reduced.VertexCount() || c >= reduced.VertexCount() {
+				t.Errorf(`ratio %g: triangle %d has vertices %d, %d and %d of %d`, ratio, i, a, b, c, reduced.VertexCount())
+				break
+			}
+		}
+		if got := boundingRadius(reduced); math.Abs(got-radius) > radius*0.1 {
+			t.Errorf(`ratio %g: bounding radius went from %g to %g`, ratio, radius, got)
+		}
+		if len(reduced.Normals) != len(reduced.Positions) {
+			t.Errorf(`ratio %g: got %d normals for %d positions`, ratio, len(reduced.Normals), len(reduced.Positions))
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  Request
+		notFound bool
+		invalid  bool
+	}{
+		{name: `missing geometry`, request: Request{Geometry: `Z`, Ratios: []float64{0.5}}, notFound: true},
+		{name: `no ratios`, request: Request{Geometry: `G`}, invalid: true},
+		{name: `ratio of 0`, request: Request{Geometry: `G`, Ratios: []float64{0}}, invalid: true},
+		{name: `ratio of 1`, request: Request{Geometry: `G`, Ratios: []float64{1}}, invalid: true},
+		{name: `not a number`, request: Request{Geometry: `G`, Ratios: []float64{math.NaN()}}, invalid: true},
+		{name: `too few distances`, request: Request{Geometry: `G`, Ratios: []float64{0.5, 0.25}, Distances: []float64{10}}, invalid: true},
+		{name: `no triangles`, request: Request{Geometry: `E`, Ratios: []float64{0.5}}, invalid: true},
+		{name: `levels`, request: Request{Geometry: `G`, Ratios: []float64{0.25, 0.5}}},
+	}
+	for _, test := range tests {
+		g := testGraph(t)
+		geometries := len(g.Geometries)
+		result, err := Generate(g, &test.request)
+		switch {
+		case test.notFound:
+			if !IsNotFound(err) {
+				t.Errorf(`%s: got %v, want a not found error`, test.name, err)
+			}
+		case test.invalid:
+			if !IsInvalid(err) {
+				t.Errorf(`%s: got %v, want an invalid request error`, test.name, err)
+			}
+		case err != nil:
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		case len(result.Levels) != len(test.request.Ratios) || len(g.Geometries) != geometries+len(test.request.Ratios):
+			t.Errorf(`%s: got %d levels and %d new geometries`, test.name, len(result.Levels), len(g.Geometries)-geometries)
+		case result.Levels[0].Ratio != 0.5 || result.Levels[0].Triangles <= result.Levels[1].Triangles || result.Levels[0].Distance >= result.Levels[1].Distance:
+			t.Errorf(`%s: levels not ordered most detailed first: %+v, %+v`, test.name, result.Levels[0], result.Levels[1])
+		case g.Geometries[result.Levels[0].Geometry][`name`] != `50%`:
+			t.Errorf(`%s: got level named %v`, test.name, g.Geometries[result.Levels[0].Geometry][`name`])
+		}
+		if err != nil && len(g.Geometries) != geometries {
+			t.Errorf(`%s: a refused request added geometries`, test.name)
+		}
+	}
+}
+
+func TestGenerateWrap(t *testing.T) {
+	g := testGraph(t)
+	result, err := Generate(g, &Request{Geometry: `G`, Ratios: []float64{0.5}, Wrap: true, Distances: []float64{20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 1 {
+		t.Fatalf(`got %d LOD objects, want 1`, len(result.Objects))
+	}
+	lod := g.Objects[result.Objects[0]]
+	if lod.Parent != `S` || lod.Object[`type`] != `LOD` || lod.Object[`name`] != `ball` || len(lod.Children) != 2 || lod.Children[0] != `A` {
+		t.Errorf(`got LOD %v with children %v under %s`, lod.Object, lod.Children, lod.Parent)
+	}
+	if children := g.Objects[`S`].Children; len(children) != 2 || children[0] != lod.Uuid {
+		t.Errorf(`LOD did not take the mesh's place, scene children are %v`, children)
+	}
+	if matrix := lod.Object[`matrix`].([]interface{}); matrix[12] != 5.0 {
+		t.Errorf(`LOD did not take over the mesh's transform: %v`, matrix)
+	}
+	// wrapping again adds to the same LOD rather than nesting another
+	again, err := Generate(g, &Request{Geometry: `G`, Ratios: []float64{0.25}, Wrap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Objects) != 1 || again.Objects[0] != lod.Uuid || len(lod.Children) != 3 {
+		t.Errorf(`second wrap: got LOD objects %v and %d children`, again.Objects, len(lod.Children))
+	}
+	if levels := lod.Object[`levels`].([]interface{}); len(levels) != 3 {
+		t.Errorf(`got %d levels, want 3`, len(levels))
+	}
+}
@@ -0,0 +1,502 @@
+/*
+Level of detail generation, reduced copies of heavy geometries are made by quadric error metric edge collapse, after
+Garland and Heckbert, and can replace the meshes drawing them with THREE.LOD objects that switch between the copies by
+distance
+*/
+package lod
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"math"
+	"sort"
+)
+
+const (
+	// how much more moving a border or a seam between materials costs than moving a surface the same distance, high
+	// enough that the outline of open meshes and the edges of texture seams stay put
+	borderWeight = 1000
+	// a collapse may not turn a neighbouring face further than this, the cosine of about 78 degrees
+	minNormalDot = 0.2
+)
+
+// The error quadric of a set of planes, the symmetric 4x4 matrix sum of p pᵀ stored as its upper triangle
+type quadric [10]float64
+
+func planeQuadric(n [3]float64, d, weight float64) quadric {
+	a, b, c := n[0], n[1], n[2]
+	return quadric{
+		a * a * weight, a * b * weight, a * c * weight, a * d * weight,
+		b * b * weight, b * c * weight, b * d * weight,
+		c * c * weight, c * d * weight,
+		d * d * weight,
+	}
+}
+
+func (q *quadric) add(o quadric) {
+	for i := range q {
+		q[i] += o[i]
+	}
+}
+
+// The summed squared distance of p from the quadric's planes
+func (q *quadric) error(p [3]float64) float64 {
+	x, y, z := p[0], p[1], p[2]
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z + q[9]
+}
+
+// The point of least error, ok is false when the planes do not pin a single point down
+func (q *quadric) optimum() (p [3]float64, ok bool) {
+	a, b, c := q[0], q[1], q[2]
+	d, e, f := q[1], q[4], q[5]
+	g, h, i := q[2], q[5], q[7]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	if math.Abs(det) <= 1e-12*math.Abs(a*e*i) || det == 0 {
+		return p, false
+	}
+	rx, ry, rz := -q[3], -q[6], -q[8]
+	p[0] = (rx*(e*i-f*h) - b*(ry*i-f*rz) + c*(ry*h-e*rz)) / det
+	p[1] = (a*(ry*i-f*rz) - rx*(d*i-f*g) + c*(d*rz-ry*g)) / det
+	p[2] = (a*(e*rz-ry*h) - b*(d*rz-ry*g) + rx*(d*h-e*g)) / det
+	return p, true
+}
+
+// Collapsing edge a b into position
+type collapse struct {
+	cost     float64
+	a, b     int
+	position [3]float64
+}
+
+// An edge queued by the cost of collapsing it, stale once either vertex has changed since it was costed. Entries are
+// kept small and held by value, the queue holds millions of them for large scans.
+type queued struct {
+	cost     float64
+	a, b     int32
+	versionA int32
+	versionB int32
+}
+
+// A binary min heap by cost, written out rather than using container/heap whose interface calls dominate the run
+type collapseHeap []queued
+
+func (h *collapseHeap) init() {
+	for i := len(*h)/2 - 1; i >= 0; i-- {
+		h.down(i)
+	}
+}
+
+func (h *collapseHeap) push(q queued) {
+	*h = append(*h, q)
+	items := *h
+	for i := len(items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if items[parent].cost <= items[i].cost {
+			break
+		}
+		items[parent], items[i] = items[i], items[parent]
+		i = parent
+	}
+}
+
+func (h *collapseHeap) pop() queued {
+	items := *h
+	top := items[0]
+	last := len(items) - 1
+	items[0] = items[last]
+	*h = items[:last]
+	h.down(0)
+	return top
+}
+
+func (h collapseHeap) down(i int) {
+	for {
+		smallest, left, right := i, 2*i+1, 2*i+2
+		if left < len(h) && h[left].cost < h[smallest].cost {
+			smallest = left
+		}
+		if right < len(h) && h[right].cost < h[smallest].cost {
+			smallest = right
+		}
+		if smallest == i {
+			return
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+}
+
+type simplifier struct {
+	positions [][3]float64
+	uvs       []float64
+	colors    []float64
+	quadrics  []quadric
+	version   []int32
+	// the live triangles around each vertex, nil once the vertex has been collapsed away
+	vertexTriangles [][]int
+	triangles       [][3]int
+	materials       []int
+	removed         []bool
+	live            int
+	heap            collapseHeap
+}
+
+// A copy of m reduced to about ratio of its triangles by collapsing the edges whose removal changes the surface
+// least. Vertices that share a position and texture coordinates are welded first so meshes stored as separate
+// triangles can be reduced, borders and seams between materials and texture islands are kept. Normals are
+// recomputed when m has them.
+func Simplify(m *mesh.Mesh, ratio float64) *mesh.Mesh {
+	s := newSimplifier(m)
+	target := int(math.Ceil(float64(m.TriangleCount()) * ratio))
+	for s.live > target && len(s.heap) > 0 {
+		q := s.heap.pop()
+		if s.vertexTriangles[q.a] == nil || s.vertexTriangles[q.b] == nil ||
+			s.version[q.a] != q.versionA || s.version[q.b] != q.versionB {
+			continue
+		}
+		// neither vertex has changed so the collapse is the one that was costed
+		c := s.cost(int(q.a), int(q.b))
+		if s.canCollapse(&c) {
+			s.collapse(&c)
+		}
+	}
+	return s.result(m.Normals != nil)
+}
+
+func newSimplifier(m *mesh.Mesh) *simplifier {
+	s := &simplifier{}
+	type weldKey struct {
+		position [3]float64
+		uv       [2]float64
+		color    [3]float64
+	}
+	welded := map[weldKey]int{}
+	remap := make([]int, m.VertexCount())
+	for i := range remap {
+		k := weldKey{position: m.Vertex(i)}
+		if m.Uvs != nil {
+			k.uv = [2]float64{m.Uvs[i*2], m.Uvs[i*2+1]}
+		}
+		if m.Colors != nil {
+			k.color = [3]float64{m.Colors[i*3], m.Colors[i*3+1], m.Colors[i*3+2]}
+		}
+		j, exists := welded[k]
+		if !exists {
+			j = len(s.positions)
+			welded[k] = j
+			s.positions = append(s.positions, k.position)
+			if m.Uvs != nil {
+				s.uvs = append(s.uvs, k.uv[0], k.uv[1])
+			}
+			if m.Colors != nil {
+				s.colors = append(s.colors, k.color[:]...)
+			}
+		}
+		remap[i] = j
+	}
+
+	n := len(s.positions)
+	s.quadrics = make([]quadric, n)
+	s.version = make([]int32, n)
+	s.vertexTriangles = make([][]int, n)
+	for i := 0; i < m.TriangleCount(); i++ {
+		a, b, c := m.Triangle(i)
+		t := [3]int{remap[a], remap[b], remap[c]}
+		if t[0] == t[1] || t[1] == t[2] || t[0] == t[2] {
+			continue
+		}
+		index := len(s.triangles)
+		s.triangles = append(s.triangles, t)
+		s.materials = append(s.materials, m.MaterialIndex(i))
+		for _, v := range t {
+			s.vertexTriangles[v] = append(s.vertexTriangles[v], index)
+		}
+	}
+	s.removed = make([]bool, len(s.triangles))
+	s.live = len(s.triangles)
+
+	// each face adds its plane weighted by its area
+	normals := make([][3]float64, len(s.triangles))
+	for i, t := range s.triangles {
+		pa, pb, pc := s.positions[t[0]], s.positions[t[1]], s.positions[t[2]]
+		normals[i] = mesh.Normal(pa, pb, pc)
+		q := planeQuadric(normals[i], -dot(normals[i], pa), triangleArea(pa, pb, pc))
+		for _, v := range t {
+			s.quadrics[v].add(q)
+		}
+	}
+
+	// edges with one face, or faces of different materials either side, are held in place by planes at right angles
+	// to their faces
+	type edgeFaces struct {
+		first, count int
+		seam         bool
+	}
+	edges := map[[2]int]*edgeFaces{}
+	for i, t := range s.triangles {
+		for k := 0; k < 3; k++ {
+			key := edgeKey(t[k], t[(k+1)%3])
+			e := edges[key]
+			if e == nil {
+				edges[key] = &edgeFaces{first: i, count: 1}
+				continue
+			}
+			e.count++
+			if s.materials[e.first] != s.materials[i] {
+				e.seam = true
+			}
+		}
+	}
+	for key, e := range edges {
+		if e.count != 1 && !e.seam {
+			continue
+		}
+		pa, pb := s.positions[key[0]], s.positions[key[1]]
+		edge := sub(pb, pa)
+		n := normalize(cross(edge, normals[e.first]))
+		q := planeQuadric(n, -dot(n, pa), borderWeight*dot(edge, edge))
+		s.quadrics[key[0]].add(q)
+		s.quadrics[key[1]].add(q)
+	}
+
+	for key := range edges {
+		s.heap = append(s.heap, s.queue(key[0], key[1]))
+	}
+	s.heap.init()
+	return s
+}
+
+func edgeKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// Costs collapsing edge a b, placing the vertex at the point of least error when there is one near the edge and
+// otherwise at whichever end or the middle costs least
+func (s *simplifier) cost(a, b int) collapse {
+	q := s.quadrics[a]
+	q.add(s.quadrics[b])
+	pa, pb := s.positions[a], s.positions[b]
+	middle := scale(add(pa, pb), 0.5)
+	candidates := [][3]float64{pa, pb, middle}
+	if p, ok := q.optimum(); ok && length(sub(p, middle)) <= length(sub(pb, pa)) {
+		candidates = append(candidates, p)
+	}
+	best := collapse{cost: math.Inf(1), a: a, b: b}
+	for _, p := range candidates {
+		if cost := q.error(p); cost < best.cost {
+			best.cost, best.position = cost, p
+		}
+	}
+	return best
+}
+
+func (s *simplifier) queue(a, b int) queued {
+	return queued{cost: s.cost(a, b).cost, a: int32(a), b: int32(b), versionA: s.version[a], versionB: s.version[b]}
+}
+
+// Collapses that would fold a neighbouring face over, or pinch the surface where a and b share neighbours not joined
+// by a face on the edge, are refused
+func (s *simplifier) canCollapse(c *collapse) bool {
+	shared := 0
+	neighbours := map[int]int{}
+	for _, v := range []int{c.a, c.b} {
+		for _, t := range s.vertexTriangles[v] {
+			if s.removed[t] {
+				continue
+			}
+			tri := s.triangles[t]
+			hasA, hasB := contains(tri, c.a), contains(tri, c.b)
+			if hasA && hasB {
+				if v == c.a {
+					shared++
+				}
+				continue
+			}
+			side := 1
+			if v == c.b {
+				side = 2
+			}
+			for _, w := range tri {
+				if w != v {
+					neighbours[w] |= side
+				}
+			}
+			before := mesh.Normal(s.positions[tri[0]], s.positions[tri[1]], s.positions[tri[2]])
+			var moved [3][3]float64
+			for k, w := range tri {
+				moved[k] = s.positions[w]
+				if w == v {
+					moved[k] = c.position
+				}
+			}
+			after := mesh.Normal(moved[0], moved[1], moved[2])
+			if after == ([3]float64{}) || dot(before, after) < minNormalDot {
+				return false
+			}
+		}
+	}
+	common := 0
+	for _, sides := range neighbours {
+		if sides == 3 {
+			common++
+		}
+	}
+	return common <= shared
+}
+
+func contains(t [3]int, v int) bool {
+	return t[0] == v || t[1] == v || t[2] == v
+}
+
+// Moves a to the collapse position and b into a, removing the faces on the edge
+func (s *simplifier) collapse(c *collapse) {
+	a, b := c.a, c.b
+	pa, pb := s.positions[a], s.positions[b]
+	// attributes follow the new position along the edge
+	f := 0.0
+	if edge := sub(pb, pa); dot(edge, edge) > 0 {
+		f = clamp(dot(sub(c.position, pa), edge)/dot(edge, edge), 0, 1)
+	}
+	for k := 0; k < 2 && s.uvs != nil; k++ {
+		s.uvs[a*2+k] += (s.uvs[b*2+k] - s.uvs[a*2+k]) * f
+	}
+	for k := 0; k < 3 && s.colors != nil; k++ {
+		s.colors[a*3+k] += (s.colors[b*3+k] - s.colors[a*3+k]) * f
+	}
+	s.positions[a] = c.position
+	s.quadrics[a].add(s.quadrics[b])
+
+	for _, t := range s.vertexTriangles[b] {
+		if s.removed[t] {
+			continue
+		}
+		if contains(s.triangles[t], a) {
+			s.removed[t] = true
+			s.live--
+			continue
+		}
+		for k := range s.triangles[t] {
+			if s.triangles[t][k] == b {
+				s.triangles[t][k] = a
+			}
+		}
+		s.vertexTriangles[a] = append(s.vertexTriangles[a], t)
+	}
+	s.vertexTriangles[b] = nil
+	s.version[b]++
+	s.version[a]++
+
+	live := s.vertexTriangles[a][:0]
+	neighbours := map[int]bool{}
+	for _, t := range s.vertexTriangles[a] {
+		if s.removed[t] {
+			continue
+		}
+		live = append(live, t)
+		for _, w := range s.triangles[t] {
+			if w != a {
+				neighbours[w] = true
+			}
+		}
+	}
+	s.vertexTriangles[a] = live
+	for w := range neighbours {
+		s.heap.push(s.queue(a, w))
+	}
+}
+
+// The remaining triangles as a mesh of the vertices they use, grouped by material
+func (s *simplifier) result(withNormals bool) *mesh.Mesh {
+	out := &mesh.Mesh{}
+	if s.uvs != nil {
+		out.Uvs = []float64{}
+	}
+	if s.colors != nil {
+		out.Colors = []float64{}
+	}
+	remap := map[int]int{}
+	byMaterial := map[int][]int{}
+	var materials []int
+	for t, tri := range s.triangles {
+		if s.removed[t] {
+			continue
+		}
+		if _, exists := byMaterial[s.materials[t]]; !exists {
+			materials = append(materials, s.materials[t])
+		}
+		byMaterial[s.materials[t]] = append(byMaterial[s.materials[t]], t)
+		for _, v := range tri {
+			if _, exists := remap[v]; exists {
+				continue
+			}
+			remap[v] = len(out.Positions) / 3
+			out.Positions = append(out.Positions, s.positions[v][:]...)
+			if s.uvs != nil {
+				out.Uvs = append(out.Uvs, s.uvs[v*2:v*2+2]...)
+			}
+			if s.colors != nil {
+				out.Colors = append(out.Colors, s.colors[v*3:v*3+3]...)
+			}
+		}
+	}
+	sort.Ints(materials)
+	for _, material := range materials {
+		start := len(out.Indices)
+		for _, t := range byMaterial[material] {
+			tri := s.triangles[t]
+			out.Indices = append(out.Indices, remap[tri[0]], remap[tri[1]], remap[tri[2]])
+		}
+		out.Groups = append(out.Groups, &mesh.Group{Start: start, Count: len(out.Indices) - start, MaterialIndex: material})
+	}
+	if len(out.Groups) == 1 && out.Groups[0].MaterialIndex == 0 {
+		out.Groups = nil
+	}
+	if withNormals {
+		out.ComputeNormals()
+	}
+	return out
+}
+
+func triangleArea(a, b, c [3]float64) float64 {
+	return length(cross(sub(b, a), sub(c, a))) / 2
+}
+
+func add(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func scale(a [3]float64, f float64) [3]float64 {
+	return [3]float64{a[0] * f, a[1] * f, a[2] * f}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func length(a [3]float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+func normalize(a [3]float64) [3]float64 {
+	l := length(a)
+	if l == 0 {
+		return a
+	}
+	return scale(a, 1/l)
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
@@ -0,0 +1,303 @@
+/*
+Three way merging of Editor.toJSON() documents keyed by uuid, changes made on only one side are applied
+automatically and properties changed differently on both sides are reported as conflicts
+*/
+package merge
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/diff"
+	"github.com/robsix/3ditor/src/server/scene"
+	"sort"
+)
+
+// Matches scene.MergeFunc
+func Documents(base, ours, theirs []byte) ([]byte, []*scene.Conflict, error) {
+	graphs := make([]*scene.Graph, 3)
+	for i, doc := range [][]byte{base, ours, theirs} {
+		graph, err := scene.ParseGraph(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		graphs[i] = graph
+	}
+	merged, conflicts := Graphs(graphs[0], graphs[1], graphs[2])
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+	data, err := json.Marshal(merged.Document())
+	return data, nil, err
+}
+
+func Graphs(base, ours, theirs *scene.Graph) (*scene.Graph, []*scene.Conflict) {
+	m := &merger{conflicts: []*scene.Conflict{}}
+	merged := &scene.Graph{
+		Metadata:   theirs.Metadata,
+		Geometries: m.mergeIndex(`geometry`, base.Geometries, ours.Geometries, theirs.Geometries),
+		Materials:  m.mergeIndex(`material`, base.Materials, ours.Materials, theirs.Materials),
+		Textures:   m.mergeIndex(`texture`, base.Textures, ours.Textures, theirs.Textures),
+		Images:     m.mergeIndex(`image`, base.Images, ours.Images, theirs.Images),
+	}
+	merged.Project = m.mergeItem(`project`, ``, base.Project, ours.Project, theirs.Project)
+	merged.Camera = m.mergeItem(`camera`, ``, base.Camera, ours.Camera, theirs.Camera)
+	merged.Scripts = m.mergeScripts(base.Scripts, ours.Scripts, theirs.Scripts)
+	merged.Extra = m.mergeItem(`document`, ``, base.Extra, ours.Extra, theirs.Extra)
+	merged.SceneExtra = m.mergeItem(`scene`, ``, base.SceneExtra, ours.SceneExtra, theirs.SceneExtra)
+	// their order first, items only ours has follow it
+	merged.Order = map[string][]string{}
+	for key, order := range theirs.Order {
+		merged.Order[key] = append(append([]string{}, order...), ours.Order[key]...)
+	}
+	m.mergeObjects(merged, base, ours, theirs)
+	return merged, m.conflicts
+}
+
+type merger struct {
+	conflicts []*scene.Conflict
+}
+
+func (m *merger) conflict(kind, uuid, property string, base, ours, theirs interface{}) {
+	m.conflicts = append(m.conflicts, &scene.Conflict{
+		Kind:     kind,
+		Uuid:     uuid,
+		Property: property,
+		Base:     base,
+		Ours:     ours,
+		Theirs:   theirs,
+	})
+}
+
+// Merges a single json value, nil meaning absent, reporting a conflict and keeping theirs when both sides differ
+func (m *merger) mergeValue(kind, uuid, property string, base, ours, theirs interface{}) interface{} {
+	switch {
+	case diff.Equal(ours, theirs), diff.Equal(ours, base):
+		return theirs
+	case diff.Equal(theirs, base):
+		return ours
+	}
+	m.conflict(kind, uuid, property, base, ours, theirs)
+	return theirs
+}
+
+// Merges an item keyed by uuid property by property, a nil map means the item does not exist on that side
+func (m *merger) mergeItem(kind, uuid string, base, ours, theirs map[string]interface{}) map[string]interface{} {
+	oursChanged := !diff.Equal(asValue(base), asValue(ours))
+	theirsChanged := !diff.Equal(asValue(base), asValue(theirs))
+	switch {
+	case !oursChanged:
+		return theirs
+	case !theirsChanged:
+		return ours
+	case ours == nil && theirs == nil:
+		return nil
+	case ours == nil || theirs == nil:
+		m.conflict(kind, uuid, `*`, asValue(base), asValue(ours), asValue(theirs))
+		return theirs
+	}
+
+	merged := map[string]interface{}{}
+	for _, key := range unionKeys(base, ours, theirs) {
+		var val interface{}
+		if kind == `object` && key == `matrix` {
+			val = m.mergeMatrix(uuid, base[key], ours[key], theirs[key])
+		} else {
+			val = m.mergeValue(kind, uuid, key, base[key], ours[key], theirs[key])
+		}
+		if val != nil {
+			merged[key] = val
+		}
+	}
+	return merged
+}
+
+// Merges an object's matrix as its position, rotation and scale so a move on one side and a rotation on the other
+// both apply. A matrix that does not decompose on every side is merged as a whole.
+func (m *merger) mergeMatrix(uuid string, base, ours, theirs interface{}) interface{} {
+	if diff.Equal(ours, theirs) || diff.Equal(ours, base) || diff.Equal(theirs, base) {
+		return m.mergeValue(`object`, uuid, `matrix`, base, ours, theirs)
+	}
+	baseTransform, baseOk := decompose(base)
+	oursTransform, oursOk := decompose(ours)
+	theirsTransform, theirsOk := decompose(theirs)
+	if !baseOk || !oursOk || !theirsOk {
+		return m.mergeValue(`object`, uuid, `matrix`, base, ours, theirs)
+	}
+
+	merged := &diff.Transform{}
+	for _, part := range []struct {
+		property                   string
+		merged, base, ours, theirs *[3]float64
+	}{
+		{`position`, &merged.Position, &baseTransform.Position, &oursTransform.Position, &theirsTransform.Position},
+		{`rotation`, &merged.Rotation, &baseTransform.Rotation, &oursTransform.Rotation, &theirsTransform.Rotation},
+		{`scale`, &merged.Scale, &baseTransform.Scale, &oursTransform.Scale, &theirsTransform.Scale},
+	} {
+		val := m.mergeValue(`object`, uuid, `matrix.`+part.property, vector(part.base), vector(part.ours), vector(part.theirs))
+		for i, v := range val.([]interface{}) {
+			part.merged[i] = v.(float64)
+		}
+	}
+	return merged.Matrix()
+}
+
+// Decomposes a matrix only when its parts compose back into it. A zero scale hides the rotation and a sheared matrix
+// has no rotation and scale to find, merging their parts would quietly drop what they hold.
+func decompose(matrix interface{}) (*diff.Transform, bool) {
+	t, ok := diff.Decompose(matrix)
+	return t, ok && diff.Equal(t.Matrix(), matrix)
+}
+
+func vector(v *[3]float64) interface{} {
+	return []interface{}{v[0], v[1], v[2]}
+}
+
+func (m *merger) mergeIndex(kind string, base, ours, theirs map[string]map[string]interface{}) map[string]map[string]interface{} {
+	merged := map[string]map[string]interface{}{}
+	for _, uuid := range unionIndexKeys(base, ours, theirs) {
+		if item := m.mergeItem(kind, uuid, base[uuid], ours[uuid], theirs[uuid]); item != nil {
+			merged[uuid] = item
+		}
+	}
+	return merged
+}
+
+// Scripts are merged by the same object uuid, name and occurrence key the diff uses, then regrouped per object
+// keeping theirs' order followed by any scripts only ours added
+func (m *merger) mergeScripts(base, ours, theirs map[string][]interface{}) map[string][]interface{} {
+	merged := m.mergeIndex(`script`, diff.ScriptKeys(base), diff.ScriptKeys(ours), diff.ScriptKeys(theirs))
+	scripts := map[string][]interface{}{}
+	added := map[string]bool{}
+	for _, side := range []map[string][]interface{}{theirs, ours} {
+		for objectUuid, list := range side {
+			occurrences := map[string]int{}
+			for _, item := range list {
+				name, _ := asMap(item)[`name`].(string)
+				key := diff.ScriptKey(objectUuid, name, occurrences[name])
+				occurrences[name]++
+				if script := merged[key]; script != nil && !added[key] {
+					added[key] = true
+					scripts[objectUuid] = append(scripts[objectUuid], script)
+				}
+			}
+		}
+	}
+	return scripts
+}
+
+// Objects are merged property by property with their parent treated as one more property, children are then
+// reattached in theirs' order followed by ours' order. Objects left without a reachable parent, because one side
+// deleted the parent or both sides re-parented into a cycle, are reported as conflicts.
+func (m *merger) mergeObjects(merged, base, ours, theirs *scene.Graph) {
+	merged.Root = theirs.Root
+	merged.Objects = map[string]*scene.Node{}
+	uuids := unionObjectKeys(base, ours, theirs)
+	for _, uuid := range uuids {
+		baseNode, oursNode, theirsNode := base.Objects[uuid], ours.Objects[uuid], theirs.Objects[uuid]
+		obj := m.mergeItem(`object`, uuid, nodeObject(baseNode), nodeObject(oursNode), nodeObject(theirsNode))
+		if obj == nil {
+			continue
+		}
+		// an object that only exists on one side keeps that side's parent, a conflict has already been reported
+		// if the other side deleted it
+		var parent string
+		switch {
+		case oursNode == nil:
+			parent = theirsNode.Parent
+		case theirsNode == nil:
+			parent = oursNode.Parent
+		default:
+			parent, _ = m.mergeValue(`object`, uuid, `parent`, nodeParent(baseNode), nodeParent(oursNode), nodeParent(theirsNode)).(string)
+		}
+		merged.Objects[uuid] = &scene.Node{Uuid: uuid, Parent: parent, Object: obj, Children: []string{}}
+	}
+
+	attached := map[string]bool{}
+	for _, graph := range []*scene.Graph{theirs, ours, base} {
+		graph.Walk(func(node *scene.Node) {
+			mergedNode := merged.Objects[node.Uuid]
+			if mergedNode == nil || attached[node.Uuid] || mergedNode.Parent == `` {
+				return
+			}
+			if parent := merged.Objects[mergedNode.Parent]; parent != nil {
+				parent.Children = append(parent.Children, node.Uuid)
+				attached[node.Uuid] = true
+			}
+		})
+	}
+
+	reachable := map[string]bool{}
+	merged.Walk(func(node *scene.Node) {
+		reachable[node.Uuid] = true
+	})
+	for _, uuid := range uuids {
+		if node := merged.Objects[uuid]; node != nil && !reachable[uuid] {
+			m.conflict(`object`, uuid, `parent`, nodeParent(base.Objects[uuid]), nodeParent(ours.Objects[uuid]), nodeParent(theirs.Objects[uuid]))
+		}
+	}
+}
+
+func nodeObject(node *scene.Node) map[string]interface{} {
+	if node == nil {
+		return nil
+	}
+	return node.Object
+}
+
+func nodeParent(node *scene.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	return node.Parent
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// Keeps absent items as an untyped nil so they compare equal to each other
+func asValue(item map[string]interface{}) interface{} {
+	if item == nil {
+		return nil
+	}
+	return item
+}
+
+func unionKeys(maps ...map[string]interface{}) []string {
+	set := map[string]bool{}
+	for _, m := range maps {
+		for key := range m {
+			set[key] = true
+		}
+	}
+	return sortedSet(set)
+}
+
+func unionIndexKeys(indexes ...map[string]map[string]interface{}) []string {
+	set := map[string]bool{}
+	for _, index := range indexes {
+		for key := range index {
+			set[key] = true
+		}
+	}
+	return sortedSet(set)
+}
+
+func unionObjectKeys(graphs ...*scene.Graph) []string {
+	set := map[string]bool{}
+	for _, graph := range graphs {
+		for key := range graph.Objects {
+			set[key] = true
+		}
+	}
+	return sortedSet(set)
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,190 @@
+package merge
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/diff"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+	cube := scenetest.Object(`A`, `cube`, `M`, scenetest.Identity)
+	base := scenetest.Document(`16777215`, cube)
+	tests := []struct {
+		name string
+		// the shared base unless set
+		base      string
+		ours      string
+		theirs    string
+		changes   []string
+		conflicts []string
+	}{
+		{
+			name:    `unchanged`,
+			ours:    base,
+			theirs:  base,
+			changes: []string{`no changes`},
+		},
+		{
+			name:    `same change on both sides`,
+			ours:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			theirs:  scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			changes: []string{`~ moved "cube" by +1 on x`},
+		},
+		{
+			name:    `move and rotate`,
+			ours:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			theirs:  scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.RotationZ(90))),
+			changes: []string{`~ moved "cube" by +1 on x`, `~ rotated "cube" by +90° on z`},
+		},
+		{
+			// flattening loses the rotation from the decomposed matrix, so it can not be merged part by part
+			name:      `flattened and moved`,
+			base:      scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.RotationZ(90))),
+			ours:      scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, `[0,0,0,0,-1,0,0,0,0,0,1,0,0,0,0,1]`)),
+			theirs:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, `[0,1,0,0,-1,0,0,0,0,0,1,0,1,0,0,1]`)),
+			conflicts: []string{`object A matrix`},
+		},
+		{
+			name:      `sheared and moved`,
+			ours:      scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, `[1,0,0,0,1,1,0,0,0,0,1,0,0,0,0,1]`)),
+			theirs:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			conflicts: []string{`object A matrix`},
+		},
+		{
+			name:      `different moves`,
+			ours:      scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			theirs:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(2, 0, 0))),
+			conflicts: []string{`object A matrix.position`},
+		},
+		{
+			name:    `material and object edits`,
+			ours:    scenetest.Document(`255`, cube),
+			theirs:  scenetest.Document(`16777215`, cube, scenetest.Object(`B`, `ball`, `M`, scenetest.Identity)),
+			changes: []string{`+ added object "ball"`, `~ changed material MeshStandardMaterial M color from #ffffff to #0000ff`},
+		},
+		{
+			name:      `different material edits`,
+			ours:      scenetest.Document(`255`, cube),
+			theirs:    scenetest.Document(`65280`, cube),
+			conflicts: []string{`material M color`},
+		},
+		{
+			name:      `removed and moved`,
+			ours:      scenetest.Document(`16777215`),
+			theirs:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Translation(1, 0, 0))),
+			conflicts: []string{`object A *`},
+		},
+		{
+			name:      `child added under a removed parent`,
+			ours:      scenetest.Document(`16777215`),
+			theirs:    scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Identity, scenetest.Object(`B`, `ball`, `M`, scenetest.Identity))),
+			conflicts: []string{`object B parent`},
+		},
+		{
+			// both sides added the ball, under different parents
+			name:      `different parents`,
+			ours:      scenetest.Document(`16777215`, scenetest.Object(`A`, `cube`, `M`, scenetest.Identity, scenetest.Object(`B`, `ball`, `M`, scenetest.Identity)), scenetest.Object(`C`, `cone`, `M`, scenetest.Identity)),
+			theirs:    scenetest.Document(`16777215`, cube, scenetest.Object(`C`, `cone`, `M`, scenetest.Identity, scenetest.Object(`B`, `ball`, `M`, scenetest.Identity))),
+			conflicts: []string{`object B parent`},
+		},
+	}
+	for _, test := range tests {
+		if test.base == `` {
+			test.base = base
+		}
+		merged, conflicts, err := Documents([]byte(test.base), []byte(test.ours), []byte(test.theirs))
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		got := []string{}
+		for _, c := range conflicts {
+			got = append(got, c.Kind+` `+c.Uuid+` `+c.Property)
+		}
+		if strings.Join(got, `; `) != strings.Join(test.conflicts, `; `) {
+			t.Errorf(`%s: got conflicts %q, want %q`, test.name, got, test.conflicts)
+		}
+		if len(conflicts) > 0 {
+			continue
+		}
+		report, err := diff.Documents([]byte(test.base), merged)
+		if err != nil {
+			t.Errorf(`%s: merged document does not parse: %v`, test.name, err)
+			continue
+		}
+		if got, want := report.String(), strings.Join(test.changes, "\n")+"\n"; got != want {
+			t.Errorf("%s: merged changes\n%swant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestDocumentsInvalid(t *testing.T) {
+	valid := scenetest.Document(`0`)
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+	}{
+		{`base`, `{`, valid, valid},
+		{`ours`, valid, `{`, valid},
+		{`theirs`, valid, valid, `{"scene":{}}`},
+	}
+	for _, test := range tests {
+		if _, _, err := Documents([]byte(test.base), []byte(test.ours), []byte(test.theirs)); err == nil {
+			t.Errorf(`invalid %s: expected an error`, test.name)
+		}
+	}
+}
+
+func TestDocumentsUnknownKeys(t *testing.T) {
+	document := func(history, animations, materials string) string {
+		return `{"project":{},"camera":{},"scripts":{},"history":` + history + `,"scene":{"animations":` + animations +
+			`,"materials":` + materials + `,"object":{"uuid":"S","type":"Scene"}}}`
+	}
+	base := document(`[]`, `[]`, `[{"uuid":"M2"},{"uuid":"M1"}]`)
+	tests := []struct {
+		name      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts []string
+	}{
+		{
+			name:   `changed on each side`,
+			ours:   document(`["moved"]`, `[]`, `[{"uuid":"M2"},{"uuid":"M1"},{"uuid":"M0"}]`),
+			theirs: document(`[]`, `[{"name":"spin"}]`, `[{"uuid":"M3"},{"uuid":"M2"},{"uuid":"M1"}]`),
+			want:   document(`["moved"]`, `[{"name":"spin"}]`, `[{"uuid":"M3"},{"uuid":"M2"},{"uuid":"M1"},{"uuid":"M0"}]`),
+		},
+		{
+			name:      `changed differently`,
+			ours:      document(`["moved"]`, `[]`, `[{"uuid":"M2"},{"uuid":"M1"}]`),
+			theirs:    document(`["rotated"]`, `[]`, `[{"uuid":"M2"},{"uuid":"M1"}]`),
+			conflicts: []string{`document  history`},
+		},
+	}
+	for _, test := range tests {
+		merged, conflicts, err := Documents([]byte(base), []byte(test.ours), []byte(test.theirs))
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		got := []string{}
+		for _, c := range conflicts {
+			got = append(got, c.Kind+` `+c.Uuid+` `+c.Property)
+		}
+		if strings.Join(got, `; `) != strings.Join(test.conflicts, `; `) {
+			t.Errorf(`%s: got conflicts %q, want %q`, test.name, got, test.conflicts)
+		}
+		if len(conflicts) > 0 {
+			continue
+		}
+		var gotDoc, wantDoc interface{}
+		json.Unmarshal(merged, &gotDoc)
+		json.Unmarshal([]byte(test.want), &wantDoc)
+		if !reflect.DeepEqual(gotDoc, wantDoc) {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, merged, test.want)
+		}
+	}
+}
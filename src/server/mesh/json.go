@@ -0,0 +1,422 @@
+package mesh
+
+import (
+	"math"
+	"strconv"
+)
+
+// Reads a geometry as written by Geometry.toJSON or BufferGeometry.toJSON
+func FromJson(geometry map[string]interface{}) (*Mesh, error) {
+	return fromJson(geometry, nil)
+}
+
+// Reads a geometry as FromJson does but leaves out the triangles using a vertex index that is out of range rather
+// than failing, returning how many were left out
+func FromJsonDropping(geometry map[string]interface{}) (*Mesh, int, error) {
+	dropped := 0
+	m, err := fromJson(geometry, &dropped)
+	return m, dropped, err
+}
+
+// Out of range vertex indices are an error when dropped is nil, otherwise their triangles are counted in it
+func fromJson(geometry map[string]interface{}, dropped *int) (*Mesh, error) {
+	uuid, _ := geometry[`uuid`].(string)
+	typ, _ := geometry[`type`].(string)
+	if data, ok := geometry[`data`].(map[string]interface{}); ok {
+		var m *Mesh
+		var err error
+		if typ == `BufferGeometry` {
+			m, err = fromBufferGeometry(data, dropped)
+		} else {
+			m, err = fromGeometry(data, dropped)
+		}
+		if err != nil {
+			return nil, &invalidGeometryError{uuid: uuid, reason: err.Error()}
+		}
+		return m, nil
+	}
+	generate := primitives[typ]
+	if generate == nil {
+		return nil, &unsupportedGeometryError{typ: typ}
+	}
+	m, err := generate(&params{geometry: geometry})
+	if err != nil {
+		return nil, &invalidGeometryError{uuid: uuid, reason: err.Error()}
+	}
+	return m, nil
+}
+
+// The mesh as BufferGeometry.toJSON writes it, indexed and with its groups
+func (m *Mesh) ToJson(uuid, name string) map[string]interface{} {
+	attributes := map[string]interface{}{`position`: jsonAttribute(m.Positions, 3)}
+	if m.Normals != nil {
+		attributes[`normal`] = jsonAttribute(m.Normals, 3)
+	}
+	if m.Uvs != nil {
+		attributes[`uv`] = jsonAttribute(m.Uvs, 2)
+	}
+	if m.Colors != nil {
+		attributes[`color`] = jsonAttribute(m.Colors, 3)
+	}
+	indexType := `Uint16Array`
+	if m.VertexCount() > 0xFFFF {
+		indexType = `Uint32Array`
+	}
+	indices := make([]interface{}, len(m.Indices))
+	for i, v := range m.Indices {
+		indices[i] = float64(v)
+	}
+	data := map[string]interface{}{
+		`attributes`: attributes,
+		`index`:      map[string]interface{}{`itemSize`: 1.0, `type`: indexType, `array`: indices},
+	}
+	if len(m.Groups) > 0 {
+		groups := make([]interface{}, len(m.Groups))
+		for i, g := range m.Groups {
+			groups[i] = map[string]interface{}{`start`: float64(g.Start), `count`: float64(g.Count), `materialIndex`: float64(g.MaterialIndex)}
+		}
+		data[`groups`] = groups
+	}
+	geometry := map[string]interface{}{`uuid`: uuid, `type`: `BufferGeometry`, `data`: data}
+	if name != `` {
+		geometry[`name`] = name
+	}
+	return geometry
+}
+
+func jsonAttribute(values []float64, itemSize int) map[string]interface{} {
+	array := make([]interface{}, len(values))
+	for i, v := range values {
+		array[i] = v
+	}
+	return map[string]interface{}{`itemSize`: float64(itemSize), `type`: `Float32Array`, `array`: array}
+}
+
+type formatError struct {
+	reason string
+}
+
+func (e *formatError) Error() string {
+	return e.reason
+}
+
+func numbers(v interface{}) ([]float64, bool) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	out := make([]float64, len(list))
+	for i, item := range list {
+		if out[i], ok = item.(float64); !ok {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+func fromBufferGeometry(data map[string]interface{}, dropped *int) (*Mesh, error) {
+	attributes, _ := data[`attributes`].(map[string]interface{})
+	position, _ := attributes[`position`].(map[string]interface{})
+	positions, ok := numbers(position[`array`])
+	if !ok {
+		return nil, &formatError{`no position attribute`}
+	}
+	if itemSize, _ := position[`itemSize`].(float64); itemSize != 0 && itemSize != 3 {
+		return nil, &formatError{`position item size must be 3`}
+	}
+	m := &Mesh{Positions: positions[:len(positions)/3*3]}
+	count := m.VertexCount()
+	m.Normals = attribute(attributes, `normal`, 3, count)
+	m.Uvs = attribute(attributes, `uv`, 2, count)
+	m.Colors = attribute(attributes, `color`, 3, count)
+
+	var outOfRange []bool
+	if index, ok := data[`index`].(map[string]interface{}); ok {
+		values, ok := numbers(index[`array`])
+		if !ok {
+			return nil, &formatError{`invalid index`}
+		}
+		m.Indices = make([]int, len(values)/3*3)
+		for i := range m.Indices {
+			m.Indices[i] = int(values[i])
+			if m.Indices[i] < 0 || m.Indices[i] >= count {
+				if dropped == nil {
+					return nil, &formatError{`index ` + strconv.Itoa(m.Indices[i]) + ` out of range`}
+				}
+				if outOfRange == nil {
+					outOfRange = make([]bool, len(m.Indices)/3)
+				}
+				outOfRange[i/3] = true
+			}
+		}
+	} else {
+		m.Indices = make([]int, count/3*3)
+		for i := range m.Indices {
+			m.Indices[i] = i
+		}
+	}
+
+	groups, _ := data[`groups`].([]interface{})
+	for _, item := range groups {
+		group, _ := item.(map[string]interface{})
+		start, _ := group[`start`].(float64)
+		count, _ := group[`count`].(float64)
+		materialIndex, _ := group[`materialIndex`].(float64)
+		g := &Group{Start: int(start), Count: int(count), MaterialIndex: int(materialIndex)}
+		if g.Start < 0 || g.Count < 0 || g.Start+g.Count > len(m.Indices) {
+			return nil, &formatError{`group out of range`}
+		}
+		m.Groups = append(m.Groups, g)
+	}
+	if outOfRange != nil {
+		m.FilterTriangles(func(i int) bool { return !outOfRange[i] })
+		*dropped += len(outOfRange) - m.TriangleCount()
+	}
+	return m, nil
+}
+
+// The values of an optional attribute, nil when it is missing or does not have itemSize values for every vertex
+func attribute(attributes map[string]interface{}, name string, itemSize, count int) []float64 {
+	a, _ := attributes[name].(map[string]interface{})
+	values, ok := numbers(a[`array`])
+	if !ok || len(values) != count*itemSize {
+		return nil
+	}
+	if n, _ := a[`itemSize`].(float64); int(n) != itemSize {
+		return nil
+	}
+	return values
+}
+
+// Face type bits of the json model format read by THREE.JSONLoader
+const (
+	faceQuad = 1 << iota
+	faceMaterial
+	faceUv
+	faceVertexUv
+	faceNormal
+	faceVertexNormal
+	faceColor
+	faceVertexColor
+)
+
+// Reads the json model format, faces with vertex normals or uvs are split into their own vertices as
+// THREE.DirectGeometry does when the editor renders them
+func fromGeometry(data map[string]interface{}, dropped *int) (*Mesh, error) {
+	positions, ok := numbers(data[`vertices`])
+	if !ok {
+		return nil, &formatError{`no vertices`}
+	}
+	faces, ok := numbers(data[`faces`])
+	if !ok {
+		return nil, &formatError{`no faces`}
+	}
+	normals, _ := numbers(data[`normals`])
+	var layers [][]float64
+	if uvs, ok := data[`uvs`].([]interface{}); ok {
+		for _, layer := range uvs {
+			if list, ok := numbers(layer); ok && len(list) > 0 {
+				layers = append(layers, list)
+			}
+		}
+	}
+
+	source := &Mesh{Positions: positions[:len(positions)/3*3]}
+	count := source.VertexCount()
+	type corner struct {
+		normal int
+		uv     int
+	}
+	var corners [][]corner
+	split := false
+	for offset := 0; offset < len(faces); {
+		typ := int(faces[offset])
+		offset++
+		n := 3
+		if typ&faceQuad != 0 {
+			n = 4
+		}
+		read := func() (int, error) {
+			if offset >= len(faces) {
+				return 0, &formatError{`truncated faces`}
+			}
+			offset++
+			return int(faces[offset-1]), nil
+		}
+		vertices := make([]int, n)
+		inRange := true
+		for i := range vertices {
+			v, err := read()
+			if err != nil {
+				return nil, err
+			}
+			if v < 0 || v >= count {
+				if dropped == nil {
+					return nil, &formatError{`face vertex ` + strconv.Itoa(v) + ` out of range`}
+				}
+				inRange = false
+			}
+			vertices[i] = v
+		}
+		face := make([]corner, n)
+		for i := range face {
+			face[i] = corner{normal: -1, uv: -1}
+		}
+		materialIndex := 0
+		var err error
+		if typ&faceMaterial != 0 {
+			if materialIndex, err = read(); err != nil {
+				return nil, err
+			}
+		}
+		if typ&faceUv != 0 {
+			offset += len(layers)
+		}
+		if typ&faceVertexUv != 0 {
+			for layer := range layers {
+				for i := range face {
+					uv, err := read()
+					if err != nil {
+						return nil, err
+					}
+					if layer == 0 && uv >= 0 && uv*2+1 < len(layers[0]) {
+						face[i].uv = uv
+						split = true
+					}
+				}
+			}
+		}
+		if typ&faceNormal != 0 {
+			normal, err := read()
+			if err != nil {
+				return nil, err
+			}
+			if normal >= 0 && normal*3+2 < len(normals) {
+				for i := range face {
+					face[i].normal = normal
+				}
+				split = true
+			}
+		}
+		if typ&faceVertexNormal != 0 {
+			for i := range face {
+				normal, err := read()
+				if err != nil {
+					return nil, err
+				}
+				if normal >= 0 && normal*3+2 < len(normals) {
+					face[i].normal = normal
+					split = true
+				}
+			}
+		}
+		if typ&faceColor != 0 {
+			offset++
+		}
+		if typ&faceVertexColor != 0 {
+			offset += n
+		}
+		if offset > len(faces) {
+			return nil, &formatError{`truncated faces`}
+		}
+		if !inRange {
+			*dropped += n - 2
+			continue
+		}
+
+		source.setMaterial(materialIndex)
+		if n == 4 {
+			source.addTriangle(vertices[0], vertices[1], vertices[3])
+			source.addTriangle(vertices[1], vertices[2], vertices[3])
+			corners = append(corners, []corner{face[0], face[1], face[3]}, []corner{face[1], face[2], face[3]})
+		} else {
+			source.addTriangle(vertices[0], vertices[1], vertices[2])
+			corners = append(corners, face)
+		}
+	}
+	source.closeGroups()
+	if !split {
+		return source, nil
+	}
+
+	m := &Mesh{Groups: source.Groups}
+	for t, face := range corners {
+		a, b, c := source.Triangle(t)
+		flat := source.FaceNormal(t)
+		for i, v := range []int{a, b, c} {
+			normal := flat
+			if face[i].normal >= 0 {
+				copy(normal[:], normals[face[i].normal*3:])
+			}
+			u, w := 0.0, 0.0
+			if face[i].uv >= 0 {
+				u, w = layers[0][face[i].uv*2], layers[0][face[i].uv*2+1]
+			}
+			m.addVertex(source.Vertex(v), normal, u, w)
+		}
+		m.addTriangle(t*3, t*3+1, t*3+2)
+	}
+	return m, nil
+}
+
+// Constructor parameters of a parametric geometry, missing values fall back to the constructor defaults
+type params struct {
+	geometry map[string]interface{}
+	// the first segment count found over maxSegments
+	err error
+}
+
+// The value of key or def when it is missing or zero, matching the `value || def` idiom of the constructors
+func (p *params) or(key string, def float64) float64 {
+	if v, _ := p.geometry[key].(float64); v != 0 && !math.IsNaN(v) {
+		return v
+	}
+	return def
+}
+
+// The value of key or def only when it is missing, matching `value !== undefined ? value : def`
+func (p *params) get(key string, def float64) float64 {
+	if v, ok := p.geometry[key].(float64); ok {
+		return v
+	}
+	return def
+}
+
+// A segment count, `Math.max( min, Math.floor( value ) || def )`
+func (p *params) segments(key string, def, min int) int {
+	n := p.limit(key, math.Floor(p.get(key, 0)))
+	if n == 0 {
+		n = def
+	}
+	if n < min {
+		n = min
+	}
+	return n
+}
+
+// The segment count n of key, recording the error check returns and giving 0 when it is over maxSegments
+func (p *params) limit(key string, n float64) int {
+	if n > maxSegments {
+		if p.err == nil {
+			p.err = &formatError{key + ` of ` + strconv.FormatFloat(n, 'g', -1, 64) + ` is more than the limit of ` + strconv.Itoa(maxSegments)}
+		}
+		return 0
+	}
+	return int(math.Max(n, math.MinInt32))
+}
+
+// Fails when a segment count was over maxSegments or the primitive would have more than maxVertices vertices
+func (p *params) check(vertices int) error {
+	if p.err != nil {
+		return p.err
+	}
+	if vertices > maxVertices {
+		return &formatError{strconv.Itoa(vertices) + ` vertices is more than the limit of ` + strconv.Itoa(maxVertices)}
+	}
+	return nil
+}
+
+func (p *params) flag(key string) bool {
+	v, _ := p.geometry[key].(bool)
+	return v
+}
@@ -0,0 +1,113 @@
+package mesh
+
+// Column major 4x4 matrices stored as 16 element slices, the layout of THREE.Matrix4.elements
+
+func Identity() []float64 {
+	return []float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+}
+
+// Reads a matrix array decoded from json
+func Matrix(v interface{}) ([]float64, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) != 16 {
+		return nil, false
+	}
+	m := make([]float64, 16)
+	for i, item := range list {
+		if m[i], ok = item.(float64); !ok {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// a * b
+func Multiply(a, b []float64) []float64 {
+	m := make([]float64, 16)
+	for col := 0; col < 4; col++ {
+		for row := 0; row < 4; row++ {
+			sum := 0.0
+			for k := 0; k < 4; k++ {
+				sum += a[k*4+row] * b[col*4+k]
+			}
+			m[col*4+row] = sum
+		}
+	}
+	return m
+}
+
+// Transforms a point, applying the perspective divide as THREE.Vector3.applyProjection does
+func Apply(m []float64, x, y, z float64) (float64, float64, float64) {
+	w := m[3]*x + m[7]*y + m[11]*z + m[15]
+	if w == 0 {
+		w = 1
+	}
+	return (m[0]*x + m[4]*y + m[8]*z + m[12]) / w,
+		(m[1]*x + m[5]*y + m[9]*z + m[13]) / w,
+		(m[2]*x + m[6]*y + m[10]*z + m[14]) / w
+}
+
+// Determinant of the upper 3x3, negative when the matrix mirrors
+func Determinant(m []float64) float64 {
+	return m[0]*(m[5]*m[10]-m[9]*m[6]) - m[4]*(m[1]*m[10]-m[9]*m[2]) + m[8]*(m[1]*m[6]-m[5]*m[2])
+}
+
+// The inverse transpose of the upper 3x3 in row major order, for transforming normals
+func NormalMatrix(m []float64) [9]float64 {
+	a, b, c := m[0], m[4], m[8]
+	d, e, f := m[1], m[5], m[9]
+	g, h, i := m[2], m[6], m[10]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	if det == 0 {
+		return [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	}
+	// the transpose of the inverse is the cofactor matrix divided by the determinant
+	return [9]float64{
+		(e*i - f*h) / det, -(d*i - f*g) / det, (d*h - e*g) / det,
+		-(b*i - c*h) / det, (a*i - c*g) / det, -(a*h - b*g) / det,
+		(b*f - c*e) / det, -(a*f - c*d) / det, (a*e - b*d) / det,
+	}
+}
+
+func ApplyNormal(n [9]float64, x, y, z float64) [3]float64 {
+	return [3]float64{n[0]*x + n[1]*y + n[2]*z, n[3]*x + n[4]*y + n[5]*z, n[6]*x + n[7]*y + n[8]*z}
+}
+
+// The inverse as THREE.Matrix4.getInverse computes it, ok is false when the matrix is singular
+func Invert(m []float64) ([]float64, bool) {
+	n11, n21, n31, n41 := m[0], m[1], m[2], m[3]
+	n12, n22, n32, n42 := m[4], m[5], m[6], m[7]
+	n13, n23, n33, n43 := m[8], m[9], m[10], m[11]
+	n14, n24, n34, n44 := m[12], m[13], m[14], m[15]
+
+	t11 := n23*n34*n42 - n24*n33*n42 + n24*n32*n43 - n22*n34*n43 - n23*n32*n44 + n22*n33*n44
+	t12 := n14*n33*n42 - n13*n34*n42 - n14*n32*n43 + n12*n34*n43 + n13*n32*n44 - n12*n33*n44
+	t13 := n13*n24*n42 - n14*n23*n42 + n14*n22*n43 - n12*n24*n43 - n13*n22*n44 + n12*n23*n44
+	t14 := n14*n23*n32 - n13*n24*n32 - n14*n22*n33 + n12*n24*n33 + n13*n22*n34 - n12*n23*n34
+	det := n11*t11 + n21*t12 + n31*t13 + n41*t14
+	if det == 0 {
+		return nil, false
+	}
+	s := 1 / det
+	return []float64{
+		t11 * s,
+		(n24*n33*n41 - n23*n34*n41 - n24*n31*n43 + n21*n34*n43 + n23*n31*n44 - n21*n33*n44) * s,
+		(n22*n34*n41 - n24*n32*n41 + n24*n31*n42 - n21*n34*n42 - n22*n31*n44 + n21*n32*n44) * s,
+		(n23*n32*n41 - n22*n33*n41 - n23*n31*n42 + n21*n33*n42 + n22*n31*n43 - n21*n32*n43) * s,
+
+		t12 * s,
+		(n13*n34*n41 - n14*n33*n41 + n14*n31*n43 - n11*n34*n43 - n13*n31*n44 + n11*n33*n44) * s,
+		(n14*n32*n41 - n12*n34*n41 - n14*n31*n42 + n11*n34*n42 + n12*n31*n44 - n11*n32*n44) * s,
+		(n12*n33*n41 - n13*n32*n41 + n13*n31*n42 - n11*n33*n42 - n12*n31*n43 + n11*n32*n43) * s,
+
+		t13 * s,
+		(n14*n23*n41 - n13*n24*n41 - n14*n21*n43 + n11*n24*n43 + n13*n21*n44 - n11*n23*n44) * s,
+		(n12*n24*n41 - n14*n22*n41 + n14*n21*n42 - n11*n24*n42 - n12*n21*n44 + n11*n22*n44) * s,
+		(n13*n22*n41 - n12*n23*n41 - n13*n21*n42 + n11*n23*n42 + n12*n21*n43 - n11*n22*n43) * s,
+
+		t14 * s,
+		(n13*n24*n31 - n14*n23*n31 + n14*n21*n33 - n11*n24*n33 - n13*n21*n34 + n11*n23*n34) * s,
+		(n14*n22*n31 - n12*n24*n31 - n14*n21*n32 + n11*n24*n32 + n12*n21*n34 - n11*n22*n34) * s,
+		(n12*n23*n31 - n13*n22*n31 + n13*n21*n32 - n11*n23*n32 - n12*n21*n33 + n11*n22*n33) * s,
+	}, true
+}
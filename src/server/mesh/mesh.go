@@ -0,0 +1,325 @@
+/*
+Triangle meshes read from the geometries of a scene document, including the parametric geometries the editor stores
+as constructor parameters, so the server can export, render and measure what the editor shows
+*/
+package mesh
+
+import (
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+)
+
+// A run of Indices drawn with one material of a multi material
+type Group struct {
+	Start         int
+	Count         int
+	MaterialIndex int
+}
+
+// Positions holds x, y, z per vertex and Indices three vertices per triangle. Normals, Uvs and Colors are nil
+// when the geometry does not have them, otherwise they hold 3, 2 and 3 values per vertex.
+type Mesh struct {
+	Positions []float64
+	Normals   []float64
+	Uvs       []float64
+	Colors    []float64
+	Indices   []int
+	Groups    []*Group
+}
+
+type unsupportedGeometryError struct {
+	typ string
+}
+
+func (e *unsupportedGeometryError) Error() string {
+	return `unsupported geometry type ` + e.typ
+}
+
+type invalidGeometryError struct {
+	uuid   string
+	reason string
+}
+
+func (e *invalidGeometryError) Error() string {
+	return `invalid geometry ` + e.uuid + `: ` + e.reason
+}
+
+func IsUnsupported(err error) bool {
+	_, ok := err.(*unsupportedGeometryError)
+	return ok
+}
+
+func (m *Mesh) VertexCount() int {
+	return len(m.Positions) / 3
+}
+
+func (m *Mesh) TriangleCount() int {
+	return len(m.Indices) / 3
+}
+
+func (m *Mesh) Vertex(i int) [3]float64 {
+	return [3]float64{m.Positions[i*3], m.Positions[i*3+1], m.Positions[i*3+2]}
+}
+
+// The vertex indices of triangle i
+func (m *Mesh) Triangle(i int) (int, int, int) {
+	return m.Indices[i*3], m.Indices[i*3+1], m.Indices[i*3+2]
+}
+
+func (m *Mesh) addPosition(x, y, z float64) int {
+	m.Positions = append(m.Positions, x, y, z)
+	return len(m.Positions)/3 - 1
+}
+
+func (m *Mesh) addVertex(position, normal [3]float64, u, v float64) int {
+	m.Normals = append(m.Normals, normal[0], normal[1], normal[2])
+	m.Uvs = append(m.Uvs, u, v)
+	return m.addPosition(position[0], position[1], position[2])
+}
+
+func (m *Mesh) addTriangle(a, b, c int) {
+	m.Indices = append(m.Indices, a, b, c)
+}
+
+// Starts a group for the triangles added after it, merging it into the previous group when the material is the same
+func (m *Mesh) setMaterial(materialIndex int) {
+	if n := len(m.Groups); n > 0 {
+		last := m.Groups[n-1]
+		if last.MaterialIndex == materialIndex {
+			return
+		}
+		last.Count = len(m.Indices) - last.Start
+		if last.Count == 0 {
+			m.Groups = m.Groups[:n-1]
+		}
+	}
+	m.Groups = append(m.Groups, &Group{Start: len(m.Indices), MaterialIndex: materialIndex})
+}
+
+func (m *Mesh) closeGroups() {
+	if n := len(m.Groups); n > 0 {
+		m.Groups[n-1].Count = len(m.Indices) - m.Groups[n-1].Start
+		if n == 1 && m.Groups[0].MaterialIndex == 0 {
+			m.Groups = nil
+		}
+	}
+}
+
+// The material index used by triangle i, 0 when the mesh has no groups
+func (m *Mesh) MaterialIndex(i int) int {
+	for _, group := range m.Groups {
+		if i*3 >= group.Start && i*3 < group.Start+group.Count {
+			return group.MaterialIndex
+		}
+	}
+	return 0
+}
+
+// Removes the triangles keep returns false for, keep is called with the index of each triangle before any are
+// removed. Groups shrink to the triangles they keep and are dropped when left empty.
+func (m *Mesh) FilterTriangles(keep func(i int) bool) {
+	indices := make([]int, 0, len(m.Indices))
+	for _, group := range m.Groups {
+		start := len(indices)
+		for i := group.Start; i+2 < group.Start+group.Count; i += 3 {
+			if keep(i / 3) {
+				indices = append(indices, m.Indices[i], m.Indices[i+1], m.Indices[i+2])
+			}
+		}
+		group.Start, group.Count = start, len(indices)-start
+	}
+	if len(m.Groups) == 0 {
+		for i := 0; i < len(m.Indices); i += 3 {
+			if keep(i / 3) {
+				indices = append(indices, m.Indices[i], m.Indices[i+1], m.Indices[i+2])
+			}
+		}
+	}
+	m.Indices = indices
+	groups := m.Groups[:0]
+	for _, group := range m.Groups {
+		if group.Count > 0 {
+			groups = append(groups, group)
+		}
+	}
+	m.Groups = groups
+}
+
+// A copy of the mesh with every vertex transformed by a column major Matrix4 array, the winding of the triangles is
+// reversed when the matrix mirrors so they keep facing outwards
+func (m *Mesh) Transform(matrix []float64) *Mesh {
+	out := &Mesh{Positions: make([]float64, len(m.Positions)), Uvs: m.Uvs, Colors: m.Colors, Indices: m.Indices, Groups: m.Groups}
+	for i := 0; i < len(m.Positions); i += 3 {
+		x, y, z := Apply(matrix, m.Positions[i], m.Positions[i+1], m.Positions[i+2])
+		out.Positions[i], out.Positions[i+1], out.Positions[i+2] = x, y, z
+	}
+	if m.Normals != nil {
+		normalMatrix := NormalMatrix(matrix)
+		out.Normals = make([]float64, len(m.Normals))
+		for i := 0; i < len(m.Normals); i += 3 {
+			n := normalize(ApplyNormal(normalMatrix, m.Normals[i], m.Normals[i+1], m.Normals[i+2]))
+			copy(out.Normals[i:], n[:])
+		}
+	}
+	if Determinant(matrix) < 0 {
+		out.Indices = make([]int, len(m.Indices))
+		for i := 0; i < len(m.Indices); i += 3 {
+			out.Indices[i], out.Indices[i+1], out.Indices[i+2] = m.Indices[i], m.Indices[i+2], m.Indices[i+1]
+		}
+	}
+	return out
+}
+
+// The unit normal of triangle i, zero for degenerate triangles
+func (m *Mesh) FaceNormal(i int) [3]float64 {
+	a, b, c := m.Triangle(i)
+	return Normal(m.Vertex(a), m.Vertex(b), m.Vertex(c))
+}
+
+func Normal(a, b, c [3]float64) [3]float64 {
+	ux, uy, uz := b[0]-a[0], b[1]-a[1], b[2]-a[2]
+	vx, vy, vz := c[0]-a[0], c[1]-a[1], c[2]-a[2]
+	n := [3]float64{uy*vz - uz*vy, uz*vx - ux*vz, ux*vy - uy*vx}
+	length := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+	if length == 0 {
+		return [3]float64{}
+	}
+	return [3]float64{n[0] / length, n[1] / length, n[2] / length}
+}
+
+// Smooth vertex normals averaged from the faces around each vertex, weighted by their area as
+// THREE.Geometry.computeVertexNormals does
+func (m *Mesh) ComputeNormals() {
+	m.Normals = make([]float64, len(m.Positions))
+	for i := 0; i < m.TriangleCount(); i++ {
+		a, b, c := m.Triangle(i)
+		pa, pb, pc := m.Vertex(a), m.Vertex(b), m.Vertex(c)
+		ux, uy, uz := pb[0]-pa[0], pb[1]-pa[1], pb[2]-pa[2]
+		vx, vy, vz := pc[0]-pa[0], pc[1]-pa[1], pc[2]-pa[2]
+		n := [3]float64{uy*vz - uz*vy, uz*vx - ux*vz, ux*vy - uy*vx}
+		for _, v := range []int{a, b, c} {
+			m.Normals[v*3] += n[0]
+			m.Normals[v*3+1] += n[1]
+			m.Normals[v*3+2] += n[2]
+		}
+	}
+	for i := 0; i < len(m.Normals); i += 3 {
+		n := normalize([3]float64{m.Normals[i], m.Normals[i+1], m.Normals[i+2]})
+		copy(m.Normals[i:], n[:])
+	}
+}
+
+// Merges vertices whose positions agree to four decimal places and drops the triangles that collapse, as
+// THREE.Geometry.mergeVertices does. Only positions are merged so it must run before any other attribute is set.
+func (m *Mesh) mergeVertices() {
+	const precision = 1e4
+	type key struct{ x, y, z float64 }
+	unique := map[key]int{}
+	changes := make([]int, m.VertexCount())
+	positions := make([]float64, 0, len(m.Positions))
+	for i := range changes {
+		v := m.Vertex(i)
+		k := key{math.Floor(v[0]*precision + 0.5), math.Floor(v[1]*precision + 0.5), math.Floor(v[2]*precision + 0.5)}
+		if j, exists := unique[k]; exists {
+			changes[i] = j
+			continue
+		}
+		unique[k] = len(positions) / 3
+		changes[i] = len(positions) / 3
+		positions = append(positions, v[0], v[1], v[2])
+	}
+	m.Positions = positions
+
+	indices := m.Indices[:0]
+	for _, group := range m.Groups {
+		start := len(indices)
+		for i := group.Start; i < group.Start+group.Count; i += 3 {
+			a, b, c := changes[m.Indices[i]], changes[m.Indices[i+1]], changes[m.Indices[i+2]]
+			if a != b && b != c && a != c {
+				indices = append(indices, a, b, c)
+			}
+		}
+		group.Start, group.Count = start, len(indices)-start
+	}
+	if len(m.Groups) == 0 {
+		for i := 0; i < len(m.Indices); i += 3 {
+			a, b, c := changes[m.Indices[i]], changes[m.Indices[i+1]], changes[m.Indices[i+2]]
+			if a != b && b != c && a != c {
+				indices = append(indices, a, b, c)
+			}
+		}
+	}
+	m.Indices = indices
+}
+
+// A geometry used by an object in the scene, World is the object's column major world matrix. Mesh is nil and Err
+// says why when the geometry could not be read.
+type Instance struct {
+	Node     *scene.Node
+	Type     string
+	Geometry string
+	Material string
+	World    []float64
+	Mesh     *Mesh
+	Err      error
+}
+
+// The objects with geometries in the subtree of root in depth first order, geometries shared between objects are
+// only read once
+func Instances(g *scene.Graph, root string) []*Instance {
+	type result struct {
+		mesh *Mesh
+		err  error
+	}
+	read := map[string]*result{}
+	instances := []*Instance{}
+	var walk func(uuid string, parentWorld []float64)
+	walk = func(uuid string, parentWorld []float64) {
+		node := g.Objects[uuid]
+		if node == nil {
+			return
+		}
+		world := parentWorld
+		if local, ok := Matrix(node.Object[`matrix`]); ok {
+			world = Multiply(parentWorld, local)
+		}
+		if geometryUuid, _ := node.Object[`geometry`].(string); geometryUuid != `` {
+			r := read[geometryUuid]
+			if r == nil {
+				r = &result{}
+				if geometry := g.Geometries[geometryUuid]; geometry != nil {
+					r.mesh, r.err = FromJson(geometry)
+				} else {
+					r.err = &invalidGeometryError{uuid: geometryUuid, reason: `missing from the scene`}
+				}
+				read[geometryUuid] = r
+			}
+			typ, _ := node.Object[`type`].(string)
+			material, _ := node.Object[`material`].(string)
+			instances = append(instances, &Instance{
+				Node:     node,
+				Type:     typ,
+				Geometry: geometryUuid,
+				Material: material,
+				World:    world,
+				Mesh:     r.mesh,
+				Err:      r.err,
+			})
+		}
+		for _, child := range node.Children {
+			walk(child, world)
+		}
+	}
+	walk(root, Identity())
+	return instances
+}
+
+// Whether the instance is drawn as triangles rather than points or lines
+func (i *Instance) IsSurface() bool {
+	return IsSurface(i.Type)
+}
+
+// Whether objects of type typ are drawn as triangles rather than points or lines
+func IsSurface(typ string) bool {
+	return typ == `Mesh` || typ == `SkinnedMesh` || typ == `MorphAnimMesh`
+}
@@ -0,0 +1,451 @@
+package mesh
+
+import (
+	"math"
+	"strconv"
+)
+
+const (
+	// primitives asking for more segments along any dimension than this, or for more vertices in all, are refused
+	// rather than built so a scene can not make the server run out of memory
+	maxSegments = 4096
+	maxVertices = 1 << 21
+	// the deepest polyhedron subdivision, 4^detail triangles per face
+	maxDetail = 8
+)
+
+// Ports of the r73 geometry constructors the editor can add, with the normals and uvs of the matching buffer
+// geometries where the editor's textures would be mapped onto them
+var primitives = map[string]func(p *params) (*Mesh, error){
+	`BoxGeometry`:          box,
+	`CubeGeometry`:         box,
+	`PlaneGeometry`:        plane,
+	`PlaneBufferGeometry`:  plane,
+	`CircleGeometry`:       circle,
+	`CircleBufferGeometry`: circle,
+	`CylinderGeometry`:     cylinder,
+	`SphereGeometry`:       sphere,
+	`SphereBufferGeometry`: sphere,
+	`TorusGeometry`:        torus,
+	`TorusKnotGeometry`:    torusKnot,
+	`IcosahedronGeometry`:  icosahedron,
+	`OctahedronGeometry`:   octahedron,
+	`TetrahedronGeometry`:  tetrahedron,
+}
+
+func box(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	width, height, depth := p.get(`width`, 1), p.get(`height`, 1), p.get(`depth`, 1)
+	widthSegments := p.segments(`widthSegments`, 1, 1)
+	heightSegments := p.segments(`heightSegments`, 1, 1)
+	depthSegments := p.segments(`depthSegments`, 1, 1)
+	sides := (depthSegments+1)*(heightSegments+1) + (widthSegments+1)*(depthSegments+1) + (widthSegments+1)*(heightSegments+1)
+	if err := p.check(2 * sides); err != nil {
+		return nil, err
+	}
+
+	// u, v and w are the axes the plane spans and faces along
+	buildPlane := func(u, v, w int, udir, vdir, width, height, depth float64, gridX, gridY, materialIndex int) {
+		m.setMaterial(materialIndex)
+		offset := m.VertexCount()
+		var normal [3]float64
+		normal[w] = 1
+		if depth <= 0 {
+			normal[w] = -1
+		}
+		for iy := 0; iy <= gridY; iy++ {
+			for ix := 0; ix <= gridX; ix++ {
+				var vector [3]float64
+				vector[u] = (float64(ix)*width/float64(gridX) - width/2) * udir
+				vector[v] = (float64(iy)*height/float64(gridY) - height/2) * vdir
+				vector[w] = depth
+				m.addVertex(vector, normal, float64(ix)/float64(gridX), 1-float64(iy)/float64(gridY))
+			}
+		}
+		gridX1 := gridX + 1
+		for iy := 0; iy < gridY; iy++ {
+			for ix := 0; ix < gridX; ix++ {
+				a := offset + ix + gridX1*iy
+				b := offset + ix + gridX1*(iy+1)
+				c := offset + ix + 1 + gridX1*(iy+1)
+				d := offset + ix + 1 + gridX1*iy
+				m.addTriangle(a, b, d)
+				m.addTriangle(b, c, d)
+			}
+		}
+	}
+	const x, y, z = 0, 1, 2
+	buildPlane(z, y, x, -1, -1, depth, height, width/2, depthSegments, heightSegments, 0)
+	buildPlane(z, y, x, 1, -1, depth, height, -width/2, depthSegments, heightSegments, 1)
+	buildPlane(x, z, y, 1, 1, width, depth, height/2, widthSegments, depthSegments, 2)
+	buildPlane(x, z, y, 1, -1, width, depth, -height/2, widthSegments, depthSegments, 3)
+	buildPlane(x, y, z, 1, -1, width, height, depth/2, widthSegments, heightSegments, 4)
+	buildPlane(x, y, z, -1, -1, width, height, -depth/2, widthSegments, heightSegments, 5)
+	m.closeGroups()
+	return m, nil
+}
+
+func plane(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	width, height := p.get(`width`, 1), p.get(`height`, 1)
+	gridX := p.segments(`widthSegments`, 1, 1)
+	gridY := p.segments(`heightSegments`, 1, 1)
+	if err := p.check((gridX + 1) * (gridY + 1)); err != nil {
+		return nil, err
+	}
+	for iy := 0; iy <= gridY; iy++ {
+		y := float64(iy)*height/float64(gridY) - height/2
+		for ix := 0; ix <= gridX; ix++ {
+			m.addVertex([3]float64{float64(ix)*width/float64(gridX) - width/2, -y, 0}, [3]float64{0, 0, 1},
+				float64(ix)/float64(gridX), 1-float64(iy)/float64(gridY))
+		}
+	}
+	gridX1 := gridX + 1
+	for iy := 0; iy < gridY; iy++ {
+		for ix := 0; ix < gridX; ix++ {
+			a := ix + gridX1*iy
+			b := ix + gridX1*(iy+1)
+			c := ix + 1 + gridX1*(iy+1)
+			d := ix + 1 + gridX1*iy
+			m.addTriangle(a, b, d)
+			m.addTriangle(b, c, d)
+		}
+	}
+	return m, nil
+}
+
+func circle(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	radius := p.or(`radius`, 50)
+	segments := 8
+	if p.geometry[`segments`] != nil {
+		segments = p.limit(`segments`, math.Max(3, p.get(`segments`, 8)))
+	}
+	if err := p.check(segments + 2); err != nil {
+		return nil, err
+	}
+	thetaStart := p.get(`thetaStart`, 0)
+	thetaLength := p.get(`thetaLength`, math.Pi*2)
+	normal := [3]float64{0, 0, 1}
+	m.addVertex([3]float64{}, normal, 0.5, 0.5)
+	for s := 0; s <= segments; s++ {
+		segment := thetaStart + float64(s)/float64(segments)*thetaLength
+		x, y := math.Cos(segment), math.Sin(segment)
+		m.addVertex([3]float64{radius * x, radius * y, 0}, normal, (x+1)/2, (y+1)/2)
+	}
+	for i := 1; i <= segments; i++ {
+		m.addTriangle(i, i+1, 0)
+	}
+	return m, nil
+}
+
+func cylinder(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	radiusTop := p.get(`radiusTop`, 20)
+	radiusBottom := p.get(`radiusBottom`, 20)
+	height := p.get(`height`, 100)
+	radialSegments := p.segments(`radialSegments`, 8, 1)
+	heightSegments := p.segments(`heightSegments`, 1, 1)
+	if err := p.check((radialSegments+1)*(heightSegments+1) + 6*radialSegments); err != nil {
+		return nil, err
+	}
+	openEnded := p.flag(`openEnded`)
+	thetaStart := p.get(`thetaStart`, 0)
+	thetaLength := p.get(`thetaLength`, 2*math.Pi)
+	heightHalf := height / 2
+
+	tanTheta := (radiusBottom - radiusTop) / height
+
+	// the normal of each column is taken from the rim, or the row below it for cones
+	normalRow := 0
+	if radiusTop == 0 {
+		normalRow = 1
+	}
+	columnNormal := func(x int) [3]float64 {
+		v := float64(normalRow) / float64(heightSegments)
+		radius := v*(radiusBottom-radiusTop) + radiusTop
+		angle := float64(x)/float64(radialSegments)*thetaLength + thetaStart
+		return normalize([3]float64{radius * math.Sin(angle), radius * tanTheta, radius * math.Cos(angle)})
+	}
+	rim := func(x, y int) [3]float64 {
+		v := float64(y) / float64(heightSegments)
+		radius := v*(radiusBottom-radiusTop) + radiusTop
+		angle := float64(x)/float64(radialSegments)*thetaLength + thetaStart
+		return [3]float64{radius * math.Sin(angle), -v*height + heightHalf, radius * math.Cos(angle)}
+	}
+
+	rows := make([][]int, heightSegments+1)
+	for y := 0; y <= heightSegments; y++ {
+		for x := 0; x <= radialSegments; x++ {
+			u := float64(x) / float64(radialSegments)
+			rows[y] = append(rows[y], m.addVertex(rim(x, y), columnNormal(x), u, 1-float64(y)/float64(heightSegments)))
+		}
+	}
+	m.setMaterial(0)
+	for x := 0; x < radialSegments; x++ {
+		for y := 0; y < heightSegments; y++ {
+			v1, v2, v3, v4 := rows[y][x], rows[y+1][x], rows[y+1][x+1], rows[y][x+1]
+			m.addTriangle(v1, v2, v4)
+			m.addTriangle(v2, v3, v4)
+		}
+	}
+	addCap := func(y int, sign float64, materialIndex int) {
+		m.setMaterial(materialIndex)
+		normal := [3]float64{0, sign, 0}
+		uvV := 0.0
+		if sign < 0 {
+			uvV = 1
+		}
+		for x := 0; x < radialSegments; x++ {
+			u1, u2 := float64(x)/float64(radialSegments), float64(x+1)/float64(radialSegments)
+			a := m.addVertex(rim(x, y), normal, u1, 1-uvV)
+			b := m.addVertex(rim(x+1, y), normal, u2, 1-uvV)
+			if sign > 0 {
+				center := m.addVertex([3]float64{0, heightHalf, 0}, normal, u2, uvV)
+				m.addTriangle(a, b, center)
+			} else {
+				center := m.addVertex([3]float64{0, -heightHalf, 0}, normal, u1, uvV)
+				m.addTriangle(b, a, center)
+			}
+		}
+	}
+	if !openEnded && radiusTop > 0 {
+		addCap(0, 1, 1)
+	}
+	if !openEnded && radiusBottom > 0 {
+		addCap(heightSegments, -1, 2)
+	}
+	m.closeGroups()
+	return m, nil
+}
+
+func sphere(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	radius := p.or(`radius`, 50)
+	widthSegments := p.segments(`widthSegments`, 8, 3)
+	heightSegments := p.segments(`heightSegments`, 6, 2)
+	if err := p.check((widthSegments + 1) * (heightSegments + 1)); err != nil {
+		return nil, err
+	}
+	phiStart := p.get(`phiStart`, 0)
+	phiLength := p.get(`phiLength`, math.Pi*2)
+	thetaStart := p.get(`thetaStart`, 0)
+	thetaLength := p.get(`thetaLength`, math.Pi)
+	thetaEnd := thetaStart + thetaLength
+
+	rows := make([][]int, heightSegments+1)
+	for y := 0; y <= heightSegments; y++ {
+		v := float64(y) / float64(heightSegments)
+		for x := 0; x <= widthSegments; x++ {
+			u := float64(x) / float64(widthSegments)
+			position := [3]float64{
+				-radius * math.Cos(phiStart+u*phiLength) * math.Sin(thetaStart+v*thetaLength),
+				radius * math.Cos(thetaStart+v*thetaLength),
+				radius * math.Sin(phiStart+u*phiLength) * math.Sin(thetaStart+v*thetaLength),
+			}
+			rows[y] = append(rows[y], m.addVertex(position, normalize(position), u, 1-v))
+		}
+	}
+	for y := 0; y < heightSegments; y++ {
+		for x := 0; x < widthSegments; x++ {
+			v1, v2, v3, v4 := rows[y][x+1], rows[y][x], rows[y+1][x], rows[y+1][x+1]
+			if y != 0 || thetaStart > 0 {
+				m.addTriangle(v1, v2, v4)
+			}
+			if y != heightSegments-1 || thetaEnd < math.Pi {
+				m.addTriangle(v2, v3, v4)
+			}
+		}
+	}
+	return m, nil
+}
+
+func torus(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	radius := p.or(`radius`, 100)
+	tube := p.or(`tube`, 40)
+	radialSegments := p.segments(`radialSegments`, 8, 1)
+	tubularSegments := p.segments(`tubularSegments`, 6, 1)
+	if err := p.check((radialSegments + 1) * (tubularSegments + 1)); err != nil {
+		return nil, err
+	}
+	arc := p.or(`arc`, math.Pi*2)
+	for j := 0; j <= radialSegments; j++ {
+		for i := 0; i <= tubularSegments; i++ {
+			u := float64(i) / float64(tubularSegments) * arc
+			v := float64(j) / float64(radialSegments) * math.Pi * 2
+			position := [3]float64{(radius + tube*math.Cos(v)) * math.Cos(u), (radius + tube*math.Cos(v)) * math.Sin(u), tube * math.Sin(v)}
+			center := [3]float64{radius * math.Cos(u), radius * math.Sin(u), 0}
+			normal := normalize([3]float64{position[0] - center[0], position[1] - center[1], position[2]})
+			m.addVertex(position, normal, float64(i)/float64(tubularSegments), float64(j)/float64(radialSegments))
+		}
+	}
+	for j := 1; j <= radialSegments; j++ {
+		for i := 1; i <= tubularSegments; i++ {
+			a := (tubularSegments+1)*j + i - 1
+			b := (tubularSegments+1)*(j-1) + i - 1
+			c := (tubularSegments+1)*(j-1) + i
+			d := (tubularSegments+1)*j + i
+			m.addTriangle(a, b, d)
+			m.addTriangle(b, c, d)
+		}
+	}
+	return m, nil
+}
+
+func torusKnot(p *params) (*Mesh, error) {
+	m := &Mesh{}
+	radius := p.or(`radius`, 100)
+	tube := p.or(`tube`, 40)
+	radialSegments := p.segments(`radialSegments`, 64, 1)
+	tubularSegments := p.segments(`tubularSegments`, 8, 1)
+	if err := p.check(radialSegments * tubularSegments); err != nil {
+		return nil, err
+	}
+	pp := p.or(`p`, 2)
+	q := p.or(`q`, 3)
+	heightScale := p.or(`heightScale`, 1)
+
+	position := func(u float64) [3]float64 {
+		quOverP := q / pp * u
+		cs := math.Cos(quOverP)
+		return [3]float64{
+			radius * (2 + cs) * 0.5 * math.Cos(u),
+			radius * (2 + cs) * math.Sin(u) * 0.5,
+			heightScale * radius * math.Sin(quOverP) * 0.5,
+		}
+	}
+	cross := func(a, b [3]float64) [3]float64 {
+		return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+	}
+
+	grid := make([][]int, radialSegments)
+	for i := 0; i < radialSegments; i++ {
+		u := float64(i) / float64(radialSegments) * 2 * pp * math.Pi
+		p1, p2 := position(u), position(u+0.01)
+		tangent := [3]float64{p2[0] - p1[0], p2[1] - p1[1], p2[2] - p1[2]}
+		n := [3]float64{p2[0] + p1[0], p2[1] + p1[1], p2[2] + p1[2]}
+		bitangent := cross(tangent, n)
+		n = normalize(cross(bitangent, tangent))
+		bitangent = normalize(bitangent)
+		for j := 0; j < tubularSegments; j++ {
+			v := float64(j) / float64(tubularSegments) * 2 * math.Pi
+			cx, cy := -tube*math.Cos(v), tube*math.Sin(v)
+			grid[i] = append(grid[i], m.addPosition(
+				p1[0]+cx*n[0]+cy*bitangent[0],
+				p1[1]+cx*n[1]+cy*bitangent[1],
+				p1[2]+cx*n[2]+cy*bitangent[2],
+			))
+		}
+	}
+	for i := 0; i < radialSegments; i++ {
+		for j := 0; j < tubularSegments; j++ {
+			ip, jp := (i+1)%radialSegments, (j+1)%tubularSegments
+			a, b, c, d := grid[i][j], grid[ip][j], grid[ip][jp], grid[i][jp]
+			m.addTriangle(a, b, d)
+			m.addTriangle(b, c, d)
+		}
+	}
+	// the seam wraps around to the first vertices so the knot has no uvs, as in the editor its normals are smoothed
+	m.ComputeNormals()
+	return m, nil
+}
+
+func icosahedron(p *params) (*Mesh, error) {
+	t := (1 + math.Sqrt(5)) / 2
+	return polyhedron(p, []float64{
+		-1, t, 0, 1, t, 0, -1, -t, 0, 1, -t, 0,
+		0, -1, t, 0, 1, t, 0, -1, -t, 0, 1, -t,
+		t, 0, -1, t, 0, 1, -t, 0, -1, -t, 0, 1,
+	}, []int{
+		0, 11, 5, 0, 5, 1, 0, 1, 7, 0, 7, 10, 0, 10, 11,
+		1, 5, 9, 5, 11, 4, 11, 10, 2, 10, 7, 6, 7, 1, 8,
+		3, 9, 4, 3, 4, 2, 3, 2, 6, 3, 6, 8, 3, 8, 9,
+		4, 9, 5, 2, 4, 11, 6, 2, 10, 8, 6, 7, 9, 8, 1,
+	})
+}
+
+func octahedron(p *params) (*Mesh, error) {
+	return polyhedron(p, []float64{
+		1, 0, 0, -1, 0, 0, 0, 1, 0, 0, -1, 0, 0, 0, 1, 0, 0, -1,
+	}, []int{
+		0, 2, 4, 0, 4, 3, 0, 3, 5, 0, 5, 2, 1, 2, 5, 1, 5, 3, 1, 3, 4, 1, 4, 2,
+	})
+}
+
+func tetrahedron(p *params) (*Mesh, error) {
+	return polyhedron(p, []float64{
+		1, 1, 1, -1, -1, 1, -1, 1, -1, 1, -1, -1,
+	}, []int{
+		2, 1, 0, 0, 3, 2, 1, 3, 0, 2, 3, 1,
+	})
+}
+
+// Subdivides each face into 4^detail triangles projected onto the sphere, as THREE.PolyhedronGeometry does
+func polyhedron(p *params, vertices []float64, indices []int) (*Mesh, error) {
+	m := &Mesh{}
+	radius := p.or(`radius`, 1)
+	detail := int(math.Max(0, p.or(`detail`, 0)))
+	if detail > maxDetail {
+		return nil, &formatError{`detail of ` + strconv.Itoa(detail) + ` is more than the limit of ` + strconv.Itoa(maxDetail)}
+	}
+	cols := 1 << uint(detail)
+	if err := p.check(len(indices) / 3 * (cols + 1) * (cols + 2) / 2); err != nil {
+		return nil, err
+	}
+	project := func(v [3]float64) int {
+		length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+		return m.addPosition(v[0]/length*radius, v[1]/length*radius, v[2]/length*radius)
+	}
+	lerp := func(a, b [3]float64, alpha float64) [3]float64 {
+		return [3]float64{a[0] + (b[0]-a[0])*alpha, a[1] + (b[1]-a[1])*alpha, a[2] + (b[2]-a[2])*alpha}
+	}
+	unit := func(i int) [3]float64 {
+		return normalize([3]float64{vertices[i*3], vertices[i*3+1], vertices[i*3+2]})
+	}
+	for f := 0; f*3 < len(indices); f++ {
+		a, b, c := unit(indices[f*3]), unit(indices[f*3+1]), unit(indices[f*3+2])
+		m.setMaterial(f)
+		v := make([][]int, cols+1)
+		for i := 0; i <= cols; i++ {
+			aj := normalize(lerp(a, c, float64(i)/float64(cols)))
+			bj := normalize(lerp(b, c, float64(i)/float64(cols)))
+			rows := cols - i
+			for j := 0; j <= rows; j++ {
+				if j == 0 && i == cols {
+					v[i] = append(v[i], project(aj))
+				} else {
+					v[i] = append(v[i], project(lerp(aj, bj, float64(j)/float64(rows))))
+				}
+			}
+		}
+		for i := 0; i < cols; i++ {
+			for j := 0; j < 2*(cols-i)-1; j++ {
+				k := j / 2
+				if j%2 == 0 {
+					m.addTriangle(v[i][k+1], v[i+1][k], v[i][k])
+				} else {
+					m.addTriangle(v[i][k+1], v[i+1][k+1], v[i+1][k])
+				}
+			}
+		}
+	}
+	m.closeGroups()
+	m.mergeVertices()
+	// every vertex lies on the sphere so its normal is its direction from the center, uvs are left out as the
+	// seam correction of the editor splits vertices per face
+	m.Normals = make([]float64, len(m.Positions))
+	for i := 0; i < m.VertexCount(); i++ {
+		n := normalize(m.Vertex(i))
+		copy(m.Normals[i*3:], n[:])
+	}
+	return m, nil
+}
+
+func normalize(v [3]float64) [3]float64 {
+	length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if length == 0 {
+		return v
+	}
+	return [3]float64{v[0] / length, v[1] / length, v[2] / length}
+}
@@ -0,0 +1,244 @@
+/*
+Tracks who is looking at each stored scene, what they have selected and where their camera is, so collaborators
+can see which part of a scene a teammate is working on before touching it
+*/
+package presence
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/websocket"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sendBufferSize = 256
+)
+
+var (
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	// handed out in turn to members that do not choose a colour
+	palette = []string{`#e6194b`, `#3cb44b`, `#4363d8`, `#f58231`, `#911eb4`, `#42d4f4`, `#f032e6`, `#9a6324`}
+)
+
+// The camera pose of a member, matching the editor camera's position and quaternion
+type Camera struct {
+	Position   []float64 `json:"position"`
+	Quaternion []float64 `json:"quaternion"`
+}
+
+type Member struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Color    string    `json:"color"`
+	Selected string    `json:"selected"`
+	Camera   *Camera   `json:"camera,omitempty"`
+	Joined   time.Time `json:"joined"`
+}
+
+// Messages exchanged over the socket. Clients send:
+//
+//	{"type": "update", "name": "...", "color": "#rrggbb", "selected": "<uuid>", "camera": {...}}
+//
+// where every field is optional and an empty selected string clears the selection, and the server sends:
+//
+//	{"type": "roster", "self": "<member id>", "members": [...]}  everyone present, sent on join
+//	{"type": "join", "member": {...}}
+//	{"type": "update", "member": {...}}
+//	{"type": "leave", "member": {...}}
+type message struct {
+	Type     string    `json:"type"`
+	Self     string    `json:"self,omitempty"`
+	Members  []*Member `json:"members,omitempty"`
+	Member   *Member   `json:"member,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Name     *string   `json:"name,omitempty"`
+	Color    *string   `json:"color,omitempty"`
+	Selected *string   `json:"selected,omitempty"`
+	Camera   *Camera   `json:"camera,omitempty"`
+}
+
+type Hub struct {
+	store     scene.Store
+	log       golog.Log
+	mtx       sync.Mutex
+	scenes    map[string]map[string]*peer
+	nextColor int
+}
+
+type peer struct {
+	member *Member
+	conn   *websocket.Conn
+	outbox *websocket.Outbox
+}
+
+func NewHub(store scene.Store, log golog.Log) *Hub {
+	return &Hub{store: store, log: log, scenes: map[string]map[string]*peer{}}
+}
+
+// Serves /api/scenes/{id}/presence, a websocket upgrade joins the scene's presence channel with ?name= and
+// ?color= and a plain GET returns the current members
+func NewSubHandler(hub *Hub) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+		if _, _, err := hub.store.Get(id); err != nil {
+			scene.WriteError(w, hub.log, err)
+			return
+		}
+		if !strings.EqualFold(r.Header.Get(`Upgrade`), `websocket`) {
+			api.WriteJson(w, http.StatusOK, hub.Members(id))
+			return
+		}
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		query := r.URL.Query()
+		hub.serve(id, conn, query.Get(`name`), query.Get(`color`))
+	}
+}
+
+// The members currently present on the scene ordered by when they joined
+func (h *Hub) Members(sceneId string) []*Member {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	return h.membersLocked(sceneId)
+}
+
+func (h *Hub) membersLocked(sceneId string) []*Member {
+	members := make([]*Member, 0, len(h.scenes[sceneId]))
+	for _, p := range h.scenes[sceneId] {
+		member := *p.member
+		members = append(members, &member)
+	}
+	sort.Sort(byJoined(members))
+	return members
+}
+
+func (h *Hub) serve(sceneId string, conn *websocket.Conn, name, color string) {
+	p := &peer{
+		member: &Member{Id: uuid.New(), Name: name, Joined: time.Now().UTC()},
+		conn:   conn,
+		outbox: websocket.NewOutbox(conn, sendBufferSize),
+	}
+	h.join(sceneId, p, color)
+	defer h.leave(sceneId, p)
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := &message{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			send(p, &message{Type: `error`, Error: err.Error()})
+			continue
+		}
+		if msg.Type != `update` {
+			send(p, &message{Type: `error`, Error: `unknown message type ` + msg.Type})
+			continue
+		}
+		if msg.Color != nil && !colorPattern.MatchString(*msg.Color) {
+			send(p, &message{Type: `error`, Error: `color must be of the form #rrggbb`})
+			continue
+		}
+		h.update(sceneId, p, msg)
+	}
+}
+
+func (h *Hub) join(sceneId string, p *peer, color string) {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	if !colorPattern.MatchString(color) {
+		color = palette[h.nextColor%len(palette)]
+		h.nextColor++
+	}
+	p.member.Color = color
+	if h.scenes[sceneId] == nil {
+		h.scenes[sceneId] = map[string]*peer{}
+	}
+	h.scenes[sceneId][p.member.Id] = p
+	send(p, &message{Type: `roster`, Self: p.member.Id, Members: h.membersLocked(sceneId)})
+	h.broadcastLocked(sceneId, p, &message{Type: `join`, Member: p.member})
+}
+
+func (h *Hub) update(sceneId string, p *peer, msg *message) {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	if msg.Name != nil {
+		p.member.Name = *msg.Name
+	}
+	if msg.Color != nil {
+		p.member.Color = *msg.Color
+	}
+	if msg.Selected != nil {
+		p.member.Selected = *msg.Selected
+	}
+	if msg.Camera != nil {
+		p.member.Camera = msg.Camera
+	}
+	h.broadcastLocked(sceneId, p, &message{Type: `update`, Member: p.member})
+}
+
+func (h *Hub) leave(sceneId string, p *peer) {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	delete(h.scenes[sceneId], p.member.Id)
+	if len(h.scenes[sceneId]) == 0 {
+		delete(h.scenes, sceneId)
+	}
+	p.outbox.Close()
+	h.broadcastLocked(sceneId, p, &message{Type: `leave`, Member: p.member})
+}
+
+// Disconnects every member, telling them the server is going away
+func (h *Hub) Close() {
+	defer h.mtx.Unlock()
+	h.mtx.Lock()
+
+	for _, peers := range h.scenes {
+		for _, p := range peers {
+			p.conn.CloseWith(websocket.CloseGoingAway)
+		}
+	}
+}
+
+// Sends msg to every member of the scene except from
+func (h *Hub) broadcastLocked(sceneId string, from *peer, msg *message) {
+	data, _ := json.Marshal(msg)
+	for _, p := range h.scenes[sceneId] {
+		if p != from {
+			p.outbox.Send(data)
+		}
+	}
+}
+
+func send(p *peer, msg *message) {
+	data, _ := json.Marshal(msg)
+	p.outbox.Send(data)
+}
+
+type byJoined []*Member
+
+func (m byJoined) Len() int           { return len(m) }
+func (m byJoined) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byJoined) Less(i, j int) bool { return m[i].Joined.Before(m[j].Joined) }
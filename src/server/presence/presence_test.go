@@ -0,0 +1,151 @@
+package presence
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"github.com/robsix/3ditor/src/server/websocket/websockettest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A hub serving the presence of one stored scene at /{id}, closed by the returned func
+func newTestServer(t *testing.T) (*Hub, *httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir(``, `presence`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := scene.NewLocalStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	meta, err := store.Create(`scene`, `alice`, []byte(scenetest.Document(`0`)))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	hub := NewHub(store, golog.NewDevNullLog())
+	handler := NewSubHandler(hub)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, strings.TrimPrefix(r.URL.Path, `/`), nil)
+	}))
+	return hub, server, meta.Id, func() {
+		hub.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func join(t *testing.T, url string) (*websockettest.Conn, *message) {
+	conn, err := websockettest.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roster := &message{}
+	if err := conn.ReadJson(roster); err != nil || roster.Type != `roster` {
+		t.Fatalf(`got %+v, %v, want a roster`, roster, err)
+	}
+	return conn, roster
+}
+
+func read(t *testing.T, conn *websockettest.Conn, typ string) *Member {
+	msg := &message{}
+	if err := conn.ReadJson(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != typ {
+		t.Fatalf(`got %+v, want a %s message`, msg, typ)
+	}
+	return msg.Member
+}
+
+func names(members []*Member) string {
+	list := []string{}
+	for _, m := range members {
+		list = append(list, m.Name)
+	}
+	return strings.Join(list, `,`)
+}
+
+func TestPresence(t *testing.T) {
+	hub, server, id, cleanup := newTestServer(t)
+	defer cleanup()
+
+	alice, roster := join(t, server.URL+`/`+id+`?name=alice&color=%23112233`)
+	defer alice.Close()
+	if names(roster.Members) != `alice` || roster.Self != roster.Members[0].Id || roster.Members[0].Color != `#112233` {
+		t.Errorf(`alice got roster %+v`, roster)
+	}
+
+	bob, roster := join(t, server.URL+`/`+id+`?name=bob&color=red`)
+	if names(roster.Members) != `alice,bob` || roster.Self != roster.Members[1].Id {
+		t.Errorf(`bob got roster %s, want alice then bob`, names(roster.Members))
+	}
+	// a colour that is not #rrggbb is replaced from the palette
+	if roster.Members[1].Color != palette[0] {
+		t.Errorf(`bob got colour %s, want %s`, roster.Members[1].Color, palette[0])
+	}
+	if joined := read(t, alice, `join`); joined.Name != `bob` {
+		t.Errorf(`alice saw %s join, want bob`, joined.Name)
+	}
+
+	bob.Write([]byte(`{"type":"update","color":"blue"}`))
+	if msg := (&message{}); bob.ReadJson(msg) != nil || msg.Type != `error` {
+		t.Errorf(`bob got %+v for an invalid colour, want an error`, msg)
+	}
+	bob.Write([]byte(`{"type":"wave"}`))
+	if msg := (&message{}); bob.ReadJson(msg) != nil || msg.Error != `unknown message type wave` {
+		t.Errorf(`bob got %+v for an unknown message, want an error`, msg)
+	}
+	bob.Write([]byte(`{"type":"update","selected":"A","camera":{"position":[0,0,5],"quaternion":[0,0,0,1]}}`))
+	// refused messages are not passed on, the first alice sees is the update
+	if updated := read(t, alice, `update`); updated.Name != `bob` || updated.Selected != `A` || updated.Camera.Position[2] != 5 {
+		t.Errorf(`alice saw update %+v`, updated)
+	}
+
+	// dropping the connection without a close frame still leaves
+	bob.Close()
+	if left := read(t, alice, `leave`); left.Name != `bob` || left.Selected != `A` {
+		t.Errorf(`alice saw %+v leave, want bob`, left)
+	}
+	if members := hub.Members(id); names(members) != `alice` {
+		t.Errorf(`got members %s after bob left, want alice`, names(members))
+	}
+
+	resp, err := http.Get(server.URL + `/` + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var members []*Member
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil || names(members) != `alice` || members[0].Color != `#112233` {
+		t.Errorf(`GET returned %v, %v`, members, err)
+	}
+}
+
+func TestPresenceMissingScene(t *testing.T) {
+	_, server, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if _, err := websockettest.Dial(server.URL + `/nope`); err == nil || !strings.Contains(err.Error(), `404`) {
+		t.Errorf(`got %v joining a missing scene, want a 404`, err)
+	}
+}
+
+func TestPresenceClose(t *testing.T) {
+	hub, server, id, cleanup := newTestServer(t)
+	defer cleanup()
+
+	alice, _ := join(t, server.URL+`/`+id)
+	defer alice.Close()
+	hub.Close()
+	if _, err := alice.Read(); err == nil {
+		t.Errorf(`alice is still connected after the hub closed`)
+	}
+}
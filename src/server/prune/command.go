@@ -0,0 +1,70 @@
+package prune
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/dirlock"
+	"github.com/robsix/3ditor/src/server/scene"
+	"io"
+)
+
+// Implements `server prune [-dry-run] [-json]` against the stores in sceneDir and assetDir. The server writes to the
+// same stores without knowing of the command so it refuses to prune while the server holds the lock on sceneDir, a
+// dry run only reads so may run at any time.
+func NewCommand(sceneDir, assetDir string, policy *Policy) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		flags := flag.NewFlagSet(`prune`, flag.ContinueOnError)
+		dryRun := flags.Bool(`dry-run`, false, `report what would be pruned without deleting anything`)
+		asJson := flags.Bool(`json`, false, `print the report as json`)
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 0 {
+			return &usageError{}
+		}
+
+		if !*dryRun {
+			dirLock, err := dirlock.Acquire(sceneDir)
+			if dirlock.IsHeld(err) {
+				return &serverRunningError{reason: err.Error()}
+			} else if err != nil {
+				return err
+			}
+			defer dirLock.Release()
+		}
+
+		scenes, err := scene.NewLocalStore(sceneDir)
+		if err != nil {
+			return err
+		}
+		assets, err := asset.NewLocalStore(assetDir)
+		if err != nil {
+			return err
+		}
+		report, err := Run(scenes, assets, policy, *dryRun)
+		if err != nil {
+			return err
+		}
+
+		if *asJson {
+			data, _ := json.MarshalIndent(report, ``, `  `)
+			_, err = out.Write(append(data, '\n'))
+			return err
+		}
+		_, err = io.WriteString(out, report.String())
+		return err
+	}
+}
+
+type usageError struct{}
+
+func (e *usageError) Error() string { return `usage: prune [-dry-run] [-json]` }
+
+type serverRunningError struct {
+	reason string
+}
+
+func (e *serverRunningError) Error() string {
+	return `can not prune: ` + e.reason + `, stop it first or use -dry-run`
+}
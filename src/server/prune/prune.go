@@ -0,0 +1,212 @@
+/*
+Housekeeping for the scene and asset stores, old revisions are pruned according to a retention policy and assets no
+retained revision refers to are deleted
+*/
+package prune
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/scene"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var assetRef = regexp.MustCompile(asset.Scheme + `[0-9a-f]{64}`)
+
+// Which revisions of a scene survive pruning, a revision is kept when any rule keeps it and the head revision is
+// always kept
+type Policy struct {
+	// the newest revisions kept whatever their age
+	KeepLast int
+	// every revision saved in this many days is kept
+	KeepDays int
+	// the last revision of each day is kept for this many days
+	KeepDailyDays int
+	// unreferenced assets younger than this are kept, uploads are stored before the scene referring to them is saved
+	AssetGrace time.Duration
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{KeepLast: 10, KeepDays: 7, KeepDailyDays: 90, AssetGrace: 24 * time.Hour}
+}
+
+// The numbers of the revisions the policy prunes, revisions must be in number order
+func (p *Policy) Select(revisions []*scene.Revision, now time.Time) []int {
+	var live []*scene.Revision
+	for _, revision := range revisions {
+		if !revision.Pruned {
+			live = append(live, revision)
+		}
+	}
+	keep := map[int]bool{}
+	days := map[string]bool{}
+	for i := len(live) - 1; i >= 0; i-- {
+		revision := live[i]
+		age := now.Sub(revision.Time)
+		day := revision.Time.UTC().Format(`2006-01-02`)
+		switch {
+		case i == len(live)-1, len(live)-1-i < p.KeepLast, age < time.Duration(p.KeepDays)*24*time.Hour:
+			keep[revision.Number] = true
+		case age < time.Duration(p.KeepDailyDays)*24*time.Hour && !days[day]:
+			keep[revision.Number] = true
+		}
+		days[day] = true
+	}
+	var pruned []int
+	for _, revision := range live {
+		if !keep[revision.Number] {
+			pruned = append(pruned, revision.Number)
+		}
+	}
+	return pruned
+}
+
+// What a run pruned, or would have pruned on a dry run
+type Report struct {
+	DryRun bool           `json:"dryRun"`
+	Scenes []*SceneReport `json:"scenes"`
+	Assets []*asset.Asset `json:"assets"`
+	// bytes freed by pruned revisions and deleted assets together
+	Bytes int64 `json:"bytes"`
+}
+
+type SceneReport struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Revisions []int  `json:"revisions"`
+	Bytes     int64  `json:"bytes"`
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	for _, s := range r.Scenes {
+		numbers := make([]string, len(s.Revisions))
+		for i, number := range s.Revisions {
+			numbers[i] = strconv.Itoa(number)
+		}
+		b.WriteString(`scene ` + strconv.Quote(s.Name) + ` ` + s.Id + `: revisions ` + strings.Join(numbers, `, `) + ` (` + formatBytes(s.Bytes) + ")\n")
+	}
+	for _, a := range r.Assets {
+		b.WriteString(`asset ` + a.Hash + ` ` + a.Type + ` (` + formatBytes(a.Size) + ")\n")
+	}
+	verb := `freed `
+	if r.DryRun {
+		verb = `would free `
+	}
+	b.WriteString(strconv.Itoa(len(r.Scenes)) + ` scenes, ` + strconv.Itoa(len(r.Assets)) + ` assets, ` + verb + formatBytes(r.Bytes) + "\n")
+	return b.String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + ` B`
+	}
+	value, suffix := float64(n)/unit, `KB`
+	for _, next := range []string{`MB`, `GB`, `TB`} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + ` ` + suffix
+}
+
+// Prunes the revisions of every scene in scenes by policy then deletes the assets none of the remaining revisions
+// refer to. scenes must be the unwrapped store so revisions are read with their asset:// references. A dry run only
+// reports what would go.
+func Run(scenes scene.Store, assets asset.Store, policy *Policy, dryRun bool) (*Report, error) {
+	report := &Report{DryRun: dryRun, Scenes: []*SceneReport{}, Assets: []*asset.Asset{}}
+	now := time.Now().UTC()
+	metas, err := scenes.List()
+	if err != nil {
+		return nil, err
+	}
+	referenced := map[string]bool{}
+	for _, meta := range metas {
+		revisions, err := scenes.Revisions(meta.Id)
+		if scene.IsNotFound(err) {
+			// deleted since it was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		pruned := policy.Select(revisions, now)
+		isPruned := map[int]bool{}
+		if len(pruned) > 0 {
+			sceneReport := &SceneReport{Id: meta.Id, Name: meta.Name, Revisions: pruned}
+			for _, number := range pruned {
+				isPruned[number] = true
+				sceneReport.Bytes += revisions[number-1].Size
+			}
+			if !dryRun {
+				if err := scenes.Prune(meta.Id, pruned); err != nil {
+					return nil, err
+				}
+			}
+			report.Scenes = append(report.Scenes, sceneReport)
+			report.Bytes += sceneReport.Bytes
+		}
+		for _, revision := range revisions {
+			if revision.Pruned || isPruned[revision.Number] {
+				continue
+			}
+			_, doc, err := scenes.GetRevision(meta.Id, revision.Number)
+			if scene.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			for _, ref := range assetRef.FindAll(doc, -1) {
+				referenced[string(ref[len(asset.Scheme):])] = true
+			}
+		}
+	}
+
+	// listed after the scan, so assets put again while it ran are seen as recently used
+	list, err := assets.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		if referenced[a.Hash] || now.Sub(a.Modified) < policy.AssetGrace {
+			continue
+		}
+		if !dryRun {
+			if err := assets.Delete(a.Hash); err != nil && !asset.IsNotFound(err) {
+				return nil, err
+			}
+		}
+		report.Assets = append(report.Assets, a)
+		report.Bytes += a.Size
+	}
+	return report, nil
+}
+
+// Runs the job straight away and then every interval until stop is closed. The returned channel is closed once the
+// job has stopped, after finishing any run in progress.
+func Schedule(scenes scene.Store, assets asset.Store, policy *Policy, interval time.Duration, stop <-chan struct{}, log golog.Log) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			report, err := Run(scenes, assets, policy, false)
+			if err != nil {
+				log.Error(`pruning failed: `, err)
+			} else if len(report.Scenes) > 0 || len(report.Assets) > 0 {
+				log.Info(`pruned `, len(report.Scenes), ` scenes and `, len(report.Assets), ` assets, freeing `, formatBytes(report.Bytes))
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,222 @@
+package prune
+
+import (
+	"fmt"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name   string
+		policy Policy
+		// the age of each revision, oldest first, a negative age marks an already pruned revision
+		ages []time.Duration
+		want []int
+	}{
+		{
+			name:   `head is always kept`,
+			policy: Policy{},
+			ages:   []time.Duration{3 * day, 2 * day, day},
+			want:   []int{1, 2},
+		},
+		{
+			name:   `keep last`,
+			policy: Policy{KeepLast: 2},
+			ages:   []time.Duration{4 * day, 3 * day, 2 * day, day},
+			want:   []int{1, 2},
+		},
+		{
+			name:   `keep last counts only live revisions`,
+			policy: Policy{KeepLast: 2},
+			ages:   []time.Duration{4 * day, 3 * day, -1, day},
+			want:   []int{1},
+		},
+		{
+			name:   `keep days boundary`,
+			policy: Policy{KeepDays: 2},
+			ages:   []time.Duration{2*day + time.Second, 2 * day, 2*day - time.Second, time.Hour},
+			want:   []int{1, 2},
+		},
+		{
+			name:   `keep the last revision of each day`,
+			policy: Policy{KeepDailyDays: 5},
+			// 10:00, 11:00 and 11:30 three days ago then 09:00 the day after
+			ages: []time.Duration{3*day + 2*time.Hour, 3*day + time.Hour, 3*day + 30*time.Minute, 2*day + 3*time.Hour, time.Hour},
+			want: []int{1, 2},
+		},
+		{
+			name:   `keep daily days boundary`,
+			policy: Policy{KeepDailyDays: 5},
+			ages:   []time.Duration{5*day + time.Second, 5*day - time.Second, time.Hour},
+			want:   []int{1},
+		},
+		{
+			name:   `a day kept by keep days is not kept again as a daily revision`,
+			policy: Policy{KeepDays: 1, KeepDailyDays: 5},
+			// both the day before, one just within a day and one just past it
+			ages: []time.Duration{day + time.Minute, day - time.Minute, time.Hour},
+			want: []int{1},
+		},
+		{
+			name:   `nothing to prune`,
+			policy: *DefaultPolicy(),
+			ages:   []time.Duration{100 * day, day, time.Hour},
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		revisions := make([]*scene.Revision, len(test.ages))
+		for i, age := range test.ages {
+			revisions[i] = &scene.Revision{Number: i + 1, Time: now.Add(-age), Pruned: age < 0}
+		}
+		if got := test.policy.Select(revisions, now); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf(`%s: got %v pruned, want %v`, test.name, got, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `prune`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scenes, err := scene.NewLocalStore(filepath.Join(dir, `scenes`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := asset.NewLocalStore(filepath.Join(dir, `assets`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := assets.Put(`image/png`, strings.NewReader(`kept`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := assets.Put(`image/png`, strings.NewReader(`dropped`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := func(ref string) []byte {
+		return []byte(`{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"images":[{"uuid":"I","url":"` + ref + `"}],` +
+			`"object":{"uuid":"S","type":"Scene"}}}`)
+	}
+	meta, err := scenes.Create(`a`, `alice`, document(dropped.Ref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scenes.Replace(meta.Id, `a`, `alice`, 0, document(kept.Ref)); err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{KeepLast: 1}
+
+	report, err := Run(scenes, assets, policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Scenes) != 1 || fmt.Sprint(report.Scenes[0].Revisions) != `[1]` || len(report.Assets) != 1 || report.Assets[0].Hash != dropped.Hash {
+		t.Errorf(`dry run: got %s`, report)
+	}
+	if _, _, err := scenes.GetRevision(meta.Id, 1); err != nil {
+		t.Errorf(`dry run pruned revision 1: %v`, err)
+	}
+	if _, f, err := assets.Get(dropped.Hash); err != nil {
+		t.Errorf(`dry run deleted the unreferenced asset: %v`, err)
+	} else {
+		f.Close()
+	}
+
+	policy.AssetGrace = time.Hour
+	if report, err := Run(scenes, assets, policy, true); err != nil || len(report.Assets) != 0 {
+		t.Errorf(`grace: got %v, %v, want no assets deleted`, report, err)
+	}
+
+	policy.AssetGrace = 0
+	if _, err := Run(scenes, assets, policy, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := scenes.GetRevision(meta.Id, 1); !scene.IsNotFound(err) {
+		t.Errorf(`revision 1 not pruned: %v`, err)
+	}
+	if _, _, err := assets.Get(dropped.Hash); !asset.IsNotFound(err) {
+		t.Errorf(`unreferenced asset not deleted: %v`, err)
+	}
+	if _, f, err := assets.Get(kept.Hash); err != nil {
+		t.Errorf(`referenced asset deleted: %v`, err)
+	} else {
+		f.Close()
+	}
+	if report, err := Run(scenes, assets, policy, false); err != nil || len(report.Scenes) != 0 || len(report.Assets) != 0 {
+		t.Errorf(`second run: got %v, %v, want nothing pruned`, report, err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `prune`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scenes, err := scene.NewLocalStore(filepath.Join(dir, `scenes`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := asset.NewLocalStore(filepath.Join(dir, `assets`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := []byte(scenetest.Document(`0`))
+	meta, err := scenes.Create(`a`, `alice`, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func() {
+		if _, err := scenes.Replace(meta.Id, `a`, `alice`, 0, document); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// waits up to wait for the revision to be pruned
+	pruned := func(number int, wait time.Duration) bool {
+		for deadline := time.Now().Add(wait); ; time.Sleep(5 * time.Millisecond) {
+			if _, _, err := scenes.GetRevision(meta.Id, number); scene.IsPruned(err) {
+				return true
+			}
+			if time.Now().After(deadline) {
+				return false
+			}
+		}
+	}
+	save()
+
+	stop := make(chan struct{})
+	done := Schedule(scenes, assets, &Policy{KeepLast: 1}, 20*time.Millisecond, stop, golog.NewDevNullLog())
+	if !pruned(1, time.Second) {
+		t.Error(`revision 1 not pruned by the first run`)
+	}
+	save()
+	if !pruned(2, time.Second) {
+		t.Error(`revision 2 not pruned by a later run`)
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal(`still running after being stopped`)
+	}
+	save()
+	if pruned(3, 100*time.Millisecond) {
+		t.Error(`revision 3 pruned after being stopped`)
+	}
+}
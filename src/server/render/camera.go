@@ -0,0 +1,89 @@
+package render
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/threejs"
+	"math"
+)
+
+// The editor's default camera, new scenes are saved with it
+var (
+	defaultFov      = 50.0
+	defaultNear     = 1.0
+	defaultFar      = 100000.0
+	defaultPosition = [3]float64{500, 250, 500}
+)
+
+// World is the camera's column major world matrix and Projection maps its view space to clip space
+type Camera struct {
+	World      []float64
+	Projection []float64
+}
+
+// A perspective camera at eye looking at target with a vertical field of view of fov degrees
+func NewPerspectiveCamera(eye, target [3]float64, fov, aspect, near, far float64) *Camera {
+	return &Camera{World: threejs.LookAt(eye, target), Projection: Perspective(fov, aspect, near, far)}
+}
+
+// The camera saved with the scene projecting onto an image of aspect, its own aspect is that of the editor viewport
+// it was saved from. Scenes saved without a camera get the editor's default one.
+func (s *Scene) Camera(aspect float64) *Camera {
+	obj := asMap(s.camera[`object`])
+	world, ok := mesh.Matrix(obj[`matrix`])
+	if !ok {
+		return NewPerspectiveCamera(defaultPosition, [3]float64{}, defaultFov, aspect, defaultNear, defaultFar)
+	}
+	zoom := floatOr(obj[`zoom`], 1)
+	if zoom <= 0 {
+		zoom = 1
+	}
+	near, far := floatOr(obj[`near`], defaultNear), floatOr(obj[`far`], defaultFar)
+	if typ, _ := obj[`type`].(string); typ == `OrthographicCamera` {
+		left, right := floatOr(obj[`left`], -1), floatOr(obj[`right`], 1)
+		top, bottom := floatOr(obj[`top`], 1), floatOr(obj[`bottom`], -1)
+		// the vertical extent is kept and the horizontal one follows the image
+		cx, cy := (left+right)/2, (top+bottom)/2
+		dy := (top - bottom) / (2 * zoom)
+		dx := dy * aspect
+		return &Camera{World: world, Projection: Orthographic(cx-dx, cx+dx, cy+dy, cy-dy, near, far)}
+	}
+	fov := floatOr(obj[`fov`], defaultFov)
+	fov = 2 * math.Atan(math.Tan(fov*math.Pi/360)/zoom) * 180 / math.Pi
+	return &Camera{World: world, Projection: Perspective(fov, aspect, near, far)}
+}
+
+// The projection matrix THREE.PerspectiveCamera makes
+func Perspective(fov, aspect, near, far float64) []float64 {
+	top := near * math.Tan(fov*math.Pi/360)
+	bottom := -top
+	left, right := bottom*aspect, top*aspect
+	return []float64{
+		2 * near / (right - left), 0, 0, 0,
+		0, 2 * near / (top - bottom), 0, 0,
+		(right + left) / (right - left), (top + bottom) / (top - bottom), -(far + near) / (far - near), -1,
+		0, 0, -2 * far * near / (far - near), 0,
+	}
+}
+
+// The projection matrix THREE.OrthographicCamera makes
+func Orthographic(left, right, top, bottom, near, far float64) []float64 {
+	w, h, p := right-left, top-bottom, far-near
+	return []float64{
+		2 / w, 0, 0, 0,
+		0, 2 / h, 0, 0,
+		0, 0, -2 / p, 0,
+		-(right + left) / w, -(top + bottom) / h, -(far + near) / p, 1,
+	}
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func floatOr(v interface{}, def float64) float64 {
+	if f, ok := v.(float64); ok {
+		return f
+	}
+	return def
+}
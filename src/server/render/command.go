@@ -0,0 +1,97 @@
+package render
+
+import (
+	"bytes"
+	"flag"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/scene"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Implements `server turntable [-frames n] [-width w] [-height h] [-delay ms] [-elevation deg] [-revision n] <scene> <out>`
+// drawing a looping preview of a scene, either the id of a scene in sceneDir or a scene .json file. The format is
+// chosen by the output's extension, .gif or an APNG for .png and .apng.
+//
+//	server turntable 6b522d63-bf89-4515-9833-b4032af67832 preview.gif
+//	server turntable -frames 72 -width 640 -height 480 scene.json preview.png
+func NewCommand(sceneDir, assetDir string) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		t := DefaultTurntable()
+		flags := flag.NewFlagSet(`turntable`, flag.ContinueOnError)
+		flags.IntVar(&t.Frames, `frames`, t.Frames, `number of frames in one turn`)
+		flags.IntVar(&t.Width, `width`, t.Width, `frame width in pixels`)
+		flags.IntVar(&t.Height, `height`, t.Height, `frame height in pixels`)
+		delay := flags.Int(`delay`, int(t.Delay/time.Millisecond), `milliseconds each frame is shown`)
+		flags.Float64Var(&t.Elevation, `elevation`, t.Elevation, `degrees the camera looks down on the scene from`)
+		revision := flags.Int(`revision`, 0, `revision of a stored scene to draw, defaults to the head revision`)
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 2 {
+			return &usageError{}
+		}
+		t.Delay = time.Duration(*delay) * time.Millisecond
+		in, target := flags.Arg(0), flags.Arg(1)
+		var format string
+		switch importer.Ext(target) {
+		case `gif`:
+			format = `gif`
+		case `png`, `apng`:
+			format = `apng`
+		default:
+			return &usageError{}
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+
+		doc, err := readScene(sceneDir, assetDir, in, *revision)
+		if err != nil {
+			return err
+		}
+		g, err := scene.ParseGraph(doc)
+		if err != nil {
+			return err
+		}
+		frames, err := t.Draw(NewScene(g))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := t.Encode(&buf, format, frames); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, buf.Bytes(), 0644)
+	}
+}
+
+// The document of a scene .json file, or of the stored scene with id in, with its geometry read back from assets
+func readScene(sceneDir, assetDir, in string, revision int) ([]byte, error) {
+	if importer.Ext(in) == `json` {
+		return ioutil.ReadFile(in)
+	}
+	scenes, err := scene.NewLocalStore(sceneDir)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := asset.NewLocalStore(assetDir)
+	if err != nil {
+		return nil, err
+	}
+	store := scene.WithAssets(scenes, assets)
+	if revision > 0 {
+		_, doc, err := store.GetRevision(in, revision)
+		return doc, err
+	}
+	_, doc, err := store.Get(in)
+	return doc, err
+}
+
+type usageError struct{}
+
+func (e *usageError) Error() string {
+	return `usage: turntable [-frames n] [-width w] [-height h] [-delay ms] [-elevation deg] [-revision n] <scene id|scene.json> <out.gif|out.png|out.apng>`
+}
@@ -0,0 +1,210 @@
+package render
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// the editor viewport's clear color
+	background = 0xaaaaaa
+	// samples per pixel along each axis, averaged to smooth edges
+	supersample = 2
+	// light for scenes without any, so they are not drawn black
+	fallbackAmbient = 0.25
+)
+
+// A clip space vertex and its shaded color
+type vertex struct {
+	clip  [4]float64
+	color [3]float64
+}
+
+type frame struct {
+	width, height int
+	color         []float64
+	depth         []float64
+}
+
+// Draws the scene as camera sees it into a width by height image
+func (s *Scene) Draw(camera *Camera, width, height int) *image.NRGBA {
+	f := &frame{width: width * supersample, height: height * supersample}
+	f.color = make([]float64, f.width*f.height*3)
+	f.depth = make([]float64, f.width*f.height)
+	clear := linearColor(background)
+	for i := range f.depth {
+		f.depth[i] = math.Inf(1)
+		copy(f.color[i*3:], clear[:])
+	}
+
+	view, ok := mesh.Invert(camera.World)
+	if !ok {
+		view = mesh.Identity()
+	}
+	viewProjection := mesh.Multiply(camera.Projection, view)
+	viewNormals := mesh.NormalMatrix(view)
+	lights := s.lights
+	if len(lights) == 0 {
+		// a light at the camera, as if lit by a headlamp
+		lights = []*light{
+			{typ: `AmbientLight`, color: [3]float64{fallbackAmbient, fallbackAmbient, fallbackAmbient}},
+			{typ: `DirectionalLight`, color: [3]float64{1, 1, 1}, direction: normalize([3]float64{camera.World[8], camera.World[9], camera.World[10]})},
+		}
+	}
+
+	for _, t := range s.triangles {
+		n := mesh.Normal(t.positions[0], t.positions[1], t.positions[2])
+		if n == ([3]float64{}) {
+			continue
+		}
+		centroid := scale(add(add(t.positions[0], t.positions[1]), t.positions[2]), 1.0/3)
+		shade := func(n [3]float64) [3]vertex {
+			var out [3]vertex
+			var irr [3]float64
+			if !t.unlit {
+				irr = irradiance(n, centroid, lights)
+			}
+			for i := range out {
+				out[i].clip = project(viewProjection, t.positions[i])
+				switch {
+				case t.normalColor:
+					viewNormal := mesh.ApplyNormal(viewNormals, n[0], n[1], n[2])
+					for k := range viewNormal {
+						out[i].color[k] = toLinear(viewNormal[k]*0.5 + 0.5)
+					}
+				case t.unlit:
+					out[i].color = t.colors[i]
+				default:
+					out[i].color = add(mul(t.colors[i], irr), t.emissive)
+				}
+			}
+			return out
+		}
+		// back faces of double sided materials are lit from behind, as the GPU does with the flipped normal
+		if t.side != backSide {
+			f.fill(clipNear(shade(n)), true)
+		}
+		if t.side != frontSide {
+			f.fill(clipNear(shade(scale(n, -1))), false)
+		}
+	}
+	return f.resolve(width, height)
+}
+
+func project(m []float64, p [3]float64) [4]float64 {
+	return [4]float64{
+		m[0]*p[0] + m[4]*p[1] + m[8]*p[2] + m[12],
+		m[1]*p[0] + m[5]*p[1] + m[9]*p[2] + m[13],
+		m[2]*p[0] + m[6]*p[1] + m[10]*p[2] + m[14],
+		m[3]*p[0] + m[7]*p[1] + m[11]*p[2] + m[15],
+	}
+}
+
+// Cuts the triangle at the near plane, leaving the polygon in front of the camera
+func clipNear(triangle [3]vertex) []vertex {
+	distance := func(v vertex) float64 { return v.clip[2] + v.clip[3] }
+	var out []vertex
+	for i := range triangle {
+		a, b := triangle[i], triangle[(i+1)%3]
+		da, db := distance(a), distance(b)
+		if da >= 0 {
+			out = append(out, a)
+		}
+		if da >= 0 != (db >= 0) {
+			t := da / (da - db)
+			var v vertex
+			for k := range v.clip {
+				v.clip[k] = a.clip[k] + (b.clip[k]-a.clip[k])*t
+			}
+			for k := range v.color {
+				v.color[k] = a.color[k] + (b.color[k]-a.color[k])*t
+			}
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Rasterizes a clipped polygon as a fan of triangles, keeping only the ones facing the way front asks for
+func (f *frame) fill(polygon []vertex, front bool) {
+	if len(polygon) < 3 {
+		return
+	}
+	screen := make([][3]float64, len(polygon))
+	for i, v := range polygon {
+		w := v.clip[3]
+		if w <= 0 {
+			return
+		}
+		screen[i] = [3]float64{
+			(v.clip[0]/w*0.5 + 0.5) * float64(f.width),
+			(0.5 - v.clip[1]/w*0.5) * float64(f.height),
+			v.clip[2] / w,
+		}
+	}
+	for i := 1; i+1 < len(polygon); i++ {
+		f.triangle([3][3]float64{screen[0], screen[i], screen[i+1]}, [3]vertex{polygon[0], polygon[i], polygon[i+1]}, front)
+	}
+}
+
+func (f *frame) triangle(p [3][3]float64, v [3]vertex, front bool) {
+	area := (p[1][0]-p[0][0])*(p[2][1]-p[0][1]) - (p[2][0]-p[0][0])*(p[1][1]-p[0][1])
+	// counter clockwise triangles face the camera, with y pointing down the screen that is a negative area
+	if area == 0 || (area < 0) != front {
+		return
+	}
+	minX := int(math.Max(0, math.Floor(math.Min(p[0][0], math.Min(p[1][0], p[2][0])))))
+	maxX := int(math.Min(float64(f.width-1), math.Ceil(math.Max(p[0][0], math.Max(p[1][0], p[2][0])))))
+	minY := int(math.Max(0, math.Floor(math.Min(p[0][1], math.Min(p[1][1], p[2][1])))))
+	maxY := int(math.Min(float64(f.height-1), math.Ceil(math.Max(p[0][1], math.Max(p[1][1], p[2][1])))))
+	for y := minY; y <= maxY; y++ {
+		py := float64(y) + 0.5
+		for x := minX; x <= maxX; x++ {
+			px := float64(x) + 0.5
+			// barycentric weights from the edge functions
+			b0 := ((p[1][0]-px)*(p[2][1]-py) - (p[2][0]-px)*(p[1][1]-py)) / area
+			b1 := ((p[2][0]-px)*(p[0][1]-py) - (p[0][0]-px)*(p[2][1]-py)) / area
+			b2 := 1 - b0 - b1
+			if b0 < 0 || b1 < 0 || b2 < 0 {
+				continue
+			}
+			z := b0*p[0][2] + b1*p[1][2] + b2*p[2][2]
+			i := y*f.width + x
+			if z > 1 || z >= f.depth[i] {
+				continue
+			}
+			f.depth[i] = z
+			for k := 0; k < 3; k++ {
+				f.color[i*3+k] = b0*v[0].color[k] + b1*v[1].color[k] + b2*v[2].color[k]
+			}
+		}
+	}
+}
+
+// Averages the samples of each pixel and converts them back to sRGB
+func (f *frame) resolve(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	const samples = supersample * supersample
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [3]float64
+			for sy := 0; sy < supersample; sy++ {
+				for sx := 0; sx < supersample; sx++ {
+					i := ((y*supersample+sy)*f.width + x*supersample + sx) * 3
+					for k := 0; k < 3; k++ {
+						sum[k] += clamp(f.color[i+k], 0, 1)
+					}
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(toSrgb(sum[0]/samples)*255 + 0.5),
+				G: uint8(toSrgb(sum[1]/samples)*255 + 0.5),
+				B: uint8(toSrgb(sum[2]/samples)*255 + 0.5),
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
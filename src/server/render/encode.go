@@ -0,0 +1,163 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"sort"
+	"time"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// Writes frames as a looping gif. Every frame shares one palette of the colors the animation uses most so flat
+// shaded faces do not flicker between frames, shading is dithered onto it.
+func EncodeGif(w io.Writer, frames []*image.NRGBA, delay time.Duration) error {
+	p := palette(frames)
+	out := &gif.GIF{}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), p)
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, frame.Bounds().Min)
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, int(delay/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(w, out)
+}
+
+// The average colors of the most used 5 bit per channel buckets
+func palette(frames []*image.NRGBA) color.Palette {
+	type bucket struct {
+		r, g, b, n int
+	}
+	buckets := map[int]*bucket{}
+	for _, frame := range frames {
+		for i := 0; i+3 < len(frame.Pix); i += 4 {
+			r, g, b := int(frame.Pix[i]), int(frame.Pix[i+1]), int(frame.Pix[i+2])
+			key := r>>3<<10 | g>>3<<5 | b>>3
+			if buckets[key] == nil {
+				buckets[key] = &bucket{}
+			}
+			bk := buckets[key]
+			bk.r, bk.g, bk.b, bk.n = bk.r+r, bk.g+g, bk.b+b, bk.n+1
+		}
+	}
+	list := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		list = append(list, bk)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].n > list[j].n })
+	if len(list) > 256 {
+		list = list[:256]
+	}
+	p := make(color.Palette, len(list))
+	for i, bk := range list {
+		p[i] = color.NRGBA{R: uint8(bk.r / bk.n), G: uint8(bk.g / bk.n), B: uint8(bk.b / bk.n), A: 0xff}
+	}
+	if len(p) == 0 {
+		p = append(p, color.Black)
+	}
+	return p
+}
+
+// Writes frames as a looping APNG in full color. The first frame is the default image so viewers without APNG
+// support show a still.
+func EncodeApng(w io.Writer, frames []*image.NRGBA, delay time.Duration) error {
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	sequence := uint32(0)
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frame); err != nil {
+			return err
+		}
+		chunks, err := readChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			for _, c := range chunks {
+				if c.typ == `IHDR` {
+					writeChunk(&out, `IHDR`, c.data)
+				}
+			}
+			// frame count and zero plays, which loops forever
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl, uint32(len(frames)))
+			writeChunk(&out, `acTL`, actl)
+		}
+
+		bounds := frame.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], sequence)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+		// x and y offsets stay zero, the delay is given in milliseconds
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delay/time.Millisecond))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		// dispose and blend ops stay zero, every frame replaces the whole image
+		writeChunk(&out, `fcTL`, fctl)
+		sequence++
+
+		for _, c := range chunks {
+			if c.typ != `IDAT` {
+				continue
+			}
+			if i == 0 {
+				writeChunk(&out, `IDAT`, c.data)
+				continue
+			}
+			fdat := make([]byte, 4+len(c.data))
+			binary.BigEndian.PutUint32(fdat, sequence)
+			copy(fdat[4:], c.data)
+			writeChunk(&out, `fdAT`, fdat)
+			sequence++
+		}
+	}
+	writeChunk(&out, `IEND`, nil)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+type invalidPngError struct{}
+
+func (e *invalidPngError) Error() string { return `invalid png` }
+
+func readChunks(data []byte) ([]*chunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, &invalidPngError{}
+	}
+	data = data[len(pngSignature):]
+	var chunks []*chunk
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			return nil, &invalidPngError{}
+		}
+		chunks = append(chunks, &chunk{typ: string(data[4:8]), data: data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return chunks, nil
+}
+
+func writeChunk(w *bytes.Buffer, typ string, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], typ)
+	w.Write(header)
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
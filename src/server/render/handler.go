@@ -0,0 +1,94 @@
+package render
+
+import (
+	"bytes"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Serves GET /api/scenes/{id}/turntable?format={gif|apng}&frames={n}&width={w}&height={h}&delay={ms}&elevation={deg}&revision={n}
+// as a looping preview orbiting the scene. Every parameter is optional, format defaults to gif and revision to the
+// head revision.
+func NewTurntableSubHandler(store scene.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		query := r.URL.Query()
+		format := query.Get(`format`)
+		if format == `` {
+			format = `gif`
+		}
+		contentType, known := TurntableFormats[format]
+		if !known {
+			api.WriteError(w, http.StatusBadRequest, &unknownFormatError{format: format})
+			return
+		}
+		t := DefaultTurntable()
+		ints := map[string]*int{`frames`: &t.Frames, `width`: &t.Width, `height`: &t.Height}
+		delay := int(t.Delay / time.Millisecond)
+		ints[`delay`] = &delay
+		for name, dst := range ints {
+			if param := query.Get(name); param != `` {
+				n, err := strconv.Atoi(param)
+				if err != nil {
+					api.WriteError(w, http.StatusBadRequest, err)
+					return
+				}
+				*dst = n
+			}
+		}
+		t.Delay = time.Duration(delay) * time.Millisecond
+		if param := query.Get(`elevation`); param != `` {
+			f, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				api.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			t.Elevation = f
+		}
+		if err := t.Validate(); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		rev := scene.ReadRequestedRevision(w, r, store, log, id)
+		if rev == nil {
+			return
+		}
+		frames, err := t.Draw(NewScene(rev.Graph))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := t.Encode(&buf, format, frames); err != nil {
+			log.Error(`failed to encode turntable: `, err)
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		name := rev.Meta.Name
+		if name == `` {
+			name = `scene`
+		}
+		ext := format
+		if format == `apng` {
+			// .apng files are rarely recognised, browsers and chat clients animate them as .png
+			ext = `png`
+		}
+		w.Header().Set(`Content-Type`, contentType)
+		w.Header().Set(`Content-Disposition`, `inline; filename=`+strconv.Quote(name+`.`+ext))
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,270 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"image"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A red box big enough to fill the middle of the editor's default view
+var bigBox = scenetest.Object(`A`, `box`, `M`, `[300,0,0,0,0,300,0,0,0,0,300,0,0,0,0,1]`)
+
+const red = `16711680`
+
+func graph(t *testing.T, doc string) *scene.Graph {
+	g, err := scene.ParseGraph([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func isBackground(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return r>>8 == 0xaa && g>>8 == 0xaa && b>>8 == 0xaa
+}
+
+func isRed(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return r>>8 > 0x40 && r > 2*g && r > 2*b
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// whether the middle of the image shows the box
+		box bool
+	}{
+		{name: `box`, doc: scenetest.Document(red, bigBox), box: true},
+		{name: `empty`, doc: scenetest.Document(red)},
+		{name: `hidden`, doc: scenetest.Document(red, strings.Replace(bigBox, `"type"`, `"visible":false,"type"`, 1))},
+		{name: `hidden parent`, doc: scenetest.Document(red, strings.Replace(scenetest.Object(`P`, `parent`, `N`, `[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1]`, bigBox), `"type"`, `"visible":false,"type"`, 1))},
+	}
+	for _, test := range tests {
+		data, err := Thumbnail([]byte(test.doc))
+		if err != nil {
+			t.Errorf(`%s: %v`, test.name, err)
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf(`%s: %v`, test.name, err)
+			continue
+		}
+		if bounds := img.Bounds(); bounds.Dx() != ThumbnailWidth || bounds.Dy() != ThumbnailHeight {
+			t.Errorf(`%s: got a %v thumbnail`, test.name, bounds)
+		}
+		if !isBackground(img, 0, 0) {
+			t.Errorf(`%s: corner is %v, want the background`, test.name, img.At(0, 0))
+		}
+		if middle := isRed(img, ThumbnailWidth/2, ThumbnailHeight/2); middle != test.box {
+			t.Errorf(`%s: got the box drawn %v, want %v`, test.name, middle, test.box)
+		}
+	}
+	if _, err := Thumbnail([]byte(`{}`)); err == nil {
+		t.Errorf(`drew a thumbnail of an invalid document`)
+	}
+}
+
+func TestTurntableValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(t *Turntable)
+		valid bool
+	}{
+		{name: `default`, edit: func(t *Turntable) {}, valid: true},
+		{name: `no frames`, edit: func(t *Turntable) { t.Frames = 0 }},
+		{name: `too many frames`, edit: func(t *Turntable) { t.Frames = maxTurntableFrames + 1 }},
+		{name: `too wide`, edit: func(t *Turntable) { t.Width = maxTurntableSize + 1 }},
+		{name: `no height`, edit: func(t *Turntable) { t.Height = 0 }},
+		{name: `too many pixels`, edit: func(t *Turntable) { t.Frames, t.Width, t.Height = 360, 1024, 1024 }},
+		{name: `too short a delay`, edit: func(t *Turntable) { t.Delay = time.Millisecond }},
+		{name: `looking up past vertical`, edit: func(t *Turntable) { t.Elevation = -91 }},
+		{name: `looking straight down`, edit: func(t *Turntable) { t.Elevation = 90 }, valid: true},
+	}
+	for _, test := range tests {
+		turntable := DefaultTurntable()
+		test.edit(turntable)
+		err := turntable.Validate()
+		if test.valid && err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		} else if !test.valid && !IsInvalidTurntable(err) {
+			t.Errorf(`%s: got %v, want an invalid turntable error`, test.name, err)
+		}
+	}
+}
+
+func TestTurntable(t *testing.T) {
+	turntable := &Turntable{Frames: 4, Width: 64, Height: 48, Delay: 80 * time.Millisecond, Elevation: 25}
+	frames, err := turntable.Draw(NewScene(graph(t, scenetest.Document(red, bigBox))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatalf(`got %d frames, want 4`, len(frames))
+	}
+	for i, frame := range frames {
+		// the orbit keeps the whole box in view and centred
+		if !isRed(frame, 32, 24) || !isBackground(frame, 0, 0) {
+			t.Errorf(`frame %d does not show the box in the middle`, i)
+		}
+	}
+	if _, err := turntable.Draw(NewScene(graph(t, scenetest.Document(red)))); err != nil {
+		t.Errorf(`failed to draw an empty scene: %v`, err)
+	}
+
+	var buf bytes.Buffer
+	if err := turntable.Encode(&buf, `gif`, frames); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := gif.DecodeAll(&buf); err != nil {
+		t.Error(err)
+	} else if len(g.Image) != 4 || g.Delay[0] != 8 || g.LoopCount != 0 {
+		t.Errorf(`got a gif of %d frames delayed %v looping %d times`, len(g.Image), g.Delay, g.LoopCount)
+	}
+
+	buf.Reset()
+	if err := turntable.Encode(&buf, `apng`, frames); err != nil {
+		t.Fatal(err)
+	}
+	// viewers without APNG support show the first frame
+	if img, err := png.Decode(bytes.NewReader(buf.Bytes())); err != nil || img.Bounds().Dx() != 64 {
+		t.Errorf(`the apng does not decode as a png: %v`, err)
+	}
+	chunks, err := readChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, c := range chunks {
+		counts[c.typ]++
+		if c.typ == `acTL` && binary.BigEndian.Uint32(c.data) != 4 {
+			t.Errorf(`acTL gives %d frames, want 4`, binary.BigEndian.Uint32(c.data))
+		}
+	}
+	if counts[`acTL`] != 1 || counts[`fcTL`] != 4 || counts[`IDAT`] == 0 || counts[`fdAT`] == 0 {
+		t.Errorf(`got chunks %v`, counts)
+	}
+
+	if err := turntable.Encode(&buf, `webp`, frames); err == nil {
+		t.Errorf(`encoded an unknown format`)
+	}
+}
+
+// A local scene store in a temporary directory holding one scene with the big box, removed by the returned func
+func newTestStore(t *testing.T) (scene.Store, string, string, func()) {
+	dir, err := ioutil.TempDir(``, `render`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := scene.NewLocalStore(filepath.Join(dir, `scenes`))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	meta, err := store.Create(`boxes`, `alice`, []byte(scenetest.Document(red, bigBox)))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, dir, meta.Id, func() { os.RemoveAll(dir) }
+}
+
+func TestWithThumbnails(t *testing.T) {
+	store, dir, _, cleanup := newTestStore(t)
+	defer cleanup()
+	thumbnails := filepath.Join(dir, `thumbnails`)
+	store, err := WithThumbnails(store, thumbnails, golog.NewDevNullLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := store.Create(`saved`, `alice`, []byte(scenetest.Document(red, bigBox)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(thumbnails, meta.Id+`.png`)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(file); err != nil; _, err = os.Stat(file) {
+		if time.Now().After(deadline) {
+			t.Fatalf(`no thumbnail drawn after saving: %v`, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := store.Delete(meta.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf(`thumbnail kept after the scene was deleted: %v`, err)
+	}
+}
+
+func TestSubHandlers(t *testing.T) {
+	store, dir, id, cleanup := newTestStore(t)
+	defer cleanup()
+	log := golog.NewDevNullLog()
+	handlers := map[string]scene.SubHandler{
+		`thumbnail`: NewThumbnailSubHandler(store, filepath.Join(dir, `thumbnails`), log),
+		`turntable`: NewTurntableSubHandler(store, log),
+	}
+	os.MkdirAll(filepath.Join(dir, `thumbnails`), 0755)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		status  int
+		typ     string
+		headers map[string]string
+	}{
+		{name: `thumbnail`, url: `/thumbnail/` + id, status: 200, typ: `image/png`},
+		{name: `missing scene thumbnail`, url: `/thumbnail/nope`, status: 404},
+		{name: `thumbnail post`, method: `POST`, url: `/thumbnail/` + id, status: 405},
+		{name: `gif turntable`, url: `/turntable/` + id + `?frames=2&width=32&height=24`, status: 200, typ: `image/gif`,
+			headers: map[string]string{`Content-Disposition`: `inline; filename="boxes.gif"`}},
+		{name: `apng turntable`, url: `/turntable/` + id + `?format=apng&frames=2&width=32&height=24&delay=100&elevation=-10&revision=1`, status: 200, typ: `image/apng`,
+			headers: map[string]string{`Content-Disposition`: `inline; filename="boxes.png"`}},
+		{name: `unknown format`, url: `/turntable/` + id + `?format=webp`, status: 400},
+		{name: `invalid frames`, url: `/turntable/` + id + `?frames=0`, status: 400},
+		{name: `frames not a number`, url: `/turntable/` + id + `?frames=two`, status: 400},
+		{name: `missing revision`, url: `/turntable/` + id + `?frames=1&revision=9`, status: 404},
+		{name: `missing scene turntable`, url: `/turntable/nope`, status: 404},
+	}
+	for _, test := range tests {
+		if test.method == `` {
+			test.method = `GET`
+		}
+		r := httptest.NewRequest(test.method, test.url, nil)
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, `/`), `/`)
+		w := httptest.NewRecorder()
+		handlers[segments[0]](w, r, segments[1], nil)
+		if w.Code != test.status {
+			t.Errorf(`%s: got status %d, want %d: %s`, test.name, w.Code, test.status, w.Body)
+			continue
+		}
+		if test.typ != `` && w.Header().Get(`Content-Type`) != test.typ {
+			t.Errorf(`%s: got Content-Type %q, want %q`, test.name, w.Header().Get(`Content-Type`), test.typ)
+		}
+		for name, value := range test.headers {
+			if w.Header().Get(name) != value {
+				t.Errorf(`%s: got %s %q, want %q`, test.name, name, w.Header().Get(name), value)
+			}
+		}
+	}
+	// the thumbnail drawn for the first request is written for the next
+	if _, err := os.Stat(filepath.Join(dir, `thumbnails`, id+`.png`)); err != nil {
+		t.Errorf(`thumbnail not kept: %v`, err)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+A software rasterizer drawing scene documents without a GPU, meshes are flat shaded with the Lambert lighting of the
+scene's lights so thumbnails and previews can be made on the server
+*/
+package render
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+)
+
+const (
+	// THREE.FrontSide, THREE.BackSide and THREE.DoubleSide
+	frontSide  = 0
+	backSide   = 1
+	doubleSide = 2
+	// THREE.VertexColors
+	vertexColors = 2
+)
+
+// A world space triangle ready to be drawn, colors are linear rgb per vertex
+type triangle struct {
+	positions [3][3]float64
+	colors    [3][3]float64
+	emissive  [3]float64
+	side      int
+	// basic materials ignore lights
+	unlit bool
+	// normal materials color faces by their normal
+	normalColor bool
+}
+
+type light struct {
+	typ      string
+	color    [3]float64
+	ground   [3]float64
+	position [3]float64
+	// unit vector towards the light of directional and hemisphere lights
+	direction [3]float64
+	distance  float64
+	decay     float64
+}
+
+// The visible surfaces and lights of a scene in world space
+type Scene struct {
+	triangles []*triangle
+	lights    []*light
+	camera    map[string]interface{}
+	min, max  [3]float64
+}
+
+// Collects the triangles of every visible mesh of g and its lights, geometries that can not be read are left out
+func NewScene(g *scene.Graph) *Scene {
+	s := &Scene{
+		camera: g.Camera,
+		min:    [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)},
+		max:    [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+	}
+
+	// parents are visited before their children so a hidden object hides its subtree
+	hidden := map[string]bool{}
+	world := map[string][]float64{}
+	g.Walk(func(node *scene.Node) {
+		visible, ok := node.Object[`visible`].(bool)
+		hidden[node.Uuid] = hidden[node.Parent] || ok && !visible || isLesserLevel(g, node)
+		parentWorld := world[node.Parent]
+		if parentWorld == nil {
+			parentWorld = mesh.Identity()
+		}
+		world[node.Uuid] = parentWorld
+		if local, ok := mesh.Matrix(node.Object[`matrix`]); ok {
+			world[node.Uuid] = mesh.Multiply(parentWorld, local)
+		}
+		if !hidden[node.Uuid] {
+			s.addLight(node.Object, world[node.Uuid])
+		}
+	})
+
+	for _, instance := range mesh.Instances(g, g.Root) {
+		if instance.Mesh == nil || !instance.IsSurface() || hidden[instance.Node.Uuid] {
+			continue
+		}
+		s.addMesh(instance.Mesh.Transform(instance.World), g.Materials[instance.Material])
+	}
+	return s
+}
+
+// Whether the node is a level of a LOD object other than its most detailed one, which is the only one drawn
+func isLesserLevel(g *scene.Graph, node *scene.Node) bool {
+	parent := g.Objects[node.Parent]
+	if parent == nil || parent.Object[`type`] != `LOD` {
+		return false
+	}
+	levels, _ := parent.Object[`levels`].([]interface{})
+	nearest, nearestDistance := ``, math.Inf(1)
+	isLevel := false
+	for _, level := range levels {
+		level := asMap(level)
+		object, _ := level[`object`].(string)
+		distance := floatOr(level[`distance`], 0)
+		isLevel = isLevel || object == node.Uuid
+		if distance < nearestDistance {
+			nearest, nearestDistance = object, distance
+		}
+	}
+	return isLevel && nearest != node.Uuid
+}
+
+// Whether the scene has anything to draw
+func (s *Scene) Empty() bool {
+	return len(s.triangles) == 0
+}
+
+// The world space bounding box of the scene's triangles
+func (s *Scene) Bounds() (min, max [3]float64) {
+	return s.min, s.max
+}
+
+func (s *Scene) addMesh(m *mesh.Mesh, material map[string]interface{}) {
+	for i := 0; i < m.TriangleCount(); i++ {
+		mat := material
+		if typ, _ := material[`type`].(string); typ == `MultiMaterial` {
+			mat = nil
+			list, _ := material[`materials`].([]interface{})
+			if index := m.MaterialIndex(i); index >= 0 && index < len(list) {
+				mat, _ = list[index].(map[string]interface{})
+			}
+		}
+		if visible, ok := mat[`visible`].(bool); ok && !visible {
+			continue
+		}
+
+		t := &triangle{side: frontSide}
+		typ, _ := mat[`type`].(string)
+		t.unlit = typ == `MeshBasicMaterial` || typ == `MeshNormalMaterial`
+		t.normalColor = typ == `MeshNormalMaterial`
+		if side, ok := mat[`side`].(float64); ok {
+			t.side = int(side)
+		}
+		color := [3]float64{1, 1, 1}
+		if hex, ok := mat[`color`].(float64); ok {
+			color = linearColor(int(hex))
+		}
+		if hex, ok := mat[`emissive`].(float64); ok {
+			t.emissive = linearColor(int(hex))
+		}
+		useVertexColors := m.Colors != nil && mat[`vertexColors`] == float64(vertexColors)
+
+		a, b, c := m.Triangle(i)
+		for j, v := range []int{a, b, c} {
+			t.positions[j] = m.Vertex(v)
+			t.colors[j] = color
+			if useVertexColors {
+				for k := 0; k < 3; k++ {
+					t.colors[j][k] *= toLinear(m.Colors[v*3+k])
+				}
+			}
+			for k := 0; k < 3; k++ {
+				s.min[k] = math.Min(s.min[k], t.positions[j][k])
+				s.max[k] = math.Max(s.max[k], t.positions[j][k])
+			}
+		}
+		s.triangles = append(s.triangles, t)
+	}
+}
+
+func (s *Scene) addLight(obj map[string]interface{}, world []float64) {
+	typ, _ := obj[`type`].(string)
+	l := &light{typ: typ, decay: 1}
+	intensity := 1.0
+	if v, ok := obj[`intensity`].(float64); ok {
+		intensity = v
+	}
+	if hex, ok := obj[`color`].(float64); ok {
+		l.color = scale(linearColor(int(hex)), intensity)
+	} else {
+		l.color = [3]float64{intensity, intensity, intensity}
+	}
+	l.position = [3]float64{world[12], world[13], world[14]}
+	// directional lights shine from their position towards their target, which the editor leaves at the origin
+	l.direction = normalize(l.position)
+	if v, ok := obj[`distance`].(float64); ok {
+		l.distance = v
+	}
+	if v, ok := obj[`decay`].(float64); ok {
+		l.decay = v
+	}
+	switch typ {
+	case `HemisphereLight`:
+		l.ground = scale(linearColor(int(floatOr(obj[`groundColor`], 0))), intensity)
+	case `AmbientLight`, `DirectionalLight`, `PointLight`, `SpotLight`:
+	default:
+		return
+	}
+	s.lights = append(s.lights, l)
+}
+
+// The light falling on a face with unit normal n at point p
+func irradiance(n, p [3]float64, lights []*light) [3]float64 {
+	var out [3]float64
+	for _, l := range lights {
+		switch l.typ {
+		case `AmbientLight`:
+			out = add(out, l.color)
+		case `HemisphereLight`:
+			weight := 0.5*dot(n, l.direction) + 0.5
+			out = add(out, add(scale(l.color, weight), scale(l.ground, 1-weight)))
+		case `DirectionalLight`:
+			out = add(out, scale(l.color, math.Max(dot(n, l.direction), 0)))
+		case `PointLight`, `SpotLight`:
+			toLight := sub(l.position, p)
+			attenuation := 1.0
+			if l.distance > 0 {
+				attenuation = math.Pow(clamp(1-length(toLight)/l.distance, 0, 1), l.decay)
+			}
+			out = add(out, scale(l.color, attenuation*math.Max(dot(n, normalize(toLight)), 0)))
+		}
+	}
+	return out
+}
+
+// Three.js colors are sRGB, lighting is summed in linear space
+func linearColor(hex int) [3]float64 {
+	return [3]float64{
+		toLinear(float64(hex>>16&0xff) / 255),
+		toLinear(float64(hex>>8&0xff) / 255),
+		toLinear(float64(hex&0xff) / 255),
+	}
+}
+
+func toLinear(c float64) float64 {
+	if c < 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func toSrgb(c float64) float64 {
+	c = clamp(c, 0, 1)
+	if c < 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+func add(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func scale(a [3]float64, f float64) [3]float64 {
+	return [3]float64{a[0] * f, a[1] * f, a[2] * f}
+}
+
+func mul(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] * b[0], a[1] * b[1], a[2] * b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func length(a [3]float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+func normalize(a [3]float64) [3]float64 {
+	l := length(a)
+	if l == 0 {
+		return a
+	}
+	return scale(a, 1/l)
+}
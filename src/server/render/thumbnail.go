@@ -0,0 +1,180 @@
+package render
+
+import (
+	"bytes"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	ThumbnailWidth  = 320
+	ThumbnailHeight = 240
+	// saves arriving faster than thumbnails are drawn beyond this are left for the sub handler to draw when asked
+	thumbnailQueueLen = 64
+)
+
+// The scene document drawn from its saved camera as a PNG thumbnail
+func Thumbnail(doc []byte) ([]byte, error) {
+	g, err := scene.ParseGraph(doc)
+	if err != nil {
+		return nil, err
+	}
+	s := NewScene(g)
+	img := s.Draw(s.Camera(float64(ThumbnailWidth)/ThumbnailHeight), ThumbnailWidth, ThumbnailHeight)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Wraps store so a thumbnail of each scene is drawn into thumbnailDir in the background whenever it is saved. store
+// must put geometry inline, as scene.WithAssets does.
+func WithThumbnails(store scene.Store, thumbnailDir string, log golog.Log) (scene.Store, error) {
+	if err := os.MkdirAll(thumbnailDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	s := &thumbnailStore{
+		Store:   store,
+		dir:     thumbnailDir,
+		log:     log,
+		queue:   make(chan string, thumbnailQueueLen),
+		pending: map[string]bool{},
+	}
+	go s.run()
+	return s, nil
+}
+
+type thumbnailStore struct {
+	scene.Store
+	dir     string
+	log     golog.Log
+	queue   chan string
+	mtx     sync.Mutex
+	pending map[string]bool
+}
+
+func (s *thumbnailStore) Create(name string, author string, doc []byte) (*scene.Meta, error) {
+	meta, err := s.Store.Create(name, author, doc)
+	if err == nil {
+		s.enqueue(meta.Id)
+	}
+	return meta, err
+}
+
+func (s *thumbnailStore) Replace(id string, name string, author string, base int, doc []byte) (*scene.Meta, error) {
+	meta, err := s.Store.Replace(id, name, author, base, doc)
+	if err == nil {
+		s.enqueue(id)
+	}
+	return meta, err
+}
+
+func (s *thumbnailStore) Restore(id string, number int, author string, base int) (*scene.Meta, error) {
+	meta, err := s.Store.Restore(id, number, author, base)
+	if err == nil {
+		s.enqueue(id)
+	}
+	return meta, err
+}
+
+func (s *thumbnailStore) Delete(id string) error {
+	if err := s.Store.Delete(id); err != nil {
+		return err
+	}
+	os.Remove(thumbnailFile(s.dir, id))
+	return nil
+}
+
+// A scene saved again before its thumbnail is drawn is only drawn once
+func (s *thumbnailStore) enqueue(id string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.pending[id] {
+		return
+	}
+	select {
+	case s.queue <- id:
+		s.pending[id] = true
+	default:
+	}
+}
+
+func (s *thumbnailStore) run() {
+	for id := range s.queue {
+		s.mtx.Lock()
+		delete(s.pending, id)
+		s.mtx.Unlock()
+		if _, err := writeThumbnail(s.Store, s.dir, id); err != nil && !scene.IsNotFound(err) {
+			s.log.Error(`failed to draw thumbnail of scene `, id, `: `, err)
+		}
+	}
+}
+
+func thumbnailFile(dir, id string) string {
+	return filepath.Join(dir, id+`.png`)
+}
+
+// Draws the head revision of the scene and replaces its thumbnail file, returning the png
+func writeThumbnail(store scene.Store, dir, id string) ([]byte, error) {
+	_, doc, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := Thumbnail(doc)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, os.Rename(tmp.Name(), thumbnailFile(dir, id))
+}
+
+// Serves GET /api/scenes/{id}/thumbnail as a PNG of the head revision, drawing it first when the thumbnail written on
+// save is missing or older than the scene
+func NewThumbnailSubHandler(store scene.Store, thumbnailDir string, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` && r.Method != `HEAD` {
+			api.MethodNotAllowed(w, `GET`, `HEAD`)
+			return
+		}
+		meta, _, err := store.Get(id)
+		if err != nil {
+			scene.WriteError(w, log, err)
+			return
+		}
+		w.Header().Set(`Content-Type`, `image/png`)
+		w.Header().Set(`Cache-Control`, `no-cache`)
+		if info, err := os.Stat(thumbnailFile(thumbnailDir, id)); err == nil && !info.ModTime().Before(meta.Modified) {
+			http.ServeFile(w, r, thumbnailFile(thumbnailDir, id))
+			return
+		}
+		data, err := writeThumbnail(store, thumbnailDir, id)
+		if err != nil {
+			scene.WriteError(w, log, err)
+			return
+		}
+		http.ServeContent(w, r, ``, meta.Modified, bytes.NewReader(data))
+	}
+}
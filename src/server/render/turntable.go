@@ -0,0 +1,125 @@
+package render
+
+import (
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	maxTurntableFrames = 360
+	maxTurntableSize   = 1024
+	// frames times pixels per frame, so a preview can not tie the server up for minutes
+	maxTurntablePixels = 120 * 640 * 480
+	// the editor's default camera looks at the origin from this direction
+	defaultAzimuth = 45.0
+)
+
+// The content type of each format turntables are encoded as
+var TurntableFormats = map[string]string{
+	`gif`:  `image/gif`,
+	`apng`: `image/apng`,
+}
+
+// An orbit of the camera around the scene's bounding box, drawn as the frames of a looping preview
+type Turntable struct {
+	Frames int
+	Width  int
+	Height int
+	// how long each frame is shown
+	Delay time.Duration
+	// degrees the camera looks down on the scene from
+	Elevation float64
+}
+
+func DefaultTurntable() *Turntable {
+	return &Turntable{Frames: 36, Width: ThumbnailWidth, Height: ThumbnailHeight, Delay: 80 * time.Millisecond, Elevation: 25}
+}
+
+type invalidTurntableError struct {
+	reason string
+}
+
+func (e *invalidTurntableError) Error() string {
+	return `invalid turntable: ` + e.reason
+}
+
+func IsInvalidTurntable(err error) bool {
+	_, ok := err.(*invalidTurntableError)
+	return ok
+}
+
+func (t *Turntable) Validate() error {
+	switch {
+	case t.Frames < 1 || t.Frames > maxTurntableFrames:
+		return &invalidTurntableError{reason: `frames must be between 1 and ` + strconv.Itoa(maxTurntableFrames)}
+	case t.Width < 1 || t.Width > maxTurntableSize || t.Height < 1 || t.Height > maxTurntableSize:
+		return &invalidTurntableError{reason: `width and height must be between 1 and ` + strconv.Itoa(maxTurntableSize)}
+	case t.Frames*t.Width*t.Height > maxTurntablePixels:
+		return &invalidTurntableError{reason: `too many frames of that size`}
+	case t.Delay < 10*time.Millisecond || t.Delay > 10*time.Second:
+		return &invalidTurntableError{reason: `delay must be between 10ms and 10s`}
+	case t.Elevation < -90 || t.Elevation > 90:
+		return &invalidTurntableError{reason: `elevation must be between -90 and 90 degrees`}
+	}
+	return nil
+}
+
+// Draws one frame for each step of a full turn around the scene, starting from the side the saved camera looks at
+// and far enough away that the whole bounding box stays in view
+func (t *Turntable) Draw(s *Scene) ([]*image.NRGBA, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	center, radius := [3]float64{}, 100.0
+	if !s.Empty() {
+		min, max := s.Bounds()
+		center = scale(add(min, max), 0.5)
+		if r := length(sub(max, min)) / 2; r > 0 {
+			radius = r
+		}
+	}
+	aspect := float64(t.Width) / float64(t.Height)
+	halfFov := defaultFov * math.Pi / 360
+	if aspect < 1 {
+		halfFov = math.Atan(math.Tan(halfFov) * aspect)
+	}
+	distance := radius / math.Sin(halfFov) * 1.05
+	near, far := math.Max(distance-radius*1.5, distance/1000), distance+radius*1.5
+
+	azimuth := defaultAzimuth * math.Pi / 180
+	if world := s.Camera(aspect).World; world != nil {
+		if x, z := world[12]-center[0], world[14]-center[2]; x != 0 || z != 0 {
+			azimuth = math.Atan2(x, z)
+		}
+	}
+	elevation := t.Elevation * math.Pi / 180
+	frames := make([]*image.NRGBA, t.Frames)
+	for i := range frames {
+		angle := azimuth + 2*math.Pi*float64(i)/float64(t.Frames)
+		eye := add(center, scale([3]float64{math.Cos(elevation) * math.Sin(angle), math.Sin(elevation), math.Cos(elevation) * math.Cos(angle)}, distance))
+		frames[i] = s.Draw(NewPerspectiveCamera(eye, center, defaultFov, aspect, near, far), t.Width, t.Height)
+	}
+	return frames, nil
+}
+
+// Encodes frames drawn by the turntable in format, gif or apng
+func (t *Turntable) Encode(w io.Writer, format string, frames []*image.NRGBA) error {
+	switch format {
+	case `gif`:
+		return EncodeGif(w, frames, t.Delay)
+	case `apng`:
+		return EncodeApng(w, frames, t.Delay)
+	}
+	return &unknownFormatError{format: format}
+}
+
+type unknownFormatError struct {
+	format string
+}
+
+func (e *unknownFormatError) Error() string {
+	return `unknown format "` + e.format + `", expected gif or apng`
+}
@@ -0,0 +1,250 @@
+/*
+Checks the geometries drawn by the meshes of a scene for the faults that make them print or render badly, and repairs
+the ones that can be fixed without guessing at what the author meant
+*/
+package repair
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+)
+
+const (
+	// positions closer than this fraction of the geometry's bounding box diagonal are the same point
+	positionTolerance = 1e-6
+	// normals, uvs and colors closer than this are the same
+	attributeTolerance = 1e-6
+)
+
+type Report struct {
+	Geometries []*GeometryReport `json:"geometries"`
+	// the number of geometries with problems
+	Problems int `json:"problems"`
+}
+
+// An object drawing a geometry
+type Object struct {
+	Uuid string `json:"uuid"`
+	Name string `json:"name,omitempty"`
+}
+
+// What was found in one geometry, every count is zero when it could not be read
+type GeometryReport struct {
+	Geometry string    `json:"geometry"`
+	Name     string    `json:"name,omitempty"`
+	Type     string    `json:"type"`
+	Objects  []*Object `json:"objects"`
+	// why the geometry could not be read
+	Error     string `json:"error,omitempty"`
+	Vertices  int    `json:"vertices"`
+	Triangles int    `json:"triangles"`
+	// edges shared by more than two triangles
+	NonManifoldEdges int `json:"nonManifoldEdges"`
+	// edges of a single triangle. Not a problem in itself as planes are open, but a solid to print should have none.
+	OpenEdges int `json:"openEdges"`
+	// triangles without area
+	DegenerateTriangles int `json:"degenerateTriangles"`
+	// vertices the same as an earlier vertex in every attribute
+	DuplicateVertices int `json:"duplicateVertices"`
+	// edges whose two triangles both run along it the same way, so one of them faces backwards
+	InconsistentWinding int `json:"inconsistentWinding"`
+	// the geometry has no normals so lit materials shade it wrongly
+	MissingNormals bool `json:"missingNormals"`
+	// vertex normals of zero length or that are not numbers
+	InvalidNormals int `json:"invalidNormals"`
+	// triangles using a vertex index past the last vertex, they are not drawn
+	OutOfRangeTriangles int `json:"outOfRangeTriangles"`
+}
+
+// Whether the geometry could be read and has none of the problems checked for
+func (r *GeometryReport) Ok() bool {
+	return r.Error == `` && r.NonManifoldEdges == 0 && r.DegenerateTriangles == 0 && r.DuplicateVertices == 0 &&
+		r.InconsistentWinding == 0 && !r.MissingNormals && r.InvalidNormals == 0 && r.OutOfRangeTriangles == 0
+}
+
+// Reports on every geometry drawn by a mesh in g, in the order the meshes are found walking the scene
+func Check(g *scene.Graph) *Report {
+	report := &Report{Geometries: []*GeometryReport{}}
+	for _, r := range surfaces(g) {
+		// geometries missing from the scene already have their error
+		if r.Error == `` {
+			if m, dropped, err := mesh.FromJsonDropping(g.Geometries[r.Geometry]); err != nil {
+				r.Error = err.Error()
+			} else {
+				r.measure(m)
+				r.OutOfRangeTriangles = dropped
+			}
+		}
+		if !r.Ok() {
+			report.Problems++
+		}
+		report.Geometries = append(report.Geometries, r)
+	}
+	return report
+}
+
+// An empty report for each geometry drawn by a mesh in g, listing the meshes drawing it
+func surfaces(g *scene.Graph) []*GeometryReport {
+	reports := []*GeometryReport{}
+	byUuid := map[string]*GeometryReport{}
+	g.Walk(func(node *scene.Node) {
+		typ, _ := node.Object[`type`].(string)
+		uuid, _ := node.Object[`geometry`].(string)
+		if !mesh.IsSurface(typ) || uuid == `` {
+			return
+		}
+		r := byUuid[uuid]
+		if r == nil {
+			r = &GeometryReport{Geometry: uuid, Objects: []*Object{}}
+			if geometry := g.Geometries[uuid]; geometry != nil {
+				r.Name, _ = geometry[`name`].(string)
+				r.Type, _ = geometry[`type`].(string)
+			} else {
+				r.Error = `missing from the scene`
+			}
+			byUuid[uuid] = r
+			reports = append(reports, r)
+		}
+		name, _ := node.Object[`name`].(string)
+		r.Objects = append(r.Objects, &Object{Uuid: node.Uuid, Name: name})
+	})
+	return reports
+}
+
+type edge struct {
+	triangles int32
+	// of the triangles, how many run from the lower to the higher vertex
+	forward int32
+}
+
+func (r *GeometryReport) measure(m *mesh.Mesh) {
+	r.Vertices, r.Triangles = m.VertexCount(), m.TriangleCount()
+	t := newTolerance(m)
+	_, unique := weld(m, t, true)
+	r.DuplicateVertices = m.VertexCount() - unique
+
+	// the surface is followed through the positions, vertices are split wherever a uv or normal seam runs
+	points, _ := weld(m, t, false)
+	edges := map[uint64]edge{}
+	for i := 0; i < m.TriangleCount(); i++ {
+		if t.degenerate(m, points, i) {
+			r.DegenerateTriangles++
+			continue
+		}
+		a, b, c := m.Triangle(i)
+		corners := [3]int{points[a], points[b], points[c]}
+		for k, from := range corners {
+			to := corners[(k+1)%3]
+			low, high := from, to
+			if low > high {
+				low, high = high, low
+			}
+			key := uint64(low)<<32 | uint64(high)
+			e := edges[key]
+			e.triangles++
+			if from < to {
+				e.forward++
+			}
+			edges[key] = e
+		}
+	}
+	for _, e := range edges {
+		switch {
+		case e.triangles == 1:
+			r.OpenEdges++
+		case e.triangles > 2:
+			r.NonManifoldEdges++
+		case e.forward != 1:
+			r.InconsistentWinding++
+		}
+	}
+
+	if m.Normals == nil {
+		r.MissingNormals = true
+	} else {
+		for i := 0; i < m.VertexCount(); i++ {
+			if !validNormal(m, i) {
+				r.InvalidNormals++
+			}
+		}
+	}
+}
+
+func validNormal(m *mesh.Mesh, i int) bool {
+	x, y, z := m.Normals[i*3], m.Normals[i*3+1], m.Normals[i*3+2]
+	length := math.Sqrt(x*x + y*y + z*z)
+	return length > attributeTolerance && !math.IsInf(length, 0)
+}
+
+type tolerance struct {
+	// the grid positions are snapped to when comparing them
+	step float64
+	// triangles with no more than this area are degenerate
+	area float64
+}
+
+func newTolerance(m *mesh.Mesh) *tolerance {
+	min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i := 0; i < m.VertexCount(); i++ {
+		v := m.Vertex(i)
+		for k := range v {
+			min[k], max[k] = math.Min(min[k], v[k]), math.Max(max[k], v[k])
+		}
+	}
+	dx, dy, dz := max[0]-min[0], max[1]-min[1], max[2]-min[2]
+	step := math.Sqrt(dx*dx+dy*dy+dz*dz) * positionTolerance
+	if !(step > 0) || math.IsInf(step, 0) {
+		step = positionTolerance
+	}
+	return &tolerance{step: step, area: step * step}
+}
+
+// For each vertex the first vertex at the same position, or with every attribute the same when all is true, and how
+// many distinct vertices there are
+func weld(m *mesh.Mesh, t *tolerance, all bool) ([]int, int) {
+	type key [11]float64
+	snap := func(v, step float64) float64 {
+		return math.Floor(v/step + 0.5)
+	}
+	first := map[key]int{}
+	same := make([]int, m.VertexCount())
+	for i := range same {
+		var k key
+		for j := 0; j < 3; j++ {
+			k[j] = snap(m.Positions[i*3+j], t.step)
+		}
+		if all {
+			for j := 0; j < 3 && m.Normals != nil; j++ {
+				k[3+j] = snap(m.Normals[i*3+j], attributeTolerance)
+			}
+			for j := 0; j < 2 && m.Uvs != nil; j++ {
+				k[6+j] = snap(m.Uvs[i*2+j], attributeTolerance)
+			}
+			for j := 0; j < 3 && m.Colors != nil; j++ {
+				k[8+j] = snap(m.Colors[i*3+j], attributeTolerance)
+			}
+		}
+		if j, exists := first[k]; exists {
+			same[i] = j
+		} else {
+			first[k] = i
+			same[i] = i
+		}
+	}
+	return same, len(first)
+}
+
+// Whether triangle i has no area, either two of its corners are at the same point or they lie on a line
+func (t *tolerance) degenerate(m *mesh.Mesh, points []int, i int) bool {
+	a, b, c := m.Triangle(i)
+	if points[a] == points[b] || points[b] == points[c] || points[a] == points[c] {
+		return true
+	}
+	pa, pb, pc := m.Vertex(a), m.Vertex(b), m.Vertex(c)
+	ux, uy, uz := pb[0]-pa[0], pb[1]-pa[1], pb[2]-pa[2]
+	vx, vy, vz := pc[0]-pa[0], pc[1]-pa[1], pc[2]-pa[2]
+	cx, cy, cz := uy*vz-uz*vy, uz*vx-ux*vz, ux*vy-uy*vx
+	return !(math.Sqrt(cx*cx+cy*cy+cz*cz)/2 > t.area)
+}
@@ -0,0 +1,111 @@
+package repair
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+)
+
+const (
+	// saves racing other saves are repaired again from the new head this many times before giving up
+	maxAttempts = 3
+)
+
+type repairResult struct {
+	*scene.Meta
+	Repaired []*Repaired `json:"repaired"`
+	// the check of the scene as saved
+	Report *Report `json:"report"`
+}
+
+// Serves GET /api/scenes/{id}/check?revision={n} reporting the problems in the scene's meshes, revision defaults to
+// the head revision
+func NewCheckSubHandler(store scene.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		rev := scene.ReadRequestedRevision(w, r, store, log, id)
+		if rev == nil {
+			return
+		}
+		api.WriteJson(w, http.StatusOK, Check(rev.Graph))
+	}
+}
+
+// Serves POST /api/scenes/{id}/repair?geometry={uuid} repairing the geometries of the head revision, or only those
+// given by any number of geometry parameters, and saving the result as a new revision. Nothing is saved when there
+// was nothing to repair. ?author= and ?holder= are used as when saving a scene, guard may veto the save with a 423 as
+// it does for saves from the editor.
+func NewSubHandler(store scene.Store, guard scene.SaveGuard, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `POST` {
+			api.MethodNotAllowed(w, `POST`)
+			return
+		}
+
+		query := r.URL.Query()
+		for attempt := 1; ; attempt++ {
+			head, doc, err := store.Get(id)
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			g, err := scene.ParseGraph(doc)
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			repaired, err := Repair(g, query[`geometry`])
+			if err != nil {
+				api.WriteError(w, http.StatusNotFound, err)
+				return
+			}
+			changed := false
+			for _, result := range repaired {
+				changed = changed || result.changed()
+			}
+			if !changed {
+				api.WriteJson(w, http.StatusOK, &repairResult{Meta: head, Repaired: repaired, Report: Check(g)})
+				return
+			}
+			after, err := json.Marshal(g.Document())
+			if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			if guard != nil {
+				if err := guard(id, query.Get(`holder`), doc, after); err != nil {
+					if scene.IsInvalid(err) {
+						scene.WriteError(w, log, err)
+					} else {
+						api.WriteError(w, http.StatusLocked, err)
+					}
+					return
+				}
+			}
+			meta, err := store.Replace(id, ``, query.Get(`author`), head.Revision, after)
+			if scene.IsHeadMoved(err) && attempt < maxAttempts {
+				continue
+			} else if err != nil {
+				scene.WriteError(w, log, err)
+				return
+			}
+			log.Info(`repaired the geometries of scene `, id)
+			api.WriteJson(w, http.StatusOK, &repairResult{Meta: meta, Repaired: repaired, Report: Check(g)})
+			return
+		}
+	}
+}
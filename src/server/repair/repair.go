@@ -0,0 +1,205 @@
+package repair
+
+import (
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+)
+
+// What Repair did to one geometry
+type Repaired struct {
+	Geometry string `json:"geometry"`
+	// why the geometry was left as it was
+	Skipped string `json:"skipped,omitempty"`
+	// vertices merged into an earlier identical vertex
+	Welded int `json:"welded"`
+	// degenerate and out of range triangles removed
+	DegenerateTriangles int `json:"degenerateTriangles"`
+	OutOfRangeTriangles int `json:"outOfRangeTriangles"`
+	// missing or invalid vertex normals computed from the triangles around them
+	Normals int `json:"normals"`
+}
+
+type noSuchGeometryError struct {
+	uuid string
+}
+
+func (e *noSuchGeometryError) Error() string {
+	return `No mesh draws geometry ` + e.uuid
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*noSuchGeometryError)
+	return ok
+}
+
+func (r *Repaired) changed() bool {
+	return r.Welded > 0 || r.DegenerateTriangles > 0 || r.OutOfRangeTriangles > 0 || r.Normals > 0
+}
+
+// Welds identical vertices, drops degenerate and out of range triangles and computes missing normals in the geometries
+// drawn by meshes in g, or only those listed in geometries when it is not empty. The geometries changed are replaced
+// in g by an indexed BufferGeometry. Parametric geometries and geometries holding data the repair would lose, like
+// skin weights or morph targets, are skipped.
+func Repair(g *scene.Graph, geometries []string) ([]*Repaired, error) {
+	reports := surfaces(g)
+	only := map[string]bool{}
+	for _, uuid := range geometries {
+		only[uuid] = true
+	}
+	for uuid := range only {
+		found := false
+		for _, r := range reports {
+			found = found || r.Geometry == uuid
+		}
+		if !found {
+			return nil, &noSuchGeometryError{uuid: uuid}
+		}
+	}
+	repaired := []*Repaired{}
+	for _, r := range reports {
+		if len(only) > 0 && !only[r.Geometry] {
+			continue
+		}
+		geometry := g.Geometries[r.Geometry]
+		result := &Repaired{Geometry: r.Geometry}
+		repaired = append(repaired, result)
+		if r.Error != `` {
+			result.Skipped = r.Error
+			continue
+		}
+		if result.Skipped = lossy(geometry); result.Skipped != `` {
+			continue
+		}
+		m, dropped, err := mesh.FromJsonDropping(geometry)
+		if err != nil {
+			result.Skipped = err.Error()
+			continue
+		}
+		result.OutOfRangeTriangles = dropped
+		repairMesh(m, result)
+		if result.changed() {
+			g.Geometries[r.Geometry] = m.ToJson(r.Geometry, r.Name)
+		}
+	}
+	return repaired, nil
+}
+
+// Why writing the geometry back as a BufferGeometry of positions, normals, uvs, colors and groups would lose some of
+// it, empty when nothing would be lost
+func lossy(geometry map[string]interface{}) string {
+	data, ok := geometry[`data`].(map[string]interface{})
+	if !ok {
+		return `parametric geometries are generated without these problems`
+	}
+	known := map[string]bool{`metadata`: true}
+	if typ, _ := geometry[`type`].(string); typ == `BufferGeometry` {
+		for _, key := range []string{`attributes`, `index`, `groups`, `boundingSphere`} {
+			known[key] = true
+		}
+		attributes, _ := data[`attributes`].(map[string]interface{})
+		for name := range attributes {
+			if name != `position` && name != `normal` && name != `uv` && name != `color` {
+				return `the ` + name + ` attribute would be lost`
+			}
+		}
+	} else {
+		for _, key := range []string{`vertices`, `faces`, `normals`, `uvs`, `scale`} {
+			known[key] = true
+		}
+		if colors, _ := data[`colors`].([]interface{}); len(colors) == 0 {
+			known[`colors`] = true
+		}
+		if uvs, _ := data[`uvs`].([]interface{}); len(uvs) > 1 {
+			if second, _ := uvs[1].([]interface{}); len(second) > 0 {
+				return `the second uv layer would be lost`
+			}
+		}
+	}
+	for key, value := range data {
+		if list, isList := value.([]interface{}); !known[key] && !(isList && len(list) == 0) {
+			return key + ` would be lost`
+		}
+	}
+	return ``
+}
+
+func repairMesh(m *mesh.Mesh, result *Repaired) {
+	t := newTolerance(m)
+	same, _ := weld(m, t, true)
+	for i, v := range m.Indices {
+		m.Indices[i] = same[v]
+	}
+	for i, j := range same {
+		if i != j {
+			result.Welded++
+		}
+	}
+
+	points, _ := weld(m, t, false)
+	before := m.TriangleCount()
+	m.FilterTriangles(func(i int) bool {
+		return !t.degenerate(m, points, i)
+	})
+	result.DegenerateTriangles = before - m.TriangleCount()
+	removeUnused(m)
+
+	if m.Normals == nil {
+		m.ComputeNormals()
+		result.Normals = m.VertexCount()
+		return
+	}
+	var invalid []int
+	for i := 0; i < m.VertexCount(); i++ {
+		if !validNormal(m, i) {
+			invalid = append(invalid, i)
+		}
+	}
+	if len(invalid) > 0 {
+		normals := m.Normals
+		m.ComputeNormals()
+		for _, i := range invalid {
+			copy(normals[i*3:i*3+3], m.Normals[i*3:i*3+3])
+		}
+		m.Normals = normals
+		result.Normals = len(invalid)
+	}
+}
+
+// Drops the vertices no triangle uses, keeping the order of the rest
+func removeUnused(m *mesh.Mesh) {
+	used := make([]bool, m.VertexCount())
+	for _, v := range m.Indices {
+		used[v] = true
+	}
+	moved := make([]int, m.VertexCount())
+	count := 0
+	for i := range moved {
+		moved[i] = -1
+		if used[i] {
+			moved[i] = count
+			count++
+		}
+	}
+	if count == m.VertexCount() {
+		return
+	}
+	compact := func(values []float64, itemSize int) []float64 {
+		if values == nil {
+			return nil
+		}
+		out := make([]float64, 0, count*itemSize)
+		for i, to := range moved {
+			if to >= 0 {
+				out = append(out, values[i*itemSize:(i+1)*itemSize]...)
+			}
+		}
+		return out
+	}
+	m.Positions = compact(m.Positions, 3)
+	m.Normals = compact(m.Normals, 3)
+	m.Uvs = compact(m.Uvs, 2)
+	m.Colors = compact(m.Colors, 3)
+	for i, v := range m.Indices {
+		m.Indices[i] = moved[v]
+	}
+}
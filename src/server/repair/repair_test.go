@@ -0,0 +1,239 @@
+package repair
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/scene"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A tetrahedron with outward facing triangles
+var (
+	tetrahedron = []float64{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1}
+	tetraFaces  = []int{0, 2, 1, 0, 1, 3, 0, 3, 2, 1, 2, 3}
+)
+
+// A BufferGeometry json object, normals are left out when nil and so is the index
+func bufferGeometry(uuid string, positions []float64, index []int, normals []float64) string {
+	attributes := map[string]interface{}{`position`: map[string]interface{}{`itemSize`: 3, `type`: `Float32Array`, `array`: positions}}
+	if normals != nil {
+		attributes[`normal`] = map[string]interface{}{`itemSize`: 3, `type`: `Float32Array`, `array`: normals}
+	}
+	data := map[string]interface{}{`attributes`: attributes}
+	if index != nil {
+		data[`index`] = map[string]interface{}{`type`: `Uint16Array`, `array`: index}
+	}
+	geometry, _ := json.Marshal(map[string]interface{}{`uuid`: uuid, `type`: `BufferGeometry`, `data`: data})
+	return string(geometry)
+}
+
+// Unindexed positions of the triangles, as exporters that write every triangle separately do
+func unindexed(positions []float64, index []int) []float64 {
+	out := []float64{}
+	for _, v := range index {
+		out = append(out, positions[v*3:v*3+3]...)
+	}
+	return out
+}
+
+// Outward normals of the tetrahedron's corners, not unit length but valid
+func tetraNormals() []float64 {
+	return []float64{-1, -1, -1, 1, 0, 0, 0, 1, 0, 0, 0, 1}
+}
+
+// A graph with a mesh A drawing each geometry, geometries are json objects with a uuid
+func testGraph(t *testing.T, geometries ...string) *scene.Graph {
+	objects := []string{}
+	for i, geometry := range geometries {
+		var g struct{ Uuid string }
+		json.Unmarshal([]byte(geometry), &g)
+		objects = append(objects, `{"uuid":"`+string(rune('A'+i))+`","type":"Mesh","geometry":"`+g.Uuid+`","material":"M"}`)
+	}
+	g, err := scene.ParseGraph([]byte(`{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"metadata":{},` +
+		`"geometries":[` + strings.Join(geometries, `,`) + `],"materials":[{"uuid":"M","type":"MeshStandardMaterial"}],` +
+		`"object":{"uuid":"S","type":"Scene","children":[` + strings.Join(objects, `,`) + `]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestCheck(t *testing.T) {
+	flipped := append([]int{}, tetraFaces...)
+	flipped[1], flipped[2] = flipped[2], flipped[1]
+	tests := []struct {
+		name     string
+		geometry string
+		want     GeometryReport
+	}{
+		{
+			name:     `closed and indexed`,
+			geometry: bufferGeometry(`G`, tetrahedron, tetraFaces, tetraNormals()),
+			want:     GeometryReport{Vertices: 4, Triangles: 4},
+		},
+		{
+			name:     `parametric box`,
+			geometry: `{"uuid":"G","type":"BoxGeometry","width":1,"height":1,"depth":1}`,
+			want:     GeometryReport{Vertices: 24, Triangles: 12},
+		},
+		{
+			name:     `separate triangles without normals`,
+			geometry: bufferGeometry(`G`, unindexed(tetrahedron, tetraFaces), nil, nil),
+			want:     GeometryReport{Vertices: 12, Triangles: 4, DuplicateVertices: 8, MissingNormals: true},
+		},
+		{
+			name:     `open`,
+			geometry: bufferGeometry(`G`, tetrahedron, tetraFaces[:9], tetraNormals()),
+			want:     GeometryReport{Vertices: 4, Triangles: 3, OpenEdges: 3},
+		},
+		{
+			name:     `flipped triangle`,
+			geometry: bufferGeometry(`G`, tetrahedron, flipped, tetraNormals()),
+			want:     GeometryReport{Vertices: 4, Triangles: 4, InconsistentWinding: 3},
+		},
+		{
+			name:     `degenerate triangle`,
+			geometry: bufferGeometry(`G`, tetrahedron, append(append([]int{}, tetraFaces...), 0, 1, 1), tetraNormals()),
+			want:     GeometryReport{Vertices: 4, Triangles: 5, DegenerateTriangles: 1},
+		},
+		{
+			name:     `non manifold edge`,
+			geometry: bufferGeometry(`G`, append(append([]float64{}, tetrahedron...), 1, 1, 1), append(append([]int{}, tetraFaces...), 1, 2, 4), append(tetraNormals(), 1, 1, 1)),
+			want:     GeometryReport{Vertices: 5, Triangles: 5, NonManifoldEdges: 1, OpenEdges: 2},
+		},
+		{
+			name:     `invalid normal`,
+			geometry: bufferGeometry(`G`, tetrahedron, tetraFaces, []float64{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1}),
+			want:     GeometryReport{Vertices: 4, Triangles: 4, InvalidNormals: 1},
+		},
+		{
+			name:     `index out of range`,
+			geometry: bufferGeometry(`G`, tetrahedron, append(append([]int{}, tetraFaces...), 0, 1, 9), tetraNormals()),
+			want:     GeometryReport{Vertices: 4, Triangles: 4, OutOfRangeTriangles: 1},
+		},
+		{
+			name:     `unsupported type`,
+			geometry: `{"uuid":"G","type":"TextGeometry"}`,
+			want:     GeometryReport{Error: `unsupported geometry type TextGeometry`},
+		},
+	}
+	for _, test := range tests {
+		report := Check(testGraph(t, test.geometry))
+		if len(report.Geometries) != 1 {
+			t.Errorf(`%s: got %d geometries reported`, test.name, len(report.Geometries))
+			continue
+		}
+		got := *report.Geometries[0]
+		if len(got.Objects) != 1 || got.Objects[0].Uuid != `A` {
+			t.Errorf(`%s: got objects %v, want A`, test.name, got.Objects)
+		}
+		got.Objects, got.Type = nil, ``
+		test.want.Geometry = `G`
+		if test.want.Error != `` && strings.HasSuffix(got.Error, test.want.Error) {
+			test.want.Error = got.Error
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf(`%s: got %+v, want %+v`, test.name, got, test.want)
+		}
+		wantProblems := 0
+		if !got.Ok() {
+			wantProblems = 1
+		}
+		if report.Problems != wantProblems {
+			t.Errorf(`%s: got %d problems, want %d`, test.name, report.Problems, wantProblems)
+		}
+	}
+}
+
+func TestCheckMissingGeometry(t *testing.T) {
+	g := testGraph(t, bufferGeometry(`G`, tetrahedron, tetraFaces, tetraNormals()))
+	delete(g.Geometries, `G`)
+	report := Check(g)
+	if report.Problems != 1 || report.Geometries[0].Error != `missing from the scene` {
+		t.Errorf(`got %+v`, report.Geometries[0])
+	}
+}
+
+func TestRepair(t *testing.T) {
+	separate := bufferGeometry(`G`, unindexed(tetrahedron, tetraFaces), nil, nil)
+	tests := []struct {
+		name       string
+		geometries []string
+		only       []string
+		want       []Repaired
+		notFound   bool
+	}{
+		{
+			name:       `separate triangles without normals`,
+			geometries: []string{separate},
+			want:       []Repaired{{Geometry: `G`, Welded: 8, Normals: 4}},
+		},
+		{
+			name:       `degenerate and out of range triangles`,
+			geometries: []string{bufferGeometry(`G`, tetrahedron, append(append([]int{}, tetraFaces...), 0, 1, 1, 0, 1, 9), tetraNormals())},
+			want:       []Repaired{{Geometry: `G`, DegenerateTriangles: 1, OutOfRangeTriangles: 1}},
+		},
+		{
+			name:       `invalid normal`,
+			geometries: []string{bufferGeometry(`G`, tetrahedron, tetraFaces, []float64{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1})},
+			want:       []Repaired{{Geometry: `G`, Normals: 1}},
+		},
+		{
+			name:       `nothing to repair`,
+			geometries: []string{bufferGeometry(`G`, tetrahedron, tetraFaces, tetraNormals())},
+			want:       []Repaired{{Geometry: `G`}},
+		},
+		{
+			name:       `parametric`,
+			geometries: []string{`{"uuid":"G","type":"BoxGeometry","width":1,"height":1,"depth":1}`},
+			want:       []Repaired{{Geometry: `G`, Skipped: `parametric geometries are generated without these problems`}},
+		},
+		{
+			name:       `skinned`,
+			geometries: []string{strings.Replace(separate, `"attributes":{`, `"attributes":{"skinIndex":{"itemSize":4,"type":"Float32Array","array":[]},`, 1)},
+			want:       []Repaired{{Geometry: `G`, Skipped: `the skinIndex attribute would be lost`}},
+		},
+		{
+			name:       `only the listed geometry`,
+			geometries: []string{separate, strings.Replace(separate, `"uuid":"G"`, `"uuid":"H"`, 1)},
+			only:       []string{`H`},
+			want:       []Repaired{{Geometry: `H`, Welded: 8, Normals: 4}},
+		},
+		{
+			name:       `unknown geometry`,
+			geometries: []string{separate},
+			only:       []string{`Z`},
+			notFound:   true,
+		},
+	}
+	for _, test := range tests {
+		g := testGraph(t, test.geometries...)
+		repaired, err := Repair(g, test.only)
+		if test.notFound {
+			if !IsNotFound(err) {
+				t.Errorf(`%s: got %v, want a not found error`, test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			continue
+		}
+		if len(repaired) != len(test.want) {
+			t.Errorf(`%s: got %d geometries repaired, want %d`, test.name, len(repaired), len(test.want))
+			continue
+		}
+		for i, r := range repaired {
+			if *r != test.want[i] {
+				t.Errorf(`%s: got %+v, want %+v`, test.name, *r, test.want[i])
+			}
+		}
+		// a repaired geometry checks clean apart from the seams a parametric or skipped geometry keeps
+		for _, r := range Check(g).Geometries {
+			if r.Geometry == repaired[0].Geometry && repaired[0].Skipped == `` && !r.Ok() {
+				t.Errorf(`%s: still has problems after repair: %+v`, test.name, *r)
+			}
+		}
+	}
+}
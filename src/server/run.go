@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/config"
+	"github.com/robsix/3ditor/src/server/embedded"
+	"github.com/robsix/3ditor/src/server/static"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The settings a reload applies, the rest keep their values until the server is restarted
+var reloadable = map[string]bool{
+	"shutdownSeconds":  true,
+	"publicDir":        true,
+	"embeddedClient":   true,
+	"log.level":        true,
+	"log.lineSpacing":  true,
+	"limits.requestMb": true,
+}
+
+// The running server and the parts of it a reload changes
+type server struct {
+	wd     string
+	args   []string
+	conf   *config.Config
+	log    *config.Logger
+	http   *http.Server
+	static *staticHandler
+	limit  *bodyLimit
+	// called once requests have finished, to close the websockets http.Server does not track
+	onShutdown []func()
+	// the certificate and key files HTTPS is served with, empty for plain HTTP
+	certFile string
+	keyFile  string
+	// redirects plain HTTP to HTTPS, nil when there is no such listener
+	redirect *http.Server
+}
+
+// Serves until SIGINT or SIGTERM, reloading the configuration on SIGHUP, and returns the exit code
+func (s *server) run() int {
+	stopped := make(chan error, 2)
+	go func() {
+		if s.certFile != `` {
+			s.log.Info("server listening for https on ", s.conf.Listen)
+			stopped <- s.http.ListenAndServeTLS(s.certFile, s.keyFile)
+		} else {
+			s.log.Info("server listening on ", s.conf.Listen)
+			stopped <- s.http.ListenAndServe()
+		}
+	}()
+	if s.redirect != nil {
+		go func() {
+			s.log.Info("redirecting http on ", s.redirect.Addr, " to https")
+			stopped <- s.redirect.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case err := <-stopped:
+			s.log.Critical("server stopped: ", err)
+			return 1
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				s.reload()
+				continue
+			}
+			return s.shutdown(sig, signals)
+		}
+	}
+}
+
+// Stops accepting connections and waits up to shutdownSeconds for requests in progress, such as saves, to finish
+// before cutting them off. A second signal cuts them off at once.
+func (s *server) shutdown(sig os.Signal, signals chan os.Signal) int {
+	timeout := time.Duration(s.conf.ShutdownSeconds) * time.Second
+	s.log.Info("received ", sig, ", waiting up to ", timeout, " for requests to finish")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			s.log.Warning("received ", sig, " again, stopping now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	code := 0
+	if s.redirect != nil {
+		s.redirect.Close()
+	}
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.log.Error("cut off requests still in progress: ", err)
+		s.http.Close()
+		code = 1
+	}
+	for _, close := range s.onShutdown {
+		close()
+	}
+	s.log.Info("server stopped")
+	return code
+}
+
+// Reads the configuration again with the arguments the server started with. The settings in reloadable are applied
+// without dropping any connections, changes to the others are logged as needing a restart. A configuration that is not
+// valid is logged and the server carries on as it was.
+func (s *server) reload() {
+	conf, err := config.Load(s.wd, s.args, os.Environ())
+	if err != nil {
+		s.log.Error("failed to reload configuration: ", err)
+		return
+	}
+	applied, restart := []string{}, []string{}
+	for _, key := range s.conf.Changed(conf) {
+		if reloadable[key] {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+
+	// the other settings keep their running values so the next reload reports them again
+	s.conf.ShutdownSeconds = conf.ShutdownSeconds
+	s.conf.PublicDir, s.conf.EmbeddedClient = conf.PublicDir, conf.EmbeddedClient
+	s.conf.Log.Level, s.conf.Log.LineSpacing = conf.Log.Level, conf.Log.LineSpacing
+	s.conf.Limits = conf.Limits
+	s.log.Reload(&s.conf.Log)
+	files, from := clientFiles(s.conf)
+	s.static.setFiles(files)
+	s.limit.setMb(s.conf.Limits.RequestMb)
+
+	if len(applied) == 0 {
+		s.log.Info("reloaded configuration, nothing to apply")
+	} else {
+		s.log.Info("reloaded configuration, applied: ", strings.Join(applied, ", "))
+	}
+	for _, key := range applied {
+		if key == "publicDir" || key == "embeddedClient" {
+			s.log.Info("serving static files from: ", from)
+			break
+		}
+	}
+	if len(restart) > 0 {
+		s.log.Warning("restart the server to apply: ", strings.Join(restart, ", "))
+	}
+}
+
+// The client files the configuration says to serve, those embedded in the binary or else those in publicDir, and
+// where they are from for the log
+func clientFiles(conf *config.Config) (http.FileSystem, string) {
+	if files, ok := embedded.Client(); ok && conf.EmbeddedClient {
+		return http.FS(files), "the client embedded in the server"
+	}
+	return http.Dir(string(conf.PublicDir)), string(conf.PublicDir)
+}
+
+// Redirects every request to the same URL on the https listen address. The redirect is temporary so browsers do not
+// remember it should the server go back to plain HTTP.
+func redirectToHttps(listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(listen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "443" && port != "https" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+// Serves the client from files that can be changed while requests are being served
+type staticHandler struct {
+	log     golog.Log
+	handler atomic.Value
+}
+
+func newStaticHandler(files http.FileSystem, log golog.Log) *staticHandler {
+	h := &staticHandler{log: log}
+	h.setFiles(files)
+	return h
+}
+
+func (h *staticHandler) setFiles(files http.FileSystem) {
+	h.handler.Store(static.NewHandler(files, h.log))
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// Refuses request bodies larger than a limit that can be changed while requests are being served, 0 is no limit
+type bodyLimit struct {
+	handler http.Handler
+	limit   int64
+}
+
+func (h *bodyLimit) setMb(mb int) {
+	atomic.StoreInt64(&h.limit, int64(mb)<<20)
+}
+
+func (h *bodyLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if limit := atomic.LoadInt64(&h.limit); limit > 0 {
+		if r.ContentLength > limit {
+			api.WriteError(w, http.StatusRequestEntityTooLarge, &bodyTooLargeError{limit: limit})
+			return
+		}
+		// bodies of unknown length fail to read past the limit instead
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return "request body is larger than the limit of " + strconv.FormatInt(e.limit>>20, 10) + " MB"
+}
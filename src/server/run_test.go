@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/robsix/3ditor/src/server/config"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A server as main makes it, configured from the conf.json in wd, without any of the api handlers
+func newTestServer(t *testing.T, wd string) *server {
+	conf, err := config.Load(wd, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Log.Output = `none`
+	log, err := conf.Log.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := clientFiles(conf)
+	static := newStaticHandler(files, log)
+	limit := &bodyLimit{handler: static}
+	limit.setMb(conf.Limits.RequestMb)
+	return &server{wd: wd, conf: conf, log: log, http: &http.Server{Handler: limit}, static: static, limit: limit}
+}
+
+func writeConf(t *testing.T, wd, conf string) {
+	if err := ioutil.WriteFile(filepath.Join(wd, config.DefaultFile), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// signal again while the request is still in progress
+		again bool
+		code  int
+	}{
+		{name: `waits for requests`, code: 0},
+		{name: `second signal cuts requests off`, again: true, code: 1},
+	}
+	for _, test := range tests {
+		listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		started, release := make(chan struct{}), make(chan struct{})
+		finished := false
+		closed := 0
+		s := &server{
+			conf: config.Default(),
+			http: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				finished = true
+			})},
+			onShutdown: []func(){func() { closed++ }},
+		}
+		s.conf.Log.Output = `none`
+		if s.log, err = s.conf.Log.Open(); err != nil {
+			t.Fatal(err)
+		}
+		go s.http.Serve(listener)
+		go http.Get(`http://` + listener.Addr().String())
+		<-started
+
+		signals := make(chan os.Signal, 1)
+		code := make(chan int)
+		go func() { code <- s.shutdown(syscall.SIGTERM, signals) }()
+		if test.again {
+			signals <- syscall.SIGINT
+		} else {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+		}
+		select {
+		case got := <-code:
+			if got != test.code {
+				t.Errorf(`%s: got exit code %d, want %d`, test.name, got, test.code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf(`%s: shutdown did not return`, test.name)
+		}
+		if !test.again && !finished {
+			t.Errorf(`%s: shutdown returned before the request finished`, test.name)
+		}
+		if closed != 1 {
+			t.Errorf(`%s: shutdown hooks called %d times, want once`, test.name, closed)
+		}
+		if _, err := http.Get(`http://` + listener.Addr().String()); err == nil {
+			t.Errorf(`%s: still accepting connections after shutdown`, test.name)
+		}
+		if test.again {
+			close(release)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	wd, err := ioutil.TempDir(``, `reload`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+	for _, dir := range []string{`old`, `new`} {
+		os.Mkdir(filepath.Join(wd, dir), 0755)
+		ioutil.WriteFile(filepath.Join(wd, dir, `index.html`), []byte(dir), 0644)
+	}
+	writeConf(t, wd, `{"listen":":8080","publicDir":"old","embeddedClient":false,"limits":{"requestMb":0}}`)
+	s := newTestServer(t, wd)
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		s.http.Handler.ServeHTTP(w, httptest.NewRequest(`POST`, `/`, strings.NewReader(strings.Repeat(`x`, 2<<20))))
+		if w.Code != http.StatusOK && w.Code != http.StatusMethodNotAllowed {
+			return w.Body.String()
+		}
+		w = httptest.NewRecorder()
+		s.http.Handler.ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+		return w.Body.String()
+	}
+	if got := get(); got != `old` {
+		t.Fatalf(`got %q before reloading`, got)
+	}
+
+	// the listen address needs a restart so keeps its running value
+	writeConf(t, wd, `{"listen":":9090","publicDir":"new","embeddedClient":false,"limits":{"requestMb":1},"shutdownSeconds":5}`)
+	s.reload()
+	if got := get(); !strings.Contains(got, `larger than the limit of 1 MB`) {
+		t.Errorf(`got %q after lowering the request limit`, got)
+	}
+	if s.conf.Listen != `:8080` || s.conf.ShutdownSeconds != 5 || string(s.conf.PublicDir) != filepath.Join(wd, `new`) {
+		t.Errorf(`got listen %s, shutdown after %ds and public dir %s`, s.conf.Listen, s.conf.ShutdownSeconds, s.conf.PublicDir)
+	}
+
+	writeConf(t, wd, `{"listen":":9090","publicDir":"new","embeddedClient":false,"limits":{"requestMb":0}}`)
+	s.reload()
+	if got := get(); got != `new` {
+		t.Errorf(`got %q after changing the public dir`, got)
+	}
+
+	// a configuration that is not valid changes nothing
+	writeConf(t, wd, `{"publicDir":"old","limits":{"requestMb":"lots"}}`)
+	s.reload()
+	if got := get(); got != `new` || s.conf.Limits.RequestMb != 0 {
+		t.Errorf(`got %q and a limit of %dMB after an invalid reload`, got, s.conf.Limits.RequestMb)
+	}
+}
+
+func TestRedirectToHttps(t *testing.T) {
+	tests := []struct {
+		listen string
+		host   string
+		want   string
+	}{
+		{listen: `:8443`, host: `example.com`, want: `https://example.com:8443/a?b=c`},
+		{listen: `:8443`, host: `example.com:8080`, want: `https://example.com:8443/a?b=c`},
+		{listen: `:443`, host: `example.com:80`, want: `https://example.com/a?b=c`},
+		{listen: `:443`, host: `[::1]:80`, want: `https://[::1]/a?b=c`},
+		{listen: `:8443`, host: `[::1]:80`, want: `https://[::1]:8443/a?b=c`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(`GET`, `/a?b=c`, nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		redirectToHttps(test.listen).ServeHTTP(w, r)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get(`Location`) != test.want {
+			t.Errorf(`%s from %s: got %d to %s, want %s`, test.host, test.listen, w.Code, w.Header().Get(`Location`), test.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/asset"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// geometry arrays shorter than this are not worth a file of their own
+	minAssetArrayLen = 1024
+)
+
+// The element layout of each JavaScript typed array geometry arrays are saved as
+var typedArrays = map[string]struct {
+	size   int
+	float  bool
+	signed bool
+}{
+	`Int8Array`:         {1, false, true},
+	`Uint8Array`:        {1, false, false},
+	`Uint8ClampedArray`: {1, false, false},
+	`Int16Array`:        {2, false, true},
+	`Uint16Array`:       {2, false, false},
+	`Int32Array`:        {4, false, true},
+	`Uint32Array`:       {4, false, false},
+	`Float32Array`:      {4, true, true},
+	`Float64Array`:      {8, true, true},
+}
+
+// Wraps store so the data uri images and large geometry arrays of saved documents are kept in assets, as little
+// endian typed arrays for geometry, and each revision only holds asset:// references to them. Revisions that share
+// textures or meshes then share the files too. Geometry arrays are put back inline when documents are read so every
+// reader sees geometry as THREE.ObjectLoader expects it, images keep their reference for clients to fetch from the
+// asset api.
+func WithAssets(store Store, assets asset.Store) Store {
+	return &assetStore{Store: store, assets: assets}
+}
+
+type assetStore struct {
+	Store
+	assets asset.Store
+}
+
+func (s *assetStore) Create(name string, author string, doc []byte) (*Meta, error) {
+	doc, err := s.externalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Create(name, author, doc)
+}
+
+func (s *assetStore) Replace(id string, name string, author string, base int, doc []byte) (*Meta, error) {
+	doc, err := s.externalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Replace(id, name, author, base, doc)
+}
+
+func (s *assetStore) Get(id string) (*Meta, []byte, error) {
+	meta, doc, err := s.Store.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = s.inline(doc); err != nil {
+		return nil, nil, err
+	}
+	return meta, doc, nil
+}
+
+func (s *assetStore) GetRevision(id string, number int) (*Revision, []byte, error) {
+	revision, doc, err := s.Store.GetRevision(id, number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = s.inline(doc); err != nil {
+		return nil, nil, err
+	}
+	return revision, doc, nil
+}
+
+// Decodes doc keeping numbers as they are written, nil when it is not a json object
+func decodeDocument(doc []byte) map[string]interface{} {
+	raw := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil
+	}
+	return raw
+}
+
+// The json objects holding the typed arrays of the document's geometries
+func geometryArrays(raw map[string]interface{}) []map[string]interface{} {
+	var arrays []map[string]interface{}
+	geometries, _ := asMap(raw[`scene`])[`geometries`].([]interface{})
+	for _, geometry := range geometries {
+		data := asMap(asMap(geometry)[`data`])
+		for _, attribute := range asMap(data[`attributes`]) {
+			if attribute, ok := attribute.(map[string]interface{}); ok {
+				arrays = append(arrays, attribute)
+			}
+		}
+		if index, ok := data[`index`].(map[string]interface{}); ok {
+			arrays = append(arrays, index)
+		}
+	}
+	return arrays
+}
+
+// Moves data uri images and large geometry arrays into assets, documents that do not decode are returned as they
+// are for the wrapped store to reject
+func (s *assetStore) externalize(doc []byte) ([]byte, error) {
+	raw := decodeDocument(doc)
+	if raw == nil {
+		return doc, nil
+	}
+	changed := false
+	images, _ := asMap(raw[`scene`])[`images`].([]interface{})
+	for _, image := range images {
+		image := asMap(image)
+		src, _ := image[`url`].(string)
+		data, mimeType, ok := decodeDataUri(src)
+		if !ok {
+			continue
+		}
+		a, err := s.assets.Put(mimeType, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		image[`url`] = a.Ref
+		changed = true
+	}
+	for _, array := range geometryArrays(raw) {
+		typ, _ := array[`type`].(string)
+		values, _ := array[`array`].([]interface{})
+		if len(values) < minAssetArrayLen {
+			continue
+		}
+		data, ok := encodeTypedArray(typ, values)
+		if !ok {
+			continue
+		}
+		a, err := s.assets.Put(`application/octet-stream`, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		array[`array`] = a.Ref
+		changed = true
+	}
+	if !changed {
+		return doc, nil
+	}
+	return json.Marshal(raw)
+}
+
+// Reads geometry arrays held in assets back into the document
+func (s *assetStore) inline(doc []byte) ([]byte, error) {
+	if !bytes.Contains(doc, []byte(asset.Scheme)) {
+		return doc, nil
+	}
+	raw := decodeDocument(doc)
+	if raw == nil {
+		return doc, nil
+	}
+	changed := false
+	for _, array := range geometryArrays(raw) {
+		ref, _ := array[`array`].(string)
+		if _, ok := asset.ParseRef(ref); !ok {
+			continue
+		}
+		_, data, err := asset.ReadRef(s.assets, ref)
+		if err != nil {
+			return nil, err
+		}
+		typ, _ := array[`type`].(string)
+		values, ok := decodeTypedArray(typ, data)
+		if !ok {
+			return nil, &invalidDocumentError{reason: `geometry array ` + ref + ` is not a whole ` + typ}
+		}
+		array[`array`] = values
+		changed = true
+	}
+	if !changed {
+		return doc, nil
+	}
+	return json.Marshal(raw)
+}
+
+// Only base64 data uris are moved into assets, the percent encoded kind is rare and small
+func decodeDataUri(uri string) ([]byte, string, bool) {
+	if !strings.HasPrefix(uri, `data:`) {
+		return nil, ``, false
+	}
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 || !strings.HasSuffix(uri[:comma], `;base64`) {
+		return nil, ``, false
+	}
+	data, err := base64.StdEncoding.DecodeString(uri[comma+1:])
+	if err != nil {
+		return nil, ``, false
+	}
+	return data, strings.TrimSuffix(uri[len(`data:`):comma], `;base64`), true
+}
+
+// The values as the bytes of a little endian typed array, ok is false when a value does not fit the type and the
+// array has to stay inline
+func encodeTypedArray(typ string, values []interface{}) ([]byte, bool) {
+	layout, known := typedArrays[typ]
+	if !known {
+		return nil, false
+	}
+	out := make([]byte, len(values)*layout.size)
+	for i, value := range values {
+		number, _ := value.(json.Number)
+		b := out[i*layout.size:]
+		if layout.float {
+			f, err := strconv.ParseFloat(string(number), 64)
+			if err != nil {
+				return nil, false
+			}
+			if layout.size == 4 {
+				if math.IsInf(float64(float32(f)), 0) {
+					return nil, false
+				}
+				binary.LittleEndian.PutUint32(b, math.Float32bits(float32(f)))
+			} else {
+				binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(string(number), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		bits := uint(layout.size * 8)
+		if layout.signed && (n < -1<<(bits-1) || n >= 1<<(bits-1)) || !layout.signed && (n < 0 || n >= 1<<bits) {
+			return nil, false
+		}
+		switch layout.size {
+		case 1:
+			b[0] = byte(n)
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(n))
+		case 4:
+			binary.LittleEndian.PutUint32(b, uint32(n))
+		}
+	}
+	return out, true
+}
+
+// The values of a little endian typed array as json numbers, float32 values are written as the shortest number that
+// reads back as the same float32
+func decodeTypedArray(typ string, data []byte) ([]interface{}, bool) {
+	layout, known := typedArrays[typ]
+	if !known || len(data)%layout.size != 0 {
+		return nil, false
+	}
+	values := make([]interface{}, len(data)/layout.size)
+	for i := range values {
+		b := data[i*layout.size:]
+		var s string
+		switch {
+		case layout.float && layout.size == 4:
+			s = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+		case layout.float:
+			s = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+		case layout.size == 1 && layout.signed:
+			s = strconv.FormatInt(int64(int8(b[0])), 10)
+		case layout.size == 1:
+			s = strconv.FormatUint(uint64(b[0]), 10)
+		case layout.size == 2 && layout.signed:
+			s = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10)
+		case layout.size == 2:
+			s = strconv.FormatUint(uint64(binary.LittleEndian.Uint16(b)), 10)
+		case layout.signed:
+			s = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10)
+		default:
+			s = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
+		}
+		values[i] = json.Number(s)
+	}
+	return values, true
+}
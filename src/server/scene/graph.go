@@ -0,0 +1,378 @@
+package scene
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// A scene object with its children detached, Parent is empty for the root object
+type Node struct {
+	Uuid     string
+	Parent   string
+	Object   map[string]interface{}
+	Children []string
+}
+
+// An Editor.toJSON() document indexed by uuid so it can be compared and edited without walking the object tree
+type Graph struct {
+	Project    map[string]interface{}
+	Camera     map[string]interface{}
+	Root       string
+	Objects    map[string]*Node
+	Geometries map[string]map[string]interface{}
+	Materials  map[string]map[string]interface{}
+	Textures   map[string]map[string]interface{}
+	Images     map[string]map[string]interface{}
+	Scripts    map[string][]interface{}
+	Metadata   map[string]interface{}
+	// the keys of the document and of its scene the graph does not index, such as animations, kept as they are
+	Extra      map[string]interface{}
+	SceneExtra map[string]interface{}
+	// the uuids of the geometries, materials, textures and images by their scene key in the order the document
+	// listed them, Document keeps that order and lists any others after them
+	Order map[string][]string
+}
+
+// The scene keys holding lists of items with uuids
+var indexKeys = []string{`geometries`, `materials`, `textures`, `images`}
+
+func ParseGraph(doc []byte) (*Graph, error) {
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, err
+	}
+	return NewGraph(raw)
+}
+
+// Indexes an already decoded document
+func NewGraph(raw map[string]interface{}) (*Graph, error) {
+	g := &Graph{
+		Project: asMap(raw[`project`]),
+		Camera:  asMap(raw[`camera`]),
+		Objects: map[string]*Node{},
+		Scripts: map[string][]interface{}{},
+		Extra:   otherKeys(raw, documentKeys),
+		Order:   map[string][]string{},
+	}
+	for objectUuid, scripts := range asMap(raw[`scripts`]) {
+		if list, ok := scripts.([]interface{}); ok {
+			g.Scripts[objectUuid] = list
+		}
+	}
+
+	sceneJson := asMap(raw[`scene`])
+	g.Metadata = asMap(sceneJson[`metadata`])
+	g.SceneExtra = otherKeys(sceneJson, append([]string{`metadata`, `object`}, indexKeys...))
+	g.Geometries, g.Order[`geometries`] = indexByUuid(sceneJson[`geometries`])
+	g.Materials, g.Order[`materials`] = indexByUuid(sceneJson[`materials`])
+	g.Textures, g.Order[`textures`] = indexByUuid(sceneJson[`textures`])
+	g.Images, g.Order[`images`] = indexByUuid(sceneJson[`images`])
+
+	root, ok := sceneJson[`object`].(map[string]interface{})
+	if !ok {
+		return nil, &invalidDocumentError{reason: `scene has no root object`}
+	}
+	uuid, err := g.addObject(root, ``)
+	if err != nil {
+		return nil, err
+	}
+	g.Root = uuid
+	return g, nil
+}
+
+func (g *Graph) addObject(obj map[string]interface{}, parent string) (string, error) {
+	uuid, _ := obj[`uuid`].(string)
+	if uuid == `` {
+		return ``, &invalidDocumentError{reason: `object without a uuid`}
+	}
+	if _, exists := g.Objects[uuid]; exists {
+		return ``, &invalidDocumentError{reason: `duplicate object uuid ` + uuid}
+	}
+	node := &Node{Uuid: uuid, Parent: parent, Object: map[string]interface{}{}, Children: []string{}}
+	for key, val := range obj {
+		if key != `children` {
+			node.Object[key] = val
+		}
+	}
+	g.Objects[uuid] = node
+	children, _ := obj[`children`].([]interface{})
+	for _, child := range children {
+		childObj, ok := child.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		childUuid, err := g.addObject(childObj, uuid)
+		if err != nil {
+			return ``, err
+		}
+		node.Children = append(node.Children, childUuid)
+	}
+	return uuid, nil
+}
+
+// Visits every object depth first starting at the root
+func (g *Graph) Walk(fn func(node *Node)) {
+	var walk func(uuid string)
+	walk = func(uuid string) {
+		node := g.Objects[uuid]
+		if node == nil {
+			return
+		}
+		fn(node)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(g.Root)
+}
+
+// Rebuilds the Editor.toJSON() document from the graph
+func (g *Graph) Document() map[string]interface{} {
+	sceneJson := map[string]interface{}{}
+	for key, val := range g.SceneExtra {
+		sceneJson[key] = val
+	}
+	if g.Metadata != nil {
+		sceneJson[`metadata`] = g.Metadata
+	}
+	for key, index := range map[string]map[string]map[string]interface{}{
+		`geometries`: g.Geometries,
+		`materials`:  g.Materials,
+		`textures`:   g.Textures,
+		`images`:     g.Images,
+	} {
+		if len(index) > 0 {
+			sceneJson[key] = listByUuid(index, g.Order[key])
+		}
+	}
+	sceneJson[`object`] = g.buildObject(g.Root)
+
+	scripts := map[string]interface{}{}
+	for objectUuid, list := range g.Scripts {
+		if len(list) > 0 {
+			scripts[objectUuid] = list
+		}
+	}
+
+	project := g.Project
+	if project == nil {
+		project = map[string]interface{}{}
+	}
+	doc := map[string]interface{}{}
+	for key, val := range g.Extra {
+		doc[key] = val
+	}
+	doc[`project`] = project
+	doc[`camera`] = g.Camera
+	doc[`scene`] = sceneJson
+	doc[`scripts`] = scripts
+	return doc
+}
+
+func (g *Graph) buildObject(uuid string) map[string]interface{} {
+	node := g.Objects[uuid]
+	obj := map[string]interface{}{}
+	for key, val := range node.Object {
+		obj[key] = val
+	}
+	children := make([]interface{}, 0, len(node.Children))
+	for _, child := range node.Children {
+		if g.Objects[child] != nil {
+			children = append(children, g.buildObject(child))
+		}
+	}
+	if len(children) > 0 {
+		obj[`children`] = children
+	}
+	return obj
+}
+
+// Returns the uuids of the object and all of its descendants
+func (g *Graph) Subtree(uuid string) []string {
+	uuids := []string{}
+	var walk func(uuid string)
+	walk = func(uuid string) {
+		node := g.Objects[uuid]
+		if node == nil {
+			return
+		}
+		uuids = append(uuids, uuid)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(uuid)
+	return uuids
+}
+
+// Adds obj and its descendants as a child of parent, placed before the sibling before or last when before is empty
+func (g *Graph) Insert(obj map[string]interface{}, parent, before string) error {
+	parentNode := g.Objects[parent]
+	if parentNode == nil {
+		return &invalidEditError{reason: `no such parent object ` + parent}
+	}
+	if err := g.checkNewUuids(obj, map[string]bool{}); err != nil {
+		return err
+	}
+	uuid, err := g.addObject(obj, parent)
+	if err != nil {
+		return err
+	}
+	parentNode.Children = insertBefore(parentNode.Children, uuid, before)
+	return nil
+}
+
+// Checks every uuid in the new subtree is free and none repeats within it
+func (g *Graph) checkNewUuids(obj map[string]interface{}, seen map[string]bool) error {
+	uuid, _ := obj[`uuid`].(string)
+	if uuid == `` {
+		return &invalidEditError{reason: `object without a uuid`}
+	}
+	if g.Objects[uuid] != nil || seen[uuid] {
+		return &invalidEditError{reason: `object ` + uuid + ` already exists`}
+	}
+	seen[uuid] = true
+	children, _ := obj[`children`].([]interface{})
+	for _, child := range children {
+		if childObj, ok := child.(map[string]interface{}); ok {
+			if err := g.checkNewUuids(childObj, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removes the object, its descendants and their scripts
+func (g *Graph) Remove(uuid string) error {
+	node := g.Objects[uuid]
+	if node == nil {
+		return &invalidEditError{reason: `no such object ` + uuid}
+	}
+	if node.Parent == `` {
+		return &invalidEditError{reason: `the root object can not be removed`}
+	}
+	for _, descendant := range g.Subtree(uuid) {
+		delete(g.Objects, descendant)
+		delete(g.Scripts, descendant)
+	}
+	parent := g.Objects[node.Parent]
+	parent.Children = removeUuid(parent.Children, uuid)
+	return nil
+}
+
+// Re-parents the object placing it before the sibling before or last when before is empty
+func (g *Graph) Move(uuid, parent, before string) error {
+	node := g.Objects[uuid]
+	if node == nil {
+		return &invalidEditError{reason: `no such object ` + uuid}
+	}
+	if node.Parent == `` {
+		return &invalidEditError{reason: `the root object can not be moved`}
+	}
+	parentNode := g.Objects[parent]
+	if parentNode == nil {
+		return &invalidEditError{reason: `no such parent object ` + parent}
+	}
+	for _, descendant := range g.Subtree(uuid) {
+		if descendant == parent {
+			return &invalidEditError{reason: `object ` + uuid + ` can not be moved into its own subtree`}
+		}
+	}
+	oldParent := g.Objects[node.Parent]
+	oldParent.Children = removeUuid(oldParent.Children, uuid)
+	node.Parent = parent
+	parentNode.Children = insertBefore(parentNode.Children, uuid, before)
+	return nil
+}
+
+func removeUuid(uuids []string, uuid string) []string {
+	kept := make([]string, 0, len(uuids))
+	for _, u := range uuids {
+		if u != uuid {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+func insertBefore(uuids []string, uuid, before string) []string {
+	for i, u := range uuids {
+		if u == before {
+			return append(uuids[:i], append([]string{uuid}, uuids[i:]...)...)
+		}
+	}
+	return append(uuids, uuid)
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// The json object's keys other than known, nil when there are none
+func otherKeys(m map[string]interface{}, known []string) map[string]interface{} {
+	var other map[string]interface{}
+	for key, val := range m {
+		if !contains(known, key) {
+			if other == nil {
+				other = map[string]interface{}{}
+			}
+			other[key] = val
+		}
+	}
+	return other
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Indexes a list of items by their uuids, also returning the uuids in list order
+func indexByUuid(v interface{}) (map[string]map[string]interface{}, []string) {
+	index := map[string]map[string]interface{}{}
+	order := []string{}
+	list, _ := v.([]interface{})
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			if uuid, ok := m[`uuid`].(string); ok {
+				if index[uuid] == nil {
+					order = append(order, uuid)
+				}
+				index[uuid] = m
+			}
+		}
+	}
+	return index, order
+}
+
+// Lists the items in order, skipping those no longer in the index, followed by any it does not mention sorted by uuid
+func listByUuid(index map[string]map[string]interface{}, order []string) []interface{} {
+	list := make([]interface{}, 0, len(index))
+	listed := map[string]bool{}
+	for _, uuid := range order {
+		if index[uuid] != nil && !listed[uuid] {
+			list = append(list, index[uuid])
+			listed[uuid] = true
+		}
+	}
+	rest := []string{}
+	for uuid := range index {
+		if !listed[uuid] {
+			rest = append(rest, uuid)
+		}
+	}
+	sort.Strings(rest)
+	for _, uuid := range rest {
+		list = append(list, index[uuid])
+	}
+	return list
+}
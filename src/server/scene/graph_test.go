@@ -0,0 +1,55 @@
+package scene
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGraphDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{
+			name: `unknown keys`,
+			doc: `{"metadata":{"type":"App"},"project":{},"camera":{"uuid":"C"},"scripts":{},"history":{"undos":[]},` +
+				`"scene":{"metadata":{"version":4.4},"animations":[{"name":"spin"}],"object":{"uuid":"S","type":"Scene"}}}`,
+		},
+		{
+			name: `list order`,
+			doc: `{"project":{},"camera":{},"scripts":{},"scene":{` +
+				`"geometries":[{"uuid":"G2"},{"uuid":"G1"}],"materials":[{"uuid":"M3"},{"uuid":"M1"},{"uuid":"M2"}],` +
+				`"object":{"uuid":"S","type":"Scene","children":[{"uuid":"B","geometry":"G2","material":"M3"},{"uuid":"A","geometry":"G1","material":"M1"}]}}}`,
+		},
+	}
+	for _, test := range tests {
+		g, err := ParseGraph([]byte(test.doc))
+		if err != nil {
+			t.Errorf(`%s: %v`, test.name, err)
+			continue
+		}
+		data, _ := json.Marshal(g.Document())
+		var got, want interface{}
+		json.Unmarshal(data, &got)
+		json.Unmarshal([]byte(test.doc), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, data, test.doc)
+		}
+	}
+}
+
+func TestGraphDocumentNewItems(t *testing.T) {
+	g, err := ParseGraph([]byte(`{"project":{},"camera":{},"scripts":{},"scene":{"materials":[{"uuid":"M3"},{"uuid":"M1"}],"object":{"uuid":"S"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(g.Materials, `M1`)
+	g.Materials[`M2`] = map[string]interface{}{`uuid`: `M2`}
+	g.Materials[`M0`] = map[string]interface{}{`uuid`: `M0`}
+
+	data, _ := json.Marshal(g.Document()[`scene`].(map[string]interface{})[`materials`])
+	if want := `[{"uuid":"M3"},{"uuid":"M0"},{"uuid":"M2"}]`; string(data) != want {
+		t.Errorf(`got materials %s, want %s`, data, want)
+	}
+}
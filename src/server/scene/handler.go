@@ -0,0 +1,379 @@
+package scene
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const (
+	Prefix = `/api/scenes`
+)
+
+// Serves the scene resource:
+//
+//	GET    /api/scenes                             list the meta data of all scenes
+//	POST   /api/scenes                             create a scene from the request body, ?name= sets its name
+//	GET    /api/scenes/{id}                        get the head scene document
+//	PUT    /api/scenes/{id}                        save the request body as a new head revision, ?name= renames it,
+//	                                               ?base= names the revision the edit started from
+//	DELETE /api/scenes/{id}                        delete the scene and all of its revisions
+//	GET    /api/scenes/{id}/revisions              list all revisions of the scene, oldest first
+//	GET    /api/scenes/{id}/revisions/{n}          get the scene document as it was at revision n
+//	POST   /api/scenes/{id}/revisions/{n}/restore  save revision n as a new head revision
+//
+// Every request that saves a revision records the ?author= query parameter against it.
+//
+// When a PUT names a base revision that is no longer the head, the body is three way merged with the head using
+// the MergeFunc set on the handler. A clean merge is saved and reported with "merged": true, otherwise nothing is
+// saved and a 409 lists the conflicts.
+//
+// A SaveGuard set on the handler can veto saves, restores and deletes with a 423, the ?holder= query parameter is the
+// secret the guard identifies the client making the change by, such as the token a collaboration client is given.
+//
+// Other packages can serve their own resources under /api/scenes/{id}/{name} by registering a SubHandler.
+func NewHandler(store Store, log golog.Log) *Handler {
+	return &Handler{store: store, log: log, subs: map[string]SubHandler{}}
+}
+
+// Checks the changes from the before document to the after document may be saved by holder, returning the reason
+// they may not. after is nil when the scene is being deleted.
+type SaveGuard func(sceneId, holder string, before, after []byte) error
+
+// Serves a sub resource of the scene with the given id, segments are the path segments following the sub resource name
+type SubHandler func(w http.ResponseWriter, r *http.Request, id string, segments []string)
+
+type Handler struct {
+	store Store
+	log   golog.Log
+	subs  map[string]SubHandler
+	merge MergeFunc
+	guard SaveGuard
+}
+
+type saveResult struct {
+	*Meta
+	Merged bool `json:"merged"`
+}
+
+type conflictResult struct {
+	Error     string      `json:"error"`
+	Base      int         `json:"base"`
+	Head      int         `json:"head"`
+	Conflicts []*Conflict `json:"conflicts"`
+}
+
+// Registers sub to serve /api/scenes/{id}/{name}, it must be called before the handler starts serving
+func (h *Handler) HandleSub(name string, sub SubHandler) {
+	h.subs[name] = sub
+}
+
+// Sets the merge used for saves made from a revision that is no longer the head, without one such saves fail with a 409
+func (h *Handler) SetMerge(merge MergeFunc) {
+	h.merge = merge
+}
+
+// Sets the guard every save and restore must pass
+func (h *Handler) SetGuard(guard SaveGuard) {
+	h.guard = guard
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := api.SplitPath(r.URL.Path, Prefix)
+	switch {
+	case len(segments) == 0:
+		h.serveCollection(w, r)
+	case len(segments) == 1:
+		h.serveScene(w, r, segments[0])
+	case segments[1] == `revisions` && len(segments) <= 4:
+		h.serveRevisions(w, r, segments[0], segments[2:])
+	case h.subs[segments[1]] != nil:
+		h.subs[segments[1]](w, r, segments[0], segments[2:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case `GET`:
+		metas, err := h.store.List()
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		api.WriteJson(w, http.StatusOK, metas)
+	case `POST`:
+		doc, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		meta, err := h.store.Create(r.URL.Query().Get(`name`), r.URL.Query().Get(`author`), doc)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		h.log.Info(`created scene: `, meta.Id)
+		w.Header().Set(`Location`, Prefix+`/`+meta.Id)
+		api.WriteJson(w, http.StatusCreated, meta)
+	default:
+		api.MethodNotAllowed(w, `GET`, `POST`)
+	}
+}
+
+func (h *Handler) serveScene(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case `GET`:
+		_, doc, err := h.store.Get(id)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		api.WriteRawJson(w, http.StatusOK, doc)
+	case `PUT`:
+		doc, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		base := 0
+		if param := r.URL.Query().Get(`base`); param != `` {
+			if base, err = strconv.Atoi(param); err != nil {
+				api.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		h.save(w, r, id, base, doc)
+	case `DELETE`:
+		// stores have no conditional delete, so unlike a save a lease taken between the check and the delete is not seen
+		if _, ok := h.checkGuard(w, r, id, 0, nil); !ok {
+			return
+		}
+		if err := h.store.Delete(id); err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		h.log.Info(`deleted scene: `, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		api.MethodNotAllowed(w, `GET`, `PUT`, `DELETE`)
+	}
+}
+
+func (h *Handler) save(w http.ResponseWriter, r *http.Request, id string, base int, doc []byte) {
+	name, author := r.URL.Query().Get(`name`), r.URL.Query().Get(`author`)
+	merged := false
+	for {
+		checked, ok := h.checkGuard(w, r, id, base, doc)
+		if !ok {
+			return
+		}
+		meta, err := h.store.Replace(id, name, author, checked, doc)
+		if err == nil {
+			api.WriteJson(w, http.StatusOK, &saveResult{Meta: meta, Merged: merged})
+			return
+		}
+		if IsHeadMoved(err) && base == 0 {
+			// the head the guard checked was replaced before the save, so the guard runs again on the new head
+			continue
+		}
+		if !IsHeadMoved(err) || h.merge == nil {
+			WriteError(w, h.log, err)
+			return
+		}
+
+		_, baseDoc, err := h.store.GetRevision(id, base)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		head, headDoc, err := h.store.Get(id)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		mergedDoc, conflicts, err := h.merge(baseDoc, doc, headDoc)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		if len(conflicts) > 0 {
+			api.WriteJson(w, http.StatusConflict, &conflictResult{
+				Error:     `save conflicts with changes made since revision ` + strconv.Itoa(base),
+				Base:      base,
+				Head:      head.Revision,
+				Conflicts: conflicts,
+			})
+			return
+		}
+		h.log.Info(`merged save of scene: `, id, ` from revision: `, base, ` onto revision: `, head.Revision)
+		// the head may move again while merging, in which case the merge is repeated against the new head
+		doc, base, merged = mergedDoc, head.Revision, true
+	}
+}
+
+func (h *Handler) serveRevisions(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+	if len(segments) == 0 {
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+		revisions, err := h.store.Revisions(id)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		api.WriteJson(w, http.StatusOK, revisions)
+		return
+	}
+
+	number, err := strconv.Atoi(segments[0])
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(segments) == 1 {
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+		_, doc, err := h.store.GetRevision(id, number)
+		if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		api.WriteRawJson(w, http.StatusOK, doc)
+		return
+	}
+
+	if segments[1] != `restore` {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != `POST` {
+		api.MethodNotAllowed(w, `POST`)
+		return
+	}
+	_, doc, err := h.store.GetRevision(id, number)
+	if err != nil {
+		WriteError(w, h.log, err)
+		return
+	}
+	for {
+		checked, ok := h.checkGuard(w, r, id, 0, doc)
+		if !ok {
+			return
+		}
+		meta, err := h.store.Restore(id, number, r.URL.Query().Get(`author`), checked)
+		if IsHeadMoved(err) {
+			continue
+		} else if err != nil {
+			WriteError(w, h.log, err)
+			return
+		}
+		h.log.Info(`restored scene: `, id, ` to revision: `, number)
+		api.WriteJson(w, http.StatusOK, meta)
+		return
+	}
+}
+
+// Runs the guard over the changes doc makes to the base revision, or to the head when base is zero, writing the
+// rejection and returning false when they are not allowed. It returns the revision the changes were checked against
+// for the write to be made on, so the write fails with a head moved error rather than saving over changes the guard
+// has not seen. Without a guard that is base itself.
+func (h *Handler) checkGuard(w http.ResponseWriter, r *http.Request, id string, base int, doc []byte) (int, bool) {
+	if h.guard == nil {
+		return base, true
+	}
+	var before []byte
+	var err error
+	if base == 0 {
+		var head *Meta
+		head, before, err = h.store.Get(id)
+		if err == nil {
+			base = head.Revision
+		}
+	} else {
+		_, before, err = h.store.GetRevision(id, base)
+	}
+	if err != nil {
+		WriteError(w, h.log, err)
+		return 0, false
+	}
+	if err := h.guard(id, r.URL.Query().Get(`holder`), before, doc); err != nil {
+		if IsInvalid(err) {
+			WriteError(w, h.log, err)
+		} else {
+			api.WriteError(w, http.StatusLocked, err)
+		}
+		return 0, false
+	}
+	return base, true
+}
+
+// A revision of a scene read for a sub resource request
+type RequestedRevision struct {
+	Meta   *Meta
+	Number int
+	Graph  *Graph
+}
+
+// Reads the revision named by the request's ?revision= parameter, or the head revision when there is none, for the
+// sub resources that can be had of any revision. Failures are written to w and nil is returned.
+func ReadRequestedRevision(w http.ResponseWriter, r *http.Request, store Store, log golog.Log, id string) *RequestedRevision {
+	meta, doc, err := store.Get(id)
+	if err != nil {
+		WriteError(w, log, err)
+		return nil
+	}
+	number := meta.Revision
+	if param := r.URL.Query().Get(`revision`); param != `` {
+		if number, err = strconv.Atoi(param); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return nil
+		}
+		if _, doc, err = store.GetRevision(id, number); err != nil {
+			WriteError(w, log, err)
+			return nil
+		}
+	}
+	g, err := ParseGraph(doc)
+	if err != nil {
+		WriteError(w, log, err)
+		return nil
+	}
+	return &RequestedRevision{Meta: meta, Number: number, Graph: g}
+}
+
+// The object named by the request's ?object= parameter, or the root object when there is none. An object not in the
+// revision is written to w as a 404 and nil is returned.
+func (rev *RequestedRevision) RequestedObject(w http.ResponseWriter, r *http.Request) *Node {
+	uuid := r.URL.Query().Get(`object`)
+	if uuid == `` {
+		uuid = rev.Graph.Root
+	}
+	node := rev.Graph.Objects[uuid]
+	if node == nil {
+		api.WriteError(w, http.StatusNotFound, &noSuchObjectError{uuid: uuid})
+	}
+	return node
+}
+
+// Writes err with the status code matching the kind of store error, unexpected errors are logged
+func WriteError(w http.ResponseWriter, log golog.Log, err error) {
+	switch {
+	case IsNotFound(err):
+		api.WriteError(w, http.StatusNotFound, err)
+	case IsInvalid(err):
+		api.WriteError(w, http.StatusBadRequest, err)
+	case IsHeadMoved(err):
+		api.WriteError(w, http.StatusConflict, err)
+	default:
+		log.Error(err)
+		api.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
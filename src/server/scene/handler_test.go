@@ -0,0 +1,48 @@
+package scene
+
+import (
+	"errors"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteGuard(t *testing.T) {
+	tests := []struct {
+		name   string
+		holder string
+		status int
+	}{
+		{name: `holder of the leases`, holder: `alice-token`, status: 204},
+		{name: `anyone else`, holder: `bob-token`, status: 423},
+	}
+	for _, test := range tests {
+		func() {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			meta, err := store.Create(`scene`, `alice`, testDocument(`a`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := NewHandler(store, golog.NewDevNullLog())
+			h.SetGuard(func(sceneId, holder string, before, after []byte) error {
+				if after != nil || string(before) != string(testDocument(`a`)) {
+					t.Errorf(`%s: guard got %s to %s, want the head to nothing`, test.name, before, after)
+				}
+				if holder != `alice-token` {
+					return errors.New(`leased by alice`)
+				}
+				return nil
+			})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(`DELETE`, Prefix+`/`+meta.Id+`?holder=`+test.holder, nil))
+			if w.Code != test.status {
+				t.Errorf(`%s: got status %d, want %d`, test.name, w.Code, test.status)
+			}
+			if _, _, err := store.Get(meta.Id); IsNotFound(err) != (test.status == 204) {
+				t.Errorf(`%s: got %v getting the scene after the delete`, test.name, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,15 @@
+package scene
+
+// Three way merges ours and theirs, both edited from base, returning the merged document or the conflicts that
+// prevent an automatic merge
+type MergeFunc func(base, ours, theirs []byte) ([]byte, []*Conflict, error)
+
+// A property changed differently on both sides of a merge, a nil value means the item did not exist on that side
+type Conflict struct {
+	Kind     string      `json:"kind"`
+	Uuid     string      `json:"uuid"`
+	Property string      `json:"property"`
+	Base     interface{} `json:"base"`
+	Ours     interface{} `json:"ours"`
+	Theirs   interface{} `json:"theirs"`
+}
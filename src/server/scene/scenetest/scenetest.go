@@ -0,0 +1,48 @@
+/*
+Builds small Editor.toJSON() documents for tests, every mesh uses box geometry G and one of the materials M and N
+*/
+package scenetest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const Identity = `[1,0,0,0,0,1,0,0,0,0,1,0,0,0,0,1]`
+
+// A column major matrix moving by x, y and z
+func Translation(x, y, z float64) string {
+	return fmt.Sprintf(`[1,0,0,0,0,1,0,0,0,0,1,0,%g,%g,%g,1]`, x, y, z)
+}
+
+// A column major matrix rotating by degrees about z
+func RotationZ(degrees float64) string {
+	a := degrees * math.Pi / 180
+	c, s := math.Cos(a), math.Sin(a)
+	return fmt.Sprintf(`[%g,%g,0,0,%g,%g,0,0,0,0,1,0,0,0,0,1]`, c, s, -s, c)
+}
+
+// A mesh of geometry G using material, children are other Object results
+func Object(uuid, name, material, matrix string, children ...string) string {
+	return fmt.Sprintf(`{"uuid":%q,"type":"Mesh","name":%q,"geometry":"G","material":%q,"matrix":%s,"children":[%s]}`,
+		uuid, name, material, matrix, strings.Join(children, `,`))
+}
+
+// The scene object S named "scene" holding children
+func Root(children ...string) string {
+	return fmt.Sprintf(`{"uuid":"S","type":"Scene","name":"scene","matrix":%s,"children":[%s]}`, Identity, strings.Join(children, `,`))
+}
+
+// A complete document whose scene root holds children, material M has color and material N is white
+func Document(color string, children ...string) string {
+	return DocumentWithRoot(color, Root(children...))
+}
+
+// Document with root as its object instead of the scene root
+func DocumentWithRoot(color, root string) string {
+	return `{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"metadata":{},` +
+		`"geometries":[{"uuid":"G","type":"BoxGeometry","width":1,"height":1,"depth":1}],` +
+		`"materials":[{"uuid":"M","type":"MeshStandardMaterial","color":` + color + `},{"uuid":"N","type":"MeshStandardMaterial","color":16777215}],` +
+		`"object":` + root + `}}`
+}
@@ -0,0 +1,425 @@
+/*
+Server side persistence of editor scenes, each scene is the exact document produced by Editor.toJSON()
+*/
+package scene
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
+	"github.com/robsix/3ditor/src/server/atomicfile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	metaFileName      = `meta.json`
+	revisionsFileName = `revisions.json`
+	revisionsDirName  = `revisions`
+)
+
+// The top level keys every Editor.toJSON() document must have
+var documentKeys = []string{`project`, `camera`, `scene`, `scripts`}
+
+type Meta struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+	Revision int       `json:"revision"`
+}
+
+// Every save of a scene is kept as an immutable numbered revision, the head of the scene is always the latest revision
+type Revision struct {
+	Number       int       `json:"number"`
+	Time         time.Time `json:"time"`
+	Author       string    `json:"author"`
+	Size         int64     `json:"size"`
+	RestoredFrom int       `json:"restoredFrom,omitempty"`
+	// pruned revisions keep their number and entry in the history but their document is gone
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+type Store interface {
+	Create(name string, author string, doc []byte) (*Meta, error)
+	List() ([]*Meta, error)
+	Get(id string) (*Meta, []byte, error)
+	// Saves doc as the new head revision, when base is non zero it fails with a head moved error unless base is
+	// still the head revision
+	Replace(id string, name string, author string, base int, doc []byte) (*Meta, error)
+	Delete(id string) error
+	Revisions(id string) ([]*Revision, error)
+	GetRevision(id string, number int) (*Revision, []byte, error)
+	// Saves revision number as the new head revision, base works as for Replace
+	Restore(id string, number int, author string, base int) (*Meta, error)
+	// Deletes the documents of the numbered revisions, the head revision can not be pruned
+	Prune(id string, numbers []int) error
+}
+
+// Checks doc is a json object containing all the keys produced by Editor.toJSON()
+func Validate(doc []byte) error {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc, &obj); err != nil {
+		return &invalidDocumentError{reason: err.Error()}
+	}
+	for _, key := range documentKeys {
+		if _, exists := obj[key]; !exists {
+			return &invalidDocumentError{reason: `missing top level key "` + key + `"`}
+		}
+	}
+	return nil
+}
+
+// Stores each scene in its own directory under storeDir containing a meta.json, a revisions.json index and a
+// revisions directory holding one file per revision.
+func NewLocalStore(storeDir string) (Store, error) {
+	if err := os.MkdirAll(storeDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: storeDir}, nil
+}
+
+type localStore struct {
+	mtx sync.RWMutex
+	dir string
+}
+
+func (s *localStore) sceneDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *localStore) readMeta(id string) (*Meta, error) {
+	if uuid.Parse(id) == nil {
+		return nil, &noSuchSceneError{id: id}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.sceneDir(id), metaFileName))
+	if os.IsNotExist(err) {
+		return nil, &noSuchSceneError{id: id}
+	} else if err != nil {
+		return nil, err
+	}
+	meta := &Meta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *localStore) revisionFile(id string, number int) string {
+	return filepath.Join(s.sceneDir(id), revisionsDirName, strconv.Itoa(number)+`.json`)
+}
+
+func (s *localStore) readRevisions(id string) ([]*Revision, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.sceneDir(id), revisionsFileName))
+	if err != nil {
+		return nil, err
+	}
+	revisions := []*Revision{}
+	if err := json.Unmarshal(data, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// Appends doc as the next revision of the scene and moves the head of meta onto it
+func (s *localStore) appendRevision(meta *Meta, revisions []*Revision, author string, doc []byte, restoredFrom int) (*Meta, error) {
+	dir := s.sceneDir(meta.Id)
+	if err := os.MkdirAll(filepath.Join(dir, revisionsDirName), os.ModePerm); err != nil {
+		return nil, err
+	}
+	revision := &Revision{
+		Number:       len(revisions) + 1,
+		Time:         time.Now().UTC(),
+		Author:       author,
+		Size:         int64(len(doc)),
+		RestoredFrom: restoredFrom,
+	}
+	if err := atomicfile.WriteFile(s.revisionFile(meta.Id, revision.Number), doc); err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(append(revisions, revision))
+	if err := atomicfile.WriteFile(filepath.Join(dir, revisionsFileName), data); err != nil {
+		return nil, err
+	}
+	meta.Modified = revision.Time
+	meta.Size = revision.Size
+	meta.Revision = revision.Number
+	data, _ = json.Marshal(meta)
+	if err := atomicfile.WriteFile(filepath.Join(dir, metaFileName), data); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *localStore) Create(name string, author string, doc []byte) (*Meta, error) {
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+	meta := &Meta{
+		Id:      uuid.New(),
+		Name:    name,
+		Created: time.Now().UTC(),
+	}
+
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	return s.appendRevision(meta, []*Revision{}, author, doc, 0)
+}
+
+func (s *localStore) List() ([]*Meta, error) {
+	defer s.mtx.RUnlock()
+	s.mtx.RLock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*Meta, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		if meta, err := s.readMeta(file.Name()); err == nil {
+			metas = append(metas, meta)
+		}
+	}
+	sort.Sort(byModifiedDesc(metas))
+	return metas, nil
+}
+
+func (s *localStore) Get(id string) (*Meta, []byte, error) {
+	defer s.mtx.RUnlock()
+	s.mtx.RLock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := ioutil.ReadFile(s.revisionFile(id, meta.Revision))
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, doc, nil
+}
+
+func (s *localStore) Replace(id string, name string, author string, base int, doc []byte) (*Meta, error) {
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	if base != 0 && base != meta.Revision {
+		return nil, &headMovedError{id: id, base: base, head: meta.Revision}
+	}
+	revisions, err := s.readRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+	if name != `` {
+		meta.Name = name
+	}
+	return s.appendRevision(meta, revisions, author, doc, 0)
+}
+
+func (s *localStore) Delete(id string) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	if _, err := s.readMeta(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.sceneDir(id))
+}
+
+func (s *localStore) Revisions(id string) ([]*Revision, error) {
+	defer s.mtx.RUnlock()
+	s.mtx.RLock()
+
+	if _, err := s.readMeta(id); err != nil {
+		return nil, err
+	}
+	return s.readRevisions(id)
+}
+
+func (s *localStore) GetRevision(id string, number int) (*Revision, []byte, error) {
+	defer s.mtx.RUnlock()
+	s.mtx.RLock()
+
+	return s.getRevision(id, number)
+}
+
+func (s *localStore) getRevision(id string, number int) (*Revision, []byte, error) {
+	if _, err := s.readMeta(id); err != nil {
+		return nil, nil, err
+	}
+	revisions, err := s.readRevisions(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if number < 1 || number > len(revisions) {
+		return nil, nil, &noSuchRevisionError{id: id, number: number}
+	}
+	if revisions[number-1].Pruned {
+		return nil, nil, &prunedRevisionError{id: id, number: number}
+	}
+	doc, err := ioutil.ReadFile(s.revisionFile(id, number))
+	if err != nil {
+		return nil, nil, err
+	}
+	return revisions[number-1], doc, nil
+}
+
+// Restoring never rewrites history, the restored document is appended as a new head revision
+func (s *localStore) Restore(id string, number int, author string, base int) (*Meta, error) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	_, doc, err := s.getRevision(id, number)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	if base != 0 && base != meta.Revision {
+		return nil, &headMovedError{id: id, base: base, head: meta.Revision}
+	}
+	revisions, err := s.readRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.appendRevision(meta, revisions, author, doc, number)
+}
+
+func (s *localStore) Prune(id string, numbers []int) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return err
+	}
+	revisions, err := s.readRevisions(id)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if number < 1 || number > len(revisions) {
+			return &noSuchRevisionError{id: id, number: number}
+		}
+		if number == meta.Revision {
+			return &invalidEditError{reason: `the head revision can not be pruned`}
+		}
+	}
+	// the index is updated first so a failed delete leaves a stray file rather than a listed revision without one
+	for _, number := range numbers {
+		revisions[number-1].Pruned = true
+	}
+	data, _ := json.Marshal(revisions)
+	if err := atomicfile.WriteFile(filepath.Join(s.sceneDir(id), revisionsFileName), data); err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if err := os.Remove(s.revisionFile(id, number)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type byModifiedDesc []*Meta
+
+func (m byModifiedDesc) Len() int           { return len(m) }
+func (m byModifiedDesc) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byModifiedDesc) Less(i, j int) bool { return m[i].Modified.After(m[j].Modified) }
+
+type noSuchSceneError struct {
+	id string
+}
+
+func (e *noSuchSceneError) Error() string { return `No such scene exists with id: ` + e.id }
+
+type invalidDocumentError struct {
+	reason string
+}
+
+func (e *invalidDocumentError) Error() string { return `Invalid scene document: ` + e.reason }
+
+type noSuchRevisionError struct {
+	id     string
+	number int
+}
+
+func (e *noSuchRevisionError) Error() string {
+	return `No such revision ` + strconv.Itoa(e.number) + ` exists for scene with id: ` + e.id
+}
+
+type prunedRevisionError struct {
+	id     string
+	number int
+}
+
+func (e *prunedRevisionError) Error() string {
+	return `Revision ` + strconv.Itoa(e.number) + ` of scene with id: ` + e.id + ` was pruned, its document is no longer kept`
+}
+
+type noSuchObjectError struct {
+	uuid string
+}
+
+func (e *noSuchObjectError) Error() string { return `No such object exists with uuid: ` + e.uuid }
+
+type invalidEditError struct {
+	reason string
+}
+
+func (e *invalidEditError) Error() string { return `Invalid scene edit: ` + e.reason }
+
+type headMovedError struct {
+	id   string
+	base int
+	head int
+}
+
+func (e *headMovedError) Error() string {
+	return `Scene ` + e.id + ` was saved from revision ` + strconv.Itoa(e.base) + ` but its head is now revision ` + strconv.Itoa(e.head)
+}
+
+func IsNotFound(err error) bool {
+	switch err.(type) {
+	case *noSuchSceneError, *noSuchRevisionError, *prunedRevisionError, *noSuchObjectError:
+		return true
+	}
+	return false
+}
+
+func IsPruned(err error) bool {
+	_, ok := err.(*prunedRevisionError)
+	return ok
+}
+
+func IsInvalid(err error) bool {
+	switch err.(type) {
+	case *invalidDocumentError, *invalidEditError:
+		return true
+	}
+	return false
+}
+
+func IsHeadMoved(err error) bool {
+	_, ok := err.(*headMovedError)
+	return ok
+}
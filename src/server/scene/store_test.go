@@ -0,0 +1,247 @@
+package scene
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testDocument(name string) []byte {
+	return []byte(`{"metadata":{},"project":{},"camera":{},"scripts":{},"scene":{"object":{"uuid":"S","type":"Scene","name":"` + name + `"}}}`)
+}
+
+func newTestStore(t *testing.T) (Store, func()) {
+	dir, err := ioutil.TempDir(``, `scene`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		valid bool
+	}{
+		{`complete`, string(testDocument(`a`)), true},
+		{`not json`, `{"project":`, false},
+		{`not an object`, `[]`, false},
+		{`missing scripts`, `{"project":{},"camera":{},"scene":{}}`, false},
+		{`empty`, ``, false},
+	}
+	for _, test := range tests {
+		err := Validate([]byte(test.doc))
+		if test.valid && err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		} else if !test.valid && !IsInvalid(err) {
+			t.Errorf(`%s: got %v, want an invalid document error`, test.name, err)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name string
+		// saves made after the scene is created and before the replace under test
+		saves    int
+		id       string
+		rename   string
+		base     int
+		doc      []byte
+		headMove bool
+		invalid  bool
+		notFound bool
+		wantHead int
+		wantName string
+	}{
+		{name: `unconditional`, base: 0, doc: testDocument(`b`), wantHead: 2, wantName: `first`},
+		{name: `from the head`, base: 1, doc: testDocument(`b`), wantHead: 2, wantName: `first`},
+		{name: `renamed`, rename: `second`, doc: testDocument(`b`), wantHead: 2, wantName: `second`},
+		{name: `unconditional after other saves`, saves: 2, base: 0, doc: testDocument(`b`), wantHead: 4, wantName: `first`},
+		{name: `from a stale base`, saves: 1, base: 1, doc: testDocument(`b`), headMove: true},
+		{name: `from a revision that does not exist yet`, base: 2, doc: testDocument(`b`), headMove: true},
+		{name: `invalid document`, doc: []byte(`{}`), invalid: true},
+		{name: `unknown scene`, id: `c0f5ed45-5d5a-4c5c-9a8a-3f0f0a0b0c0d`, doc: testDocument(`b`), notFound: true},
+		{name: `malformed id`, id: `../escape`, doc: testDocument(`b`), notFound: true},
+	}
+	for _, test := range tests {
+		func() {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			meta, err := store.Create(`first`, `alice`, testDocument(`a`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.saves; i++ {
+				if _, err := store.Replace(meta.Id, ``, `bob`, 0, testDocument(`a`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			id := meta.Id
+			if test.id != `` {
+				id = test.id
+			}
+
+			replaced, err := store.Replace(id, test.rename, `carol`, test.base, test.doc)
+			switch {
+			case test.headMove:
+				if !IsHeadMoved(err) {
+					t.Errorf(`%s: got %v, want a head moved error`, test.name, err)
+				}
+			case test.invalid:
+				if !IsInvalid(err) {
+					t.Errorf(`%s: got %v, want an invalid document error`, test.name, err)
+				}
+			case test.notFound:
+				if !IsNotFound(err) {
+					t.Errorf(`%s: got %v, want a not found error`, test.name, err)
+				}
+			case err != nil:
+				t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			case replaced.Revision != test.wantHead || replaced.Name != test.wantName:
+				t.Errorf(`%s: got revision %d named %q, want revision %d named %q`, test.name, replaced.Revision, replaced.Name, test.wantHead, test.wantName)
+			}
+			if err != nil {
+				// a refused replace must leave the head where it was
+				if got, _, err := store.Get(meta.Id); err != nil || got.Revision != 1+test.saves {
+					t.Errorf(`%s: head moved to %v after a refused replace (%v)`, test.name, got, err)
+				}
+				return
+			}
+
+			_, doc, err := store.Get(id)
+			if err != nil || string(doc) != string(test.doc) {
+				t.Errorf(`%s: got head document %q (%v), want %q`, test.name, doc, err, test.doc)
+			}
+			revisions, err := store.Revisions(id)
+			if err != nil || len(revisions) != test.wantHead || revisions[test.wantHead-1].Author != `carol` {
+				t.Errorf(`%s: got revisions %v (%v)`, test.name, revisions, err)
+			}
+		}()
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		number   int
+		base     int
+		prune    bool
+		headMove bool
+		notFound bool
+	}{
+		{name: `unconditional`, number: 1},
+		{name: `from the head`, number: 1, base: 2},
+		{name: `from a stale base`, number: 1, base: 1, headMove: true},
+		{name: `missing revision`, number: 3, notFound: true},
+		{name: `zero revision`, number: 0, notFound: true},
+		{name: `pruned revision`, number: 1, prune: true, notFound: true},
+	}
+	for _, test := range tests {
+		func() {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			meta, err := store.Create(`first`, `alice`, testDocument(`a`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Replace(meta.Id, ``, `bob`, 1, testDocument(`b`)); err != nil {
+				t.Fatal(err)
+			}
+			if test.prune {
+				if err := store.Prune(meta.Id, []int{1}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restored, err := store.Restore(meta.Id, test.number, `carol`, test.base)
+			switch {
+			case test.headMove:
+				if !IsHeadMoved(err) {
+					t.Errorf(`%s: got %v, want a head moved error`, test.name, err)
+				}
+				return
+			case test.notFound:
+				if !IsNotFound(err) || IsPruned(err) != test.prune {
+					t.Errorf(`%s: got %v, want a not found error`, test.name, err)
+				}
+				return
+			case err != nil:
+				t.Errorf(`%s: unexpected error: %v`, test.name, err)
+				return
+			}
+
+			revision, doc, err := store.GetRevision(meta.Id, restored.Revision)
+			if err != nil || restored.Revision != 3 || revision.RestoredFrom != test.number || string(doc) != string(testDocument(`a`)) {
+				t.Errorf(`%s: got revision %v with %q (%v)`, test.name, revision, doc, err)
+			}
+		}()
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers []int
+		invalid bool
+		missing bool
+	}{
+		{name: `old revisions`, numbers: []int{1, 2}},
+		{name: `head revision`, numbers: []int{1, 3}, invalid: true},
+		{name: `missing revision`, numbers: []int{4}, missing: true},
+	}
+	for _, test := range tests {
+		func() {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			meta, err := store.Create(`first`, `alice`, testDocument(`a`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := store.Replace(meta.Id, ``, `bob`, 0, testDocument(`b`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = store.Prune(meta.Id, test.numbers)
+			switch {
+			case test.invalid:
+				if !IsInvalid(err) {
+					t.Errorf(`%s: got %v, want an invalid edit error`, test.name, err)
+				}
+			case test.missing:
+				if !IsNotFound(err) {
+					t.Errorf(`%s: got %v, want a not found error`, test.name, err)
+				}
+			case err != nil:
+				t.Errorf(`%s: unexpected error: %v`, test.name, err)
+			}
+
+			revisions, err := store.Revisions(meta.Id)
+			if err != nil || len(revisions) != 3 {
+				t.Fatalf(`%s: got revisions %v (%v)`, test.name, revisions, err)
+			}
+			for _, revision := range revisions {
+				pruned := false
+				if !test.invalid && !test.missing {
+					for _, number := range test.numbers {
+						pruned = pruned || number == revision.Number
+					}
+				}
+				if revision.Pruned != pruned {
+					t.Errorf(`%s: revision %d pruned is %v, want %v`, test.name, revision.Number, revision.Pruned, pruned)
+				}
+			}
+		}()
+	}
+}
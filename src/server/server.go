@@ -1,23 +1,232 @@
 package main
 
 import (
-	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
-	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/json"
+	"flag"
+	"fmt"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/collab"
+	"github.com/robsix/3ditor/src/server/config"
+	"github.com/robsix/3ditor/src/server/devcert"
+	"github.com/robsix/3ditor/src/server/diff"
+	"github.com/robsix/3ditor/src/server/dirlock"
+	"github.com/robsix/3ditor/src/server/format/bundle"
+	"github.com/robsix/3ditor/src/server/format/collada"
+	"github.com/robsix/3ditor/src/server/format/gltf"
+	"github.com/robsix/3ditor/src/server/format/obj"
+	"github.com/robsix/3ditor/src/server/format/ply"
+	"github.com/robsix/3ditor/src/server/format/stl"
+	"github.com/robsix/3ditor/src/server/format/vtk"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/lock"
+	"github.com/robsix/3ditor/src/server/lod"
+	"github.com/robsix/3ditor/src/server/merge"
+	"github.com/robsix/3ditor/src/server/presence"
+	"github.com/robsix/3ditor/src/server/prune"
+	"github.com/robsix/3ditor/src/server/render"
+	"github.com/robsix/3ditor/src/server/repair"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/stats"
+	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
 )
 
+// Sub commands run instead of the server when named as the first argument, made from the configuration they run with
+var commands = map[string]func(conf *config.Config) func(args []string, out io.Writer) error{
+	"diff": func(*config.Config) func(args []string, out io.Writer) error {
+		return diff.RunCommand
+	},
+	"gltf": func(*config.Config) func(args []string, out io.Writer) error {
+		return gltf.RunCommand
+	},
+	"prune": func(conf *config.Config) func(args []string, out io.Writer) error {
+		return prune.NewCommand(string(conf.SceneDir), string(conf.AssetDir), retentionPolicy(conf))
+	},
+	"turntable": func(conf *config.Config) func(args []string, out io.Writer) error {
+		return render.NewCommand(string(conf.SceneDir), string(conf.AssetDir))
+	},
+	"stats": func(conf *config.Config) func(args []string, out io.Writer) error {
+		return stats.NewCommand(string(conf.SceneDir), string(conf.AssetDir))
+	},
+}
+
+func retentionPolicy(conf *config.Config) *prune.Policy {
+	return &prune.Policy{
+		KeepLast:      conf.Retention.KeepLast,
+		KeepDays:      conf.Retention.KeepDays,
+		KeepDailyDays: conf.Retention.KeepDailyDays,
+		AssetGrace:    time.Duration(conf.Retention.AssetGraceHours) * time.Hour,
+	}
+}
+
 func main() {
-	log := golog.NewConsoleLog(0)
-	wd, _ := os.Getwd()
-	conf, _ := json.FromFile(filepath.Join(wd, "conf.json"))
-	publicDir := filepath.Join(append([]string{wd}, conf.MustStringArray([]string{"..", "client"}, "publicDir")...)...)
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to find the working directory: ", err)
+		os.Exit(1)
+	}
+	// sub commands take their own arguments so only the server reads settings from flags
+	args := os.Args[1:]
+	var newCommand func(conf *config.Config) func(args []string, out io.Writer) error
+	if len(args) > 0 {
+		if newCommand = commands[args[0]]; newCommand != nil {
+			args = nil
+		}
+	}
+	conf, err := config.Load(wd, args, os.Environ())
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if newCommand != nil {
+		if err := newCommand(conf)(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	sceneDir := string(conf.SceneDir)
+	assetDir := string(conf.AssetDir)
+	thumbnailDir := string(conf.ThumbnailDir)
+	retention := retentionPolicy(conf)
+	pruneInterval := time.Duration(conf.Retention.IntervalHours) * time.Hour
+
+	log, err := conf.Log.Open()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open log: ", err)
+		os.Exit(1)
+	}
+	fatal := func(a ...interface{}) {
+		log.Critical(a...)
+		log.Flush()
+		os.Exit(1)
+	}
+	var onShutdown []func()
+
+	assetStore, err := asset.NewLocalStore(assetDir)
+	if err != nil {
+		fatal("failed to open asset store: ", err)
+	}
+	log.Info("storing assets in: ", assetDir)
+	assetHandler := asset.NewHandler(assetStore, log)
+	http.Handle(asset.Prefix, assetHandler)
+	http.Handle(asset.Prefix+`/`, assetHandler)
+	// held until the server exits so sub commands such as prune do not write to the store behind its back
+	if _, err := dirlock.Acquire(sceneDir); err != nil {
+		fatal("failed to lock scene store: ", err)
+	}
+	sceneStore, err := scene.NewLocalStore(sceneDir)
+	if err != nil {
+		fatal("failed to open scene store: ", err)
+	}
+	log.Info("storing scenes in: ", sceneDir)
+	if pruneInterval > 0 {
+		stopPruning := make(chan struct{})
+		pruned := prune.Schedule(sceneStore, assetStore, retention, pruneInterval, stopPruning, log)
+		onShutdown = append(onShutdown, func() {
+			close(stopPruning)
+			<-pruned
+		})
+	}
+	sceneStore = scene.WithAssets(sceneStore, assetStore)
+	if conf.Features.Thumbnails {
+		if sceneStore, err = render.WithThumbnails(sceneStore, thumbnailDir, log); err != nil {
+			fatal("failed to open thumbnail dir: ", err)
+		}
+	}
+	sceneHandler := scene.NewHandler(sceneStore, log)
+	locks := lock.NewManager()
+	sceneHandler.SetMerge(merge.Documents)
+	saveGuard := lock.NewSaveGuard(locks)
+	sceneHandler.SetGuard(saveGuard)
+	sceneHandler.HandleSub("diff", diff.NewSubHandler(sceneStore, log))
+	sceneHandler.HandleSub("locks", lock.NewSubHandler(locks, sceneStore, log))
+	if conf.Features.Collab {
+		collabHub := collab.NewHub(sceneStore, merge.Documents, locks, log)
+		sceneHandler.HandleSub("collab", collab.NewSubHandler(collabHub))
+		onShutdown = append(onShutdown, collabHub.Close)
+	}
+	if conf.Features.Presence {
+		presenceHub := presence.NewHub(sceneStore, log)
+		sceneHandler.HandleSub("presence", presence.NewSubHandler(presenceHub))
+		onShutdown = append(onShutdown, presenceHub.Close)
+	}
+	sceneHandler.HandleSub("stl", stl.NewSubHandler(sceneStore, log))
+	sceneHandler.HandleSub("gltf", gltf.NewSubHandler(sceneStore, assetStore, log))
+	if conf.Features.Thumbnails {
+		sceneHandler.HandleSub("thumbnail", render.NewThumbnailSubHandler(sceneStore, thumbnailDir, log))
+	}
+	if conf.Features.Turntable {
+		sceneHandler.HandleSub("turntable", render.NewTurntableSubHandler(sceneStore, log))
+	}
+	if conf.Features.Lod {
+		sceneHandler.HandleSub("lod", lod.NewSubHandler(sceneStore, saveGuard, log))
+	}
+	if conf.Features.Repair {
+		sceneHandler.HandleSub("check", repair.NewCheckSubHandler(sceneStore, log))
+		sceneHandler.HandleSub("repair", repair.NewSubHandler(sceneStore, saveGuard, log))
+	}
+	sceneHandler.HandleSub("stats", stats.NewSubHandler(sceneStore, assetStore, log))
+	http.Handle(scene.Prefix, sceneHandler)
+	http.Handle(scene.Prefix+`/`, sceneHandler)
+	if conf.Features.Import {
+		importHandler := importer.NewHandler(log)
+		importHandler.Register("obj", obj.Import)
+		importHandler.Register("stl", stl.Import)
+		importHandler.Register("gltf", gltf.Import)
+		importHandler.Register("glb", gltf.Import)
+		importHandler.Register("ply", ply.Import)
+		importHandler.Register("vtk", vtk.Import)
+		importHandler.Register("dae", collada.Import)
+		importHandler.Register("zip", bundle.NewImportFunc(importHandler, assetStore))
+		importHandler.Register("kmz", bundle.NewImportFunc(importHandler, assetStore))
+		http.Handle(importer.Prefix+`/`, importHandler)
+	}
 
-	log.Info("serving static files from: ", publicDir)
-	fileServer := http.FileServer(http.Dir(publicDir))
-	http.Handle(`/`, fileServer)
+	files, from := clientFiles(conf)
+	log.Info("serving static files from: ", from)
+	static := newStaticHandler(files, log)
+	http.Handle(`/`, static)
 
-	log.Info("server listening on port 8080")
-	http.ListenAndServe(":8080", nil)
+	limit := &bodyLimit{handler: http.DefaultServeMux}
+	limit.setMb(conf.Limits.RequestMb)
+	s := &server{
+		wd:         wd,
+		args:       args,
+		conf:       conf,
+		log:        log,
+		http:       &http.Server{Addr: conf.Listen, Handler: limit},
+		certFile:   string(conf.Tls.Cert),
+		keyFile:    string(conf.Tls.Key),
+		static:     static,
+		limit:      limit,
+		onShutdown: onShutdown,
+	}
+	if s.certFile == `` && conf.Tls.SelfSigned {
+		cert, err := devcert.Ensure(string(conf.Tls.SelfSignedDir), conf.Tls.HostList())
+		if err != nil {
+			fatal("failed to make development certificate: ", err)
+		}
+		if cert.Generated {
+			log.Info("generated development certificate: ", cert.CertFile)
+		}
+		log.Info("serving https with self signed development certificate for ", strings.Join(cert.Hosts, ", "), ", sha-256 fingerprint ", cert.Fingerprint, ", expires ", cert.NotAfter.Format("2006-01-02"))
+		s.certFile, s.keyFile = cert.CertFile, cert.KeyFile
+	}
+	if s.certFile != `` {
+		// websockets still upgrade over HTTP/1.1 connections as extended CONNECT is left off
+		s.http.Protocols = &http.Protocols{}
+		s.http.Protocols.SetHTTP1(true)
+		s.http.Protocols.SetHTTP2(true)
+	}
+	if conf.Tls.RedirectHttp != `` {
+		s.redirect = &http.Server{Addr: conf.Tls.RedirectHttp, Handler: redirectToHttps(conf.Listen)}
+	}
+	code := s.run()
+	log.Flush()
+	os.Exit(code)
 }
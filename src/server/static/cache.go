@@ -0,0 +1,165 @@
+package static
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// below this compressing saves less than the headers it costs
+	minCompressSize = 1 << 10
+	// above this the file is sent as it is rather than held compressed in memory
+	maxCompressSize = 16 << 20
+)
+
+// Precompressed variants looked for next to a file, in order of preference, by coding and file extension
+var variants = []struct {
+	coding string
+	ext    string
+}{{`br`, `.br`}, {`gzip`, `.gz`}}
+
+// What is known about a file as it was last seen, redone when its size or modification time change
+type entry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+	// the file gzipped on the fly for clients when it has no precompressed variant, made on first use
+	gzipped []byte
+}
+
+type cache struct {
+	mtx     sync.Mutex
+	entries map[string]*entry
+}
+
+func newCache() *cache {
+	return &cache{entries: map[string]*entry{}}
+}
+
+// A representation of a file ready to serve
+type content struct {
+	io.ReadSeeker
+	io.Closer
+	encoding string
+	etag     string
+	modTime  time.Time
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// The best representation of the file name for a client accepting the content codings in accepts: a precompressed
+// variant, else the file gzipped when its type compresses, else the file itself
+func (c *cache) get(files http.FileSystem, name, contentType string, accepts map[string]bool) (*content, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e, err := c.entry(name, f, info.Size(), info.ModTime())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	for _, variant := range variants {
+		if !accepts[variant.coding] {
+			continue
+		}
+		v, err := files.Open(name + variant.ext)
+		if err != nil {
+			continue
+		}
+		if vInfo, err := v.Stat(); err != nil || vInfo.IsDir() {
+			v.Close()
+			continue
+		}
+		f.Close()
+		return &content{ReadSeeker: v, Closer: v, encoding: variant.coding, etag: tagged(e.etag, variant.coding), modTime: e.modTime}, nil
+	}
+
+	if accepts[`gzip`] && compresses(contentType) && e.size >= minCompressSize && e.size <= maxCompressSize {
+		gzipped, err := c.gzip(e, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &content{ReadSeeker: bytes.NewReader(gzipped), Closer: nopCloser{}, encoding: `gzip`, etag: tagged(e.etag, `gzip`), modTime: e.modTime}, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &content{ReadSeeker: f, Closer: f, etag: e.etag, modTime: e.modTime}, nil
+}
+
+// The entry for the file name opened as f, made anew when the file has changed since it was last seen
+func (c *cache) entry(name string, f http.File, size int64, modTime time.Time) (*entry, error) {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	if e := c.entries[name]; e != nil && e.size == size && e.modTime.Equal(modTime) {
+		return e, nil
+	}
+	e := &entry{size: size, modTime: modTime}
+	if modTime.IsZero() {
+		// files embedded in the binary have no modification time so are told apart by their contents
+		hash := sha1.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return nil, err
+		}
+		e.etag = `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+	} else {
+		e.etag = `"` + strconv.FormatInt(size, 16) + `-` + strconv.FormatInt(modTime.UnixNano(), 16) + `"`
+	}
+	c.entries[name] = e
+	return e, nil
+}
+
+func (c *cache) gzip(e *entry, f http.File) ([]byte, error) {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	if e.gzipped != nil {
+		return e.gzipped, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if _, err := io.Copy(writer, f); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	e.gzipped = b.Bytes()
+	return e.gzipped, nil
+}
+
+// Each representation of a file needs its own ETag
+func tagged(etag, coding string) string {
+	return strings.TrimSuffix(etag, `"`) + `-` + coding + `"`
+}
+
+func compresses(contentType string) bool {
+	contentType = strings.SplitN(contentType, `;`, 2)[0]
+	return strings.HasPrefix(contentType, `text/`) ||
+		strings.HasSuffix(contentType, `javascript`) ||
+		strings.HasSuffix(contentType, `json`) ||
+		strings.HasSuffix(contentType, `xml`)
+}
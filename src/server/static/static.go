@@ -0,0 +1,141 @@
+/*
+Serves the client as a single page app: paths the client routes itself get index.html, directories are never listed,
+text is sent compressed and fingerprinted build output is cached for good
+*/
+package static
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	index = `/index.html`
+	// fingerprinted files never change under the same name
+	immutable = `public, max-age=31536000, immutable`
+	// everything else, index.html above all, is revalidated against its ETag on every use
+	revalidate = `no-cache`
+)
+
+// A content hash of at least 8 hex digits between dots or after a dash, e.g. main.3f9a2c1b.js or three-3f9a2c1b.min.js
+var fingerprint = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.`)
+
+// Serves GET and HEAD of the files in files. Paths that are not files and do not look like one, having no extension
+// in their last segment, are routes of the client so get /index.html. Paths under /api/ never fall back, a missing
+// api is a 404 rather than a page.
+func NewHandler(files http.FileSystem, log golog.Log) http.Handler {
+	return &handler{files: files, log: log, cache: newCache()}
+}
+
+type handler struct {
+	files http.FileSystem
+	log   golog.Log
+	cache *cache
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != `GET` && r.Method != `HEAD` {
+		api.MethodNotAllowed(w, `GET`, `HEAD`)
+		return
+	}
+	name := path.Clean(`/` + r.URL.Path)
+	file, err := h.find(name)
+	if os.IsNotExist(err) && isRoute(name) {
+		name = index
+		file, err = h.find(name)
+	}
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		h.log.Error(`failed to serve static file: `, name, ` `, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serve(w, r, file)
+}
+
+// The file to serve for name, a directory is served by its index.html or else not at all
+func (h *handler) find(name string) (string, error) {
+	info, err := h.stat(name)
+	if err != nil {
+		return ``, err
+	}
+	if info.IsDir() {
+		name = path.Join(name, index)
+		if info, err = h.stat(name); err != nil {
+			return ``, err
+		} else if info.IsDir() {
+			return ``, os.ErrNotExist
+		}
+	}
+	return name, nil
+}
+
+func (h *handler) stat(name string) (os.FileInfo, error) {
+	f, err := h.files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func isRoute(name string) bool {
+	return !strings.HasPrefix(name+`/`, `/api/`) && path.Ext(name) == ``
+}
+
+// Serves the file name, or a precompressed variant of it the client accepts
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, name string) {
+	header := w.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == `` {
+		contentType = `application/octet-stream`
+	}
+	header.Set(`Content-Type`, contentType)
+	if fingerprint.MatchString(path.Base(name)) {
+		header.Set(`Cache-Control`, immutable)
+	} else {
+		header.Set(`Cache-Control`, revalidate)
+	}
+	header.Add(`Vary`, `Accept-Encoding`)
+
+	content, err := h.cache.get(h.files, name, contentType, accepted(r.Header.Get(`Accept-Encoding`)))
+	if err != nil {
+		h.log.Error(`failed to serve static file: `, name, ` `, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	if content.encoding != `` {
+		header.Set(`Content-Encoding`, content.encoding)
+	}
+	header.Set(`ETag`, content.etag)
+	http.ServeContent(w, r, name, content.modTime, content)
+}
+
+// The content codings acceptable to a client with the Accept-Encoding header value, the q=0 ones left out
+func accepted(acceptEncoding string) map[string]bool {
+	codings := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, `,`) {
+		params := strings.Split(part, `;`)
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		refused := false
+		for _, param := range params[1:] {
+			param = strings.ReplaceAll(param, ` `, ``)
+			if param == `q=0` || strings.HasPrefix(param, `q=0.`) && strings.Trim(param[4:], `0`) == `` {
+				refused = true
+			}
+		}
+		if coding != `` && !refused {
+			codings[coding] = true
+		}
+	}
+	return codings
+}
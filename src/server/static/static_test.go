@@ -0,0 +1,164 @@
+package static
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `static`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := strings.Repeat(`console.log(1);`, 100)
+	files := map[string][]byte{
+		`secret.txt`:               []byte(`secret`),
+		`public/index.html`:        []byte(`index`),
+		`public/app.js`:            []byte(script),
+		`public/main.3f9a2c1b.js`:  []byte(`main`),
+		`public/style.css`:         []byte(`style`),
+		`public/style.css.gz`:      gzipped(t, `precompressed style`),
+		`public/image.png`:         bytes.Repeat([]byte{0}, 2048),
+		`public/docs/index.html`:   []byte(`docs`),
+		`public/empty/readme.json`: []byte(`{}`),
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandler(http.Dir(filepath.Join(dir, `public`)), golog.NewDevNullLog())
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		acceptEncoding string
+		status         int
+		// the body once decoded
+		body         string
+		encoding     string
+		cacheControl string
+	}{
+		{name: `root`, path: `/`, status: 200, body: `index`, cacheControl: revalidate},
+		{name: `index`, path: `/index.html`, status: 200, body: `index`, cacheControl: revalidate},
+		{name: `client route`, path: `/scenes/42/edit`, status: 200, body: `index`, cacheControl: revalidate},
+		{name: `directory with an index`, path: `/docs/`, status: 200, body: `docs`},
+		{name: `directory without an index is never listed`, path: `/empty`, status: 200, body: `index`},
+		{name: `missing file`, path: `/missing.js`, status: 404},
+		{name: `api does not fall back`, path: `/api/nope`, status: 404},
+		{name: `api root does not fall back`, path: `/api`, status: 404},
+		{name: `traversal`, path: `/../secret.txt`, status: 404},
+		{name: `nested traversal`, path: `/docs/../../secret.txt`, status: 404},
+		{name: `traversal to a route`, path: `/../secret`, status: 200, body: `index`},
+		{name: `gzipped on the fly`, path: `/app.js`, acceptEncoding: `deflate, gzip`, status: 200, body: script, encoding: `gzip`},
+		{name: `not accepted`, path: `/app.js`, acceptEncoding: `br`, status: 200, body: script},
+		{name: `refused`, path: `/app.js`, acceptEncoding: `gzip;q=0`, status: 200, body: script},
+		{name: `too small to compress`, path: `/index.html`, acceptEncoding: `gzip`, status: 200, body: `index`},
+		{name: `precompressed`, path: `/style.css`, acceptEncoding: `gzip`, status: 200, body: `precompressed style`, encoding: `gzip`},
+		{name: `precompressed not accepted`, path: `/style.css`, status: 200, body: `style`},
+		{name: `binary`, path: `/image.png`, acceptEncoding: `gzip`, status: 200, body: string(files[`public/image.png`])},
+		{name: `fingerprinted`, path: `/main.3f9a2c1b.js`, status: 200, body: `main`, cacheControl: immutable},
+		{name: `head`, method: `HEAD`, path: `/app.js`, status: 200},
+		{name: `post`, method: `POST`, path: `/app.js`, status: 405},
+	}
+	for _, test := range tests {
+		if test.method == `` {
+			test.method = `GET`
+		}
+		r := httptest.NewRequest(test.method, `/`, nil)
+		// set after parsing so the path reaches the handler as a client that does not clean it would send it
+		r.URL.Path = test.path
+		if test.acceptEncoding != `` {
+			r.Header.Set(`Accept-Encoding`, test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf(`%s: got status %d, want %d`, test.name, w.Code, test.status)
+			continue
+		}
+		if test.status != 200 {
+			continue
+		}
+		if encoding := w.Header().Get(`Content-Encoding`); encoding != test.encoding {
+			t.Errorf(`%s: got encoding %q, want %q`, test.name, encoding, test.encoding)
+		}
+		if test.cacheControl != `` && w.Header().Get(`Cache-Control`) != test.cacheControl {
+			t.Errorf(`%s: got Cache-Control %q, want %q`, test.name, w.Header().Get(`Cache-Control`), test.cacheControl)
+		}
+		body := w.Body.Bytes()
+		if test.encoding == `gzip` {
+			r, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Errorf(`%s: %v`, test.name, err)
+				continue
+			}
+			body, _ = ioutil.ReadAll(r)
+		}
+		if string(body) != test.body {
+			t.Errorf(`%s: got body %.40q, want %.40q`, test.name, body, test.body)
+		}
+	}
+}
+
+func TestHandlerRevalidate(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `static`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, `app.js`)
+	ioutil.WriteFile(file, []byte(strings.Repeat(`a`, 2048)), 0644)
+	h := NewHandler(http.Dir(dir), golog.NewDevNullLog())
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(`GET`, `/app.js`, nil)
+		r.Header.Set(`Accept-Encoding`, acceptEncoding)
+		r.Header.Set(`If-None-Match`, ifNoneMatch)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	plain, compressed := get(``, ``).Header().Get(`ETag`), get(`gzip`, ``).Header().Get(`ETag`)
+	if plain == `` || plain == compressed {
+		t.Fatalf(`got ETags %s and %s, want a different one for each representation`, plain, compressed)
+	}
+	if w := get(`gzip`, compressed); w.Code != http.StatusNotModified {
+		t.Errorf(`got status %d revalidating, want 304`, w.Code)
+	}
+	if w := get(``, compressed); w.Code != http.StatusOK {
+		t.Errorf(`got status %d revalidating the plain file with the gzipped ETag, want 200`, w.Code)
+	}
+
+	ioutil.WriteFile(file, []byte(strings.Repeat(`b`, 4096)), 0644)
+	w := get(`gzip`, compressed)
+	if w.Code != http.StatusOK {
+		t.Errorf(`got status %d after the file changed, want 200`, w.Code)
+	}
+	if r, err := gzip.NewReader(w.Body); err != nil {
+		t.Error(err)
+	} else if body, _ := ioutil.ReadAll(r); string(body) != strings.Repeat(`b`, 4096) {
+		t.Errorf(`got the old gzipped contents after the file changed`)
+	}
+}
@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/importer"
+	"github.com/robsix/3ditor/src/server/scene"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// Limits a scene must keep within, zero is no limit
+type Budget struct {
+	Triangles    int
+	Vertices     int
+	TextureBytes int64
+}
+
+// Why s is over the budget, empty when it is within it
+func (b *Budget) Check(s *Stats) []string {
+	reasons := []string{}
+	if b.Triangles > 0 && s.Triangles > b.Triangles {
+		reasons = append(reasons, strconv.Itoa(s.Triangles)+` triangles is over the budget of `+strconv.Itoa(b.Triangles))
+	}
+	if b.Vertices > 0 && s.Vertices > b.Vertices {
+		reasons = append(reasons, strconv.Itoa(s.Vertices)+` vertices is over the budget of `+strconv.Itoa(b.Vertices))
+	}
+	if b.TextureBytes > 0 && s.TextureBytes > b.TextureBytes {
+		reasons = append(reasons, formatBytes(s.TextureBytes)+` of textures is over the budget of `+formatBytes(b.TextureBytes))
+	}
+	return reasons
+}
+
+// Implements `server stats [-json] [-objects] [-revision n] [-max-triangles n] [-max-vertices n] [-max-texture-mb n] [<scene>...]`
+// printing the stats of each scene given, either the id of a scene in sceneDir or a scene .json file, or of every
+// stored scene when none are given. It fails when any scene is over a budget set by the -max flags so it can guard
+// the library from a script:
+//
+//	server stats -max-triangles 500000 -max-texture-mb 256
+//	server stats -json -objects 6b522d63-bf89-4515-9833-b4032af67832
+func NewCommand(sceneDir, assetDir string) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		flags := flag.NewFlagSet(`stats`, flag.ContinueOnError)
+		asJson := flags.Bool(`json`, false, `print the stats as json`)
+		objects := flags.Bool(`objects`, false, `list the stats of each object as well as the totals`)
+		revision := flags.Int(`revision`, 0, `revision of a single stored scene, defaults to the head revision`)
+		budget := &Budget{}
+		flags.IntVar(&budget.Triangles, `max-triangles`, 0, `fail when a scene has more triangles`)
+		flags.IntVar(&budget.Vertices, `max-vertices`, 0, `fail when a scene has more vertices`)
+		textureMb := flags.Int64(`max-texture-mb`, 0, `fail when a scene's textures take more megabytes`)
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *revision != 0 && flags.NArg() != 1 {
+			return &usageError{}
+		}
+		budget.TextureBytes = *textureMb << 20
+
+		scenes, err := scene.NewLocalStore(sceneDir)
+		if err != nil {
+			return err
+		}
+		assets, err := asset.NewLocalStore(assetDir)
+		if err != nil {
+			return err
+		}
+		store := scene.WithAssets(scenes, assets)
+		ins := flags.Args()
+		if len(ins) == 0 {
+			metas, err := store.List()
+			if err != nil {
+				return err
+			}
+			for _, meta := range metas {
+				ins = append(ins, meta.Id)
+			}
+		}
+
+		all := []*Stats{}
+		over := 0
+		for _, in := range ins {
+			s, err := read(store, assets, in, *revision)
+			if err != nil {
+				return err
+			}
+			if !*objects {
+				s.PerObject = nil
+			}
+			s.OverBudget = budget.Check(s)
+			if len(s.OverBudget) > 0 {
+				over++
+			}
+			all = append(all, s)
+		}
+
+		if *asJson {
+			data, _ := json.MarshalIndent(all, ``, `  `)
+			if _, err := out.Write(append(data, '\n')); err != nil {
+				return err
+			}
+		} else {
+			for _, s := range all {
+				text := s.String()
+				if *objects {
+					text += s.ObjectsString()
+				}
+				for _, reason := range s.OverBudget {
+					text += `  over budget: ` + reason + "\n"
+				}
+				if _, err := io.WriteString(out, text); err != nil {
+					return err
+				}
+			}
+		}
+		if over > 0 {
+			return &overBudgetError{scenes: over}
+		}
+		return nil
+	}
+}
+
+// The stats of a scene .json file, or of the stored scene with id in
+func read(store scene.Store, assets asset.Store, in string, revision int) (*Stats, error) {
+	if importer.Ext(in) == `json` {
+		doc, err := ioutil.ReadFile(in)
+		if err != nil {
+			return nil, err
+		}
+		g, err := scene.ParseGraph(doc)
+		if err != nil {
+			return nil, err
+		}
+		s := Compute(g, assets)
+		s.Name = in
+		return s, nil
+	}
+	meta, doc, err := store.Get(in)
+	if err != nil {
+		return nil, err
+	}
+	number := meta.Revision
+	if revision > 0 {
+		if _, doc, err = store.GetRevision(in, revision); err != nil {
+			return nil, err
+		}
+		number = revision
+	}
+	g, err := scene.ParseGraph(doc)
+	if err != nil {
+		return nil, err
+	}
+	s := Compute(g, assets)
+	s.Id, s.Name, s.Revision = meta.Id, meta.Name, number
+	return s, nil
+}
+
+type overBudgetError struct {
+	scenes int
+}
+
+func (e *overBudgetError) Error() string {
+	return strconv.Itoa(e.scenes) + ` scenes over budget`
+}
+
+type usageError struct{}
+
+func (e *usageError) Error() string {
+	return `usage: stats [-json] [-objects] [-revision n] [-max-triangles n] [-max-vertices n] [-max-texture-mb n] [<scene id|scene.json>...]`
+}
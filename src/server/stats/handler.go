@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/api"
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/scene"
+	"net/http"
+)
+
+// Serves GET /api/scenes/{id}/stats?revision={n} with the Stats of the scene, revision defaults to the head revision
+func NewSubHandler(store scene.Store, assets asset.Store, log golog.Log) scene.SubHandler {
+	return func(w http.ResponseWriter, r *http.Request, id string, segments []string) {
+		if len(segments) != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != `GET` {
+			api.MethodNotAllowed(w, `GET`)
+			return
+		}
+
+		rev := scene.ReadRequestedRevision(w, r, store, log, id)
+		if rev == nil {
+			return
+		}
+		s := Compute(rev.Graph, assets)
+		s.Id, s.Name, s.Revision = rev.Meta.Id, rev.Meta.Name, rev.Number
+		api.WriteJson(w, http.StatusOK, s)
+	}
+}
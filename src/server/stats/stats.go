@@ -0,0 +1,276 @@
+/*
+Counts what a stored scene holds and what it costs to draw, so budgets can be checked across every scene rather than
+only the one open in an editor
+*/
+package stats
+
+import (
+	"github.com/robsix/3ditor/src/server/asset"
+	"github.com/robsix/3ditor/src/server/mesh"
+	"github.com/robsix/3ditor/src/server/scene"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An axis aligned box in world space
+type Box struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
+}
+
+func (b *Box) union(o *Box) *Box {
+	if b == nil {
+		return o
+	}
+	if o == nil {
+		return b
+	}
+	out := &Box{}
+	for k := range out.Min {
+		out.Min[k], out.Max[k] = math.Min(b.Min[k], o.Min[k]), math.Max(b.Max[k], o.Max[k])
+	}
+	return out
+}
+
+type Stats struct {
+	// the scene the stats are for, left empty for documents not read from the store
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	// objects under the scene, not counting the scene itself
+	Objects int            `json:"objects"`
+	Types   map[string]int `json:"types"`
+	// summed over every object drawing a geometry, so a geometry shared by two meshes counts twice
+	Vertices   int `json:"vertices"`
+	Triangles  int `json:"triangles"`
+	Geometries int `json:"geometries"`
+	Materials  int `json:"materials"`
+	Textures   int `json:"textures"`
+	// estimated bytes the textures take once uploaded, see textureBytes
+	TextureBytes int64 `json:"textureBytes"`
+	// textures whose image size could not be found, they are left out of TextureBytes
+	UnsizedTextures int           `json:"unsizedTextures,omitempty"`
+	Scripts         int           `json:"scripts"`
+	Bounds          *Box          `json:"bounds"`
+	PerObject       []*ObjectStat `json:"perObject,omitempty"`
+	// set by the stats command to why the scene is over its budget
+	OverBudget []string `json:"overBudget,omitempty"`
+}
+
+// The stats of a single object, not including its children
+type ObjectStat struct {
+	Uuid         string `json:"uuid"`
+	Name         string `json:"name,omitempty"`
+	Type         string `json:"type"`
+	Parent       string `json:"parent"`
+	Geometry     string `json:"geometry,omitempty"`
+	Material     string `json:"material,omitempty"`
+	Vertices     int    `json:"vertices"`
+	Triangles    int    `json:"triangles"`
+	TextureBytes int64  `json:"textureBytes"`
+	Scripts      int    `json:"scripts"`
+	Bounds       *Box   `json:"bounds,omitempty"`
+	// why the geometry could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// The stats of the scene in g. Images held in assets are sized by reading them from assets, which may be nil to leave
+// them unsized.
+func Compute(g *scene.Graph, assets asset.Store) *Stats {
+	s := &Stats{
+		Types:      map[string]int{},
+		Geometries: len(g.Geometries),
+		Materials:  len(g.Materials),
+		Textures:   len(g.Textures),
+		PerObject:  []*ObjectStat{},
+	}
+	textures := map[string]int64{}
+	for uuid, texture := range g.Textures {
+		image := g.Images[stringOf(texture[`image`])]
+		if width, height, ok := imageSize(stringOf(image[`url`]), assets); ok {
+			textures[uuid] = textureBytes(texture, width, height)
+			s.TextureBytes += textures[uuid]
+		} else {
+			s.UnsizedTextures++
+		}
+	}
+
+	instances := map[string]*mesh.Instance{}
+	for _, instance := range mesh.Instances(g, g.Root) {
+		instances[instance.Node.Uuid] = instance
+	}
+	g.Walk(func(node *scene.Node) {
+		if node.Uuid == g.Root {
+			return
+		}
+		o := &ObjectStat{
+			Uuid:     node.Uuid,
+			Name:     stringOf(node.Object[`name`]),
+			Type:     stringOf(node.Object[`type`]),
+			Parent:   node.Parent,
+			Geometry: stringOf(node.Object[`geometry`]),
+			Material: stringOf(node.Object[`material`]),
+			Scripts:  len(g.Scripts[node.Uuid]),
+		}
+		if instance := instances[node.Uuid]; instance != nil {
+			if instance.Err != nil {
+				o.Error = instance.Err.Error()
+			} else {
+				o.Vertices = instance.Mesh.VertexCount()
+				if instance.IsSurface() {
+					o.Triangles = instance.Mesh.TriangleCount()
+				}
+				o.Bounds = bounds(instance.Mesh, instance.World)
+			}
+		}
+		for uuid := range materialTextures(g, g.Materials[o.Material]) {
+			o.TextureBytes += textures[uuid]
+		}
+
+		s.Objects++
+		s.Types[o.Type]++
+		s.Vertices += o.Vertices
+		s.Triangles += o.Triangles
+		s.Scripts += o.Scripts
+		s.Bounds = s.Bounds.union(o.Bounds)
+		s.PerObject = append(s.PerObject, o)
+	})
+	return s
+}
+
+func stringOf(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// The world space bounds of the mesh's vertices, nil when it has none
+func bounds(m *mesh.Mesh, world []float64) *Box {
+	if m.VertexCount() == 0 {
+		return nil
+	}
+	b := &Box{}
+	for k := range b.Min {
+		b.Min[k], b.Max[k] = math.Inf(1), math.Inf(-1)
+	}
+	for i := 0; i < m.VertexCount(); i++ {
+		v := m.Vertex(i)
+		x, y, z := mesh.Apply(world, v[0], v[1], v[2])
+		for k, value := range [3]float64{x, y, z} {
+			b.Min[k], b.Max[k] = math.Min(b.Min[k], value), math.Max(b.Max[k], value)
+		}
+	}
+	return b
+}
+
+// The uuids of the textures a material maps, including those of the materials inside a MultiMaterial
+func materialTextures(g *scene.Graph, material map[string]interface{}) map[string]bool {
+	uuids := map[string]bool{}
+	var find func(v interface{})
+	find = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if g.Textures[v] != nil {
+				uuids[v] = true
+			}
+		case []interface{}:
+			for _, item := range v {
+				find(item)
+			}
+		case map[string]interface{}:
+			for key, item := range v {
+				if key != `uuid` {
+					find(item)
+				}
+			}
+		}
+	}
+	find(material)
+	return uuids
+}
+
+func (s *Stats) String() string {
+	var b strings.Builder
+	name := s.Name
+	if name == `` {
+		name = `scene`
+	}
+	b.WriteString(strconv.Quote(name))
+	if s.Id != `` {
+		b.WriteString(` ` + s.Id + ` revision ` + strconv.Itoa(s.Revision))
+	}
+	b.WriteString(": " + strconv.Itoa(s.Objects) + ` objects, ` + strconv.Itoa(s.Triangles) + ` triangles, ` +
+		strconv.Itoa(s.Vertices) + ` vertices, ` + strconv.Itoa(s.Materials) + ` materials, ` + strconv.Itoa(s.Textures) +
+		` textures (` + formatBytes(s.TextureBytes))
+	if s.UnsizedTextures > 0 {
+		b.WriteString(`, ` + strconv.Itoa(s.UnsizedTextures) + ` unsized`)
+	}
+	b.WriteString(`), ` + strconv.Itoa(s.Scripts) + ` scripts`)
+	if s.Bounds != nil {
+		b.WriteString(`, size ` + formatSize(s.Bounds))
+	}
+	b.WriteString("\n")
+
+	types := make([]string, 0, len(s.Types))
+	for typ := range s.Types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for i, typ := range types {
+		types[i] = typ + ` ` + strconv.Itoa(s.Types[typ])
+	}
+	if len(types) > 0 {
+		b.WriteString(`  ` + strings.Join(types, `, `) + "\n")
+	}
+	return b.String()
+}
+
+// One line per object for the objects that draw something, have textures or scripts
+func (s *Stats) ObjectsString() string {
+	var b strings.Builder
+	for _, o := range s.PerObject {
+		if o.Vertices == 0 && o.TextureBytes == 0 && o.Scripts == 0 && o.Error == `` {
+			continue
+		}
+		name := o.Name
+		if name == `` {
+			name = o.Type
+		}
+		b.WriteString(`  ` + strconv.Quote(name) + ` ` + o.Uuid + `: `)
+		if o.Error != `` {
+			b.WriteString(o.Error + "\n")
+			continue
+		}
+		b.WriteString(strconv.Itoa(o.Triangles) + ` triangles, ` + strconv.Itoa(o.Vertices) + ` vertices, ` +
+			formatBytes(o.TextureBytes) + ` textures, ` + strconv.Itoa(o.Scripts) + ` scripts`)
+		if o.Bounds != nil {
+			b.WriteString(`, size ` + formatSize(o.Bounds))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func formatSize(b *Box) string {
+	parts := make([]string, 3)
+	for k := range parts {
+		parts[k] = strconv.FormatFloat(b.Max[k]-b.Min[k], 'g', 4, 64)
+	}
+	return strings.Join(parts, ` x `)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + ` B`
+	}
+	value, suffix := float64(n)/unit, `KB`
+	for _, next := range []string{`MB`, `GB`, `TB`} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + ` ` + suffix
+}
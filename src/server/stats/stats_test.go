@@ -0,0 +1,228 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/github.com/robsix/golog"
+	"github.com/robsix/3ditor/src/server/scene"
+	"github.com/robsix/3ditor/src/server/scene/scenetest"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A data uri of a width by height png
+func pngUri(t *testing.T, width, height int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return `data:image/png;base64,` + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// Box A moved to x=10 with its texture mapped 4x4 image and a script, its child B sharing the box geometry and an
+// empty group C. Texture U's image can not be read.
+func testDocument(t *testing.T) string {
+	b := scenetest.Object(`B`, `b`, `N`, scenetest.Identity)
+	a := scenetest.Object(`A`, `a`, `M`, scenetest.Translation(10, 0, 0), b)
+	doc := scenetest.Document(`0`, a, `{"uuid":"C","type":"Group","name":"c","matrix":`+scenetest.Identity+`}`)
+	doc = strings.Replace(doc, `"color":0}`, `"color":0,"map":"T"}`, 1)
+	doc = strings.Replace(doc, `"scripts":{}`, `"scripts":{"A":[{"name":"spin","source":"this.rotation.y += 0.1"}]}`, 1)
+	return strings.Replace(doc, `,"object":`, `,"textures":[`+
+		`{"uuid":"T","image":"I","wrap":[1001,1001],"minFilter":1008},{"uuid":"U","image":"J"}],`+
+		`"images":[{"uuid":"I","url":"`+pngUri(t, 4, 4)+`"},{"uuid":"J","url":"textures/missing.png"}],"object":`, 1)
+}
+
+func TestCompute(t *testing.T) {
+	g, err := scene.ParseGraph([]byte(testDocument(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Compute(g, nil)
+	want := &Stats{
+		Objects:         3,
+		Types:           map[string]int{`Mesh`: 2, `Group`: 1},
+		Vertices:        48,
+		Triangles:       24,
+		Geometries:      1,
+		Materials:       2,
+		Textures:        2,
+		TextureBytes:    84,
+		UnsizedTextures: 1,
+		Scripts:         1,
+		Bounds:          &Box{Min: [3]float64{9.5, -0.5, -0.5}, Max: [3]float64{10.5, 0.5, 0.5}},
+	}
+	perObject := s.PerObject
+	s.PerObject = nil
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf(`got %+v, want %+v`, s, want)
+	}
+	if len(perObject) != 3 {
+		t.Fatalf(`got %d objects, want 3`, len(perObject))
+	}
+	if a := perObject[0]; a.Uuid != `A` || a.Triangles != 12 || a.TextureBytes != 84 || a.Scripts != 1 || a.Parent != `S` {
+		t.Errorf(`got %+v for A`, a)
+	}
+	if b := perObject[1]; b.Uuid != `B` || b.Parent != `A` || b.TextureBytes != 0 || b.Bounds.Min[0] != 9.5 {
+		t.Errorf(`got %+v for B`, b)
+	}
+	if c := perObject[2]; c.Uuid != `C` || c.Vertices != 0 || c.Bounds != nil {
+		t.Errorf(`got %+v for C`, c)
+	}
+	if text := s.String(); !strings.HasPrefix(text, `"scene": 3 objects, 24 triangles, 48 vertices, 2 materials, 2 textures (84 B, 1 unsized), 1 scripts, size 1 x 1 x 1`) {
+		t.Errorf(`got %q`, text)
+	}
+}
+
+func TestTextureBytes(t *testing.T) {
+	tests := []struct {
+		name          string
+		texture       string
+		width, height int
+		want          int64
+	}{
+		{name: `mipmapped power of two`, texture: `{}`, width: 4, height: 4, want: (16 + 4 + 1) * 4},
+		{name: `mipmapped oblong`, texture: `{}`, width: 4, height: 1, want: (4 + 2 + 1) * 4},
+		{name: `scaled to a power of two`, texture: `{}`, width: 3, height: 5, want: (16 + 4 + 1) * 4},
+		{name: `linear filtered and clamped`, texture: `{"minFilter":1006,"wrap":[1001,1001]}`, width: 3, height: 5, want: 15 * 4},
+		{name: `linear filtered but repeating`, texture: `{"minFilter":1006,"wrap":[1000,1001]}`, width: 3, height: 5, want: (16 + 4 + 1) * 4},
+		{name: `one pixel`, texture: `{}`, width: 1, height: 1, want: 4},
+	}
+	for _, test := range tests {
+		texture := map[string]interface{}{}
+		json.Unmarshal([]byte(test.texture), &texture)
+		if got := textureBytes(texture, test.width, test.height); got != test.want {
+			t.Errorf(`%s: got %d bytes, want %d`, test.name, got, test.want)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	s := &Stats{Triangles: 100, Vertices: 300, TextureBytes: 2 << 20}
+	tests := []struct {
+		name   string
+		budget Budget
+		want   []string
+	}{
+		{name: `no limits`, want: []string{}},
+		{name: `within`, budget: Budget{Triangles: 100, Vertices: 300, TextureBytes: 2 << 20}, want: []string{}},
+		{name: `over`, budget: Budget{Triangles: 99, Vertices: 299, TextureBytes: 1 << 20}, want: []string{
+			`100 triangles is over the budget of 99`,
+			`300 vertices is over the budget of 299`,
+			`2.0 MB of textures is over the budget of 1.0 MB`,
+		}},
+	}
+	for _, test := range tests {
+		if got := test.budget.Check(s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf(`%s: got %q, want %q`, test.name, got, test.want)
+		}
+	}
+}
+
+func TestSubHandler(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `stats`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := scene.NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := store.Create(`boxes`, `alice`, []byte(scenetest.Document(`0`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta, err = store.Replace(meta.Id, `boxes`, `alice`, 0, []byte(testDocument(t))); err != nil {
+		t.Fatal(err)
+	}
+	h := NewSubHandler(store, nil, golog.NewDevNullLog())
+
+	tests := []struct {
+		name     string
+		method   string
+		id       string
+		query    string
+		status   int
+		revision int
+		objects  int
+	}{
+		{name: `head`, id: meta.Id, status: 200, revision: 2, objects: 3},
+		{name: `earlier revision`, id: meta.Id, query: `?revision=1`, status: 200, revision: 1, objects: 0},
+		{name: `missing revision`, id: meta.Id, query: `?revision=3`, status: 404},
+		{name: `bad revision`, id: meta.Id, query: `?revision=one`, status: 400},
+		{name: `missing scene`, id: `nope`, status: 404},
+		{name: `post`, method: `POST`, id: meta.Id, status: 405},
+	}
+	for _, test := range tests {
+		if test.method == `` {
+			test.method = `GET`
+		}
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(test.method, `/`+test.query, nil), test.id, nil)
+		if w.Code != test.status {
+			t.Errorf(`%s: got status %d, want %d`, test.name, w.Code, test.status)
+			continue
+		}
+		if test.status != 200 {
+			continue
+		}
+		s := &Stats{}
+		if err := json.Unmarshal(w.Body.Bytes(), s); err != nil {
+			t.Errorf(`%s: %v`, test.name, err)
+		} else if s.Id != meta.Id || s.Name != `boxes` || s.Revision != test.revision || s.Objects != test.objects {
+			t.Errorf(`%s: got %s %q revision %d with %d objects`, test.name, s.Id, s.Name, s.Revision, s.Objects)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `stats`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, `boxes.json`)
+	if err := ioutil.WriteFile(file, []byte(testDocument(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	command := NewCommand(filepath.Join(dir, `scenes`), filepath.Join(dir, `assets`))
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+		// lines the output must contain
+		out []string
+	}{
+		{name: `file`, args: []string{file}, out: []string{`3 objects, 24 triangles`, `Group 1, Mesh 2`}},
+		{name: `objects`, args: []string{`-objects`, file}, out: []string{`"a" A: 12 triangles`, `"b" B: 12 triangles`}},
+		{name: `within budget`, args: []string{`-max-triangles`, `24`, file}},
+		{name: `over budget`, args: []string{`-max-triangles`, `23`, file}, err: `1 scenes over budget`, out: []string{`over budget: 24 triangles is over the budget of 23`}},
+		{name: `json`, args: []string{`-json`, file}, out: []string{`"triangles": 24`}},
+		{name: `revision of several scenes`, args: []string{`-revision`, `1`, file, file}, err: (&usageError{}).Error()},
+		{name: `missing scene`, args: []string{`nope`}, err: `No such scene exists with id: nope`},
+		{name: `no scenes stored`, args: []string{}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := command(test.args, &out)
+		if test.err == `` && err != nil {
+			t.Errorf(`%s: unexpected error: %v`, test.name, err)
+		} else if test.err != `` && (err == nil || err.Error() != test.err) {
+			t.Errorf(`%s: got error %v, want %q`, test.name, err, test.err)
+		}
+		for _, line := range test.out {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf(`%s: output %q does not contain %q`, test.name, out.String(), line)
+			}
+		}
+	}
+}
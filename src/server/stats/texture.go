@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"encoding/base64"
+	"github.com/robsix/3ditor/src/server/asset"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	// THREE.ClampToEdgeWrapping, THREE.NearestFilter and THREE.LinearFilter
+	clampToEdgeWrapping = 1001
+	nearestFilter       = 1003
+	linearFilter        = 1006
+	// THREE.Texture's default minFilter, THREE.LinearMipMapLinearFilter
+	linearMipMapLinearFilter = 1008
+	// textures are uploaded as 8 bit RGBA
+	bytesPerPixel = 4
+)
+
+// The bytes a texture of a width x height image takes once uploaded as the r73 WebGLRenderer does it. Images are
+// scaled to the nearest power of two when the texture repeats or mipmaps, and power of two images get a full chain
+// of mipmaps.
+func textureBytes(texture map[string]interface{}, width, height int) int64 {
+	minFilter := linearMipMapLinearFilter
+	if f, ok := texture[`minFilter`].(float64); ok {
+		minFilter = int(f)
+	}
+	needsPowerOfTwo := minFilter != nearestFilter && minFilter != linearFilter
+	if wrap, _ := texture[`wrap`].([]interface{}); len(wrap) == 2 {
+		s, _ := wrap[0].(float64)
+		t, _ := wrap[1].(float64)
+		needsPowerOfTwo = needsPowerOfTwo || int(s) != clampToEdgeWrapping || int(t) != clampToEdgeWrapping
+	}
+	if needsPowerOfTwo {
+		width, height = nearestPowerOfTwo(width), nearestPowerOfTwo(height)
+	}
+	size := int64(width) * int64(height) * bytesPerPixel
+	if !isPowerOfTwo(width) || !isPowerOfTwo(height) {
+		return size
+	}
+	for total := size; ; {
+		if width == 1 && height == 1 {
+			return total
+		}
+		if width > 1 {
+			width /= 2
+		}
+		if height > 1 {
+			height /= 2
+		}
+		total += int64(width) * int64(height) * bytesPerPixel
+	}
+}
+
+// THREE.Math.nearestPowerOfTwo
+func nearestPowerOfTwo(n int) int {
+	return int(math.Pow(2, math.Floor(math.Log2(float64(n))+0.5)))
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// The dimensions of the image at a base64 data uri or an asset:// reference, read from its header without decoding
+// the pixels
+func imageSize(url string, assets asset.Store) (int, int, bool) {
+	var r io.Reader
+	if hash, ok := asset.ParseRef(url); ok {
+		if assets == nil {
+			return 0, 0, false
+		}
+		_, f, err := assets.Get(hash)
+		if err != nil {
+			return 0, 0, false
+		}
+		defer f.Close()
+		r = f
+	} else if comma := strings.IndexByte(url, ','); strings.HasPrefix(url, `data:`) && comma >= 0 && strings.HasSuffix(url[:comma], `;base64`) {
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(url[comma+1:]))
+	} else {
+		return 0, 0, false
+	}
+	config, _, err := image.DecodeConfig(r)
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
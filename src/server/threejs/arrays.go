@@ -0,0 +1,228 @@
+package threejs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// A sequence of float values read back in order when the document is written
+type Floats interface {
+	Len() int
+	Range(fn func(v float32) error) error
+	Close() error
+}
+
+// A sequence of unsigned integer values, used for geometry indices
+type Uints interface {
+	Len() int
+	Max() uint32
+	Range(fn func(v uint32) error) error
+	Close() error
+}
+
+// Floats held in memory
+type FloatArray []float32
+
+func (a FloatArray) Len() int { return len(a) }
+
+func (a FloatArray) Range(fn func(v float32) error) error {
+	for _, v := range a {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a FloatArray) Close() error { return nil }
+
+// Uints held in memory
+type UintArray []uint32
+
+func (a UintArray) Len() int { return len(a) }
+
+func (a UintArray) Max() uint32 {
+	max := uint32(0)
+	for _, v := range a {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func (a UintArray) Range(fn func(v uint32) error) error {
+	for _, v := range a {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a UintArray) Close() error { return nil }
+
+// 32 bit values appended to a temporary file so attributes of huge meshes never have to be held in memory
+type spill struct {
+	file   *os.File
+	writer *bufio.Writer
+	n      int
+	err    error
+}
+
+func newSpill(prefix string) (*spill, error) {
+	file, err := ioutil.TempFile(``, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &spill{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// Appends a value, the first write error is kept and returned by Range
+func (s *spill) append(v uint32) {
+	if s.err != nil {
+		return
+	}
+	buf := [4]byte{}
+	binary.LittleEndian.PutUint32(buf[:], v)
+	if _, err := s.writer.Write(buf[:]); err != nil {
+		s.err = err
+		return
+	}
+	s.n++
+}
+
+func (s *spill) Len() int { return s.n }
+
+func (s *spill) rangeBits(fn func(v uint32) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(s.file)
+	buf := make([]byte, 4)
+	for i := 0; i < s.n; i++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return err
+		}
+		if err := fn(binary.LittleEndian.Uint32(buf)); err != nil {
+			return err
+		}
+	}
+	_, err := s.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (s *spill) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// Floats appended to a temporary file, Close must be called to remove the file
+type SpillFloats struct {
+	*spill
+}
+
+func NewSpillFloats() (*SpillFloats, error) {
+	s, err := newSpill(`3ditor-floats-`)
+	if err != nil {
+		return nil, err
+	}
+	return &SpillFloats{s}, nil
+}
+
+// Appends values, the first write error is kept and returned by Range
+func (s *SpillFloats) Append(values ...float32) {
+	for _, v := range values {
+		s.append(math.Float32bits(v))
+	}
+}
+
+func (s *SpillFloats) Range(fn func(v float32) error) error {
+	return s.rangeBits(func(v uint32) error {
+		return fn(math.Float32frombits(v))
+	})
+}
+
+// Indices appended to a temporary file, Close must be called to remove the file
+type SpillUints struct {
+	*spill
+	max uint32
+}
+
+func NewSpillUints() (*SpillUints, error) {
+	s, err := newSpill(`3ditor-uints-`)
+	if err != nil {
+		return nil, err
+	}
+	return &SpillUints{spill: s}, nil
+}
+
+// Appends values, the first write error is kept and returned by Range
+func (s *SpillUints) Append(values ...uint32) {
+	for _, v := range values {
+		if v > s.max {
+			s.max = v
+		}
+		s.append(v)
+	}
+}
+
+func (s *SpillUints) Max() uint32 { return s.max }
+
+func (s *SpillUints) Range(fn func(v uint32) error) error {
+	return s.rangeBits(fn)
+}
+
+// Smooth vertex normals for an indexed triangle mesh, each face adds its area weighted normal to its vertices as
+// THREE.BufferGeometry.computeVertexNormals does. The positions are read into memory to do so.
+func ComputeNormals(position Floats, index Uints) (FloatArray, error) {
+	positions := make(FloatArray, 0, position.Len())
+	if err := position.Range(func(v float32) error {
+		positions = append(positions, v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	normals := make(FloatArray, len(positions))
+	triangle := make([]int, 0, 3)
+	err := index.Range(func(v uint32) error {
+		triangle = append(triangle, int(v)*3)
+		if len(triangle) < 3 {
+			return nil
+		}
+		a, b, c := triangle[0], triangle[1], triangle[2]
+		triangle = triangle[:0]
+		if a+2 >= len(positions) || b+2 >= len(positions) || c+2 >= len(positions) {
+			return nil
+		}
+		ux, uy, uz := positions[b]-positions[a], positions[b+1]-positions[a+1], positions[b+2]-positions[a+2]
+		vx, vy, vz := positions[c]-positions[a], positions[c+1]-positions[a+1], positions[c+2]-positions[a+2]
+		nx, ny, nz := uy*vz-uz*vy, uz*vx-ux*vz, ux*vy-uy*vx
+		for _, i := range []int{a, b, c} {
+			normals[i] += nx
+			normals[i+1] += ny
+			normals[i+2] += nz
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+2 < len(normals); i += 3 {
+		x, y, z := normals[i], normals[i+1], normals[i+2]
+		if length := float32(math.Sqrt(float64(x*x + y*y + z*z))); length > 0 {
+			normals[i], normals[i+1], normals[i+2] = x/length, y/length, z/length
+		}
+	}
+	return normals, nil
+}
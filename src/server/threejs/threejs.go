@@ -0,0 +1,190 @@
+/*
+Builders for the Three.js JSON object format (version 4.4) consumed by THREE.ObjectLoader, used by the server side
+importers. Documents are written with WriteTo which streams the geometry arrays instead of marshalling them into
+memory.
+*/
+package threejs
+
+import (
+	"encoding/json"
+	"github.com/robsix/3ditor/src/server/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
+	"math"
+	"strings"
+)
+
+var IdentityMatrix = []float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+const (
+	// THREE.FaceColors and THREE.VertexColors
+	FaceColors   = 1
+	VertexColors = 2
+	// THREE.DoubleSide
+	DoubleSide = 2
+)
+
+type Document struct {
+	Geometries []*Geometry
+	Materials  []Material
+	Textures   []map[string]interface{}
+	Images     []map[string]interface{}
+	Object     *Object
+}
+
+type Geometry struct {
+	Uuid       string
+	Name       string
+	Attributes []*Attribute
+	Index      Uints
+	Groups     []*Group
+}
+
+// Attributes are written in the order they are added, Values must hold ItemSize values per vertex
+type Attribute struct {
+	Name     string
+	ItemSize int
+	Values   Floats
+}
+
+type Group struct {
+	Start         int `json:"start"`
+	Count         int `json:"count"`
+	MaterialIndex int `json:"materialIndex"`
+}
+
+// Material json as produced by Material.toJSON(), left as a map since every material type has its own properties
+type Material map[string]interface{}
+
+type Object struct {
+	Uuid     string                 `json:"uuid"`
+	Type     string                 `json:"type"`
+	Name     string                 `json:"name,omitempty"`
+	Matrix   []float64              `json:"matrix"`
+	Geometry string                 `json:"geometry,omitempty"`
+	Material string                 `json:"material,omitempty"`
+	UserData map[string]interface{} `json:"userData,omitempty"`
+	Children []*Object              `json:"children,omitempty"`
+	// type specific properties such as the fov of a camera, written alongside the common ones
+	Properties map[string]interface{} `json:"-"`
+}
+
+type objectJson Object
+
+func (o *Object) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal((*objectJson)(o))
+	if err != nil || len(o.Properties) == 0 {
+		return data, err
+	}
+	properties, err := json.Marshal(o.Properties)
+	if err != nil {
+		return nil, err
+	}
+	// splice the properties into the object after its common fields
+	return append(append(data[:len(data)-1], ','), properties[1:]...), nil
+}
+
+func NewGeometry(name string) *Geometry {
+	return &Geometry{Uuid: NewUuid(), Name: name}
+}
+
+func (g *Geometry) AddAttribute(name string, itemSize int, values Floats) {
+	g.Attributes = append(g.Attributes, &Attribute{Name: name, ItemSize: itemSize, Values: values})
+}
+
+func (g *Geometry) Attribute(name string) *Attribute {
+	for _, attribute := range g.Attributes {
+		if attribute.Name == name {
+			return attribute
+		}
+	}
+	return nil
+}
+
+// The number of vertices in the position attribute
+func (g *Geometry) VertexCount() int {
+	if position := g.Attribute(`position`); position != nil {
+		return position.Values.Len() / position.ItemSize
+	}
+	return 0
+}
+
+func NewObject(typ, name string) *Object {
+	return &Object{Uuid: NewUuid(), Type: typ, Name: name, Matrix: append([]float64{}, IdentityMatrix...)}
+}
+
+// A column major matrix placing an object at eye looking towards target with y up, as Object3D.lookAt does for
+// cameras
+func LookAt(eye, target [3]float64) []float64 {
+	z := normalize([3]float64{eye[0] - target[0], eye[1] - target[1], eye[2] - target[2]})
+	x := normalize([3]float64{z[2], 0, -z[0]})
+	if x == ([3]float64{}) {
+		x = [3]float64{1, 0, 0}
+	}
+	y := [3]float64{z[1]*x[2] - z[2]*x[1], z[2]*x[0] - z[0]*x[2], z[0]*x[1] - z[1]*x[0]}
+	return []float64{x[0], x[1], x[2], 0, y[0], y[1], y[2], 0, z[0], z[1], z[2], 0, eye[0], eye[1], eye[2], 1}
+}
+
+func normalize(v [3]float64) [3]float64 {
+	length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if length == 0 {
+		return v
+	}
+	return [3]float64{v[0] / length, v[1] / length, v[2] / length}
+}
+
+// Three.js uses upper case uuids
+func NewUuid() string {
+	return strings.ToUpper(uuid.New())
+}
+
+func NewMaterial(typ, name string) Material {
+	m := Material{`uuid`: NewUuid(), `type`: typ}
+	if name != `` {
+		m[`name`] = name
+	}
+	return m
+}
+
+func (m Material) Uuid() string {
+	uuid, _ := m[`uuid`].(string)
+	return uuid
+}
+
+// Combines materials into the MultiMaterial selected between by geometry group material indices
+func NewMultiMaterial(materials []Material) Material {
+	m := NewMaterial(`MultiMaterial`, ``)
+	list := make([]interface{}, len(materials))
+	for i, material := range materials {
+		list[i] = material
+	}
+	m[`materials`] = list
+	return m
+}
+
+// Adds a mesh, its geometry and its material to the document under parent
+func (d *Document) AddMesh(parent *Object, typ, name string, geometry *Geometry, material Material) *Object {
+	object := NewObject(typ, name)
+	object.Geometry = geometry.Uuid
+	object.Material = material.Uuid()
+	d.Geometries = append(d.Geometries, geometry)
+	d.Materials = append(d.Materials, material)
+	parent.Children = append(parent.Children, object)
+	return object
+}
+
+// Releases any temporary storage held by the geometry attributes
+func (d *Document) Close() error {
+	var err error
+	for _, geometry := range d.Geometries {
+		for _, attribute := range geometry.Attributes {
+			if closeErr := attribute.Values.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+		if geometry.Index != nil {
+			if closeErr := geometry.Index.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
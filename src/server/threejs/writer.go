@@ -0,0 +1,178 @@
+package threejs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) error {
+	_, err := c.Write([]byte(s))
+	return err
+}
+
+func (c *countingWriter) writeJson(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(data)
+	return err
+}
+
+// Writes the document in the format THREE.ObjectLoader parses
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	err := d.write(out)
+	if flushErr := out.w.Flush(); err == nil {
+		err = flushErr
+	}
+	return out.n, err
+}
+
+// Writes the document as an editor scene, the Editor.toJSON format the scene store holds, with the document's object
+// as the only child of the scene and the editor's default camera
+func (d *Document) WriteSceneTo(w io.Writer) (int64, error) {
+	root := NewObject(`Scene`, `Scene`)
+	root.Children = []*Object{d.Object}
+	sceneDoc := *d
+	sceneDoc.Object = root
+
+	camera := NewObject(`PerspectiveCamera`, `Camera`)
+	camera.Matrix = LookAt([3]float64{500, 250, 500}, [3]float64{})
+	camera.Properties = map[string]interface{}{`fov`: 50, `aspect`: 1, `near`: 1, `far`: 100000}
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	out.WriteString(`{"project":{"shadows":true,"vr":false},"camera":{"metadata":{"version":4.4,"type":"Object","generator":"3ditor"},"object":`)
+	err := out.writeJson(camera)
+	if err == nil {
+		out.WriteString(`},"scripts":{},"scene":`)
+		if err = sceneDoc.write(out); err == nil {
+			err = out.WriteString(`}`)
+		}
+	}
+	if flushErr := out.w.Flush(); err == nil {
+		err = flushErr
+	}
+	return out.n, err
+}
+
+func (d *Document) write(out *countingWriter) error {
+	if err := out.WriteString(`{"metadata":{"version":4.4,"type":"Object","generator":"3ditor"}`); err != nil {
+		return err
+	}
+	if len(d.Geometries) > 0 {
+		if err := out.WriteString(`,"geometries":[`); err != nil {
+			return err
+		}
+		for i, geometry := range d.Geometries {
+			if i > 0 {
+				out.WriteString(`,`)
+			}
+			if err := geometry.write(out); err != nil {
+				return err
+			}
+		}
+		out.WriteString(`]`)
+	}
+	for _, part := range []struct {
+		key   string
+		items interface{}
+		n     int
+	}{
+		{`materials`, d.Materials, len(d.Materials)},
+		{`textures`, d.Textures, len(d.Textures)},
+		{`images`, d.Images, len(d.Images)},
+	} {
+		if part.n == 0 {
+			continue
+		}
+		out.WriteString(`,"` + part.key + `":`)
+		if err := out.writeJson(part.items); err != nil {
+			return err
+		}
+	}
+	out.WriteString(`,"object":`)
+	if err := out.writeJson(d.Object); err != nil {
+		return err
+	}
+	return out.WriteString(`}`)
+}
+
+func (g *Geometry) write(out *countingWriter) error {
+	out.WriteString(`{"uuid":` + strconv.Quote(g.Uuid) + `,"type":"BufferGeometry"`)
+	if g.Name != `` {
+		out.WriteString(`,"name":`)
+		out.writeJson(g.Name)
+	}
+	out.WriteString(`,"data":{`)
+	if g.Index != nil {
+		typ := `Uint16Array`
+		if g.Index.Max() > 0xFFFF {
+			typ = `Uint32Array`
+		}
+		out.WriteString(`"index":{"type":"` + typ + `","array":[`)
+		i := 0
+		buf := []byte{}
+		err := g.Index.Range(func(v uint32) error {
+			buf = buf[:0]
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			i++
+			_, err := out.Write(strconv.AppendUint(buf, uint64(v), 10))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.WriteString(`]},`)
+	}
+	out.WriteString(`"attributes":{`)
+	for a, attribute := range g.Attributes {
+		if a > 0 {
+			out.WriteString(`,`)
+		}
+		out.WriteString(strconv.Quote(attribute.Name) + `:{"itemSize":` + strconv.Itoa(attribute.ItemSize) + `,"type":"Float32Array","array":[`)
+		i := 0
+		buf := []byte{}
+		err := attribute.Values.Range(func(v float32) error {
+			buf = buf[:0]
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			i++
+			// json has no representation of NaN or infinity
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				v = 0
+			}
+			_, err := out.Write(strconv.AppendFloat(buf, float64(v), 'g', -1, 32))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.WriteString(`]}`)
+	}
+	out.WriteString(`}`)
+	if len(g.Groups) > 0 {
+		out.WriteString(`,"groups":`)
+		if err := out.writeJson(g.Groups); err != nil {
+			return err
+		}
+	}
+	return out.WriteString(`}}`)
+}
@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"sync"
+)
+
+// Queues outgoing messages and writes them from its own goroutine so a slow peer never blocks the sender,
+// a peer that falls more than size messages behind is disconnected
+type Outbox struct {
+	conn   *Conn
+	queue  chan []byte
+	mtx    sync.Mutex
+	closed bool
+}
+
+func NewOutbox(conn *Conn, size int) *Outbox {
+	o := &Outbox{conn: conn, queue: make(chan []byte, size)}
+	go func() {
+		for data := range o.queue {
+			if err := conn.WriteMessage(data); err != nil {
+				conn.Close()
+			}
+		}
+		conn.Close()
+	}()
+	return o
+}
+
+func (o *Outbox) Send(data []byte) {
+	defer o.mtx.Unlock()
+	o.mtx.Lock()
+
+	if o.closed {
+		return
+	}
+	select {
+	case o.queue <- data:
+	default:
+		o.conn.Close()
+	}
+}
+
+// Closes the connection once the already queued messages have been written
+func (o *Outbox) Close() {
+	defer o.mtx.Unlock()
+	o.mtx.Lock()
+
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+A minimal server side RFC 6455 websocket implementation, enough for exchanging json messages with the editor
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	acceptGuid = `258EAFA5-E914-47DA-95CA-C5AB0DC85B11`
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// messages larger than this are rejected with a close frame
+	DefaultMaxMessageSize = 64 << 20
+
+	// the close code telling the peer the server is stopping
+	CloseGoingAway = 1001
+
+	// how long a peer has to answer a ping before it is disconnected
+	DefaultPongWait = 60 * time.Second
+
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	ErrClosed          = errors.New(`websocket closed`)
+	errMessageTooLarge = errors.New(`websocket message too large`)
+	errProtocol        = errors.New(`websocket protocol error`)
+)
+
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	writeMtx       sync.Mutex
+	closeOnce      sync.Once
+	closed         chan struct{}
+	pingOnce       sync.Once
+	MaxMessageSize int64
+	// the peer is pinged every half PongWait from the first read and disconnected when it has not answered for
+	// PongWait, so a peer that went away without closing is noticed, 0 never pings
+	PongWait time.Duration
+}
+
+// Upgrades the request to a websocket connection, on failure an error response has already been written.
+// Cross origin requests are refused so other sites cannot open sockets with the user's cookies.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != `GET` ||
+		!headerContains(r.Header, `Connection`, `upgrade`) ||
+		!headerContains(r.Header, `Upgrade`, `websocket`) {
+		http.Error(w, `websocket upgrade required`, http.StatusUpgradeRequired)
+		return nil, errProtocol
+	}
+	if r.Header.Get(`Sec-Websocket-Version`) != `13` {
+		w.Header().Set(`Sec-WebSocket-Version`, `13`)
+		http.Error(w, `unsupported websocket version`, http.StatusBadRequest)
+		return nil, errProtocol
+	}
+	key := r.Header.Get(`Sec-Websocket-Key`)
+	if key == `` {
+		http.Error(w, `missing Sec-WebSocket-Key`, http.StatusBadRequest)
+		return nil, errProtocol
+	}
+	if origin := r.Header.Get(`Origin`); origin != `` {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, `cross origin websocket refused`, http.StatusForbidden)
+			return nil, errProtocol
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, `websocket not supported`, http.StatusInternalServerError)
+		return nil, errProtocol
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + acceptGuid))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader, closed: make(chan struct{}), MaxMessageSize: DefaultMaxMessageSize, PongWait: DefaultPongWait}, nil
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, `,`) {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reads the next complete text or binary message, answering pings and close frames along the way.
+// Returns ErrClosed once the peer has closed the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	c.pingOnce.Do(c.startPinging)
+	message := []byte{}
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if err == errMessageTooLarge {
+				c.writeClose(1009)
+			} else if err == errProtocol {
+				c.writeClose(1002)
+			}
+			c.Close()
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			if c.PongWait > 0 {
+				c.conn.SetReadDeadline(time.Now().Add(c.PongWait))
+			}
+			continue
+		case opClose:
+			c.writeClose(1000)
+			c.Close()
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				c.writeClose(1002)
+				c.Close()
+				return nil, errProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				c.writeClose(1002)
+				c.Close()
+				return nil, errProtocol
+			}
+		default:
+			c.writeClose(1002)
+			c.Close()
+			return nil, errProtocol
+		}
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			c.writeClose(1009)
+			c.Close()
+			return nil, errMessageTooLarge
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *Conn) startPinging() {
+	if c.PongWait <= 0 {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.PongWait))
+	go func() {
+		ticker := time.NewTicker(c.PongWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.writeFrame(opPing, nil); err != nil {
+					c.Close()
+					return
+				}
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+	if !masked {
+		return false, 0, nil, errProtocol
+	}
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext))
+	}
+	if length < 0 || length > c.MaxMessageSize {
+		return false, 0, nil, errMessageTooLarge
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// Sends data as a single text frame, safe to call from multiple goroutines
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	defer c.writeMtx.Unlock()
+	c.writeMtx.Lock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *Conn) writeClose(code uint16) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return c.writeFrame(opClose, payload)
+}
+
+// Sends a close frame with code before closing the connection
+func (c *Conn) CloseWith(code uint16) error {
+	c.writeClose(code)
+	return c.Close()
+}
+
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
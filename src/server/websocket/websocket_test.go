@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"github.com/robsix/3ditor/src/server/websocket/websockettest"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name        string
+		ignorePings bool
+		// the least pings read, an ignored connection may be dropped before its second
+		pings int
+	}{
+		{name: `answered pings`, pings: 2},
+		{name: `ignored pings`, ignorePings: true, pings: 1},
+	}
+	for _, test := range tests {
+		func() {
+			// the error the server's read ended with
+			ended := make(chan error, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.PongWait = 100 * time.Millisecond
+				for {
+					data, err := conn.ReadMessage()
+					if err != nil {
+						ended <- err
+						return
+					}
+					conn.WriteMessage(data)
+				}
+			}))
+			defer server.Close()
+
+			client, err := websockettest.Dial(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.IgnorePings = test.ignorePings
+
+			// pings are only answered while reading, so the client echoes messages for a few pong waits
+			var echoErr error
+			for deadline := time.Now().Add(400 * time.Millisecond); echoErr == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if echoErr = client.Write([]byte(`x`)); echoErr == nil {
+					_, echoErr = client.Read()
+				}
+			}
+			if client.Pings < test.pings {
+				t.Errorf(`%s: got %d pings, want at least %d`, test.name, client.Pings, test.pings)
+			}
+
+			select {
+			case err := <-ended:
+				if netErr, ok := err.(net.Error); !test.ignorePings || !ok || !netErr.Timeout() {
+					t.Errorf(`%s: server read ended with %v`, test.name, err)
+				}
+				if echoErr == nil {
+					t.Errorf(`%s: client still connected after the server timed out`, test.name)
+				}
+			default:
+				if test.ignorePings {
+					t.Errorf(`%s: server still connected after %d unanswered pings`, test.name, client.Pings)
+				}
+				if echoErr != nil {
+					t.Errorf(`%s: unexpected error: %v`, test.name, echoErr)
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,157 @@
+/*
+A minimal websocket client for testing the server's socket handlers
+*/
+package websockettest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+
+	// how long Read waits for a message before failing, so a test missing a message fails rather than hangs
+	readTimeout = 5 * time.Second
+)
+
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// pings are answered while reading unless this is set, as with a peer that has gone away without closing
+	IgnorePings bool
+	// the number of pings read
+	Pings int
+}
+
+// Opens a websocket to rawUrl, an http:// url such as an httptest server's with the path and query to request
+func Dial(rawUrl string) (*Conn, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(`tcp`, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest(`GET`, rawUrl, nil)
+	req.Header.Set(`Connection`, `Upgrade`)
+	req.Header.Set(`Upgrade`, `websocket`)
+	req.Header.Set(`Sec-WebSocket-Version`, `13`)
+	req.Header.Set(`Sec-WebSocket-Key`, `dGhlIHNhbXBsZSBub25jZQ==`)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errors.New(`websocket upgrade refused: ` + resp.Status)
+	}
+	return &Conn{conn: conn, reader: reader}, nil
+}
+
+// Sends data as a masked text frame
+func (c *Conn) Write(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Sends v marshalled to json
+func (c *Conn) WriteJson(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Write(data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Reads the next text message, io.EOF once the server has closed the connection
+func (c *Conn) Read() ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			return nil, err
+		}
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			ext := make([]byte, 2)
+			if _, err := io.ReadFull(c.reader, ext); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext))
+		case 127:
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(c.reader, ext); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return nil, err
+		}
+		switch header[0] & 0x0F {
+		case opPing:
+			c.Pings++
+			if !c.IgnorePings {
+				c.writeFrame(opPong, payload)
+			}
+		case opClose:
+			return nil, io.EOF
+		case opText:
+			return payload, nil
+		}
+	}
+}
+
+// Reads the next text message into v
+func (c *Conn) ReadJson(v interface{}) error {
+	data, err := c.Read()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Closes the connection without a close frame
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}